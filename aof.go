@@ -0,0 +1,492 @@
+package namestore
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FsyncPolicy controls when the append-only log is synced to disk.
+type FsyncPolicy int
+
+const (
+	// FsyncEverySecond syncs once per second; a crash loses at most about a
+	// second of writes. This is the default.
+	FsyncEverySecond FsyncPolicy = iota
+	// FsyncAlways syncs before every write returns.
+	FsyncAlways
+	// FsyncNever leaves syncing to the operating system.
+	FsyncNever
+)
+
+// DefaultLogCompactionSize is the minimum log size that triggers automatic
+// compaction.
+const DefaultLogCompactionSize = 64 << 20
+
+// Append-only log format, version 1. The file starts with the magic "NSAOF"
+// and a big-endian uint16 version, followed by frames:
+//
+//	length  uint32, big-endian, of payload
+//	crc     uint32, big-endian, CRC-32C of payload
+//	payload one or more records
+//
+// Each frame holds the records of one locked operation, so batch writes such
+// as MSet or a transaction replay all-or-nothing. Records carry the resulting
+// state of a key rather than the operation that produced it, which makes
+// replay independent of operation semantics:
+//
+//	'P' put:   keyLen uvarint, key, valueLen uvarint, value, expire int64 (Unix ns, 0 for none)
+//	'D' del:   keyLen uvarint, key
+//	'C' clear: prefixLen uvarint, prefix
+//	'R' reset: no fields; removes every key
+const (
+	aofMagic   = "NSAOF"
+	aofVersion = 1
+
+	aofPut   = 'P'
+	aofDel   = 'D'
+	aofClear = 'C'
+	aofReset = 'R'
+
+	// aofFrameHeader is the size of a frame's length and checksum.
+	aofFrameHeader = 8
+	// aofRewriteBatch is the number of records per frame when compacting.
+	aofRewriteBatch = 1000
+)
+
+// WithAppendLog records every mutation in an append-only log at path, synced
+// according to policy. OpenMemory replays the log on startup, after loading
+// the snapshot if WithSnapshotFile is also set, and tolerates a truncated
+// final frame left by a crash. Only OpenMemory honors this option:
+// NewMemory and NewShardedMemory ignore it.
+func WithAppendLog(path string, policy FsyncPolicy) MemoryOption {
+	return func(c *memoryConfig) {
+		c.logPath = path
+		c.logPolicy = policy
+	}
+}
+
+// WithLogCompaction compacts the append-only log in the background once it
+// is at least minSize bytes and has doubled since the last compaction.
+// Negative values disable automatic compaction; CompactLog still works.
+// Defaults to DefaultLogCompactionSize.
+func WithLogCompaction(minSize int64) MemoryOption {
+	return func(c *memoryConfig) {
+		c.logCompactionSize = minSize
+	}
+}
+
+// appendLog is the open log file. Frames are written while the Memory lock
+// is held, so they are in mutation order; mu additionally guards against the
+// background syncer.
+type appendLog struct {
+	mu       sync.Mutex
+	f        *os.File
+	path     string
+	policy   FsyncPolicy
+	size     int64
+	baseSize int64 // size after the last compaction
+	dirty    bool
+	err      error         // first write error; the log stops accepting frames
+	rewrite  *bytes.Buffer // frames written while a compaction is running
+}
+
+// unlock writes the log records of the current operation, if any, and
+// releases the write lock. Mutating methods use it instead of mu.Unlock.
+func (m *Memory) unlock() {
+	if len(m.logBatch) > 0 {
+		m.log.writeFrame(m.logBatch)
+		m.logBatch = m.logBatch[:0]
+	}
+	m.mu.Unlock()
+}
+
+func (m *Memory) logPutLocked(key string, e entry) {
+	if m.log == nil {
+		return
+	}
+	var expire int64
+	if !e.expire.IsZero() {
+		expire = e.expire.UnixNano()
+	}
+	m.logBatch = appendAOFPut(m.logBatch, key, e.value, expire)
+}
+
+func (m *Memory) logDelLocked(key string) {
+	if m.log != nil {
+		m.logBatch = appendAOFString(append(m.logBatch, aofDel), key)
+	}
+}
+
+func (m *Memory) logClearLocked(prefix string) {
+	if m.log != nil {
+		m.logBatch = appendAOFString(append(m.logBatch, aofClear), prefix)
+	}
+}
+
+// logResetLocked records that the store was emptied, as by Restore.
+func (m *Memory) logResetLocked() {
+	if m.log != nil {
+		m.logBatch = append(m.logBatch, aofReset)
+	}
+}
+
+func appendAOFPut(b []byte, key string, value []byte, expire int64) []byte {
+	b = appendAOFString(append(b, aofPut), key)
+	b = binary.AppendUvarint(b, uint64(len(value)))
+	b = append(b, value...)
+	return binary.BigEndian.AppendUint64(b, uint64(expire))
+}
+
+func appendAOFString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+func appendAOFFrame(b, payload []byte) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(payload)))
+	b = binary.BigEndian.AppendUint32(b, crc32.Checksum(payload, castagnoli))
+	return append(b, payload...)
+}
+
+func (l *appendLog) writeFrame(payload []byte) {
+	frame := appendAOFFrame(nil, payload)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err != nil {
+		return
+	}
+	if _, err := l.f.Write(frame); err != nil {
+		l.err = fmt.Errorf("namestore: append log: %w", err)
+		return
+	}
+	l.size += int64(len(frame))
+	if l.rewrite != nil {
+		l.rewrite.Write(frame)
+	}
+	if l.policy == FsyncAlways {
+		if err := l.f.Sync(); err != nil {
+			l.err = fmt.Errorf("namestore: append log: %w", err)
+		}
+		return
+	}
+	l.dirty = true
+}
+
+// sync flushes pending writes for FsyncEverySecond.
+func (l *appendLog) sync() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.dirty && l.err == nil {
+		if err := l.f.Sync(); err != nil {
+			l.err = fmt.Errorf("namestore: append log: %w", err)
+		}
+		l.dirty = false
+	}
+}
+
+func (l *appendLog) needsCompaction(minSize int64) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return minSize > 0 && l.rewrite == nil && l.size >= minSize && l.size >= 2*l.baseSize
+}
+
+func (l *appendLog) close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	err := l.err
+	if err == nil && l.policy != FsyncNever {
+		err = l.f.Sync()
+	}
+	if cerr := l.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// openLog replays the log at cfg.logPath into m and opens it for appending.
+func (m *Memory) openLog(cfg memoryConfig) error {
+	f, err := os.OpenFile(cfg.logPath, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+
+	size, err := m.replayLog(f)
+	if err == nil {
+		err = f.Truncate(size) // drop a torn final frame
+	}
+	if err == nil && size == 0 {
+		size, err = writeAOFHeader(f)
+	}
+	if err == nil {
+		_, err = f.Seek(size, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	m.log = &appendLog{f: f, path: cfg.logPath, policy: cfg.logPolicy, size: size, baseSize: size}
+	minSize := cfg.logCompactionSize
+	if minSize == 0 {
+		minSize = DefaultLogCompactionSize
+	}
+	m.logMaint = startPeriodic(time.Second, func() {
+		if m.log.policy == FsyncEverySecond {
+			m.log.sync()
+		}
+		if m.log.needsCompaction(minSize) {
+			_ = m.CompactLog()
+		}
+	})
+	return nil
+}
+
+func writeAOFHeader(w io.Writer) (int64, error) {
+	header := binary.BigEndian.AppendUint16([]byte(aofMagic), aofVersion)
+	n, err := w.Write(header)
+	return int64(n), err
+}
+
+// replayLog applies every complete frame in f and returns the offset after
+// the last one. A torn or checksum-failing frame at the end of the file is
+// ignored; damage before the end is reported as ErrCorrupt.
+func (m *Memory) replayLog(f *os.File) (int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	fileSize := info.Size()
+	if fileSize == 0 {
+		return 0, nil
+	}
+
+	r := bufio.NewReader(f)
+	header := make([]byte, len(aofMagic)+2)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:len(aofMagic)]) != aofMagic {
+		return 0, fmt.Errorf("%w: %s is not an append log", ErrCorrupt, f.Name())
+	}
+	if v := binary.BigEndian.Uint16(header[len(aofMagic):]); v != aofVersion {
+		return 0, fmt.Errorf("%w: unsupported append log version %d", ErrCorrupt, v)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.cfg.now()
+	offset := int64(len(header))
+	var fh [aofFrameHeader]byte
+	for offset < fileSize {
+		if _, err := io.ReadFull(r, fh[:]); err != nil {
+			return offset, nil // torn frame header at the end
+		}
+		n := int64(binary.BigEndian.Uint32(fh[:4]))
+		end := offset + aofFrameHeader + n
+		if end > fileSize {
+			return offset, nil // torn frame at the end
+		}
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return 0, err
+		}
+		if crc32.Checksum(payload, castagnoli) != binary.BigEndian.Uint32(fh[4:]) {
+			if end == fileSize {
+				return offset, nil // final frame only partially reached the disk
+			}
+			return 0, fmt.Errorf("%w: append log checksum mismatch at offset %d", ErrCorrupt, offset)
+		}
+		if err := m.applyFrameLocked(payload, now); err != nil {
+			return 0, fmt.Errorf("%w: append log offset %d: %v", ErrCorrupt, offset, err)
+		}
+		offset = end
+	}
+	return offset, nil
+}
+
+// applyFrameLocked replays the records of one frame. m.log is not yet set,
+// so nothing is logged again.
+func (m *Memory) applyFrameLocked(payload []byte, now time.Time) error {
+	for len(payload) > 0 {
+		op := payload[0]
+		if op == aofReset {
+			m.data = make(map[string]entry)
+			m.used = 0
+			payload = payload[1:]
+			continue
+		}
+		key, rest, err := readAOFString(payload[1:])
+		if err != nil {
+			return err
+		}
+
+		switch op {
+		case aofPut:
+			vlen, n := binary.Uvarint(rest)
+			if n <= 0 || uint64(len(rest)-n) < vlen+8 {
+				return errors.New("malformed put record")
+			}
+			value := bytes.Clone(rest[n : n+int(vlen)])
+			rest = rest[n+int(vlen):]
+
+			e := entry{value: value}
+			if ns := int64(binary.BigEndian.Uint64(rest)); ns != 0 {
+				e.expire = time.Unix(0, ns)
+			}
+			rest = rest[8:]
+			if e.expiredAt(now) {
+				m.deleteLocked(key)
+			} else {
+				m.loadLocked(key, e)
+			}
+		case aofDel:
+			m.deleteLocked(key)
+		case aofClear:
+			m.clearLocked(key)
+		default:
+			return fmt.Errorf("unknown record type %q", op)
+		}
+		payload = rest
+	}
+	return nil
+}
+
+func readAOFString(b []byte) (string, []byte, error) {
+	n, size := binary.Uvarint(b)
+	if size <= 0 || uint64(len(b)-size) < n {
+		return "", nil, errors.New("malformed record")
+	}
+	return string(b[size : size+int(n)]), b[size+int(n):], nil
+}
+
+// CompactLog rewrites the append-only log from the current contents. The
+// store is only locked while collecting entries and while switching files;
+// writes made during the rewrite are carried over. It runs automatically as
+// configured by WithLogCompaction, and calling it while a compaction is
+// running is a no-op.
+func (m *Memory) CompactLog() error {
+	if !m.compacting.CompareAndSwap(false, true) {
+		return nil
+	}
+	defer m.compacting.Store(false)
+
+	m.mu.Lock()
+	l := m.log
+	if l == nil {
+		m.mu.Unlock()
+		return fmt.Errorf("%w: no append log open", ErrUnsupported)
+	}
+	keys, entries := m.liveEntriesLocked()
+	l.mu.Lock()
+	l.rewrite = new(bytes.Buffer)
+	l.mu.Unlock()
+	m.mu.Unlock()
+
+	dir, base := filepath.Split(l.path)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := os.CreateTemp(dir, "."+base+".tmp-*")
+	if err != nil {
+		l.abortRewrite(nil)
+		return err
+	}
+	if err := writeAOFState(tmp, keys, entries); err != nil {
+		l.abortRewrite(tmp)
+		return err
+	}
+
+	// Block writers while the frames written meanwhile are appended and the
+	// files are switched.
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.log != l {
+		l.abortRewrite(tmp) // closed meanwhile
+		return nil
+	}
+	return l.switchTo(tmp)
+}
+
+// abortRewrite stops buffering frames for a compaction and removes tmp.
+func (l *appendLog) abortRewrite(tmp *os.File) {
+	l.mu.Lock()
+	l.rewrite = nil
+	l.mu.Unlock()
+	if tmp != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}
+}
+
+func writeAOFState(f *os.File, keys []string, entries []entry) error {
+	w := bufio.NewWriter(f)
+	if _, err := writeAOFHeader(w); err != nil {
+		return err
+	}
+	// The reset makes the rewritten log independent of any snapshot loaded
+	// before it.
+	payload := []byte{aofReset}
+	for i, k := range keys {
+		var expire int64
+		if !entries[i].expire.IsZero() {
+			expire = entries[i].expire.UnixNano()
+		}
+		payload = appendAOFPut(payload, k, entries[i].value, expire)
+		if (i+1)%aofRewriteBatch == 0 {
+			if _, err := w.Write(appendAOFFrame(nil, payload)); err != nil {
+				return err
+			}
+			payload = payload[:0]
+		}
+	}
+	if len(payload) > 0 {
+		if _, err := w.Write(appendAOFFrame(nil, payload)); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// switchTo replaces the log with the rewritten file tmp.
+func (l *appendLog) switchTo(tmp *os.File) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	pending := l.rewrite
+	l.rewrite = nil
+	fail := func(err error) error {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if l.err != nil {
+		return fail(l.err)
+	}
+
+	if _, err := tmp.Write(pending.Bytes()); err != nil {
+		return fail(err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return fail(err)
+	}
+	size, err := tmp.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fail(err)
+	}
+	if err := os.Rename(tmp.Name(), l.path); err != nil {
+		return fail(err)
+	}
+	syncDir(filepath.Dir(l.path))
+
+	l.f.Close()
+	l.f, l.size, l.baseSize, l.dirty = tmp, size, size, false
+	return nil
+}
@@ -0,0 +1,336 @@
+package namestore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func openLogged(t *testing.T, path string, opts ...MemoryOption) *Memory {
+	t.Helper()
+	m, err := OpenMemory(append([]MemoryOption{WithAppendLog(path, FsyncAlways)}, opts...)...)
+	if err != nil {
+		t.Fatalf("OpenMemory failed: %v", err)
+	}
+	return m
+}
+
+func TestMemory_AppendLogReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.aof")
+	ctx := context.Background()
+
+	m := openLogged(t, path)
+	_ = m.Set(ctx, "app:a", []byte("alpha"), 0)
+	_ = m.Set(ctx, "app:ttl", []byte("beta"), time.Hour)
+	_ = m.Set(ctx, "app:gone", []byte("x"), 0)
+	_ = m.Delete(ctx, "app:gone")
+	_ = m.MSet(ctx, map[string][]byte{"app:m1": []byte("1"), "app:m2": []byte("2")}, 0)
+	_ = m.MDel(ctx, []string{"app:m2"})
+	_, _ = m.Incr(ctx, "app:n", 40)
+	_, _ = m.Decr(ctx, "app:n", -2)
+	_, _ = m.GetSet(ctx, "app:a", []byte("alpha2"))
+	_, _ = m.CompareAndSwap(ctx, "app:m1", []byte("1"), []byte("one"), 0)
+	_ = m.Set(ctx, "app:p", []byte("p"), time.Hour)
+	_ = m.Persist(ctx, "app:p")
+	_ = m.Expire(ctx, "app:m1", time.Hour)
+	_ = m.Set(ctx, "tmp:1", []byte("t"), 0)
+	_ = m.Set(ctx, "tmp:2", []byte("t"), 0)
+	_ = m.Clear(ctx, "tmp")
+	if err := m.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	r := openLogged(t, path)
+	defer r.Close()
+
+	if n, _ := r.Incr(ctx, "app:n", 0); n != 42 {
+		t.Errorf("counter = %d, want 42", n)
+	}
+	want := map[string]string{"app:a": "alpha2", "app:ttl": "beta", "app:m1": "one", "app:p": "p"}
+	if len(r.data) != len(want)+1 {
+		t.Errorf("replayed %d keys, want %d", len(r.data), len(want))
+	}
+	for k, v := range want {
+		if got, err := r.Get(ctx, k); err != nil || string(got) != v {
+			t.Errorf("%s = %q, %v, want %q", k, got, err, v)
+		}
+	}
+	if ttl, _ := r.TTL(ctx, "app:p"); ttl != -1 {
+		t.Errorf("persisted key TTL = %v, want -1", ttl)
+	}
+	if ttl, _ := r.TTL(ctx, "app:m1"); ttl <= 0 {
+		t.Errorf("expired key TTL = %v, want > 0", ttl)
+	}
+	if got, want := r.data["app:ttl"].expire, m.data["app:ttl"].expire; !got.Equal(want) {
+		t.Errorf("expire = %v, want %v", got, want)
+	}
+}
+
+func TestMemory_AppendLogTx(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.aof")
+	ctx := context.Background()
+
+	m := openLogged(t, path)
+	c := New[string]("app", "tx", WithDriver[string](m))
+	err := c.Tx(ctx, func(tx Tx[string]) error {
+		tx.Set("a", []byte("1"), 0)
+		tx.Incr("n", 5)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Tx failed: %v", err)
+	}
+	_ = m.Close()
+
+	r := openLogged(t, path)
+	defer r.Close()
+	if n, _ := r.Incr(ctx, "app:tx:n", 0); n != 5 {
+		t.Errorf("n = %d, want 5", n)
+	}
+	if got, _ := r.Get(ctx, "app:tx:a"); string(got) != "1" {
+		t.Errorf("a = %q, want 1", got)
+	}
+}
+
+func TestMemory_AppendLogTruncatedTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.aof")
+	ctx := context.Background()
+
+	m := openLogged(t, path)
+	_ = m.Set(ctx, "a", []byte("1"), 0)
+	_ = m.Set(ctx, "b", []byte("2"), 0)
+	_ = m.Close()
+
+	info, _ := os.Stat(path)
+	for _, cut := range []int64{1, 5, 10} {
+		if err := os.Truncate(path, info.Size()-cut); err != nil {
+			t.Fatal(err)
+		}
+
+		r := openLogged(t, path)
+		if got, _ := r.Get(ctx, "a"); string(got) != "1" {
+			t.Errorf("cut %d: a = %q, want 1", cut, got)
+		}
+		if exists, _ := r.Exists(ctx, "b"); exists {
+			t.Errorf("cut %d: torn record for b should be dropped", cut)
+		}
+		// New writes land after the last complete frame.
+		_ = r.Set(ctx, "b", []byte("3"), 0)
+		_ = r.Close()
+
+		r = openLogged(t, path)
+		if got, _ := r.Get(ctx, "b"); string(got) != "3" {
+			t.Errorf("cut %d: b after reopen = %q, want 3", cut, got)
+		}
+		_ = r.Close()
+		info, _ = os.Stat(path)
+	}
+}
+
+func TestMemory_AppendLogCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.aof")
+	ctx := context.Background()
+
+	m := openLogged(t, path)
+	_ = m.Set(ctx, "a", []byte("first"), 0)
+	_ = m.Set(ctx, "b", []byte("second"), 0)
+	_ = m.Close()
+
+	// Damage the first frame's payload; the second frame is intact, so this
+	// is not a torn tail.
+	data, _ := os.ReadFile(path)
+	data[len(aofMagic)+2+aofFrameHeader+3] ^= 0xff
+	_ = os.WriteFile(path, data, 0o644)
+
+	if _, err := OpenMemory(WithAppendLog(path, FsyncAlways)); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("expected ErrCorrupt, got %v", err)
+	}
+
+	_ = os.WriteFile(path, []byte("garbage"), 0o644)
+	if _, err := OpenMemory(WithAppendLog(path, FsyncAlways)); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("expected ErrCorrupt for bad header, got %v", err)
+	}
+}
+
+func TestMemory_AppendLogFsyncPolicies(t *testing.T) {
+	ctx := context.Background()
+	for _, policy := range []FsyncPolicy{FsyncAlways, FsyncEverySecond, FsyncNever} {
+		path := filepath.Join(t.TempDir(), "store.aof")
+		m, err := OpenMemory(WithAppendLog(path, policy))
+		if err != nil {
+			t.Fatalf("policy %d: OpenMemory failed: %v", policy, err)
+		}
+		_ = m.Set(ctx, "k", []byte("v"), 0)
+		if err := m.Close(); err != nil {
+			t.Fatalf("policy %d: Close failed: %v", policy, err)
+		}
+
+		r := openLogged(t, path)
+		if got, _ := r.Get(ctx, "k"); string(got) != "v" {
+			t.Errorf("policy %d: k = %q", policy, got)
+		}
+		_ = r.Close()
+	}
+}
+
+func TestMemory_CompactLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.aof")
+	ctx := context.Background()
+
+	m := openLogged(t, path)
+	for i := 0; i < 500; i++ {
+		_ = m.Set(ctx, fmt.Sprintf("k:%d", i%10), []byte(fmt.Sprint(i)), 0)
+	}
+	_ = m.Set(ctx, "short", []byte("x"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	before, _ := os.Stat(path)
+
+	if err := m.CompactLog(); err != nil {
+		t.Fatalf("CompactLog failed: %v", err)
+	}
+	after, _ := os.Stat(path)
+	if after.Size() >= before.Size()/5 {
+		t.Errorf("log shrank from %d to %d bytes only", before.Size(), after.Size())
+	}
+
+	_ = m.Set(ctx, "k:0", []byte("new"), 0)
+	_ = m.Close()
+
+	r := openLogged(t, path)
+	defer r.Close()
+	if len(r.data) != 10 {
+		t.Errorf("replayed %d keys, want 10", len(r.data))
+	}
+	if got, _ := r.Get(ctx, "k:0"); string(got) != "new" {
+		t.Errorf("k:0 = %q, want new", got)
+	}
+	if got, _ := r.Get(ctx, "k:9"); string(got) != "499" {
+		t.Errorf("k:9 = %q, want 499", got)
+	}
+
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("temporary files left behind: %v", entries)
+	}
+}
+
+func TestMemory_CompactLogConcurrentWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.aof")
+	ctx := context.Background()
+
+	m := openLogged(t, path)
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				_ = m.Set(ctx, fmt.Sprintf("g%d:%d", g, i), []byte("v"), 0)
+			}
+		}(g)
+	}
+	for i := 0; i < 5; i++ {
+		if err := m.CompactLog(); err != nil {
+			t.Errorf("CompactLog failed: %v", err)
+		}
+	}
+	wg.Wait()
+	_ = m.Close()
+
+	r := openLogged(t, path)
+	defer r.Close()
+	if len(r.data) != 800 {
+		t.Errorf("replayed %d keys, want 800", len(r.data))
+	}
+}
+
+func TestMemory_AppendLogWithSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	snap, log := filepath.Join(dir, "store.snap"), filepath.Join(dir, "store.aof")
+	ctx := context.Background()
+
+	m := openLogged(t, log, WithSnapshotFile(snap, 0))
+	_ = m.Set(ctx, "a", []byte("1"), 0)
+	_ = m.SaveSnapshot(snap)
+	_ = m.Delete(ctx, "a")
+	_ = m.CompactLog()
+	_ = m.Set(ctx, "b", []byte("2"), 0)
+	_ = m.Close()
+
+	// The snapshot written by Close matches the log; load an older one to
+	// check that the compacted log alone determines the contents.
+	old := NewMemory().(*Memory)
+	_ = old.Set(ctx, "a", []byte("1"), 0)
+	_ = old.SaveSnapshot(snap)
+
+	r := openLogged(t, log, WithSnapshotFile(snap, 0))
+	defer r.Close()
+	if exists, _ := r.Exists(ctx, "a"); exists {
+		t.Error("key deleted before compaction came back from the snapshot")
+	}
+	if got, _ := r.Get(ctx, "b"); string(got) != "2" {
+		t.Errorf("b = %q, want 2", got)
+	}
+}
+
+func TestMemory_AppendLogRestore(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	src := NewMemory().(*Memory)
+	_ = src.Set(ctx, "restored", []byte("yes"), 0)
+	snap := filepath.Join(dir, "src.snap")
+	_ = src.SaveSnapshot(snap)
+
+	path := filepath.Join(dir, "store.aof")
+	m := openLogged(t, path)
+	_ = m.Set(ctx, "old", []byte("x"), 0)
+	if err := m.LoadSnapshot(snap); err != nil {
+		t.Fatalf("LoadSnapshot failed: %v", err)
+	}
+	_ = m.Close()
+
+	r := openLogged(t, path)
+	defer r.Close()
+	if exists, _ := r.Exists(ctx, "old"); exists {
+		t.Error("Restore should be logged as replacing the contents")
+	}
+	if got, _ := r.Get(ctx, "restored"); string(got) != "yes" {
+		t.Errorf("restored = %q", got)
+	}
+}
+
+func TestMemory_CompactLogWithoutLog(t *testing.T) {
+	m := NewMemory().(*Memory)
+	if err := m.CompactLog(); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("expected ErrUnsupported, got %v", err)
+	}
+}
+
+func TestMemory_AutomaticLogCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.aof")
+	ctx := context.Background()
+
+	m := openLogged(t, path, WithLogCompaction(1024))
+	defer m.Close()
+	for i := 0; i < 200; i++ {
+		_ = m.Set(ctx, "k", []byte(fmt.Sprint(i)), 0)
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		info, _ := os.Stat(path)
+		if info.Size() < 1024 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("log was not compacted, size %d", info.Size())
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -392,6 +392,100 @@ func BenchmarkMemory_ConcurrentMixed(b *testing.B) {
 	})
 }
 
+// Benchmark lock contention: Memory (single RWMutex) vs ShardedMemory.
+
+func BenchmarkContention_Incr_Memory(b *testing.B) {
+	benchmarkContendedIncr(b, NewMemory())
+}
+
+func BenchmarkContention_Incr_Sharded(b *testing.B) {
+	benchmarkContendedIncr(b, NewShardedMemory(0))
+}
+
+func BenchmarkContention_Mixed_Memory(b *testing.B) {
+	benchmarkContendedMixed(b, NewMemory())
+}
+
+func BenchmarkContention_Mixed_Sharded(b *testing.B) {
+	benchmarkContendedMixed(b, NewShardedMemory(0))
+}
+
+func BenchmarkContention_MSet_Memory(b *testing.B) {
+	benchmarkContendedMSet(b, NewMemory())
+}
+
+func BenchmarkContention_MSet_Sharded(b *testing.B) {
+	benchmarkContendedMSet(b, NewShardedMemory(0))
+}
+
+func contentionKeys(n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = "counter:" + strconv.Itoa(i)
+	}
+	return keys
+}
+
+func benchmarkContendedIncr(b *testing.B, d Driver) {
+	ctx := context.Background()
+	keys := contentionKeys(1024)
+
+	b.SetParallelism(64)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			_, _ = d.Incr(ctx, keys[i%len(keys)], 1)
+			i += 7
+		}
+	})
+}
+
+func benchmarkContendedMixed(b *testing.B, d Driver) {
+	ctx := context.Background()
+	keys := contentionKeys(1024)
+	value := []byte("benchmark-value")
+	for _, k := range keys {
+		_ = d.Set(ctx, k, value, 0)
+	}
+
+	b.SetParallelism(64)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			key := keys[i%len(keys)]
+			if i%4 == 0 {
+				_ = d.Set(ctx, key, value, 0)
+			} else {
+				_, _ = d.Get(ctx, key)
+			}
+			i += 7
+		}
+	})
+}
+
+func benchmarkContendedMSet(b *testing.B, d Driver) {
+	ctx := context.Background()
+	keys := contentionKeys(1024)
+	value := []byte("benchmark-value")
+
+	b.SetParallelism(64)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			pairs := map[string][]byte{
+				keys[i%len(keys)]:     value,
+				keys[(i+1)%len(keys)]: value,
+				keys[(i+2)%len(keys)]: value,
+			}
+			_ = d.MSet(ctx, pairs, 0)
+			i += 7
+		}
+	})
+}
+
 // Benchmark different value sizes.
 
 func BenchmarkMemory_Set_SmallValue(b *testing.B) {
@@ -0,0 +1,219 @@
+package namestore
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultBreakerThreshold is the number of consecutive failures that
+	// opens a CircuitBreaker.
+	DefaultBreakerThreshold = 5
+	// DefaultBreakerCooldown is how long a CircuitBreaker stays open before
+	// letting a probe through.
+	DefaultBreakerCooldown = 10 * time.Second
+)
+
+// BreakerState is the state of a CircuitBreaker.
+type BreakerState int
+
+const (
+	// BreakerClosed lets every operation through.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen fails every operation with ErrCircuitOpen.
+	BreakerOpen
+	// BreakerHalfOpen lets one probe through and fails the others; the
+	// probe's outcome closes or reopens the circuit.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// BreakerOption customizes a CircuitBreaker.
+type BreakerOption func(*breakerConfig)
+
+type breakerConfig struct {
+	threshold int
+	cooldown  time.Duration
+	failure   func(error) bool
+	clock     Clock
+}
+
+// WithBreakerThreshold sets how many consecutive failures open the circuit.
+// Defaults to DefaultBreakerThreshold.
+func WithBreakerThreshold(n int) BreakerOption {
+	return func(c *breakerConfig) {
+		if n > 0 {
+			c.threshold = n
+		}
+	}
+}
+
+// WithBreakerCooldown sets how long the circuit stays open before a probe.
+// Defaults to DefaultBreakerCooldown.
+func WithBreakerCooldown(d time.Duration) BreakerOption {
+	return func(c *breakerConfig) {
+		if d > 0 {
+			c.cooldown = d
+		}
+	}
+}
+
+// WithBreakerFailureIf sets the classifier deciding which errors count as
+// failures; other errors, like ErrNotFound, count as successes. Defaults to
+// IsTransient.
+func WithBreakerFailureIf(failure func(error) bool) BreakerOption {
+	return func(c *breakerConfig) {
+		if failure != nil {
+			c.failure = failure
+		}
+	}
+}
+
+// WithBreakerClock makes the breaker time its cooldown with clock instead of
+// time.Now.
+func WithBreakerClock(clock Clock) BreakerOption {
+	return func(c *breakerConfig) {
+		c.clock = clock
+	}
+}
+
+// CircuitBreaker stops calling a failing driver for a while, so callers fail
+// fast with ErrCircuitOpen instead of waiting on timeouts. It opens after a
+// run of consecutive failures, and after the cooldown lets a single probe
+// through: the circuit closes if the probe succeeds and reopens otherwise.
+// An operation whose ctx is done by the time it fails counts as neither.
+//
+// Wrap is a Middleware, and all drivers it wraps share the breaker's state:
+//
+//	breaker := namestore.NewCircuitBreaker(namestore.WithBreakerThreshold(10))
+//	client := namestore.New[string]("myapp", "users",
+//	    namestore.WithDriver[string](redis),
+//	    namestore.WithMiddleware[string](namestore.Retry(), breaker.Wrap),
+//	)
+//
+// With Retry outside the breaker as above, each attempt counts separately
+// and retries stop as soon as the circuit opens.
+type CircuitBreaker struct {
+	cfg breakerConfig
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// NewCircuitBreaker returns a closed CircuitBreaker.
+func NewCircuitBreaker(opts ...BreakerOption) *CircuitBreaker {
+	cfg := breakerConfig{
+		threshold: DefaultBreakerThreshold,
+		cooldown:  DefaultBreakerCooldown,
+		failure:   IsTransient,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return &CircuitBreaker{cfg: cfg}
+}
+
+// Wrap returns d guarded by the breaker.
+func (b *CircuitBreaker) Wrap(d Driver) Driver {
+	return Intercept(b.intercept)(d)
+}
+
+// State returns the current state. An open circuit whose cooldown has passed
+// reports BreakerHalfOpen.
+func (b *CircuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen && !b.now().Before(b.openedAt.Add(b.cfg.cooldown)) {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
+
+func (b *CircuitBreaker) now() time.Time {
+	if b.cfg.clock == nil {
+		return time.Now()
+	}
+	return b.cfg.clock.Now()
+}
+
+func (b *CircuitBreaker) intercept(ctx context.Context, op OpInfo, next func(context.Context) error) error {
+	probe, err := b.admit()
+	if err != nil {
+		return err
+	}
+	err = next(ctx)
+	b.record(probe, err != nil && ctx.Err() != nil, err != nil && b.cfg.failure(err))
+	return err
+}
+
+// admit reports whether an operation may run, and whether it is the probe.
+func (b *CircuitBreaker) admit() (probe bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if b.now().Before(b.openedAt.Add(b.cfg.cooldown)) {
+			return false, ErrCircuitOpen
+		}
+		b.state = BreakerHalfOpen
+	case BreakerHalfOpen:
+		if b.probing {
+			return false, ErrCircuitOpen
+		}
+	default:
+		return false, nil
+	}
+	b.probing = true
+	return true, nil
+}
+
+// record updates the state with an operation's outcome. canceled
+// operations, which failed after their ctx was done, are not counted.
+func (b *CircuitBreaker) record(probe, canceled, failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if probe {
+		b.probing = false
+	}
+	if canceled {
+		return
+	}
+	if probe {
+		if failed {
+			b.open()
+		} else {
+			b.state, b.failures = BreakerClosed, 0
+		}
+		return
+	}
+	// Operations admitted before the circuit opened do not affect it.
+	if b.state != BreakerClosed {
+		return
+	}
+	if !failed {
+		b.failures = 0
+		return
+	}
+	if b.failures++; b.failures >= b.cfg.threshold {
+		b.open()
+	}
+}
+
+func (b *CircuitBreaker) open() {
+	b.state, b.openedAt, b.failures = BreakerOpen, b.now(), 0
+}
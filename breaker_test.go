@@ -0,0 +1,157 @@
+package namestore_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"code.byted.org/khicago/namestore"
+	"code.byted.org/khicago/namestore/namestoretest"
+)
+
+func TestCircuitBreaker_OpenAndProbe(t *testing.T) {
+	ctx := context.Background()
+	clock := namestoretest.NewFakeClock(time.Time{})
+	breaker := namestore.NewCircuitBreaker(
+		namestore.WithBreakerThreshold(3),
+		namestore.WithBreakerCooldown(time.Second),
+		namestore.WithBreakerClock(clock))
+	d := &failingDriver{DriverBase: namestore.DriverBase{Next: namestore.NewMemory()}, err: errReset}
+	c := namestore.New[string]("app", "users", namestore.WithDriver[string](d), namestore.WithMiddleware[string](breaker.Wrap))
+	_ = d.Next.Set(ctx, "app:users:1", []byte("v"), 0)
+
+	// Successes and data errors reset the failure count.
+	d.n = 2
+	_ = c.Set(ctx, "1", []byte("v"), 0)
+	_ = c.Set(ctx, "1", []byte("v"), 0)
+	_, _ = c.Get(ctx, "missing")
+	d.n = 2
+	_ = c.Set(ctx, "1", []byte("v"), 0)
+	_ = c.Set(ctx, "1", []byte("v"), 0)
+	if s := breaker.State(); s != namestore.BreakerClosed {
+		t.Fatalf("state = %v after non-consecutive failures", s)
+	}
+
+	d.n = 1
+	_ = c.Set(ctx, "1", []byte("v"), 0)
+	if s := breaker.State(); s != namestore.BreakerOpen {
+		t.Fatalf("state = %v after 3 consecutive failures", s)
+	}
+	calls := d.calls
+	if _, err := c.Get(ctx, "1"); !errors.Is(err, namestore.ErrCircuitOpen) || d.calls != calls {
+		t.Errorf("Get while open = %v, driver called %d times", err, d.calls-calls)
+	}
+
+	// A failed probe reopens the circuit for another cooldown.
+	clock.Advance(time.Second)
+	if s := breaker.State(); s != namestore.BreakerHalfOpen {
+		t.Fatalf("state = %v after cooldown", s)
+	}
+	d.n = 1
+	if _, err := c.Get(ctx, "1"); !errors.Is(err, errReset) {
+		t.Errorf("probe = %v", err)
+	}
+	if _, err := c.Get(ctx, "1"); !errors.Is(err, namestore.ErrCircuitOpen) {
+		t.Errorf("Get after failed probe = %v", err)
+	}
+
+	// A successful probe closes it.
+	clock.Advance(time.Second)
+	if v, err := c.Get(ctx, "1"); err != nil || string(v) != "v" {
+		t.Errorf("probe = %q, %v", v, err)
+	}
+	if s := breaker.State(); s != namestore.BreakerClosed {
+		t.Errorf("state = %v after successful probe", s)
+	}
+}
+
+// blockingDriver blocks Get until release is closed.
+type blockingDriver struct {
+	namestore.DriverBase
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (d *blockingDriver) Get(ctx context.Context, key string) ([]byte, error) {
+	d.entered <- struct{}{}
+	<-d.release
+	return nil, namestore.ErrNotFound
+}
+
+func TestCircuitBreaker_SingleProbe(t *testing.T) {
+	ctx := context.Background()
+	clock := namestoretest.NewFakeClock(time.Time{})
+	breaker := namestore.NewCircuitBreaker(namestore.WithBreakerThreshold(1), namestore.WithBreakerClock(clock))
+	failing := breaker.Wrap(&failingDriver{DriverBase: namestore.DriverBase{Next: namestore.NewMemory()}, n: 1, err: errReset})
+	blocking := &blockingDriver{entered: make(chan struct{}), release: make(chan struct{})}
+	probing := breaker.Wrap(blocking)
+
+	// Drivers wrapped by the same breaker share its state.
+	if _, err := failing.Get(ctx, "k"); !errors.Is(err, errReset) {
+		t.Fatal(err)
+	}
+	clock.Advance(namestore.DefaultBreakerCooldown)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, _ = probing.Get(ctx, "k")
+	}()
+	<-blocking.entered
+	if _, err := failing.Get(ctx, "k"); !errors.Is(err, namestore.ErrCircuitOpen) {
+		t.Errorf("Get during probe = %v", err)
+	}
+	close(blocking.release)
+	wg.Wait()
+
+	// ErrNotFound is a healthy reply.
+	if s := breaker.State(); s != namestore.BreakerClosed {
+		t.Errorf("state = %v", s)
+	}
+}
+
+func TestCircuitBreaker_CanceledProbe(t *testing.T) {
+	clock := namestoretest.NewFakeClock(time.Time{})
+	breaker := namestore.NewCircuitBreaker(namestore.WithBreakerThreshold(1), namestore.WithBreakerClock(clock))
+	d := &failingDriver{DriverBase: namestore.DriverBase{Next: namestore.NewMemory()}, n: 2, err: errReset}
+	wrapped := breaker.Wrap(d)
+
+	_, _ = wrapped.Get(context.Background(), "k")
+	clock.Advance(namestore.DefaultBreakerCooldown)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _ = wrapped.Get(ctx, "k")
+	if s := breaker.State(); s != namestore.BreakerHalfOpen {
+		t.Errorf("state = %v after a canceled probe, want another probe allowed", s)
+	}
+}
+
+func TestCircuitBreaker_WithRetry(t *testing.T) {
+	breaker := namestore.NewCircuitBreaker(namestore.WithBreakerThreshold(2))
+	d := &failingDriver{DriverBase: namestore.DriverBase{Next: namestore.NewMemory()}, n: 10, err: errReset}
+	c := namestore.New[string]("app", "users",
+		namestore.WithDriver[string](d),
+		namestore.WithMiddleware[string](
+			namestore.Retry(namestore.WithRetryAttempts(5), namestore.WithRetryBackoff(time.Millisecond, time.Millisecond)),
+			breaker.Wrap))
+
+	if _, err := c.Get(context.Background(), "k"); !errors.Is(err, namestore.ErrCircuitOpen) || d.calls != 2 {
+		t.Errorf("Get = %v after %d calls, want ErrCircuitOpen after 2", err, d.calls)
+	}
+}
+
+func TestBreakerState_String(t *testing.T) {
+	for s, want := range map[namestore.BreakerState]string{
+		namestore.BreakerClosed:   "closed",
+		namestore.BreakerOpen:     "open",
+		namestore.BreakerHalfOpen: "half-open",
+		namestore.BreakerState(9): "unknown",
+	} {
+		if got := s.String(); got != want {
+			t.Errorf("%d.String() = %q, want %q", int(s), got, want)
+		}
+	}
+}
@@ -1,15 +1,25 @@
-package namestore
+package namestore_test
 
 import (
 	"context"
 	"errors"
 	"testing"
 	"time"
+
+	"code.byted.org/khicago/namestore"
+	"code.byted.org/khicago/namestore/namestoretest"
 )
 
+// newClockedClient returns a client on a Memory driven by a fake clock.
+func newClockedClient() (namestore.Client[string], *namestoretest.FakeClock) {
+	clock := namestoretest.NewFakeClock(time.Time{})
+	d := namestore.NewMemory(namestore.WithClock(clock))
+	return namestore.New[string]("root", "domain", namestore.WithDriver[string](d)), clock
+}
+
 // TestClient_TTL tests retrieving the time-to-live for keys.
 func TestClient_TTL(t *testing.T) {
-	c := New[string]("root", "domain")
+	c, clock := newClockedClient()
 	ctx := context.Background()
 
 	// Key with TTL.
@@ -19,8 +29,13 @@ func TestClient_TTL(t *testing.T) {
 		t.Fatalf("TTL failed: %v", err)
 	}
 
-	if ttl <= 0 || ttl > 100*time.Millisecond {
-		t.Errorf("TTL = %v, expected > 0 and <= 100ms", ttl)
+	if ttl != 100*time.Millisecond {
+		t.Errorf("TTL = %v, want 100ms", ttl)
+	}
+
+	clock.Advance(40 * time.Millisecond)
+	if ttl, _ := c.TTL(ctx, "key1"); ttl != 60*time.Millisecond {
+		t.Errorf("TTL after 40ms = %v, want 60ms", ttl)
 	}
 
 	// Key without TTL.
@@ -36,14 +51,14 @@ func TestClient_TTL(t *testing.T) {
 
 	// Missing key.
 	_, err = c.TTL(ctx, "missing")
-	if !errors.Is(err, ErrNotFound) {
+	if !errors.Is(err, namestore.ErrNotFound) {
 		t.Errorf("TTL for missing key: expected ErrNotFound, got %v", err)
 	}
 }
 
 // TestClient_Expire tests adding expiration to existing keys.
 func TestClient_Expire(t *testing.T) {
-	c := New[string]("root", "domain")
+	c, clock := newClockedClient()
 	ctx := context.Background()
 
 	// Set key without TTL.
@@ -61,9 +76,13 @@ func TestClient_Expire(t *testing.T) {
 		t.Error("key1 should exist after Expire")
 	}
 
-	// Wait for expiration.
-	time.Sleep(20 * time.Millisecond)
+	// The key lives until the clock passes its expiration.
+	clock.Advance(10 * time.Millisecond)
+	if exists, _ := c.Exists(ctx, "key1"); !exists {
+		t.Error("key1 should exist until its TTL has passed")
+	}
 
+	clock.Advance(time.Nanosecond)
 	exists, _ = c.Exists(ctx, "key1")
 	if exists {
 		t.Error("key1 should expire after TTL")
@@ -72,18 +91,18 @@ func TestClient_Expire(t *testing.T) {
 
 // TestClient_Expire_MissingKey tests Expire on a non-existent key.
 func TestClient_Expire_MissingKey(t *testing.T) {
-	c := New[string]("root", "domain")
+	c := namestore.New[string]("root", "domain")
 	ctx := context.Background()
 
 	err := c.Expire(ctx, "missing", 10*time.Second)
-	if !errors.Is(err, ErrNotFound) {
+	if !errors.Is(err, namestore.ErrNotFound) {
 		t.Errorf("Expire for missing key: expected ErrNotFound, got %v", err)
 	}
 }
 
 // TestClient_Persist tests removing expiration from keys.
 func TestClient_Persist(t *testing.T) {
-	c := New[string]("root", "domain")
+	c, clock := newClockedClient()
 	ctx := context.Background()
 
 	// Set key with TTL.
@@ -95,8 +114,8 @@ func TestClient_Persist(t *testing.T) {
 		t.Fatalf("Persist failed: %v", err)
 	}
 
-	// Wait longer than original TTL.
-	time.Sleep(20 * time.Millisecond)
+	// Move past the original TTL.
+	clock.Advance(20 * time.Millisecond)
 
 	// Key should still exist.
 	exists, _ := c.Exists(ctx, "key1")
@@ -113,11 +132,11 @@ func TestClient_Persist(t *testing.T) {
 
 // TestClient_Persist_MissingKey tests Persist on a non-existent key.
 func TestClient_Persist_MissingKey(t *testing.T) {
-	c := New[string]("root", "domain")
+	c := namestore.New[string]("root", "domain")
 	ctx := context.Background()
 
 	err := c.Persist(ctx, "missing")
-	if !errors.Is(err, ErrNotFound) {
+	if !errors.Is(err, namestore.ErrNotFound) {
 		t.Errorf("Persist for missing key: expected ErrNotFound, got %v", err)
 	}
 }
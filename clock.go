@@ -0,0 +1,37 @@
+package namestore
+
+import "time"
+
+// Clock tells the time used for expirations. Tests can substitute a manual
+// clock, such as namestoretest.FakeClock, to expire keys without sleeping.
+type Clock interface {
+	Now() time.Time
+}
+
+// WithClock makes Memory read the current time from clock instead of
+// time.Now, both to compute expiration times and to decide whether a key
+// has expired. It applies to every shard of a ShardedMemory, and to the
+// local tier of a NearCache through WithNearCacheMemory. The janitor still
+// runs on a real ticker, but judges expiration by clock.
+func WithClock(clock Clock) MemoryOption {
+	return func(c *memoryConfig) {
+		c.clock = clock
+	}
+}
+
+// now returns the configured clock's time, or time.Now without one.
+func (c memoryConfig) now() time.Time {
+	if c.clock == nil {
+		return time.Now()
+	}
+	return c.clock.Now()
+}
+
+// expiry returns the expiration time of a key written at now with ttl, or
+// the zero time when ttl is not positive.
+func expiry(now time.Time, ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return now.Add(ttl)
+}
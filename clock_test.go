@@ -0,0 +1,141 @@
+package namestore_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"code.byted.org/khicago/namestore"
+	"code.byted.org/khicago/namestore/namestoretest"
+)
+
+// expectExpiry checks that key lives for exactly ttl on clock.
+func expectExpiry(t *testing.T, d namestore.Driver, clock *namestoretest.FakeClock, key string, ttl time.Duration) {
+	t.Helper()
+	ctx := context.Background()
+	if got, err := d.TTL(ctx, key); err != nil || got != ttl {
+		t.Fatalf("TTL(%q) = %v, %v; want %v", key, got, err, ttl)
+	}
+	clock.Advance(ttl)
+	if _, err := d.Get(ctx, key); err != nil {
+		t.Fatalf("Get(%q) at expiration = %v", key, err)
+	}
+	clock.Advance(time.Nanosecond)
+	if _, err := d.Get(ctx, key); !errors.Is(err, namestore.ErrNotFound) {
+		t.Fatalf("Get(%q) after expiration = %v, want ErrNotFound", key, err)
+	}
+}
+
+func TestWithClock_Memory(t *testing.T) {
+	clock := namestoretest.NewFakeClock(time.Time{})
+	d := namestore.NewMemory(namestore.WithClock(clock))
+	ctx := context.Background()
+
+	_ = d.Set(ctx, "app:c:set", []byte("v"), time.Hour)
+	expectExpiry(t, d, clock, "app:c:set", time.Hour)
+
+	_ = d.MSet(ctx, map[string][]byte{"app:c:a": []byte("1"), "app:c:b": []byte("2")}, time.Minute)
+	clock.Advance(time.Minute + time.Nanosecond)
+	if got, _ := d.MGet(ctx, []string{"app:c:a", "app:c:b"}); len(got) != 0 {
+		t.Errorf("MGet after expiration = %q", got)
+	}
+	if keys, _ := d.Keys(ctx, "app:c", "*"); len(keys) != 0 {
+		t.Errorf("Keys after expiration = %q", keys)
+	}
+
+	_ = d.Set(ctx, "app:c:n", make([]byte, 8), 0)
+	_ = d.Expire(ctx, "app:c:n", time.Second)
+	if n, _ := d.Incr(ctx, "app:c:n", 1); n != 1 {
+		t.Errorf("Incr = %d, want 1", n)
+	}
+	expectExpiry(t, d, clock, "app:c:n", time.Second)
+}
+
+func TestWithClock_ShardedMemory(t *testing.T) {
+	clock := namestoretest.NewFakeClock(time.Time{})
+	d := namestore.NewShardedMemory(4, namestore.WithClock(clock))
+	ctx := context.Background()
+
+	pairs := make(map[string][]byte)
+	for _, k := range []string{"a", "b", "c", "d", "e", "f"} {
+		pairs["app:c:"+k] = []byte(k)
+	}
+	_ = d.MSet(ctx, pairs, time.Second)
+	expectExpiry(t, d, clock, "app:c:a", time.Second)
+	if got, _ := d.MGet(ctx, []string{"app:c:b", "app:c:f"}); len(got) != 0 {
+		t.Errorf("MGet after expiration = %q", got)
+	}
+}
+
+func TestWithClock_NearCache(t *testing.T) {
+	clock := namestoretest.NewFakeClock(time.Time{})
+	l2 := namestore.NewMemory(namestore.WithClock(clock))
+	d := namestore.NewNearCache(l2, namestore.WithNearCacheMemory(namestore.WithClock(clock)))
+	defer d.Close()
+	ctx := context.Background()
+
+	_ = d.Set(ctx, "app:c:k", []byte("v"), time.Second)
+	if _, err := d.Get(ctx, "app:c:k"); err != nil {
+		t.Fatal(err)
+	}
+	// The local copy expires together with the remote value.
+	expectExpiry(t, d, clock, "app:c:k", time.Second)
+}
+
+func TestWithFileClock(t *testing.T) {
+	clock := namestoretest.NewFakeClock(time.Now())
+	d, err := namestore.NewFileDriver(t.TempDir(), namestore.WithFileClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	ctx := context.Background()
+
+	_ = d.Set(ctx, "app:c:k", []byte("v"), time.Hour)
+	expectExpiry(t, d, clock, "app:c:k", time.Hour)
+	if ok, _ := d.SetNX(ctx, "app:c:k", []byte("w"), 0); !ok {
+		t.Error("SetNX over an expired record should succeed")
+	}
+}
+
+func TestWithFileClock_SweepResumes(t *testing.T) {
+	clock := namestoretest.NewFakeClock(time.Time{})
+	d, err := namestore.NewFileDriver(t.TempDir(), namestore.WithFileClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	ctx := context.Background()
+
+	for i := 0; i < 4; i++ {
+		_ = d.Set(ctx, fmt.Sprintf("app:a%d", i), []byte("v"), 0)
+	}
+	_ = d.Set(ctx, "app:z", []byte("v"), time.Second)
+	clock.Advance(time.Second + time.Nanosecond)
+
+	// Each batch visits two records and picks up after the previous one.
+	path, _ := namestore.FileRecordPath(d, "app:z")
+	for i := 0; i < 2; i++ {
+		if done := namestore.FileSweepBatch(d, 2); done {
+			t.Fatalf("batch %d reached the end early", i)
+		}
+		if _, err := os.Stat(path); err != nil {
+			t.Fatalf("batch %d removed a record it should not have visited", i)
+		}
+	}
+	if done := namestore.FileSweepBatch(d, 2); !done {
+		t.Error("last batch did not reach the end")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("expired record was not removed")
+	}
+	if pos := namestore.FileSweepPosition(d); pos != "" {
+		t.Errorf("sweep position %q not reset at the end", pos)
+	}
+	if keys, _ := d.Keys(ctx, "app", "*"); len(keys) != 4 {
+		t.Errorf("Keys = %v, want the persistent records", keys)
+	}
+}
@@ -0,0 +1,113 @@
+// Command namestore-server serves a namestore driver over the Redis protocol
+// for local development and integration tests.
+//
+// By default it serves an in-memory store; -dir serves a file-backed store
+// instead, and -appendlog makes the in-memory store durable:
+//
+//	namestore-server -addr 127.0.0.1:6379
+//	namestore-server -dir ./data
+//	namestore-server -appendlog ./namestore.aof
+//
+// SIGINT or SIGTERM shuts the server down gracefully.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"code.byted.org/khicago/namestore"
+	"code.byted.org/khicago/namestore/nsresp"
+)
+
+func main() {
+	var (
+		addr        = flag.String("addr", "127.0.0.1:6379", "TCP address to listen on")
+		maxClients  = flag.Int("maxclients", nsresp.DefaultMaxConns, "maximum number of client connections")
+		idleTimeout = flag.Duration("timeout", 0, "close connections idle for this long (0 keeps them open)")
+		dir         = flag.String("dir", "", "serve a file-backed store rooted at this directory")
+		appendLog   = flag.String("appendlog", "", "persist the in-memory store to this append-only log")
+		grace       = flag.Duration("shutdown-timeout", 10*time.Second, "how long to wait for clients on shutdown")
+	)
+	flag.Parse()
+
+	if err := run(*addr, *maxClients, *idleTimeout, *dir, *appendLog, *grace); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(addr string, maxClients int, idleTimeout time.Duration, dir, appendLog string, grace time.Duration) error {
+	driver, err := openDriver(dir, appendLog)
+	if err != nil {
+		return err
+	}
+	defer driver.Close()
+
+	srv := nsresp.NewServer(driver,
+		nsresp.WithMaxConns(maxClients),
+		nsresp.WithIdleTimeout(idleTimeout),
+		nsresp.WithLogger(stdLogger{}),
+	)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	errc := make(chan error, 1)
+	go func() { errc <- srv.ListenAndServe(addr) }()
+	log.Printf("namestore-server listening on %s", addr)
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	log.Print("namestore-server shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-errc; !errors.Is(err, nsresp.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// openDriver opens the driver selected by the flags.
+func openDriver(dir, appendLog string) (interface {
+	namestore.Driver
+	io.Closer
+}, error) {
+	switch {
+	case dir != "" && appendLog != "":
+		return nil, errors.New("-dir and -appendlog cannot be combined")
+	case dir != "":
+		return namestore.NewFileDriver(dir)
+	case appendLog != "":
+		return namestore.OpenMemory(namestore.WithAppendLog(appendLog, namestore.FsyncEverySecond))
+	default:
+		return namestore.OpenMemory()
+	}
+}
+
+// stdLogger writes server messages with the standard log package.
+type stdLogger struct{}
+
+func (stdLogger) Info(ctx context.Context, format string, args ...interface{}) {
+	log.Printf("INFO: "+format, args...)
+}
+
+func (stdLogger) Warn(ctx context.Context, format string, args ...interface{}) {
+	log.Printf("WARN: "+format, args...)
+}
+
+func (stdLogger) Error(ctx context.Context, format string, args ...interface{}) {
+	log.Printf("ERROR: "+format, args...)
+}
+
+func (stdLogger) Debug(ctx context.Context, format string, args ...interface{}) {}
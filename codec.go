@@ -0,0 +1,71 @@
+package namestore
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+)
+
+// Codec converts values to and from their stored byte representation.
+// CompareAndSwap on a TypedClient compares encoded bytes, so codecs should
+// encode equal values identically.
+type Codec[T any] interface {
+	Encode(value T) ([]byte, error)
+	Decode(data []byte) (T, error)
+}
+
+// JSONCodec returns a Codec that stores values as JSON.
+func JSONCodec[T any]() Codec[T] {
+	return jsonCodec[T]{}
+}
+
+type jsonCodec[T any] struct{}
+
+func (jsonCodec[T]) Encode(value T) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+func (jsonCodec[T]) Decode(data []byte) (T, error) {
+	var value T
+	err := json.Unmarshal(data, &value)
+	return value, err
+}
+
+// GobCodec returns a Codec that stores values with encoding/gob. Each value
+// is encoded as a self-contained stream, including its type description.
+// Gob encodes maps in random order, so avoid it for map values used with
+// CompareAndSwap.
+func GobCodec[T any]() Codec[T] {
+	return gobCodec[T]{}
+}
+
+type gobCodec[T any] struct{}
+
+func (gobCodec[T]) Encode(value T) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(value); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec[T]) Decode(data []byte) (T, error) {
+	var value T
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&value)
+	return value, err
+}
+
+// StringCodec returns a Codec that stores strings as their raw bytes.
+func StringCodec[T ~string]() Codec[T] {
+	return stringCodec[T]{}
+}
+
+type stringCodec[T ~string] struct{}
+
+func (stringCodec[T]) Encode(value T) ([]byte, error) {
+	return []byte(value), nil
+}
+
+func (stringCodec[T]) Decode(data []byte) (T, error) {
+	return T(data), nil
+}
@@ -68,5 +68,6 @@
 //	    // Handle missing key
 //	}
 //
-// Available errors: ErrNotFound, ErrTypeMismatch, ErrInvalidPattern
+// Available errors: ErrNotFound, ErrTypeMismatch, ErrInvalidPattern, ErrUnsupported,
+// ErrOutOfMemory, ErrDecode, ErrInvalidCursor, ErrTxConflict, ErrCorrupt, ErrLocked, ErrClosed
 package namestore
@@ -0,0 +1,260 @@
+package namestore
+
+import (
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+)
+
+// EvictionPolicy selects which keys Memory evicts once a capacity limit set
+// by WithMaxEntries or WithMaxBytes is reached. The policies mirror Redis'
+// maxmemory-policy settings and, like Redis, approximate LRU and LFU by
+// sampling a few keys per eviction rather than keeping exact orderings.
+type EvictionPolicy int
+
+const (
+	// NoEviction rejects writes that would exceed a limit with ErrOutOfMemory.
+	NoEviction EvictionPolicy = iota
+	// AllKeysLRU evicts the least recently used key.
+	AllKeysLRU
+	// AllKeysLFU evicts the least frequently used key.
+	AllKeysLFU
+	// VolatileLRU evicts the least recently used key that has a TTL.
+	VolatileLRU
+	// VolatileLFU evicts the least frequently used key that has a TTL.
+	VolatileLFU
+)
+
+// String returns the Redis name of the policy.
+func (p EvictionPolicy) String() string {
+	switch p {
+	case NoEviction:
+		return "noeviction"
+	case AllKeysLRU:
+		return "allkeys-lru"
+	case AllKeysLFU:
+		return "allkeys-lfu"
+	case VolatileLRU:
+		return "volatile-lru"
+	case VolatileLFU:
+		return "volatile-lfu"
+	default:
+		return "unknown"
+	}
+}
+
+const (
+	// evictionSamples is the number of candidate keys compared per eviction.
+	evictionSamples = 5
+	// evictionMaxScan bounds the entries visited while looking for candidates,
+	// which matters for volatile policies on maps with few TTL keys.
+	evictionMaxScan = 100 * evictionSamples
+
+	// LFU counters follow Redis: logarithmic growth starting from lfuInitFreq,
+	// decaying by one every lfuDecayPeriod without access.
+	lfuInitFreq    = 5
+	lfuLogFactor   = 10
+	lfuMaxFreq     = 255
+	lfuDecayPeriod = time.Minute
+)
+
+// WithMaxEntries limits the number of stored keys. Zero means unlimited.
+func WithMaxEntries(n int) MemoryOption {
+	return func(c *memoryConfig) {
+		if n >= 0 {
+			c.maxEntries = n
+		}
+	}
+}
+
+// WithMaxBytes limits the total size of stored keys and values. Zero means
+// unlimited.
+func WithMaxBytes(n int64) MemoryOption {
+	return func(c *memoryConfig) {
+		if n >= 0 {
+			c.maxBytes = n
+		}
+	}
+}
+
+// WithEvictionPolicy selects how keys are evicted when a limit is reached.
+// Defaults to NoEviction. Expired keys are always reclaimed first, whatever
+// the policy.
+func WithEvictionPolicy(policy EvictionPolicy) MemoryOption {
+	return func(c *memoryConfig) {
+		c.policy = policy
+	}
+}
+
+func (c memoryConfig) limited() bool {
+	return c.maxEntries > 0 || c.maxBytes > 0
+}
+
+func (c memoryConfig) volatileOnly() bool {
+	return c.policy == VolatileLRU || c.policy == VolatileLFU
+}
+
+func (c memoryConfig) lfu() bool {
+	return c.policy == AllKeysLFU || c.policy == VolatileLFU
+}
+
+// entryStats records access recency and frequency for eviction. It is shared
+// by copies of an entry and updated atomically, so reads holding only the
+// read lock can record accesses.
+type entryStats struct {
+	lastAccess atomic.Int64 // unix nanoseconds
+	freq       atomic.Uint32
+}
+
+func newEntryStats(now time.Time) *entryStats {
+	s := &entryStats{}
+	s.lastAccess.Store(now.UnixNano())
+	s.freq.Store(lfuInitFreq)
+	return s
+}
+
+// touch records an access. Safe on nil stats, which untracked entries carry.
+func (s *entryStats) touch(now time.Time) {
+	if s == nil {
+		return
+	}
+	freq := s.frequency(now)
+	if freq < lfuMaxFreq {
+		base := float64(freq) - lfuInitFreq
+		if base < 0 {
+			base = 0
+		}
+		if rand.Float64() < 1/(base*lfuLogFactor+1) {
+			freq++
+		}
+	}
+	s.freq.Store(freq)
+	s.lastAccess.Store(now.UnixNano())
+}
+
+// frequency returns the LFU counter decayed by the time since last access.
+func (s *entryStats) frequency(now time.Time) uint32 {
+	freq := s.freq.Load()
+	idle := now.Sub(time.Unix(0, s.lastAccess.Load()))
+	if decay := uint64(idle / lfuDecayPeriod); decay > 0 {
+		if decay >= uint64(freq) {
+			return 0
+		}
+		freq -= uint32(decay)
+	}
+	return freq
+}
+
+// growth describes how a pending write changes the store's footprint.
+type growth struct {
+	entries int   // new keys
+	bytes   int64 // net change in bytes
+	written int   // keys written
+	size    int64 // total bytes of written entries
+}
+
+func (g *growth) add(m *Memory, key string, value []byte) {
+	size := entrySize(key, value)
+	g.written++
+	g.size += size
+	g.bytes += size
+	if old, ok := m.data[key]; ok {
+		g.bytes -= entrySize(key, old.value)
+	} else {
+		g.entries++
+	}
+}
+
+// overLimit reports whether applying g would exceed a limit.
+func (m *Memory) overLimit(g growth) bool {
+	if m.cfg.maxEntries > 0 && len(m.data)+g.entries > m.cfg.maxEntries {
+		return true
+	}
+	return m.cfg.maxBytes > 0 && m.used+g.bytes > m.cfg.maxBytes
+}
+
+// reserveLocked evicts keys until the pending write g fits within the limits.
+// Keys reported by protected are part of the write and never evicted. It
+// returns ErrOutOfMemory if the write cannot fit.
+func (m *Memory) reserveLocked(g growth, protected func(key string) bool) error {
+	victims, err := m.planEvictionsLocked(g, protected)
+	if err != nil {
+		return err
+	}
+	m.evictLocked(victims)
+	return nil
+}
+
+// planEvictionsLocked returns the keys reserveLocked would evict to fit g,
+// without evicting them, so writes spanning several shards can check that
+// every shard has room before any of them changes.
+func (m *Memory) planEvictionsLocked(g growth, protected func(key string) bool) ([]string, error) {
+	if (m.cfg.maxEntries > 0 && g.written > m.cfg.maxEntries) ||
+		(m.cfg.maxBytes > 0 && g.size > m.cfg.maxBytes) {
+		return nil, ErrOutOfMemory
+	}
+
+	var victims []string
+	planned := make(map[string]bool)
+	excluded := func(key string) bool { return planned[key] || protected(key) }
+	for m.overLimit(g) {
+		key, ok := m.evictionCandidateLocked(excluded)
+		if !ok {
+			return nil, ErrOutOfMemory
+		}
+		planned[key] = true
+		victims = append(victims, key)
+		g.entries--
+		g.bytes -= entrySize(key, m.data[key].value)
+	}
+	return victims, nil
+}
+
+func (m *Memory) evictLocked(victims []string) {
+	for _, key := range victims {
+		m.removeLocked(key, EventEvicted)
+	}
+}
+
+// evictionCandidateLocked samples up to evictionSamples eligible keys and
+// returns the best one to evict under the configured policy. An expired key
+// is returned as soon as it is seen; under NoEviction only expired keys are
+// candidates.
+func (m *Memory) evictionCandidateLocked(protected func(key string) bool) (string, bool) {
+	now := m.cfg.now()
+	volatileOnly := m.cfg.volatileOnly()
+	lfu := m.cfg.lfu()
+
+	var (
+		victim   string
+		found    bool
+		bestFreq uint32
+		bestSeen int64
+		sampled  int
+		visited  int
+	)
+	for key, e := range m.data {
+		if visited++; visited > evictionMaxScan || sampled == evictionSamples {
+			break
+		}
+		if protected(key) {
+			continue
+		}
+		if e.expiredAt(now) {
+			return key, true
+		}
+		if m.cfg.policy == NoEviction || (volatileOnly && e.expire.IsZero()) || e.stats == nil {
+			continue
+		}
+		sampled++
+
+		freq, seen := e.stats.frequency(now), e.stats.lastAccess.Load()
+		better := !found ||
+			(lfu && (freq < bestFreq || (freq == bestFreq && seen < bestSeen))) ||
+			(!lfu && seen < bestSeen)
+		if better {
+			victim, found, bestFreq, bestSeen = key, true, freq, seen
+		}
+	}
+	return victim, found
+}
@@ -0,0 +1,234 @@
+package namestore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestMemory_NoEviction(t *testing.T) {
+	m := NewMemory(WithMaxEntries(3)).(*Memory)
+	ctx := context.Background()
+
+	for _, k := range []string{"a", "b", "c"} {
+		if err := m.Set(ctx, k, []byte("v"), 0); err != nil {
+			t.Fatalf("Set(%s) failed: %v", k, err)
+		}
+	}
+
+	if err := m.Set(ctx, "d", []byte("v"), 0); !errors.Is(err, ErrOutOfMemory) {
+		t.Errorf("Set over limit = %v, want ErrOutOfMemory", err)
+	}
+	if ok, err := m.SetNX(ctx, "d", []byte("v"), 0); ok || !errors.Is(err, ErrOutOfMemory) {
+		t.Errorf("SetNX over limit = %v, %v, want ErrOutOfMemory", ok, err)
+	}
+	if _, err := m.Incr(ctx, "counter", 1); !errors.Is(err, ErrOutOfMemory) {
+		t.Errorf("Incr over limit = %v, want ErrOutOfMemory", err)
+	}
+	if err := m.MSet(ctx, map[string][]byte{"a": []byte("x"), "d": []byte("x")}, 0); !errors.Is(err, ErrOutOfMemory) {
+		t.Errorf("MSet over limit = %v, want ErrOutOfMemory", err)
+	}
+	if got, _ := m.Get(ctx, "a"); string(got) != "v" {
+		t.Errorf("failed MSet modified a: %q", got)
+	}
+
+	// Overwrites do not grow the store.
+	if err := m.Set(ctx, "a", []byte("v2"), 0); err != nil {
+		t.Errorf("overwrite failed: %v", err)
+	}
+
+	_ = m.Delete(ctx, "b")
+	if err := m.Set(ctx, "d", []byte("v"), 0); err != nil {
+		t.Errorf("Set after Delete failed: %v", err)
+	}
+}
+
+func TestMemory_NoEvictionReclaimsExpired(t *testing.T) {
+	m := NewMemory(WithMaxEntries(2)).(*Memory)
+	ctx := context.Background()
+
+	_ = m.Set(ctx, "a", []byte("v"), 0)
+	_ = m.Set(ctx, "b", []byte("v"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	if err := m.Set(ctx, "c", []byte("v"), 0); err != nil {
+		t.Fatalf("Set should reclaim the expired key: %v", err)
+	}
+	if exists, _ := m.Exists(ctx, "a"); !exists {
+		t.Error("live key a was evicted")
+	}
+}
+
+func TestMemory_MaxBytes(t *testing.T) {
+	m := NewMemory(WithMaxBytes(20), WithEvictionPolicy(AllKeysLRU)).(*Memory)
+	ctx := context.Background()
+
+	if err := m.Set(ctx, "big", make([]byte, 32), 0); !errors.Is(err, ErrOutOfMemory) {
+		t.Errorf("oversized Set = %v, want ErrOutOfMemory", err)
+	}
+
+	_ = m.Set(ctx, "k1", []byte("12345678"), 0) // 10 bytes
+	_ = m.Set(ctx, "k2", []byte("12345678"), 0) // 10 bytes
+	if m.used != 20 {
+		t.Fatalf("used = %d, want 20", m.used)
+	}
+
+	_ = m.Set(ctx, "k3", []byte("1234"), 0)
+	if len(m.data) != 2 || m.used > 20 {
+		t.Errorf("after eviction: %d entries, %d bytes", len(m.data), m.used)
+	}
+	if exists, _ := m.Exists(ctx, "k3"); !exists {
+		t.Error("written key should be kept")
+	}
+}
+
+func TestMemory_ByteAccounting(t *testing.T) {
+	m := NewMemory().(*Memory)
+	ctx := context.Background()
+
+	_ = m.Set(ctx, "a", []byte("1234"), 0)
+	_ = m.MSet(ctx, map[string][]byte{"b": []byte("12"), "c": []byte("123")}, 0)
+	_, _ = m.Incr(ctx, "n", 1)
+	_, _ = m.GetSet(ctx, "a", []byte("12"))
+	_, _ = m.CompareAndSwap(ctx, "b", []byte("12"), []byte("123456"), 0)
+	_ = m.Set(ctx, "x:1", []byte("1"), 0)
+	_ = m.Delete(ctx, "c")
+
+	var want int64
+	for k, e := range m.data {
+		want += int64(len(k) + len(e.value))
+	}
+	if m.used != want {
+		t.Errorf("used = %d, want %d", m.used, want)
+	}
+
+	_ = m.Clear(ctx, "x")
+	_ = m.MDel(ctx, []string{"a", "b", "n"})
+	if m.used != 0 {
+		t.Errorf("used after removing everything = %d, want 0", m.used)
+	}
+}
+
+func TestMemory_AllKeysLRU(t *testing.T) {
+	// With no more keys than samples, every candidate is compared and the
+	// approximation is exact.
+	m := NewMemory(WithMaxEntries(evictionSamples), WithEvictionPolicy(AllKeysLRU)).(*Memory)
+	ctx := context.Background()
+
+	for i := 0; i < evictionSamples; i++ {
+		_ = m.Set(ctx, fmt.Sprintf("k%d", i), []byte("v"), 0)
+		time.Sleep(time.Millisecond)
+	}
+	_, _ = m.Get(ctx, "k0")
+
+	_ = m.Set(ctx, "new", []byte("v"), 0)
+	if exists, _ := m.Exists(ctx, "k1"); exists {
+		t.Error("least recently used key k1 should be evicted")
+	}
+	if exists, _ := m.Exists(ctx, "k0"); !exists {
+		t.Error("recently read key k0 should be kept")
+	}
+}
+
+func TestMemory_AllKeysLFU(t *testing.T) {
+	m := NewMemory(WithMaxEntries(evictionSamples), WithEvictionPolicy(AllKeysLFU)).(*Memory)
+	ctx := context.Background()
+
+	for i := 0; i < evictionSamples; i++ {
+		_ = m.Set(ctx, fmt.Sprintf("k%d", i), []byte("v"), 0)
+	}
+	for i := 0; i < 200; i++ {
+		_, _ = m.Get(ctx, "k0")
+	}
+
+	for i := 0; i < evictionSamples-1; i++ {
+		if err := m.Set(ctx, fmt.Sprintf("new%d", i), []byte("v"), 0); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+	}
+	if exists, _ := m.Exists(ctx, "k0"); !exists {
+		t.Error("frequently read key k0 should be kept")
+	}
+	if len(m.data) != evictionSamples {
+		t.Errorf("%d entries, want %d", len(m.data), evictionSamples)
+	}
+}
+
+func TestMemory_VolatileLRU(t *testing.T) {
+	m := NewMemory(WithMaxEntries(3), WithEvictionPolicy(VolatileLRU)).(*Memory)
+	ctx := context.Background()
+
+	_ = m.Set(ctx, "p1", []byte("v"), 0)
+	_ = m.Set(ctx, "v1", []byte("v"), time.Hour)
+	_ = m.Set(ctx, "p2", []byte("v"), 0)
+
+	if err := m.Set(ctx, "p3", []byte("v"), 0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if exists, _ := m.Exists(ctx, "v1"); exists {
+		t.Error("key with TTL should be evicted")
+	}
+
+	if err := m.Set(ctx, "p4", []byte("v"), 0); !errors.Is(err, ErrOutOfMemory) {
+		t.Errorf("Set with no volatile keys left = %v, want ErrOutOfMemory", err)
+	}
+}
+
+func TestEntryStats_Frequency(t *testing.T) {
+	now := time.Now()
+	s := newEntryStats(now)
+	for i := 0; i < 1000; i++ {
+		s.touch(now)
+	}
+	freq := s.frequency(now)
+	if freq <= lfuInitFreq || freq > lfuMaxFreq {
+		t.Fatalf("frequency after 1000 hits = %d", freq)
+	}
+
+	if decayed := s.frequency(now.Add(3 * lfuDecayPeriod)); decayed != freq-3 {
+		t.Errorf("decayed frequency = %d, want %d", decayed, freq-3)
+	}
+	if decayed := s.frequency(now.Add(1000 * lfuDecayPeriod)); decayed != 0 {
+		t.Errorf("fully decayed frequency = %d, want 0", decayed)
+	}
+}
+
+func TestEvictionPolicy_String(t *testing.T) {
+	if got := VolatileLFU.String(); got != "volatile-lfu" {
+		t.Errorf("String() = %q", got)
+	}
+	if got := EvictionPolicy(99).String(); got != "unknown" {
+		t.Errorf("String() = %q", got)
+	}
+}
+
+func TestShardedMemory_Limits(t *testing.T) {
+	s := NewShardedMemory(4, WithMaxEntries(8), WithEvictionPolicy(AllKeysLRU)).(*ShardedMemory)
+	ctx := context.Background()
+
+	for i := 0; i < 100; i++ {
+		if err := s.Set(ctx, fmt.Sprintf("k%d", i), []byte("v"), 0); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+	}
+	pairs := map[string][]byte{}
+	for i := 0; i < 4; i++ {
+		pairs[fmt.Sprintf("batch%d", i)] = []byte("v")
+	}
+	if err := s.MSet(ctx, pairs, 0); err != nil {
+		t.Fatalf("MSet failed: %v", err)
+	}
+
+	total := 0
+	for _, shard := range s.shards {
+		if n := len(shard.data); n > 2 {
+			t.Errorf("shard holds %d keys, limit is 2", n)
+		}
+		total += len(shard.data)
+	}
+	if total > 8 {
+		t.Errorf("total keys = %d, want <= 8", total)
+	}
+}
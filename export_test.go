@@ -0,0 +1,13 @@
+package namestore
+
+// Internals used by the tests in package namestore_test, which can use
+// namestoretest without an import cycle.
+var (
+	FileSweepBatch = (*FileDriver).sweepBatch
+	FileRecordPath = (*FileDriver).recordPath
+)
+
+// FileSweepPosition returns the record the janitor of d resumes after.
+func FileSweepPosition(d *FileDriver) string {
+	return d.sweepAfter
+}
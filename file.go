@@ -0,0 +1,1083 @@
+package namestore
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// On-disk layout. Keys are split on ':' and each segment becomes a path
+// component, so the directory tree doubles as a prefix index: Keys and Scan
+// walk the directory of their prefix and Clear removes it. Segments are
+// escaped to lowercase letters, digits, '_' and '-', with every other byte
+// written as %xx and the empty segment as "%". Records are files named
+// "<segment>.v"; names starting with '.' belong to the driver.
+//
+//	a:b:c   -> a/b/c.v
+//	User:1  -> %55ser/1.v
+//
+// A record is
+//
+//	magic   "NSKV"
+//	version uint8
+//	expire  int64, big-endian Unix nanoseconds, 0 for no expiry
+//	crc     uint32, big-endian CRC-32C of value
+//	value
+const (
+	fileMagic      = "NSKV"
+	fileVersion    = 1
+	fileHeaderSize = len(fileMagic) + 1 + 8 + 4
+
+	fileRecordExt = ".v"
+	fileLockName  = "LOCK"
+	fileJournal   = ".journal"
+	fileTempGlob  = ".tmp-*"
+	fileTrashGlob = ".trash-*"
+
+	// fileSweepBatches bounds the batches of janitorMaxScan records a
+	// janitor tick visits.
+	fileSweepBatches = 8
+
+	// fileMaxSegment keeps escaped names within common 255-byte file name
+	// limits.
+	fileMaxSegment = 250
+)
+
+// FileOption customizes the FileDriver.
+type FileOption func(*fileConfig)
+
+type fileConfig struct {
+	janitorInterval time.Duration
+	clock           Clock
+}
+
+// WithFileJanitor removes expired records from disk every interval. Without
+// it, an expired record is deleted when a write touches it, and otherwise
+// only ignored.
+func WithFileJanitor(interval time.Duration) FileOption {
+	return func(c *fileConfig) {
+		c.janitorInterval = interval
+	}
+}
+
+// WithFileClock makes the driver read the current time from clock instead
+// of time.Now, the FileDriver counterpart of WithClock. Expiration times are
+// stored as absolute times, so a clock far from the real time also affects
+// records read by other processes.
+func WithFileClock(clock Clock) FileOption {
+	return func(c *fileConfig) {
+		c.clock = clock
+	}
+}
+
+// FileDriver implements Driver on a single directory, for programs that need
+// persistent state without running a server. Every write goes to a synced
+// temporary file that is renamed into place, so a crash never leaves a
+// partially written record; MSet and MDel additionally go through a journal
+// so they are applied completely or not at all. The directory is locked
+// while open, and a second NewFileDriver on it, from any process, fails with
+// ErrLocked.
+//
+// Counters written by Incr use the same 8-byte encoding as Memory.
+type FileDriver struct {
+	mu      sync.RWMutex
+	dir     string
+	lock    *os.File
+	closed  bool
+	scans   memoryScans
+	janitor *periodic
+	clock   Clock
+
+	sweepAfter string // the last record visited by the janitor
+	// unrecovered is set when a journal could not be applied or removed.
+	// Writes are refused until it is replayed, so they can neither replace
+	// it nor be undone by it.
+	unrecovered bool
+}
+
+// NewFileDriver opens the store in dir, creating the directory if needed,
+// and finishes any batch write interrupted by a crash. Call Close to release
+// the directory.
+func NewFileDriver(dir string, opts ...FileOption) (*FileDriver, error) {
+	var cfg fileConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	dir = filepath.Clean(dir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	lock, err := os.OpenFile(filepath.Join(dir, fileLockName), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(lock); err != nil {
+		lock.Close()
+		return nil, err
+	}
+
+	d := &FileDriver{dir: dir, lock: lock, clock: cfg.clock}
+	if err := d.recover(); err != nil {
+		unlockFile(lock)
+		lock.Close()
+		return nil, err
+	}
+	if cfg.janitorInterval > 0 {
+		d.janitor = startPeriodic(cfg.janitorInterval, d.sweep)
+	}
+	return d, nil
+}
+
+func (d *FileDriver) now() time.Time {
+	if d.clock == nil {
+		return time.Now()
+	}
+	return d.clock.Now()
+}
+
+// Close stops the janitor and releases the directory lock. Operations on a
+// closed driver return ErrClosed. It is safe to call more than once.
+func (d *FileDriver) Close() error {
+	d.janitor.stop()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return nil
+	}
+	d.closed = true
+	unlockFile(d.lock)
+	return d.lock.Close()
+}
+
+// recover replays an interrupted journal and removes leftovers of writes
+// and clears that did not complete.
+func (d *FileDriver) recover() error {
+	if err := d.replay(); err != nil {
+		return err
+	}
+	for _, glob := range []string{fileTempGlob, fileTrashGlob} {
+		matches, _ := filepath.Glob(filepath.Join(d.dir, glob))
+		for _, m := range matches {
+			if err := os.RemoveAll(m); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// replay applies and removes the journal of an interrupted commit, if any.
+func (d *FileDriver) replay() error {
+	journal := filepath.Join(d.dir, fileJournal)
+	data, err := os.ReadFile(journal)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	ops, err := decodeFileJournal(data)
+	if err != nil {
+		return err
+	}
+	if err := d.applyOps(ops, true); err != nil {
+		return err
+	}
+	if err := os.Remove(journal); err != nil {
+		return err
+	}
+	syncDir(d.dir)
+	return nil
+}
+
+// escapeSegment maps a key segment to a file name component.
+func escapeSegment(s string) (string, error) {
+	if s == "" {
+		return "%", nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '_' || c == '-' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02x", c)
+		}
+	}
+	if b.Len() > fileMaxSegment {
+		return "", fmt.Errorf("namestore: file driver: key segment too long: %.32q...", s)
+	}
+	return b.String(), nil
+}
+
+// unescapeSegment reverses escapeSegment, reporting false for names the
+// driver did not create.
+func unescapeSegment(name string) (string, bool) {
+	if name == "%" {
+		return "", true
+	}
+	if name == "" || name[0] == '.' {
+		return "", false
+	}
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] != '%' {
+			b.WriteByte(name[i])
+			continue
+		}
+		if i+2 >= len(name) {
+			return "", false
+		}
+		c, err := strconv.ParseUint(name[i+1:i+3], 16, 8)
+		if err != nil {
+			return "", false
+		}
+		b.WriteByte(byte(c))
+		i += 2
+	}
+	return b.String(), true
+}
+
+// relPath returns the escaped path components of key, with the record
+// extension on the last one.
+func relPath(key string) ([]string, error) {
+	segs := strings.Split(key, ":")
+	for i, seg := range segs {
+		name, err := escapeSegment(seg)
+		if err != nil {
+			return nil, err
+		}
+		segs[i] = name
+	}
+	segs[len(segs)-1] += fileRecordExt
+	return segs, nil
+}
+
+func (d *FileDriver) recordPath(key string) (string, error) {
+	rel, err := relPath(key)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(append([]string{d.dir}, rel...)...), nil
+}
+
+// prefixDir returns the directory holding the keys under prefix.
+func (d *FileDriver) prefixDir(prefix string) (string, error) {
+	segs := strings.Split(prefix, ":")
+	for i, seg := range segs {
+		name, err := escapeSegment(seg)
+		if err != nil {
+			return "", err
+		}
+		segs[i] = name
+	}
+	return filepath.Join(append([]string{d.dir}, segs...)...), nil
+}
+
+// fileRecord is a record read from disk.
+type fileRecord struct {
+	value  []byte
+	expire time.Time
+}
+
+func (r fileRecord) expiredAt(now time.Time) bool {
+	return !r.expire.IsZero() && now.After(r.expire)
+}
+
+func encodeFileRecord(value []byte, expire time.Time) []byte {
+	b := make([]byte, 0, fileHeaderSize+len(value))
+	b = append(b, fileMagic...)
+	b = append(b, fileVersion)
+	var ns int64
+	if !expire.IsZero() {
+		ns = expire.UnixNano()
+	}
+	b = binary.BigEndian.AppendUint64(b, uint64(ns))
+	b = binary.BigEndian.AppendUint32(b, crc32.Checksum(value, castagnoli))
+	return append(b, value...)
+}
+
+// parseFileHeader validates a record header and returns its expiry.
+func parseFileHeader(path string, h []byte) (time.Time, error) {
+	if len(h) < fileHeaderSize || string(h[:len(fileMagic)]) != fileMagic {
+		return time.Time{}, fmt.Errorf("%w: %s is not a record", ErrCorrupt, path)
+	}
+	if v := h[len(fileMagic)]; v != fileVersion {
+		return time.Time{}, fmt.Errorf("%w: %s: unsupported record version %d", ErrCorrupt, path, v)
+	}
+	var expire time.Time
+	if ns := int64(binary.BigEndian.Uint64(h[len(fileMagic)+1:])); ns != 0 {
+		expire = time.Unix(0, ns)
+	}
+	return expire, nil
+}
+
+// readRecord reads the record at path; ok is false if it does not exist.
+func readRecord(path string) (rec fileRecord, ok bool, err error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return rec, false, nil
+	}
+	if err != nil {
+		return rec, false, err
+	}
+	if rec.expire, err = parseFileHeader(path, data); err != nil {
+		return rec, false, err
+	}
+	rec.value = data[fileHeaderSize:]
+	if crc32.Checksum(rec.value, castagnoli) != binary.BigEndian.Uint32(data[fileHeaderSize-4:]) {
+		return rec, false, fmt.Errorf("%w: %s: checksum mismatch", ErrCorrupt, path)
+	}
+	if len(rec.value) == 0 {
+		rec.value = nil
+	}
+	return rec, true, nil
+}
+
+// readExpire reads only the header of the record at path.
+func readExpire(path string) (expire time.Time, ok bool, err error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return expire, false, nil
+	}
+	if err != nil {
+		return expire, false, err
+	}
+	defer f.Close()
+
+	h := make([]byte, fileHeaderSize)
+	if _, err := io.ReadFull(f, h); err != nil {
+		return expire, false, fmt.Errorf("%w: %s: %v", ErrCorrupt, path, err)
+	}
+	expire, err = parseFileHeader(path, h)
+	return expire, err == nil, err
+}
+
+// get reads the live record for key. Callers hold at least the read lock.
+func (d *FileDriver) get(key string, now time.Time) (fileRecord, bool, error) {
+	if d.closed {
+		return fileRecord{}, false, ErrClosed
+	}
+	path, err := d.recordPath(key)
+	if err != nil {
+		return fileRecord{}, false, err
+	}
+	rec, ok, err := readRecord(path)
+	if err != nil || !ok || rec.expiredAt(now) {
+		return fileRecord{}, false, err
+	}
+	return rec, true, nil
+}
+
+// fileOp moves the temporary file src over dst, or deletes dst if src is
+// empty. Paths are relative to the store directory.
+type fileOp struct {
+	src, dst string
+}
+
+// stage writes a record to a synced temporary file and returns the op that
+// installs it under key.
+func (d *FileDriver) stage(key string, value []byte, expire time.Time) (fileOp, error) {
+	path, err := d.recordPath(key)
+	if err != nil {
+		return fileOp{}, err
+	}
+	tmp, err := os.CreateTemp(d.dir, fileTempGlob)
+	if err != nil {
+		return fileOp{}, err
+	}
+	_, err = tmp.Write(encodeFileRecord(value, expire))
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fileOp{}, err
+	}
+	return fileOp{src: filepath.Base(tmp.Name()), dst: d.rel(path)}, nil
+}
+
+func (d *FileDriver) rel(path string) string {
+	rel, _ := filepath.Rel(d.dir, path)
+	return filepath.ToSlash(rel)
+}
+
+func (d *FileDriver) abs(rel string) string {
+	return filepath.Join(d.dir, filepath.FromSlash(rel))
+}
+
+// discard removes the temporary files of ops that were not committed.
+func (d *FileDriver) discard(ops []fileOp) {
+	for _, op := range ops {
+		if op.src != "" {
+			os.Remove(d.abs(op.src))
+		}
+	}
+}
+
+// commit applies ops atomically: more than one op goes through a journal
+// that recover replays after a crash. If the journal cannot be applied, it
+// is replayed at once; should that fail too, commits fail until a later one
+// manages to replay it.
+func (d *FileDriver) commit(ops []fileOp) error {
+	if err := d.replayUnrecovered(); err != nil {
+		return err
+	}
+	if len(ops) <= 1 {
+		return d.applyOps(ops, false)
+	}
+
+	journal := filepath.Join(d.dir, fileJournal)
+	if err := writeFileAtomic(journal, func(w io.Writer) error {
+		_, err := w.Write(encodeFileJournal(ops))
+		return err
+	}); err != nil {
+		d.discard(ops)
+		return err
+	}
+	if err := d.applyOps(ops, false); err != nil {
+		if d.replay() != nil {
+			d.unrecovered = true
+			return err
+		}
+		return nil
+	}
+	if err := os.Remove(journal); err != nil {
+		d.unrecovered = true
+		return err
+	}
+	syncDir(d.dir)
+	return nil
+}
+
+// replayUnrecovered replays the journal a failed commit left behind.
+// Callers hold the write lock.
+func (d *FileDriver) replayUnrecovered() error {
+	if !d.unrecovered {
+		return nil
+	}
+	if err := d.replay(); err != nil {
+		return fmt.Errorf("namestore: file driver: replaying an interrupted write: %w", err)
+	}
+	d.unrecovered = false
+	return nil
+}
+
+// applyOps performs ops in order. When replaying, moves whose temporary file
+// is gone were already done.
+func (d *FileDriver) applyOps(ops []fileOp, replay bool) error {
+	for _, op := range ops {
+		dst := d.abs(op.dst)
+		if op.src == "" {
+			if err := os.Remove(dst); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+			syncDir(filepath.Dir(dst))
+			d.prune(filepath.Dir(dst))
+			continue
+		}
+
+		src := d.abs(op.src)
+		if replay {
+			if _, err := os.Stat(src); errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+		}
+		if err := d.mkdirs(filepath.Dir(dst)); err != nil {
+			return err
+		}
+		if err := os.Rename(src, dst); err != nil {
+			return err
+		}
+		syncDir(filepath.Dir(dst))
+	}
+	return nil
+}
+
+// mkdirs creates dir and its missing parents, syncing each parent so the new
+// entries survive a crash.
+func (d *FileDriver) mkdirs(dir string) error {
+	if dir == d.dir {
+		return nil
+	}
+	if _, err := os.Stat(dir); err == nil {
+		return nil
+	}
+	if err := d.mkdirs(filepath.Dir(dir)); err != nil {
+		return err
+	}
+	if err := os.Mkdir(dir, 0o755); err != nil && !errors.Is(err, fs.ErrExist) {
+		return err
+	}
+	syncDir(filepath.Dir(dir))
+	return nil
+}
+
+// prune removes dir and its parents while they are empty.
+func (d *FileDriver) prune(dir string) {
+	for dir != d.dir && strings.HasPrefix(dir, d.dir) {
+		if os.Remove(dir) != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// Journal format: magic "NSJ1", uvarint count, count × (uvarint len, src,
+// uvarint len, dst), then a CRC-32C of everything before it.
+const fileJournalMagic = "NSJ1"
+
+func encodeFileJournal(ops []fileOp) []byte {
+	b := []byte(fileJournalMagic)
+	b = binary.AppendUvarint(b, uint64(len(ops)))
+	for _, op := range ops {
+		b = appendAOFString(b, op.src)
+		b = appendAOFString(b, op.dst)
+	}
+	return binary.BigEndian.AppendUint32(b, crc32.Checksum(b, castagnoli))
+}
+
+func decodeFileJournal(data []byte) ([]fileOp, error) {
+	corrupt := fmt.Errorf("%w: file driver journal", ErrCorrupt)
+	if len(data) < len(fileJournalMagic)+4 || string(data[:len(fileJournalMagic)]) != fileJournalMagic {
+		return nil, corrupt
+	}
+	body, sum := data[:len(data)-4], binary.BigEndian.Uint32(data[len(data)-4:])
+	if crc32.Checksum(body, castagnoli) != sum {
+		return nil, corrupt
+	}
+
+	b := body[len(fileJournalMagic):]
+	count, n := binary.Uvarint(b)
+	if n <= 0 {
+		return nil, corrupt
+	}
+	b = b[n:]
+	var ops []fileOp
+	for i := uint64(0); i < count; i++ {
+		var op fileOp
+		var err error
+		if op.src, b, err = readAOFString(b); err != nil {
+			return nil, corrupt
+		}
+		if op.dst, b, err = readAOFString(b); err != nil {
+			return nil, corrupt
+		}
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+// set writes a single record. Callers hold the write lock.
+func (d *FileDriver) set(key string, value []byte, expire time.Time) error {
+	op, err := d.stage(key, value, expire)
+	if err != nil {
+		return err
+	}
+	if err := d.commit([]fileOp{op}); err != nil {
+		d.discard([]fileOp{op})
+		return err
+	}
+	return nil
+}
+
+// remove deletes the record for key, if any. Callers hold the write lock.
+func (d *FileDriver) remove(key string) error {
+	path, err := d.recordPath(key)
+	if err != nil {
+		return err
+	}
+	return d.commit([]fileOp{{dst: d.rel(path)}})
+}
+
+func (d *FileDriver) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return ErrClosed
+	}
+	return d.set(key, value, expiry(d.now(), ttl))
+}
+
+func (d *FileDriver) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	_, ok, err := d.get(key, d.now())
+	if err != nil || ok {
+		return false, err
+	}
+	if err := d.set(key, value, expiry(d.now(), ttl)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (d *FileDriver) Get(ctx context.Context, key string) ([]byte, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	rec, ok, err := d.get(key, d.now())
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return rec.value, nil
+}
+
+func (d *FileDriver) Delete(ctx context.Context, key string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return ErrClosed
+	}
+	return d.remove(key)
+}
+
+func (d *FileDriver) Exists(ctx context.Context, key string) (bool, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		return false, ErrClosed
+	}
+
+	path, err := d.recordPath(key)
+	if err != nil {
+		return false, err
+	}
+	expire, ok, err := readExpire(path)
+	if err != nil || !ok {
+		return false, err
+	}
+	return expire.IsZero() || !d.now().After(expire), nil
+}
+
+// MGet retrieves multiple keys.
+func (d *FileDriver) MGet(ctx context.Context, keys []string) (map[string][]byte, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	now := d.now()
+	result := make(map[string][]byte, len(keys))
+	for _, key := range keys {
+		rec, ok, err := d.get(key, now)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			result[key] = rec.value
+		}
+	}
+	return result, nil
+}
+
+// MSet sets multiple key-value pairs atomically, including across crashes.
+func (d *FileDriver) MSet(ctx context.Context, pairs map[string][]byte, ttl time.Duration) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return ErrClosed
+	}
+
+	exp := expiry(d.now(), ttl)
+	ops := make([]fileOp, 0, len(pairs))
+	for key, value := range pairs {
+		op, err := d.stage(key, value, exp)
+		if err != nil {
+			d.discard(ops)
+			return err
+		}
+		ops = append(ops, op)
+	}
+	return d.commit(ops)
+}
+
+// MDel deletes multiple keys atomically, including across crashes.
+func (d *FileDriver) MDel(ctx context.Context, keys []string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return ErrClosed
+	}
+
+	ops := make([]fileOp, 0, len(keys))
+	for _, key := range keys {
+		path, err := d.recordPath(key)
+		if err != nil {
+			return err
+		}
+		ops = append(ops, fileOp{dst: d.rel(path)})
+	}
+	return d.commit(ops)
+}
+
+// TTL returns the remaining time-to-live. Returns -1 if key has no expiration, ErrNotFound if key doesn't exist.
+func (d *FileDriver) TTL(ctx context.Context, key string) (time.Duration, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		return 0, ErrClosed
+	}
+
+	path, err := d.recordPath(key)
+	if err != nil {
+		return 0, err
+	}
+	expire, ok, err := readExpire(path)
+	if err != nil {
+		return 0, err
+	}
+	now := d.now()
+	if !ok || !expire.IsZero() && now.After(expire) {
+		return 0, ErrNotFound
+	}
+	if expire.IsZero() {
+		return -1, nil
+	}
+	return expire.Sub(now), nil
+}
+
+// Expire sets or updates the TTL for a key.
+func (d *FileDriver) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	rec, ok, err := d.get(key, d.now())
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNotFound
+	}
+	return d.set(key, rec.value, expiry(d.now(), ttl))
+}
+
+// Persist removes the expiration from a key.
+func (d *FileDriver) Persist(ctx context.Context, key string) error {
+	return d.Expire(ctx, key, 0)
+}
+
+// walk visits the records under dir in lexical path order, calling fn with
+// each key and its expiry until fn returns false. keyPrefix is the key
+// prefix that dir stands for. If after is set, the walk resumes after the
+// record with those path components.
+func walk(dir, keyPrefix string, after []string, fn func(key string, expire time.Time) (bool, error)) (bool, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	for _, ent := range entries {
+		name := ent.Name()
+		var sub []string
+		if len(after) > 0 {
+			if name < after[0] || name == after[0] && (!ent.IsDir() || len(after) == 1) {
+				continue
+			}
+			if name == after[0] {
+				sub = after[1:]
+			}
+			after = nil
+		}
+
+		path := filepath.Join(dir, name)
+		if ent.IsDir() {
+			seg, ok := unescapeSegment(name)
+			if !ok {
+				continue
+			}
+			if more, err := walk(path, keyPrefix+seg+":", sub, fn); !more || err != nil {
+				return more, err
+			}
+			continue
+		}
+
+		base, isRecord := strings.CutSuffix(name, fileRecordExt)
+		seg, ok := unescapeSegment(base)
+		if !isRecord || !ok {
+			continue
+		}
+		expire, ok, err := readExpire(path)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			continue
+		}
+		if more, err := fn(keyPrefix+seg, expire); !more || err != nil {
+			return more, err
+		}
+	}
+	return true, nil
+}
+
+// Keys returns all keys matching the prefix and pattern, reading only the
+// directory of the prefix.
+func (d *FileDriver) Keys(ctx context.Context, prefix, pattern string) ([]string, error) {
+	if pattern != "" && pattern != "*" {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, ErrInvalidPattern
+		}
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		return nil, ErrClosed
+	}
+	dir, err := d.prefixDir(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	now := d.now()
+	var result []string
+	_, err = walk(dir, prefix+":", nil, func(key string, expire time.Time) (bool, error) {
+		if matchKey(key, prefix, pattern) && (expire.IsZero() || !now.After(expire)) {
+			result = append(result, key)
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func matchKey(key, prefix, pattern string) bool {
+	if pattern == "" || pattern == "*" {
+		return true
+	}
+	matched, _ := filepath.Match(pattern, key[len(prefix)+1:])
+	return matched
+}
+
+// Scan returns a page of keys in lexical path order, visiting at most
+// count*10 records per call. Cursors follow the rules of Memory.Scan; keys
+// present for the whole scan are returned exactly once.
+func (d *FileDriver) Scan(ctx context.Context, prefix, pattern string, cursor uint64, count int) ([]string, uint64, error) {
+	if pattern != "" && pattern != "*" {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, 0, ErrInvalidPattern
+		}
+	}
+	if count <= 0 {
+		count = DefaultScanCount
+	}
+
+	now := d.now()
+	scan, err := d.scans.take(prefix, pattern, cursor, now)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		return nil, 0, ErrClosed
+	}
+	dir, err := d.prefixDir(prefix)
+	if err != nil {
+		return nil, 0, err
+	}
+	var after []string
+	if scan.after != "" {
+		if after, err = relPath(scan.after[len(prefix)+1:]); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	var keys []string
+	visited := 0
+	done, err := walk(dir, prefix+":", after, func(key string, expire time.Time) (bool, error) {
+		visited++
+		scan.after = key
+		if matchKey(key, prefix, pattern) && (expire.IsZero() || !now.After(expire)) {
+			keys = append(keys, key)
+		}
+		return len(keys) < count && visited < count*scanWorkFactor, nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	if done {
+		return keys, 0, nil
+	}
+	return keys, d.scans.park(scan, cursor, now), nil
+}
+
+// Clear removes all keys with the given prefix by renaming the prefix
+// directory out of the way and deleting it.
+func (d *FileDriver) Clear(ctx context.Context, prefix string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return ErrClosed
+	}
+
+	if err := d.replayUnrecovered(); err != nil {
+		return err
+	}
+	dir, err := d.prefixDir(prefix)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(dir); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	trash, err := os.MkdirTemp(d.dir, fileTrashGlob)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(dir, filepath.Join(trash, "data")); err != nil {
+		os.Remove(trash)
+		return err
+	}
+	syncDir(filepath.Dir(dir))
+	d.prune(filepath.Dir(dir))
+	// Leftovers of a crash here are removed by the next open.
+	return os.RemoveAll(trash)
+}
+
+// Incr atomically increments the integer value.
+func (d *FileDriver) Incr(ctx context.Context, key string, delta int64) (int64, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	rec, ok, err := d.get(key, d.now())
+	if err != nil {
+		return 0, err
+	}
+	var current int64
+	if ok {
+		if len(rec.value) != 8 {
+			return 0, ErrTypeMismatch
+		}
+		current = int64(binary.LittleEndian.Uint64(rec.value))
+	}
+
+	newValue := current + delta
+	buf := binary.LittleEndian.AppendUint64(nil, uint64(newValue))
+	if err := d.set(key, buf, rec.expire); err != nil {
+		return 0, err
+	}
+	return newValue, nil
+}
+
+// Decr atomically decrements the integer value.
+func (d *FileDriver) Decr(ctx context.Context, key string, delta int64) (int64, error) {
+	return d.Incr(ctx, key, -delta)
+}
+
+// GetSet atomically sets a key to a new value and returns the old value,
+// keeping its TTL.
+func (d *FileDriver) GetSet(ctx context.Context, key string, value []byte) ([]byte, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	rec, ok, err := d.get(key, d.now())
+	if err != nil {
+		return nil, err
+	}
+	if err := d.set(key, value, rec.expire); err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return rec.value, nil
+}
+
+// CompareAndSwap atomically compares and swaps if oldValue matches.
+func (d *FileDriver) CompareAndSwap(ctx context.Context, key string, oldValue, newValue []byte, ttl time.Duration) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	rec, ok, err := d.get(key, d.now())
+	if err != nil || !ok || !bytes.Equal(rec.value, oldValue) {
+		return false, err
+	}
+	if err := d.set(key, newValue, expiry(d.now(), ttl)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// sweep deletes expired records in batches of at most janitorMaxScan
+// visited records, holding the lock for one batch at a time. A tick visits
+// at most fileSweepBatches batches; the next one resumes where it stopped,
+// so large directories are covered over several ticks.
+func (d *FileDriver) sweep() {
+	for i := 0; i < fileSweepBatches; i++ {
+		if done := d.sweepBatch(janitorMaxScan); done {
+			return
+		}
+	}
+}
+
+// sweepBatch visits up to limit records after sweepAfter and deletes the
+// expired ones. It reports whether the walk reached the end of the
+// directory, in which case the next batch starts over.
+func (d *FileDriver) sweepBatch(limit int) (done bool) {
+	d.mu.RLock()
+	if d.closed {
+		d.mu.RUnlock()
+		return true
+	}
+	var after []string
+	if d.sweepAfter != "" {
+		after, _ = relPath(d.sweepAfter)
+	}
+	now := d.now()
+	var expired []string
+	visited := 0
+	done, err := walk(d.dir, "", after, func(key string, expire time.Time) (bool, error) {
+		visited++
+		d.sweepAfter = key
+		if !expire.IsZero() && now.After(expire) {
+			expired = append(expired, key)
+		}
+		return visited < limit, nil
+	})
+	d.mu.RUnlock()
+	if done || err != nil {
+		d.sweepAfter = ""
+	}
+
+	if len(expired) > 0 {
+		d.mu.Lock()
+		for _, key := range expired {
+			// Re-check: the key may have been rewritten meanwhile.
+			path, err := d.recordPath(key)
+			if err != nil {
+				continue
+			}
+			if expire, ok, _ := readExpire(path); ok && !expire.IsZero() && d.now().After(expire) {
+				_ = d.remove(key)
+			}
+		}
+		d.mu.Unlock()
+	}
+	return done || err != nil
+}
@@ -0,0 +1,11 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd || windows)
+
+package namestore
+
+import "os"
+
+// lockFile is a no-op on platforms without advisory file locks; keeping two
+// processes off the same directory is up to the caller there.
+func lockFile(f *os.File) error { return nil }
+
+func unlockFile(f *os.File) {}
@@ -0,0 +1,420 @@
+package namestore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func openFileDriver(t *testing.T, dir string, opts ...FileOption) *FileDriver {
+	t.Helper()
+	d, err := NewFileDriver(dir, opts...)
+	if err != nil {
+		t.Fatalf("NewFileDriver failed: %v", err)
+	}
+	return d
+}
+
+func TestFileDriver_Operations(t *testing.T) {
+	d := openFileDriver(t, t.TempDir())
+	defer d.Close()
+	ctx := context.Background()
+
+	if _, err := d.Get(ctx, "app:missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	_ = d.Set(ctx, "app:k", []byte("v"), 0)
+	if got, err := d.Get(ctx, "app:k"); err != nil || string(got) != "v" {
+		t.Fatalf("Get = %q, %v", got, err)
+	}
+	if ok, _ := d.SetNX(ctx, "app:k", []byte("x"), 0); ok {
+		t.Error("SetNX should fail for existing key")
+	}
+	if ok, _ := d.SetNX(ctx, "app:nx", []byte("x"), 0); !ok {
+		t.Error("SetNX should succeed for new key")
+	}
+
+	_ = d.Set(ctx, "app:empty", nil, 0)
+	if got, err := d.Get(ctx, "app:empty"); err != nil || got != nil {
+		t.Errorf("empty value = %q, %v", got, err)
+	}
+
+	n, _ := d.Incr(ctx, "app:n", 5)
+	n2, _ := d.Decr(ctx, "app:n", 2)
+	if n != 5 || n2 != 3 {
+		t.Errorf("Incr/Decr = %d/%d, want 5/3", n, n2)
+	}
+	if _, err := d.Incr(ctx, "app:k", 1); !errors.Is(err, ErrTypeMismatch) {
+		t.Errorf("expected ErrTypeMismatch, got %v", err)
+	}
+
+	old, _ := d.GetSet(ctx, "app:k", []byte("v2"))
+	if string(old) != "v" {
+		t.Errorf("GetSet old = %q", old)
+	}
+	if _, err := d.GetSet(ctx, "app:new", []byte("n")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetSet on missing key: %v", err)
+	}
+	if ok, _ := d.CompareAndSwap(ctx, "app:k", []byte("wrong"), []byte("v3"), 0); ok {
+		t.Error("CompareAndSwap should fail on mismatch")
+	}
+	if ok, _ := d.CompareAndSwap(ctx, "app:k", []byte("v2"), []byte("v3"), 0); !ok {
+		t.Error("CompareAndSwap should succeed")
+	}
+
+	_ = d.MSet(ctx, map[string][]byte{"app:m:1": []byte("1"), "app:m:2": []byte("2")}, 0)
+	got, _ := d.MGet(ctx, []string{"app:m:1", "app:m:2", "app:m:3"})
+	if len(got) != 2 || string(got["app:m:2"]) != "2" {
+		t.Errorf("MGet = %v", got)
+	}
+	_ = d.MDel(ctx, []string{"app:m:1", "app:m:2", "app:m:3"})
+	if exists, _ := d.Exists(ctx, "app:m:1"); exists {
+		t.Error("MDel should delete keys")
+	}
+	if _, err := os.Stat(filepath.Join(d.dir, "app", "m")); !os.IsNotExist(err) {
+		t.Error("empty directories should be removed")
+	}
+
+	_ = d.Delete(ctx, "app:k")
+	if exists, _ := d.Exists(ctx, "app:k"); exists {
+		t.Error("key should be deleted")
+	}
+}
+
+func TestFileDriver_TTL(t *testing.T) {
+	dir := t.TempDir()
+	d := openFileDriver(t, dir)
+	ctx := context.Background()
+
+	_ = d.Set(ctx, "app:short", []byte("v"), 20*time.Millisecond)
+	_ = d.Set(ctx, "app:long", []byte("v"), time.Hour)
+	_ = d.Set(ctx, "app:none", []byte("v"), 0)
+
+	if ttl, _ := d.TTL(ctx, "app:none"); ttl != -1 {
+		t.Errorf("TTL without expiry = %v, want -1", ttl)
+	}
+	if _, err := d.TTL(ctx, "app:missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if err := d.Expire(ctx, "app:missing", time.Minute); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	_ = d.Expire(ctx, "app:none", time.Hour)
+	_ = d.Persist(ctx, "app:long")
+	if ttl, _ := d.TTL(ctx, "app:long"); ttl != -1 {
+		t.Errorf("TTL after Persist = %v, want -1", ttl)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if _, err := d.Get(ctx, "app:short"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expired key: %v", err)
+	}
+	if exists, _ := d.Exists(ctx, "app:short"); exists {
+		t.Error("expired key should not exist")
+	}
+	_ = d.Close()
+
+	// Expiry is stored on disk and survives reopening.
+	d = openFileDriver(t, dir)
+	defer d.Close()
+	if ttl, _ := d.TTL(ctx, "app:none"); ttl <= 0 || ttl > time.Hour {
+		t.Errorf("TTL after reopen = %v", ttl)
+	}
+	keys, _ := d.Keys(ctx, "app", "*")
+	if len(keys) != 2 {
+		t.Errorf("Keys = %v, want 2 live keys", keys)
+	}
+}
+
+func TestFileDriver_Persistence(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	d := openFileDriver(t, dir)
+	_ = d.Set(ctx, "app:users:1", []byte("alice"), 0)
+	_, _ = d.Incr(ctx, "app:counter", 7)
+	_ = d.Close()
+
+	if err := d.Set(ctx, "app:x", nil, 0); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+
+	d = openFileDriver(t, dir)
+	defer d.Close()
+	if got, _ := d.Get(ctx, "app:users:1"); string(got) != "alice" {
+		t.Errorf("after reopen = %q", got)
+	}
+	if n, _ := d.Incr(ctx, "app:counter", 0); n != 7 {
+		t.Errorf("counter = %d, want 7", n)
+	}
+}
+
+func TestFileDriver_KeyEscaping(t *testing.T) {
+	d := openFileDriver(t, t.TempDir())
+	defer d.Close()
+	ctx := context.Background()
+
+	keys := []string{"app:User", "app:user", "app:a/b", "app:..", "app:", "app::x", "app:%41", "app:日本", "app:x.v"}
+	for _, k := range keys {
+		if err := d.Set(ctx, k, []byte(k), 0); err != nil {
+			t.Fatalf("Set(%q) failed: %v", k, err)
+		}
+	}
+	for _, k := range keys {
+		if got, err := d.Get(ctx, k); err != nil || string(got) != k {
+			t.Errorf("Get(%q) = %q, %v", k, got, err)
+		}
+	}
+
+	got, _ := d.Keys(ctx, "app", "*")
+	sort.Strings(got)
+	sort.Strings(keys)
+	if fmt.Sprint(got) != fmt.Sprint(keys) {
+		t.Errorf("Keys = %q, want %q", got, keys)
+	}
+
+	long := make([]byte, 200)
+	for i := range long {
+		long[i] = 'A'
+	}
+	if err := d.Set(ctx, "app:"+string(long), nil, 0); err == nil {
+		t.Error("expected an error for an overlong key segment")
+	}
+}
+
+func TestFileDriver_KeysAndClear(t *testing.T) {
+	d := openFileDriver(t, t.TempDir())
+	defer d.Close()
+	ctx := context.Background()
+
+	for i := 0; i < 20; i++ {
+		_ = d.Set(ctx, fmt.Sprintf("app:users:%d", i), []byte("v"), 0)
+		_ = d.Set(ctx, fmt.Sprintf("app:other:%d", i), []byte("v"), 0)
+	}
+	_ = d.Set(ctx, "app:users:nested:deep", []byte("v"), 0)
+	_ = d.Set(ctx, "app:users", []byte("parent"), 0)
+
+	keys, _ := d.Keys(ctx, "app:users", "*")
+	if len(keys) != 21 {
+		t.Errorf("Keys returned %d keys, want 21", len(keys))
+	}
+	keys, _ = d.Keys(ctx, "app:users", "1?")
+	if len(keys) != 10 {
+		t.Errorf("Keys with pattern = %v", keys)
+	}
+	if _, err := d.Keys(ctx, "app:users", "[invalid"); !errors.Is(err, ErrInvalidPattern) {
+		t.Errorf("expected ErrInvalidPattern, got %v", err)
+	}
+
+	_ = d.Clear(ctx, "app:users")
+	if keys, _ = d.Keys(ctx, "app:users", "*"); len(keys) != 0 {
+		t.Errorf("Keys after Clear = %v", keys)
+	}
+	if keys, _ = d.Keys(ctx, "app:other", "*"); len(keys) != 20 {
+		t.Errorf("Clear removed keys from another namespace: %d left", len(keys))
+	}
+	if got, _ := d.Get(ctx, "app:users"); string(got) != "parent" {
+		t.Errorf("Clear removed the prefix key itself: %q", got)
+	}
+	if err := d.Clear(ctx, "app:missing"); err != nil {
+		t.Errorf("Clear of an empty namespace: %v", err)
+	}
+}
+
+func TestFileDriver_Scan(t *testing.T) {
+	d := openFileDriver(t, t.TempDir())
+	defer d.Close()
+	ctx := context.Background()
+
+	for i := 0; i < 50; i++ {
+		_ = d.Set(ctx, fmt.Sprintf("app:k:%02d", i), []byte("v"), 0)
+		_ = d.Set(ctx, fmt.Sprintf("app:k:sub:%02d", i), []byte("v"), 0)
+	}
+
+	seen := make(map[string]int)
+	var cursor uint64
+	for pages := 0; ; pages++ {
+		keys, next, err := d.Scan(ctx, "app:k", "*", cursor, 7)
+		if err != nil {
+			t.Fatalf("Scan failed: %v", err)
+		}
+		if len(keys) > 7 {
+			t.Errorf("page has %d keys, want at most 7", len(keys))
+		}
+		for _, k := range keys {
+			seen[k]++
+		}
+		if pages == 2 {
+			// Changes mid-scan must not cause duplicates or skip stable keys.
+			_ = d.Set(ctx, "app:k:00a", []byte("v"), 0)
+			_ = d.Delete(ctx, "app:k:sub:49")
+		}
+		if cursor = next; cursor == 0 {
+			break
+		}
+	}
+	for k, n := range seen {
+		if n > 1 {
+			t.Errorf("%s returned %d times", k, n)
+		}
+	}
+	for i := 0; i < 49; i++ {
+		if k := fmt.Sprintf("app:k:sub:%02d", i); seen[k] != 1 {
+			t.Errorf("%s not returned", k)
+		}
+	}
+
+	if _, _, err := d.Scan(ctx, "app:k", "*", 12345, 10); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("expected ErrInvalidCursor, got %v", err)
+	}
+}
+
+func TestFileDriver_Lock(t *testing.T) {
+	dir := t.TempDir()
+	d := openFileDriver(t, dir)
+
+	if _, err := NewFileDriver(dir); !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected ErrLocked, got %v", err)
+	}
+
+	_ = d.Close()
+	d2, err := NewFileDriver(dir)
+	if err != nil {
+		t.Fatalf("reopen after Close failed: %v", err)
+	}
+	_ = d2.Close()
+}
+
+func TestFileDriver_JournalRecovery(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	d := openFileDriver(t, dir)
+	_ = d.Set(ctx, "app:gone", []byte("x"), 0)
+
+	// Simulate a crash after the journal of an MSet was written but before
+	// all of its renames happened.
+	op1, _ := d.stage("app:a", []byte("1"), time.Time{})
+	op2, _ := d.stage("app:b", []byte("2"), time.Time{})
+	gone, _ := d.recordPath("app:gone")
+	ops := []fileOp{op1, op2, {dst: d.rel(gone)}}
+	_ = os.WriteFile(filepath.Join(dir, fileJournal), encodeFileJournal(ops), 0o644)
+	_ = d.applyOps(ops[:1], false)
+	stray, _ := os.CreateTemp(dir, fileTempGlob)
+	stray.Close()
+	_ = d.Close()
+
+	d = openFileDriver(t, dir)
+	defer d.Close()
+	got, _ := d.MGet(ctx, []string{"app:a", "app:b", "app:gone"})
+	if len(got) != 2 || string(got["app:a"]) != "1" || string(got["app:b"]) != "2" {
+		t.Errorf("after recovery MGet = %v", got)
+	}
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		if e.Name()[0] == '.' {
+			t.Errorf("leftover %s after recovery", e.Name())
+		}
+	}
+}
+
+func TestFileDriver_FailedCommitReplays(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	d := openFileDriver(t, dir)
+	defer d.Close()
+
+	// A non-empty directory where a record belongs makes its rename fail.
+	blocked, _ := d.recordPath("app:b")
+	_ = os.MkdirAll(filepath.Join(blocked, "x"), 0o755)
+	if err := d.MSet(ctx, map[string][]byte{"app:a": []byte("1"), "app:b": []byte("2")}, 0); err == nil {
+		t.Fatal("MSet onto a blocked record succeeded")
+	}
+	if err := d.Set(ctx, "app:c", []byte("3"), 0); err == nil {
+		t.Error("Set succeeded before the failed MSet was replayed")
+	}
+	if err := d.MDel(ctx, []string{"app:a", "app:c"}); err == nil {
+		t.Error("MDel succeeded before the failed MSet was replayed")
+	}
+
+	_ = os.RemoveAll(blocked)
+	if err := d.Set(ctx, "app:c", []byte("3"), 0); err != nil {
+		t.Fatalf("Set after unblocking failed: %v", err)
+	}
+	got, _ := d.MGet(ctx, []string{"app:a", "app:b", "app:c"})
+	if len(got) != 3 || string(got["app:a"]) != "1" || string(got["app:b"]) != "2" {
+		t.Errorf("after replay MGet = %v", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, fileJournal)); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("journal left after replay: %v", err)
+	}
+}
+
+func TestFileDriver_Corrupt(t *testing.T) {
+	d := openFileDriver(t, t.TempDir())
+	defer d.Close()
+	ctx := context.Background()
+
+	_ = d.Set(ctx, "app:k", []byte("value"), 0)
+	path, _ := d.recordPath("app:k")
+	data, _ := os.ReadFile(path)
+	data[len(data)-1] ^= 0xff
+	_ = os.WriteFile(path, data, 0o644)
+
+	if _, err := d.Get(ctx, "app:k"); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("expected ErrCorrupt, got %v", err)
+	}
+}
+
+func TestFileDriver_Janitor(t *testing.T) {
+	d := openFileDriver(t, t.TempDir(), WithFileJanitor(10*time.Millisecond))
+	defer d.Close()
+	ctx := context.Background()
+
+	_ = d.Set(ctx, "app:tmp:1", []byte("v"), time.Millisecond)
+	_ = d.Set(ctx, "app:keep", []byte("v"), 0)
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, err := os.Stat(filepath.Join(d.dir, "app", "tmp")); os.IsNotExist(err) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expired record was not removed from disk")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if exists, _ := d.Exists(ctx, "app:keep"); !exists {
+		t.Error("janitor removed a live key")
+	}
+}
+
+func TestFileDriver_WithClient(t *testing.T) {
+	d := openFileDriver(t, t.TempDir())
+	defer d.Close()
+	c := New[string]("app", "users", WithDriver[string](d))
+	ctx := context.Background()
+
+	_ = c.MSet(ctx, map[string][]byte{"1": []byte("a"), "2": []byte("b")}, 0)
+	keys, _ := c.Keys(ctx, "*")
+	sort.Strings(keys)
+	if len(keys) != 2 || keys[0] != "1" {
+		t.Errorf("Keys = %v", keys)
+	}
+
+	it := NewKeyIterator(c, "*", 1)
+	n := 0
+	for it.Next(ctx) {
+		n++
+	}
+	if it.Err() != nil || n != 2 {
+		t.Errorf("iterated %d keys, err %v", n, it.Err())
+	}
+}
@@ -0,0 +1,291 @@
+// Package resp implements the subset of the Redis serialization protocol
+// (RESP2 and RESP3) needed by namestore's Redis driver and server.
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Kind identifies the type of a RESP value by its wire prefix byte.
+type Kind byte
+
+// RESP2 kinds.
+const (
+	SimpleString Kind = '+'
+	Error        Kind = '-'
+	Integer      Kind = ':'
+	BulkString   Kind = '$'
+	Array        Kind = '*'
+)
+
+// RESP3 kinds.
+const (
+	Null           Kind = '_'
+	Boolean        Kind = '#'
+	Double         Kind = ','
+	BigNumber      Kind = '('
+	BulkError      Kind = '!'
+	VerbatimString Kind = '='
+	Map            Kind = '%'
+	Set            Kind = '~'
+	Attribute      Kind = '|'
+	Push           Kind = '>'
+)
+
+// maxBulkLen bounds bulk payloads to protect against corrupt length headers.
+const maxBulkLen = 512 << 20
+
+// ErrProtocol reports malformed input.
+var ErrProtocol = errors.New("resp: protocol error")
+
+// Value is a decoded RESP value.
+//
+// Strings, errors, doubles, big numbers and verbatim strings keep their raw
+// payload in Str. Arrays, sets and pushes keep their elements in Elems; maps
+// are flattened into alternating key/value elements. A RESP2 null bulk string
+// or null array decodes with IsNull set, as does the RESP3 null type.
+type Value struct {
+	Kind   Kind
+	Str    []byte
+	Int    int64
+	Bool   bool
+	IsNull bool
+	Elems  []Value
+}
+
+// ServerError is an error reply sent by the peer.
+type ServerError string
+
+func (e ServerError) Error() string { return string(e) }
+
+// Err returns the value as a ServerError if it is an error reply.
+func (v Value) Err() error {
+	if v.Kind == Error || v.Kind == BulkError {
+		return ServerError(v.Str)
+	}
+	return nil
+}
+
+// Reader decodes RESP values from a buffered stream.
+type Reader struct {
+	br *bufio.Reader
+}
+
+// NewReader wraps r in a RESP reader.
+func NewReader(r io.Reader) *Reader {
+	if br, ok := r.(*bufio.Reader); ok {
+		return &Reader{br: br}
+	}
+	return &Reader{br: bufio.NewReader(r)}
+}
+
+// ReadValue decodes the next value. Attribute frames are skipped.
+func (r *Reader) ReadValue() (Value, error) {
+	for {
+		v, err := r.readValue()
+		if err != nil {
+			return Value{}, err
+		}
+		if v.Kind != Attribute {
+			return v, nil
+		}
+	}
+}
+
+func (r *Reader) readValue() (Value, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return Value{}, err
+	}
+	if len(line) == 0 {
+		return Value{}, fmt.Errorf("%w: empty line", ErrProtocol)
+	}
+
+	kind, payload := Kind(line[0]), line[1:]
+	switch kind {
+	case SimpleString, Error, Double, BigNumber:
+		return Value{Kind: kind, Str: append([]byte(nil), payload...)}, nil
+	case Integer:
+		n, err := parseInt(payload)
+		if err != nil {
+			return Value{}, err
+		}
+		return Value{Kind: kind, Int: n}, nil
+	case Null:
+		return Value{Kind: kind, IsNull: true}, nil
+	case Boolean:
+		if len(payload) != 1 || (payload[0] != 't' && payload[0] != 'f') {
+			return Value{}, fmt.Errorf("%w: bad boolean %q", ErrProtocol, payload)
+		}
+		return Value{Kind: kind, Bool: payload[0] == 't'}, nil
+	case BulkString, BulkError, VerbatimString:
+		return r.readBulk(kind, payload)
+	case Array, Set, Push, Map, Attribute:
+		return r.readAggregate(kind, payload)
+	default:
+		return Value{}, fmt.Errorf("%w: unknown type %q", ErrProtocol, line[0])
+	}
+}
+
+func (r *Reader) readBulk(kind Kind, header []byte) (Value, error) {
+	n, err := parseInt(header)
+	if err != nil {
+		return Value{}, err
+	}
+	if n < 0 {
+		return Value{Kind: kind, IsNull: true}, nil
+	}
+	if n > maxBulkLen {
+		return Value{}, fmt.Errorf("%w: bulk length %d too large", ErrProtocol, n)
+	}
+
+	buf := make([]byte, n+2)
+	if _, err := io.ReadFull(r.br, buf); err != nil {
+		return Value{}, err
+	}
+	if buf[n] != '\r' || buf[n+1] != '\n' {
+		return Value{}, fmt.Errorf("%w: missing bulk terminator", ErrProtocol)
+	}
+	return Value{Kind: kind, Str: buf[:n:n]}, nil
+}
+
+func (r *Reader) readAggregate(kind Kind, header []byte) (Value, error) {
+	n, err := parseInt(header)
+	if err != nil {
+		return Value{}, err
+	}
+	if n < 0 {
+		return Value{Kind: kind, IsNull: true}, nil
+	}
+	if kind == Map || kind == Attribute {
+		n *= 2
+	}
+	if n > maxBulkLen {
+		return Value{}, fmt.Errorf("%w: aggregate length %d too large", ErrProtocol, n)
+	}
+
+	elems := make([]Value, n)
+	for i := range elems {
+		if elems[i], err = r.ReadValue(); err != nil {
+			return Value{}, err
+		}
+	}
+	return Value{Kind: kind, Elems: elems}, nil
+}
+
+func (r *Reader) readLine() ([]byte, error) {
+	line, err := r.br.ReadSlice('\n')
+	if err != nil {
+		if errors.Is(err, bufio.ErrBufferFull) {
+			return nil, fmt.Errorf("%w: line too long", ErrProtocol)
+		}
+		return nil, err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("%w: missing CRLF", ErrProtocol)
+	}
+	return line[:len(line)-2], nil
+}
+
+func parseInt(b []byte) (int64, error) {
+	n, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: bad integer %q", ErrProtocol, b)
+	}
+	return n, nil
+}
+
+// Writer encodes RESP2 values onto a buffered stream.
+// Callers must call Flush to send buffered data.
+type Writer struct {
+	bw  *bufio.Writer
+	num []byte
+}
+
+// NewWriter wraps w in a RESP writer.
+func NewWriter(w io.Writer) *Writer {
+	if bw, ok := w.(*bufio.Writer); ok {
+		return &Writer{bw: bw}
+	}
+	return &Writer{bw: bufio.NewWriter(w)}
+}
+
+// WriteCommand writes args as an array of bulk strings.
+func (w *Writer) WriteCommand(args ...[]byte) error {
+	if err := w.WriteArrayHeader(len(args)); err != nil {
+		return err
+	}
+	for _, arg := range args {
+		if err := w.WriteBulk(arg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteSimple writes a simple string reply.
+func (w *Writer) WriteSimple(s string) error {
+	return w.writeLine(SimpleString, s)
+}
+
+// WriteError writes an error reply. msg should start with an error code such as "ERR".
+func (w *Writer) WriteError(msg string) error {
+	return w.writeLine(Error, msg)
+}
+
+// WriteInteger writes an integer reply.
+func (w *Writer) WriteInteger(n int64) error {
+	return w.writeHeader(Integer, n)
+}
+
+// WriteBulk writes a bulk string. A nil slice is written as an empty string;
+// use WriteNull for a null reply.
+func (w *Writer) WriteBulk(b []byte) error {
+	if err := w.writeHeader(BulkString, int64(len(b))); err != nil {
+		return err
+	}
+	if _, err := w.bw.Write(b); err != nil {
+		return err
+	}
+	_, err := w.bw.WriteString("\r\n")
+	return err
+}
+
+// WriteNull writes a RESP2 null bulk string.
+func (w *Writer) WriteNull() error {
+	_, err := w.bw.WriteString("$-1\r\n")
+	return err
+}
+
+// WriteArrayHeader writes the header for an array of n elements.
+func (w *Writer) WriteArrayHeader(n int) error {
+	return w.writeHeader(Array, int64(n))
+}
+
+// Flush sends buffered data to the underlying writer.
+func (w *Writer) Flush() error {
+	return w.bw.Flush()
+}
+
+func (w *Writer) writeLine(kind Kind, s string) error {
+	if err := w.bw.WriteByte(byte(kind)); err != nil {
+		return err
+	}
+	if _, err := w.bw.WriteString(s); err != nil {
+		return err
+	}
+	_, err := w.bw.WriteString("\r\n")
+	return err
+}
+
+func (w *Writer) writeHeader(kind Kind, n int64) error {
+	w.num = append(w.num[:0], byte(kind))
+	w.num = strconv.AppendInt(w.num, n, 10)
+	w.num = append(w.num, '\r', '\n')
+	_, err := w.bw.Write(w.num)
+	return err
+}
@@ -0,0 +1,128 @@
+package resp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReader_RESP2(t *testing.T) {
+	input := "+OK\r\n-ERR boom\r\n:42\r\n$5\r\nhello\r\n$-1\r\n*2\r\n$1\r\na\r\n:7\r\n*-1\r\n"
+	r := NewReader(strings.NewReader(input))
+
+	v, _ := r.ReadValue()
+	if v.Kind != SimpleString || string(v.Str) != "OK" {
+		t.Errorf("simple string = %+v", v)
+	}
+
+	v, _ = r.ReadValue()
+	if err := v.Err(); err == nil || err.Error() != "ERR boom" {
+		t.Errorf("error reply = %v", err)
+	}
+
+	v, _ = r.ReadValue()
+	if v.Kind != Integer || v.Int != 42 {
+		t.Errorf("integer = %+v", v)
+	}
+
+	v, _ = r.ReadValue()
+	if v.Kind != BulkString || string(v.Str) != "hello" {
+		t.Errorf("bulk = %+v", v)
+	}
+
+	v, _ = r.ReadValue()
+	if !v.IsNull {
+		t.Errorf("null bulk = %+v", v)
+	}
+
+	v, _ = r.ReadValue()
+	if v.Kind != Array || len(v.Elems) != 2 || string(v.Elems[0].Str) != "a" || v.Elems[1].Int != 7 {
+		t.Errorf("array = %+v", v)
+	}
+
+	v, _ = r.ReadValue()
+	if !v.IsNull {
+		t.Errorf("null array = %+v", v)
+	}
+
+	if _, err := r.ReadValue(); !errors.Is(err, io.EOF) {
+		t.Errorf("expected EOF, got %v", err)
+	}
+}
+
+func TestReader_RESP3(t *testing.T) {
+	input := "_\r\n#t\r\n,3.14\r\n(12345678901234567890\r\n=7\r\ntxt:abc\r\n" +
+		"%1\r\n+k\r\n:1\r\n~1\r\n+m\r\n|1\r\n+meta\r\n+x\r\n+after\r\n!3\r\nERR\r\n"
+	r := NewReader(strings.NewReader(input))
+
+	want := []func(Value) bool{
+		func(v Value) bool { return v.Kind == Null && v.IsNull },
+		func(v Value) bool { return v.Kind == Boolean && v.Bool },
+		func(v Value) bool { return v.Kind == Double && string(v.Str) == "3.14" },
+		func(v Value) bool { return v.Kind == BigNumber && string(v.Str) == "12345678901234567890" },
+		func(v Value) bool { return v.Kind == VerbatimString && string(v.Str) == "txt:abc" },
+		func(v Value) bool { return v.Kind == Map && len(v.Elems) == 2 && v.Elems[1].Int == 1 },
+		func(v Value) bool { return v.Kind == Set && len(v.Elems) == 1 },
+		// The attribute frame is skipped transparently.
+		func(v Value) bool { return v.Kind == SimpleString && string(v.Str) == "after" },
+		func(v Value) bool { return v.Err() != nil },
+	}
+	for i, check := range want {
+		v, err := r.ReadValue()
+		if err != nil {
+			t.Fatalf("value %d: %v", i, err)
+		}
+		if !check(v) {
+			t.Errorf("value %d unexpected: %+v", i, v)
+		}
+	}
+}
+
+func TestReader_Malformed(t *testing.T) {
+	tests := []string{
+		"?x\r\n",
+		":abc\r\n",
+		"$3\r\nabcd\r\n",
+		"+missing-cr\n",
+		"#x\r\n",
+	}
+	for _, input := range tests {
+		_, err := NewReader(strings.NewReader(input)).ReadValue()
+		if !errors.Is(err, ErrProtocol) {
+			t.Errorf("input %q: expected ErrProtocol, got %v", input, err)
+		}
+	}
+}
+
+func TestWriter_RoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	_ = w.WriteCommand([]byte("SET"), []byte("k"), []byte("v\r\n"))
+	_ = w.WriteSimple("OK")
+	_ = w.WriteError("ERR bad")
+	_ = w.WriteInteger(-3)
+	_ = w.WriteNull()
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	r := NewReader(&buf)
+	cmd, err := r.ReadValue()
+	if err != nil || len(cmd.Elems) != 3 || string(cmd.Elems[2].Str) != "v\r\n" {
+		t.Fatalf("command = %+v, %v", cmd, err)
+	}
+	if v, _ := r.ReadValue(); string(v.Str) != "OK" {
+		t.Errorf("simple = %+v", v)
+	}
+	if v, _ := r.ReadValue(); v.Err() == nil {
+		t.Errorf("error = %+v", v)
+	}
+	if v, _ := r.ReadValue(); v.Int != -3 {
+		t.Errorf("integer = %+v", v)
+	}
+	if v, _ := r.ReadValue(); !v.IsNull {
+		t.Errorf("null = %+v", v)
+	}
+}
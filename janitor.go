@@ -0,0 +1,110 @@
+package namestore
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// janitorSampleSize is the number of keys with a TTL inspected per round.
+	janitorSampleSize = 20
+	// janitorMaxScan bounds how many entries a round may visit, so maps made
+	// mostly of persistent keys do not keep the lock held.
+	janitorMaxScan = 20 * janitorSampleSize
+	// janitorRepeatRatio repeats a sweep while more than 1/ratio of the sample
+	// was expired, the same heuristic Redis uses for active expiry.
+	janitorRepeatRatio = 4
+)
+
+// WithJanitor enables active expiration: every interval a background
+// goroutine samples keys that carry a TTL and deletes the expired ones.
+// Without it, expired keys are only removed when they are next accessed.
+// Call Close to stop the goroutine.
+func WithJanitor(interval time.Duration) MemoryOption {
+	return func(c *memoryConfig) {
+		c.janitorInterval = interval
+	}
+}
+
+func startJanitor(interval time.Duration, sweep func(budget time.Duration) int) *periodic {
+	// Spend at most a quarter of the interval sweeping.
+	return startPeriodic(interval, func() { sweep(interval / 4) })
+}
+
+// periodic runs a function on a fixed interval until stopped.
+type periodic struct {
+	quit chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+func startPeriodic(interval time.Duration, fn func()) *periodic {
+	p := &periodic{quit: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(p.done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				fn()
+			case <-p.quit:
+				return
+			}
+		}
+	}()
+	return p
+}
+
+// stop terminates the goroutine and waits for it to exit. Safe on nil.
+func (p *periodic) stop() {
+	if p == nil {
+		return
+	}
+	p.once.Do(func() {
+		close(p.quit)
+		<-p.done
+	})
+}
+
+// sweep deletes expired entries in short rounds of at most janitorSampleSize
+// keys with a TTL, releasing the lock between rounds. It keeps going while a
+// round finds a high proportion of expired keys and the budget allows, and
+// returns the number of deleted entries.
+func (m *Memory) sweep(budget time.Duration) int {
+	start := time.Now()
+	deleted := 0
+	for {
+		sampled, expired := m.sweepRound()
+		deleted += expired
+		if sampled == 0 || expired*janitorRepeatRatio <= sampled || time.Since(start) >= budget {
+			return deleted
+		}
+	}
+}
+
+// sweepRound relies on Go's randomized map iteration order to sample a
+// different region of the map on every call.
+func (m *Memory) sweepRound() (sampled, expired int) {
+	m.mu.Lock()
+	defer m.unlock()
+
+	now := m.cfg.now()
+	visited := 0
+	for key, e := range m.data {
+		if visited++; visited > janitorMaxScan || sampled == janitorSampleSize {
+			break
+		}
+		if e.expire.IsZero() {
+			continue
+		}
+		sampled++
+		if e.expiredAt(now) {
+			m.removeLocked(key, EventExpired)
+			expired++
+		}
+	}
+	return sampled, expired
+}
@@ -0,0 +1,146 @@
+package namestore
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestMemory_Sweep(t *testing.T) {
+	m := NewMemory().(*Memory)
+	ctx := context.Background()
+
+	for i := 0; i < 500; i++ {
+		_ = m.Set(ctx, fmt.Sprintf("expiring:%d", i), []byte("v"), time.Millisecond)
+	}
+	for i := 0; i < 100; i++ {
+		_ = m.Set(ctx, fmt.Sprintf("persistent:%d", i), []byte("v"), 0)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	// Every sampled TTL key is expired, so the sweep repeats until none remain.
+	if deleted := m.sweep(time.Second); deleted != 500 {
+		t.Errorf("sweep deleted %d entries, want 500", deleted)
+	}
+	if n := len(m.data); n != 100 {
+		t.Errorf("%d entries left, want the 100 persistent keys", n)
+	}
+}
+
+func TestMemory_SweepKeepsLiveKeys(t *testing.T) {
+	m := NewMemory().(*Memory)
+	ctx := context.Background()
+
+	for i := 0; i < 100; i++ {
+		_ = m.Set(ctx, fmt.Sprintf("expiring:%d", i), []byte("v"), time.Millisecond)
+		_ = m.Set(ctx, fmt.Sprintf("volatile:%d", i), []byte("v"), time.Hour)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	if deleted := m.sweep(time.Second); deleted == 0 {
+		t.Error("sweep deleted nothing")
+	}
+	for i := 0; i < 100; i++ {
+		if _, ok := m.data[fmt.Sprintf("volatile:%d", i)]; !ok {
+			t.Fatalf("sweep removed unexpired key volatile:%d", i)
+		}
+	}
+}
+
+func TestMemory_SweepRoundIsBounded(t *testing.T) {
+	m := NewMemory().(*Memory)
+	ctx := context.Background()
+
+	for i := 0; i < 1000; i++ {
+		_ = m.Set(ctx, fmt.Sprintf("k:%d", i), []byte("v"), time.Millisecond)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	sampled, expired := m.sweepRound()
+	if sampled != janitorSampleSize || expired != janitorSampleSize {
+		t.Errorf("sweepRound = (%d, %d), want (%d, %d)", sampled, expired, janitorSampleSize, janitorSampleSize)
+	}
+}
+
+func TestMemory_Janitor(t *testing.T) {
+	m := NewMemory(WithJanitor(5 * time.Millisecond)).(*Memory)
+	defer m.Close()
+	ctx := context.Background()
+
+	for i := 0; i < 100; i++ {
+		_ = m.Set(ctx, fmt.Sprintf("session:%d", i), []byte("v"), time.Millisecond)
+	}
+	_ = m.Set(ctx, "keep", []byte("v"), 0)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		m.mu.RLock()
+		n := len(m.data)
+		m.mu.RUnlock()
+		if n == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("janitor left %d entries, want 1", n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestMemory_CloseStopsJanitor(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	m := NewMemory(WithJanitor(time.Millisecond)).(*Memory)
+	if err := m.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatalf("second Close failed: %v", err)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("goroutines = %d after Close, want <= %d", n, before)
+	}
+
+	// Data stays usable after Close, and Close without a janitor is a no-op.
+	if err := m.Set(context.Background(), "k", []byte("v"), 0); err != nil {
+		t.Errorf("Set after Close failed: %v", err)
+	}
+	if err := NewMemory().(*Memory).Close(); err != nil {
+		t.Errorf("Close without janitor failed: %v", err)
+	}
+}
+
+func TestShardedMemory_Janitor(t *testing.T) {
+	s := NewShardedMemory(4, WithJanitor(5*time.Millisecond)).(*ShardedMemory)
+	defer s.Close()
+	ctx := context.Background()
+
+	for i := 0; i < 100; i++ {
+		_ = s.Set(ctx, fmt.Sprintf("session:%d", i), []byte("v"), time.Millisecond)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		n := 0
+		for _, shard := range s.shards {
+			shard.mu.RLock()
+			n += len(shard.data)
+			shard.mu.RUnlock()
+		}
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("janitor left %d entries across shards", n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	for _, shard := range s.shards {
+		if shard.janitor != nil {
+			t.Fatal("shards should not run their own janitor")
+		}
+	}
+}
@@ -0,0 +1,346 @@
+package namestore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// DefaultNegativeCacheEntries bounds the keys a client remembers as missing
+// with WithNegativeCache.
+const DefaultNegativeCacheEntries = 10000
+
+// Loader computes the value of a key missing from the store, for GetOrLoad.
+// It returns ErrNotFound if the key has no value.
+type Loader[TKey ~string] func(ctx context.Context, key TKey) ([]byte, error)
+
+// BatchLoader computes the values of keys missing from the store, for
+// MGetOrLoad. Keys left out of the result have no value.
+type BatchLoader[TKey ~string] func(ctx context.Context, keys []TKey) (map[TKey][]byte, error)
+
+// WithNegativeCache makes GetOrLoad and MGetOrLoad remember for ttl the keys
+// their loader found to have no value, and report them missing without
+// loading them again. The client keeps these keys in process memory, up to
+// DefaultNegativeCacheEntries of them. The store is still read first, so a
+// value written in the meantime is returned.
+func WithNegativeCache[TKey ~string](ttl time.Duration) Option[TKey] {
+	return func(c *client[TKey]) {
+		if ttl <= 0 {
+			c.negative = nil
+			return
+		}
+		c.negative = newMemory(newMemoryConfig([]MemoryOption{
+			WithMaxEntries(DefaultNegativeCacheEntries),
+			WithEvictionPolicy(AllKeysLRU),
+		}))
+		c.negativeTTL = ttl
+	}
+}
+
+// loads deduplicates concurrent loads of the same key in the same store
+// across all clients of the process.
+var loads = loadGroup{calls: make(map[loadKey]*loadCall)}
+
+type loadGroup struct {
+	mu    sync.Mutex
+	calls map[loadKey]*loadCall
+	// waitHook, if set, is called by every caller about to wait for a load
+	// in flight. Tests use it to know that callers have joined a load.
+	waitHook func()
+}
+
+// loadKey identifies a load by store, see storeID, and full key.
+type loadKey struct {
+	store any
+	key   string
+}
+
+// storeID returns the identity under which a client with driver d shares
+// loads. Clients of the same driver share them; drivers that are not
+// pointers may not be comparable, so owner, the client, stands in for them.
+func storeID(d Driver, owner any) any {
+	if reflect.ValueOf(d).Kind() == reflect.Pointer {
+		return d
+	}
+	return owner
+}
+
+// loadCall is a load in progress. value and err are set before done is
+// closed.
+type loadCall struct {
+	done     chan struct{}
+	value    []byte
+	err      error
+	waitHook func()
+}
+
+// claim returns the load in flight for key, or starts one that the caller
+// leads and must finish.
+func (g *loadGroup) claim(key loadKey) (call *loadCall, leader bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if call, ok := g.calls[key]; ok {
+		return call, false
+	}
+	call = &loadCall{done: make(chan struct{}), waitHook: g.waitHook}
+	g.calls[key] = call
+	return call, true
+}
+
+func (g *loadGroup) finish(key loadKey, call *loadCall, value []byte, err error) {
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+	call.value, call.err = value, err
+	close(call.done)
+}
+
+// wait returns a copy of the loaded value once the leader finishes. It
+// reports retry if the leader failed only because its own ctx ended while
+// ctx is still live, so the caller should load the key itself.
+func (l *loadCall) wait(ctx context.Context) (value []byte, retry bool, err error) {
+	if l.waitHook != nil {
+		l.waitHook()
+	}
+	select {
+	case <-l.done:
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	}
+	if l.err != nil {
+		if errors.Is(l.err, context.Canceled) || errors.Is(l.err, context.DeadlineExceeded) {
+			return nil, ctx.Err() == nil, l.err
+		}
+		return nil, false, l.err
+	}
+	return bytes.Clone(l.value), false, nil
+}
+
+// GetOrLoad returns the value of key, calling load to compute it when the
+// store does not have it and storing the result with ttl. Concurrent calls
+// for the same full key within the process share one load if they are made
+// through clients of the same driver. If the store fails, the key is loaded
+// anyway.
+//
+// Errors from load are returned and nothing is stored; ErrNotFound is
+// remembered if WithNegativeCache is set. A failure to store the loaded
+// value is logged, and the value still returned.
+func (c *client[TKey]) GetOrLoad(ctx context.Context, key TKey, ttl time.Duration, load Loader[TKey]) ([]byte, error) {
+	ctx, call := c.begin(ctx, "GetOrLoad")
+	fullKey := c.key(key)
+	value, err := c.driver.Get(ctx, fullKey)
+	if err == nil {
+		c.end(ctx, call, Observation{Hits: 1}, nil)
+		return value, nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		c.logf("error", ctx, "GetOrLoad %s: Get failed, loading: %v", key, err)
+	}
+
+	value, err = c.load(ctx, key, fullKey, ttl, load)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		c.logf("error", ctx, "GetOrLoad %s failed: %v", key, err)
+	}
+	// end counts ErrNotFound as the miss.
+	c.end(ctx, call, Observation{Misses: hit(!errors.Is(err, ErrNotFound))}, err)
+	return value, err
+}
+
+// load loads one key missing from the store, or waits for a load in flight.
+func (c *client[TKey]) load(ctx context.Context, key TKey, fullKey string, ttl time.Duration, load Loader[TKey]) ([]byte, error) {
+	if c.knownMissing(ctx, fullKey) {
+		return nil, ErrNotFound
+	}
+	for {
+		call, leader := loads.claim(loadKey{c.store, fullKey})
+		if leader {
+			return c.fill(ctx, key, fullKey, ttl, load, call)
+		}
+		value, retry, err := call.wait(ctx)
+		if !retry {
+			return value, err
+		}
+	}
+}
+
+// fill runs load as the leader of call.
+func (c *client[TKey]) fill(ctx context.Context, key TKey, fullKey string, ttl time.Duration, load Loader[TKey], call *loadCall) (value []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			loads.finish(loadKey{c.store, fullKey}, call, nil, fmt.Errorf("namestore: loader panicked: %v", r))
+			panic(r)
+		}
+	}()
+
+	value, err = load(ctx, key)
+	switch {
+	case err == nil:
+		if err := c.driver.Set(ctx, fullKey, value, ttl); err != nil {
+			c.logf("error", ctx, "GetOrLoad %s: Set failed: %v", key, err)
+		}
+	case errors.Is(err, ErrNotFound):
+		c.rememberMissing(ctx, fullKey)
+	}
+	loads.finish(loadKey{c.store, fullKey}, call, value, err)
+	return value, err
+}
+
+// MGetOrLoad returns the values of keys, calling load once with the keys
+// the store does not have and storing its results with ttl. Keys without a
+// value are left out of the result. Keys already being loaded by another
+// call in the process, through a client of the same driver, are waited for
+// instead of loaded again.
+//
+// Like GetOrLoad, it loads every key if the store fails, and stores
+// nothing when load fails. On error it returns no values.
+func (c *client[TKey]) MGetOrLoad(ctx context.Context, keys []TKey, ttl time.Duration, load BatchLoader[TKey]) (map[TKey][]byte, error) {
+	result := make(map[TKey][]byte, len(keys))
+	if len(keys) == 0 {
+		return result, nil
+	}
+
+	ctx, call := c.begin(ctx, "MGetOrLoad")
+	fullKeys := make([]string, len(keys))
+	for i, k := range keys {
+		fullKeys[i] = c.key(k)
+	}
+	found, err := c.driver.MGet(ctx, fullKeys)
+	if err != nil {
+		c.logf("error", ctx, "MGetOrLoad: MGet failed, loading: %v", err)
+	}
+
+	var missing []pendingLoad[TKey]
+	for i, k := range keys {
+		if v, ok := found[fullKeys[i]]; ok {
+			result[k] = v
+		} else if !c.knownMissing(ctx, fullKeys[i]) {
+			missing = append(missing, pendingLoad[TKey]{key: k, fullKey: fullKeys[i]})
+		}
+	}
+	hits := len(result)
+
+	if len(missing) > 0 {
+		if err := c.loadMany(ctx, missing, ttl, load, result); err != nil {
+			c.logf("error", ctx, "MGetOrLoad failed: %v", err)
+			c.end(ctx, call, Observation{BatchSize: len(keys)}, err)
+			return nil, err
+		}
+	}
+	c.end(ctx, call, Observation{Hits: hits, Misses: len(keys) - hits, BatchSize: len(keys)}, nil)
+	return result, nil
+}
+
+type pendingLoad[TKey ~string] struct {
+	key     TKey
+	fullKey string
+	call    *loadCall
+}
+
+// loadMany loads the missing keys into result: those not in flight with one
+// call of load, the others by waiting for their leaders.
+func (c *client[TKey]) loadMany(ctx context.Context, missing []pendingLoad[TKey], ttl time.Duration, load BatchLoader[TKey], result map[TKey][]byte) error {
+	var lead, follow []pendingLoad[TKey]
+	for _, p := range missing {
+		var leader bool
+		if p.call, leader = loads.claim(loadKey{c.store, p.fullKey}); leader {
+			lead = append(lead, p)
+		} else {
+			follow = append(follow, p)
+		}
+	}
+	if len(lead) > 0 {
+		if err := c.fillMany(ctx, lead, ttl, load, result); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range follow {
+		value, retry, err := p.call.wait(ctx)
+		if retry {
+			value, err = c.load(ctx, p.key, p.fullKey, ttl, func(ctx context.Context, key TKey) ([]byte, error) {
+				values, err := load(ctx, []TKey{key})
+				if err != nil {
+					return nil, err
+				}
+				if v, ok := values[key]; ok {
+					return v, nil
+				}
+				return nil, ErrNotFound
+			})
+		}
+		switch {
+		case err == nil:
+			result[p.key] = value
+		case !errors.Is(err, ErrNotFound):
+			return err
+		}
+	}
+	return nil
+}
+
+// fillMany runs load as the leader of every call in lead.
+func (c *client[TKey]) fillMany(ctx context.Context, lead []pendingLoad[TKey], ttl time.Duration, load BatchLoader[TKey], result map[TKey][]byte) (err error) {
+	var values map[TKey][]byte
+	defer func() {
+		r := recover()
+		if r != nil {
+			err = fmt.Errorf("namestore: loader panicked: %v", r)
+		}
+		for _, p := range lead {
+			switch v, ok := values[p.key]; {
+			case err != nil:
+				loads.finish(loadKey{c.store, p.fullKey}, p.call, nil, err)
+			case ok:
+				loads.finish(loadKey{c.store, p.fullKey}, p.call, v, nil)
+			default:
+				loads.finish(loadKey{c.store, p.fullKey}, p.call, nil, ErrNotFound)
+			}
+		}
+		if r != nil {
+			panic(r)
+		}
+	}()
+
+	keys := make([]TKey, len(lead))
+	for i, p := range lead {
+		keys[i] = p.key
+	}
+	if values, err = load(ctx, keys); err != nil {
+		return err
+	}
+
+	pairs := make(map[string][]byte, len(values))
+	for _, p := range lead {
+		if v, ok := values[p.key]; ok {
+			pairs[p.fullKey] = v
+			result[p.key] = v
+		} else {
+			c.rememberMissing(ctx, p.fullKey)
+		}
+	}
+	if len(pairs) > 0 {
+		if err := c.driver.MSet(ctx, pairs, ttl); err != nil {
+			c.logf("error", ctx, "MGetOrLoad: MSet failed: %v", err)
+		}
+	}
+	return nil
+}
+
+// knownMissing reports whether the negative cache holds fullKey.
+func (c *client[TKey]) knownMissing(ctx context.Context, fullKey string) bool {
+	if c.negative == nil {
+		return false
+	}
+	ok, _ := c.negative.Exists(ctx, fullKey)
+	return ok
+}
+
+func (c *client[TKey]) rememberMissing(ctx context.Context, fullKey string) {
+	if c.negative != nil {
+		_ = c.negative.Set(ctx, fullKey, []byte{}, c.negativeTTL)
+	}
+}
@@ -0,0 +1,358 @@
+package namestore
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGetOrLoad(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	c := New[string]("app", "loader", WithDriver[string](m))
+	var loads int
+	load := func(ctx context.Context, key string) ([]byte, error) {
+		loads++
+		return []byte("value of " + key), nil
+	}
+
+	v, err := c.GetOrLoad(ctx, "1", time.Hour, load)
+	if err != nil || string(v) != "value of 1" || loads != 1 {
+		t.Fatalf("GetOrLoad = %q, %v after %d loads", v, err, loads)
+	}
+	if ttl, err := m.TTL(ctx, "app:loader:1"); err != nil || ttl <= 0 || ttl > time.Hour {
+		t.Errorf("stored TTL = %v, %v", ttl, err)
+	}
+	if v, err := c.GetOrLoad(ctx, "1", time.Hour, load); err != nil || string(v) != "value of 1" || loads != 1 {
+		t.Errorf("second GetOrLoad = %q, %v after %d loads, want a hit", v, err, loads)
+	}
+}
+
+func TestGetOrLoad_Errors(t *testing.T) {
+	ctx := context.Background()
+	c := New[string]("app", "loader-errors")
+	boom := errors.New("boom")
+	var loads int
+	load := func(ctx context.Context, key string) ([]byte, error) {
+		loads++
+		if key == "missing" {
+			return nil, ErrNotFound
+		}
+		return nil, boom
+	}
+
+	for i := 1; i <= 2; i++ {
+		if _, err := c.GetOrLoad(ctx, "1", 0, load); !errors.Is(err, boom) || loads != 2*i-1 {
+			t.Errorf("GetOrLoad = %v after %d loads", err, loads)
+		}
+		if _, err := c.GetOrLoad(ctx, "missing", 0, load); !errors.Is(err, ErrNotFound) || loads != 2*i {
+			t.Errorf("GetOrLoad missing = %v after %d loads", err, loads)
+		}
+	}
+	if ok, _ := c.Exists(ctx, "1"); ok {
+		t.Error("a failed load was stored")
+	}
+}
+
+func TestGetOrLoad_NegativeCache(t *testing.T) {
+	ctx := context.Background()
+	c := New[string]("app", "loader-negative", WithNegativeCache[string](50*time.Millisecond))
+	var loads int
+	load := func(ctx context.Context, key string) ([]byte, error) {
+		loads++
+		return nil, ErrNotFound
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := c.GetOrLoad(ctx, "1", 0, load); !errors.Is(err, ErrNotFound) || loads != 1 {
+			t.Fatalf("GetOrLoad = %v after %d loads", err, loads)
+		}
+	}
+	values, err := c.MGetOrLoad(ctx, []string{"1"}, 0, func(ctx context.Context, keys []string) (map[string][]byte, error) {
+		t.Errorf("batch loader called for %v", keys)
+		return nil, nil
+	})
+	if err != nil || len(values) != 0 {
+		t.Errorf("MGetOrLoad = %v, %v", values, err)
+	}
+
+	// A stored value wins over the negative entry.
+	_ = c.Set(ctx, "1", []byte("v"), 0)
+	if v, err := c.GetOrLoad(ctx, "1", 0, load); err != nil || string(v) != "v" {
+		t.Errorf("GetOrLoad after Set = %q, %v", v, err)
+	}
+	_ = c.Delete(ctx, "1")
+
+	time.Sleep(100 * time.Millisecond)
+	if _, err := c.GetOrLoad(ctx, "1", 0, load); !errors.Is(err, ErrNotFound) || loads != 2 {
+		t.Errorf("GetOrLoad after negative TTL = %v after %d loads", err, loads)
+	}
+}
+
+func TestGetOrLoad_Singleflight(t *testing.T) {
+	ctx := context.Background()
+	waits := countWaits(t)
+	// Two clients of the same namespace share loads.
+	d := NewMemory()
+	clients := []Client[string]{
+		New[string]("app", "loader-flight", WithDriver[string](d)),
+		New[string]("app", "loader-flight", WithDriver[string](d)),
+	}
+	var loads atomic.Int32
+	started, release := make(chan struct{}), make(chan struct{})
+	load := func(ctx context.Context, key string) ([]byte, error) {
+		if loads.Add(1) == 1 {
+			close(started)
+		}
+		<-release
+		return []byte("v"), nil
+	}
+
+	const n = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, err := clients[0].GetOrLoad(ctx, "hot", 0, load)
+		errs <- err
+	}()
+	<-started
+	for i := 1; i < n; i++ {
+		wg.Add(1)
+		go func(c Client[string]) {
+			defer wg.Done()
+			v, err := c.GetOrLoad(ctx, "hot", 0, load)
+			if err == nil && string(v) != "v" {
+				err = errors.New("wrong value " + string(v))
+			}
+			errs <- err
+		}(clients[i%2])
+	}
+	waitFor(t, func() bool { return waits.Load() == n-1 })
+	close(release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if got := loads.Load(); got != 1 {
+		t.Errorf("loaded %d times, want 1", got)
+	}
+}
+
+func TestGetOrLoad_SeparateDrivers(t *testing.T) {
+	ctx := context.Background()
+	// The same full key in two stores is two values.
+	a := New[string]("app", "loader-drivers", WithDriver[string](NewMemory()))
+	b := New[string]("app", "loader-drivers", WithDriver[string](NewMemory()))
+	started, release := make(chan struct{}), make(chan struct{})
+	done := make(chan []byte)
+	go func() {
+		v, _ := a.GetOrLoad(ctx, "1", 0, func(ctx context.Context, key string) ([]byte, error) {
+			close(started)
+			<-release
+			return []byte("a"), nil
+		})
+		done <- v
+	}()
+	<-started
+
+	// Waiting for the first load would time out.
+	bctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	v, err := b.GetOrLoad(bctx, "1", 0, func(ctx context.Context, key string) ([]byte, error) {
+		return []byte("b"), nil
+	})
+	close(release)
+	if err != nil || string(v) != "b" {
+		t.Errorf("GetOrLoad on the second driver = %q, %v", v, err)
+	}
+	if v := <-done; string(v) != "a" {
+		t.Errorf("GetOrLoad on the first driver = %q", v)
+	}
+}
+
+func TestGetOrLoad_LeaderCanceled(t *testing.T) {
+	waits := countWaits(t)
+	c := New[string]("app", "loader-cancel")
+	started := make(chan struct{})
+	var loads atomic.Int32
+	load := func(ctx context.Context, key string) ([]byte, error) {
+		if loads.Add(1) == 1 {
+			close(started)
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return []byte("v"), nil
+	}
+
+	leaderCtx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := c.GetOrLoad(leaderCtx, "1", 0, load)
+		done <- err
+	}()
+	<-started
+
+	result := make(chan []byte)
+	go func() {
+		v, _ := c.GetOrLoad(context.Background(), "1", 0, load)
+		result <- v
+	}()
+	waitFor(t, func() bool { return waits.Load() == 1 })
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("leader = %v", err)
+	}
+	if v := <-result; string(v) != "v" || loads.Load() != 2 {
+		t.Errorf("follower = %q after %d loads, want its own load", v, loads.Load())
+	}
+}
+
+func TestMGetOrLoad(t *testing.T) {
+	ctx := context.Background()
+	c := New[string]("app", "loader-batch", WithNegativeCache[string](time.Hour))
+	_ = c.Set(ctx, "a", []byte("A"), 0)
+
+	var asked [][]string
+	load := func(ctx context.Context, keys []string) (map[string][]byte, error) {
+		asked = append(asked, append([]string(nil), keys...))
+		values := make(map[string][]byte)
+		for _, k := range keys {
+			if k != "missing" {
+				values[k] = []byte("loaded " + k)
+			}
+		}
+		values["unasked"] = []byte("ignored")
+		return values, nil
+	}
+
+	got, err := c.MGetOrLoad(ctx, []string{"a", "b", "c", "missing"}, time.Minute, load)
+	want := map[string][]byte{"a": []byte("A"), "b": []byte("loaded b"), "c": []byte("loaded c")}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("MGetOrLoad = %q, %v", got, err)
+	}
+	if len(asked) != 1 {
+		t.Fatalf("loader called %d times", len(asked))
+	}
+	sort.Strings(asked[0])
+	if !reflect.DeepEqual(asked[0], []string{"b", "c", "missing"}) {
+		t.Errorf("loader asked for %v", asked[0])
+	}
+	if ttl, err := c.TTL(ctx, "b"); err != nil || ttl <= 0 {
+		t.Errorf("loaded key TTL = %v, %v", ttl, err)
+	}
+	if ok, _ := c.Exists(ctx, "unasked"); ok {
+		t.Error("stored a key that was not asked for")
+	}
+
+	// Everything is now stored or known missing.
+	if got, err := c.MGetOrLoad(ctx, []string{"b", "missing"}, 0, load); err != nil || len(got) != 1 || len(asked) != 1 {
+		t.Errorf("second MGetOrLoad = %q, %v after %d loads", got, err, len(asked))
+	}
+
+	boom := errors.New("boom")
+	if got, err := c.MGetOrLoad(ctx, []string{"a", "d"}, 0, func(ctx context.Context, keys []string) (map[string][]byte, error) {
+		return nil, boom
+	}); !errors.Is(err, boom) || got != nil {
+		t.Errorf("MGetOrLoad with failing loader = %q, %v", got, err)
+	}
+	if got, err := c.MGetOrLoad(ctx, nil, 0, load); err != nil || len(got) != 0 {
+		t.Errorf("MGetOrLoad() = %v, %v", got, err)
+	}
+}
+
+func TestMGetOrLoad_SharesLoadsInFlight(t *testing.T) {
+	ctx := context.Background()
+	waits := countWaits(t)
+	c := New[string]("app", "loader-batch-flight")
+	started, release := make(chan struct{}), make(chan struct{})
+	go func() {
+		_, _ = c.GetOrLoad(ctx, "b", 0, func(ctx context.Context, key string) ([]byte, error) {
+			close(started)
+			<-release
+			return []byte("from single"), nil
+		})
+	}()
+	<-started
+
+	var asked []string
+	done := make(chan map[string][]byte)
+	go func() {
+		got, _ := c.MGetOrLoad(ctx, []string{"a", "b"}, 0, func(ctx context.Context, keys []string) (map[string][]byte, error) {
+			asked = keys
+			return map[string][]byte{"a": []byte("from batch")}, nil
+		})
+		done <- got
+	}()
+	waitFor(t, func() bool { return waits.Load() == 1 })
+	close(release)
+
+	got := <-done
+	want := map[string][]byte{"a": []byte("from batch"), "b": []byte("from single")}
+	if !reflect.DeepEqual(got, want) || !reflect.DeepEqual(asked, []string{"a"}) {
+		t.Errorf("MGetOrLoad = %q after loading %v", got, asked)
+	}
+}
+
+func TestGetOrLoad_Metrics(t *testing.T) {
+	ctx := context.Background()
+	rec := &recordingMetrics{}
+	c := New[string]("app", "loader-metrics", WithMetrics[string](rec))
+	load := func(ctx context.Context, key string) ([]byte, error) { return []byte("v"), nil }
+
+	_, _ = c.GetOrLoad(ctx, "1", 0, load)
+	_, _ = c.GetOrLoad(ctx, "1", 0, load)
+	_, _ = c.MGetOrLoad(ctx, []string{"1", "2"}, 0, func(ctx context.Context, keys []string) (map[string][]byte, error) {
+		return nil, nil
+	})
+
+	obs := rec.obs
+	if len(obs) != 3 {
+		t.Fatalf("got %d observations: %+v", len(obs), obs)
+	}
+	for i, want := range []Observation{
+		{Op: "GetOrLoad", Misses: 1},
+		{Op: "GetOrLoad", Hits: 1},
+		{Op: "MGetOrLoad", Hits: 1, Misses: 1, BatchSize: 2},
+	} {
+		o := obs[i]
+		if o.Op != want.Op || o.Hits != want.Hits || o.Misses != want.Misses || o.BatchSize != want.BatchSize || o.Err != nil {
+			t.Errorf("observation %d = %+v, want %+v", i, o, want)
+		}
+	}
+}
+
+// countWaits counts the callers that wait for a load in flight until the
+// test ends.
+func countWaits(t *testing.T) *atomic.Int32 {
+	var n atomic.Int32
+	loads.mu.Lock()
+	loads.waitHook = func() { n.Add(1) }
+	loads.mu.Unlock()
+	t.Cleanup(func() {
+		loads.mu.Lock()
+		loads.waitHook = nil
+		loads.mu.Unlock()
+	})
+	return &n
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
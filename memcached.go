@@ -0,0 +1,589 @@
+package namestore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// memcachedMaxRelativeExpiry is the largest exptime memcached treats as relative;
+// larger values are interpreted as absolute Unix timestamps.
+const memcachedMaxRelativeExpiry = 30 * 24 * time.Hour
+
+// memcachedMaxRetries bounds the gets/cas and incr/add retry loops under contention.
+const memcachedMaxRetries = 16
+
+// memcachedBatchSize bounds the number of keys per multi-key get.
+const memcachedBatchSize = 100
+
+var errMemcachedContention = errors.New("namestore: memcached: too much contention, giving up")
+
+// MemcachedOption customizes the Memcached driver.
+type MemcachedOption func(*memcachedConfig)
+
+type memcachedConfig struct {
+	poolSize    int
+	dialTimeout time.Duration
+	ioTimeout   time.Duration
+	dialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// WithMemcachedPoolSize limits the number of open connections. Defaults to 10.
+func WithMemcachedPoolSize(size int) MemcachedOption {
+	return func(c *memcachedConfig) {
+		if size > 0 {
+			c.poolSize = size
+		}
+	}
+}
+
+// WithMemcachedTimeouts sets the dial timeout and the per-command I/O timeout
+// used when the context carries no deadline. Zero leaves a value unchanged.
+func WithMemcachedTimeouts(dial, io time.Duration) MemcachedOption {
+	return func(c *memcachedConfig) {
+		if dial > 0 {
+			c.dialTimeout = dial
+		}
+		if io > 0 {
+			c.ioTimeout = io
+		}
+	}
+}
+
+// WithMemcachedDialer replaces the network dialer.
+func WithMemcachedDialer(dial func(ctx context.Context, network, addr string) (net.Conn, error)) MemcachedOption {
+	return func(c *memcachedConfig) {
+		if dial != nil {
+			c.dialContext = dial
+		}
+	}
+}
+
+// memcachedReply is an error or unexpected status line returned by the server.
+// The connection stays usable after it.
+type memcachedReply string
+
+func (r memcachedReply) Error() string { return "namestore: memcached: " + string(r) }
+
+// memcachedItem is one VALUE block of a get/gets response.
+type memcachedItem struct {
+	value []byte
+	cas   uint64
+}
+
+// Memcached implements Driver on top of a memcached server using the text protocol.
+//
+// memcached cannot enumerate keys or report remaining TTLs, so Keys, Scan,
+// Clear, TTL and Persist return ErrUnsupported. Expire with a non-positive ttl clears
+// the expiration (touch with exptime 0), as with Memory.
+//
+// Counters use memcached's native unsigned decimal representation: values
+// written by Incr read back as decimal text, and decrementing below zero
+// clamps at zero. Because the text protocol cannot read a key's remaining TTL,
+// GetSet stores the new value without an expiration.
+type Memcached struct {
+	addr string
+	cfg  memcachedConfig
+	pool *connPool
+}
+
+// NewMemcached creates a Memcached Driver for the server at addr ("host:port").
+// Connections are dialed lazily; call Close to release them.
+func NewMemcached(addr string, opts ...MemcachedOption) *Memcached {
+	cfg := memcachedConfig{
+		poolSize:    10,
+		dialTimeout: 5 * time.Second,
+		ioTimeout:   5 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.dialContext == nil {
+		dialer := &net.Dialer{Timeout: cfg.dialTimeout}
+		cfg.dialContext = dialer.DialContext
+	}
+
+	m := &Memcached{addr: addr, cfg: cfg}
+	m.pool = newConnPool(cfg.poolSize, m.dial)
+	return m
+}
+
+// Close closes all pooled connections. Subsequent operations fail.
+func (m *Memcached) Close() error {
+	return m.pool.close()
+}
+
+func (m *Memcached) dial(ctx context.Context) (*poolConn, error) {
+	dialCtx, cancel := context.WithTimeout(ctx, m.cfg.dialTimeout)
+	defer cancel()
+
+	conn, err := m.cfg.dialContext(dialCtx, "tcp", m.addr)
+	if err != nil {
+		return nil, fmt.Errorf("namestore: memcached dial %s: %w", m.addr, err)
+	}
+	return newPoolConn(conn), nil
+}
+
+// with runs fn on a pooled connection. The connection is discarded unless fn
+// succeeds or fails with a server reply.
+func (m *Memcached) with(ctx context.Context, fn func(c *poolConn) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c, err := m.pool.get(ctx)
+	if err != nil {
+		return err
+	}
+	if err := c.setDeadline(ctx, m.cfg.ioTimeout); err != nil {
+		m.pool.put(c, false)
+		return err
+	}
+
+	err = fn(c)
+	var reply memcachedReply
+	m.pool.put(c, err == nil || errors.As(err, &reply) || errors.Is(err, ErrTypeMismatch))
+	return err
+}
+
+func memcachedValidateKey(key string) error {
+	if key == "" || len(key) > 250 {
+		return fmt.Errorf("namestore: memcached: invalid key length %d", len(key))
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return fmt.Errorf("namestore: memcached: invalid key %q", key)
+		}
+	}
+	return nil
+}
+
+// memcachedExptime converts ttl to memcached's exptime, which is relative
+// seconds up to 30 days and an absolute Unix time beyond that.
+func memcachedExptime(ttl time.Duration) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+	secs := int64((ttl + time.Second - 1) / time.Second)
+	if ttl > memcachedMaxRelativeExpiry {
+		return time.Now().Unix() + secs
+	}
+	return secs
+}
+
+func memcachedReadLine(c *poolConn) (string, error) {
+	line, err := c.br.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+}
+
+// memcachedReplyError converts a server status line into an error.
+func memcachedReplyError(line string) error {
+	if strings.HasPrefix(line, "CLIENT_ERROR cannot increment or decrement non-numeric value") {
+		return fmt.Errorf("%w: %s", ErrTypeMismatch, line)
+	}
+	return memcachedReply(line)
+}
+
+func memcachedWriteStorage(c *poolConn, cmd, key string, value []byte, exptime int64, cas uint64) {
+	fmt.Fprintf(c.bw, "%s %s 0 %d %d", cmd, key, exptime, len(value))
+	if cmd == "cas" {
+		fmt.Fprintf(c.bw, " %d", cas)
+	}
+	_, _ = c.bw.WriteString("\r\n")
+	_, _ = c.bw.Write(value)
+	_, _ = c.bw.WriteString("\r\n")
+}
+
+// store issues a storage command and returns the status line
+// (STORED, NOT_STORED, EXISTS or NOT_FOUND).
+func (m *Memcached) store(ctx context.Context, cmd, key string, value []byte, exptime int64, cas uint64) (string, error) {
+	if err := memcachedValidateKey(key); err != nil {
+		return "", err
+	}
+	var status string
+	err := m.with(ctx, func(c *poolConn) error {
+		memcachedWriteStorage(c, cmd, key, value, exptime, cas)
+		if err := c.bw.Flush(); err != nil {
+			return err
+		}
+		line, err := memcachedReadLine(c)
+		if err != nil {
+			return err
+		}
+		switch line {
+		case "STORED", "NOT_STORED", "EXISTS", "NOT_FOUND":
+			status = line
+			return nil
+		}
+		return memcachedReplyError(line)
+	})
+	return status, err
+}
+
+// retrieve issues get (or gets) for keys and returns the items found.
+func (m *Memcached) retrieve(ctx context.Context, cmd string, keys []string) (map[string]memcachedItem, error) {
+	for _, k := range keys {
+		if err := memcachedValidateKey(k); err != nil {
+			return nil, err
+		}
+	}
+
+	items := make(map[string]memcachedItem, len(keys))
+	err := m.with(ctx, func(c *poolConn) error {
+		fmt.Fprintf(c.bw, "%s %s\r\n", cmd, strings.Join(keys, " "))
+		if err := c.bw.Flush(); err != nil {
+			return err
+		}
+		for {
+			line, err := memcachedReadLine(c)
+			if err != nil {
+				return err
+			}
+			if line == "END" {
+				return nil
+			}
+			key, item, err := memcachedReadItem(c, line)
+			if err != nil {
+				return err
+			}
+			items[key] = item
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// memcachedReadItem parses "VALUE <key> <flags> <bytes> [<cas>]" and its data block.
+func memcachedReadItem(c *poolConn, header string) (string, memcachedItem, error) {
+	fields := strings.Fields(header)
+	if len(fields) < 4 || fields[0] != "VALUE" {
+		return "", memcachedItem{}, memcachedReplyError(header)
+	}
+	n, err := strconv.Atoi(fields[3])
+	if err != nil || n < 0 {
+		return "", memcachedItem{}, fmt.Errorf("namestore: memcached: bad value header %q", header)
+	}
+
+	var item memcachedItem
+	if len(fields) >= 5 {
+		if item.cas, err = strconv.ParseUint(fields[4], 10, 64); err != nil {
+			return "", memcachedItem{}, fmt.Errorf("namestore: memcached: bad cas in %q", header)
+		}
+	}
+	buf := make([]byte, n+2)
+	if _, err := io.ReadFull(c.br, buf); err != nil {
+		return "", memcachedItem{}, err
+	}
+	if n > 0 {
+		item.value = buf[:n:n]
+	}
+	return fields[1], item, nil
+}
+
+// simple sends a single-line command and returns the single-line response.
+func (m *Memcached) simple(ctx context.Context, key, format string, args ...any) (string, error) {
+	if err := memcachedValidateKey(key); err != nil {
+		return "", err
+	}
+	var line string
+	err := m.with(ctx, func(c *poolConn) error {
+		fmt.Fprintf(c.bw, format, args...)
+		if err := c.bw.Flush(); err != nil {
+			return err
+		}
+		var err error
+		line, err = memcachedReadLine(c)
+		return err
+	})
+	return line, err
+}
+
+func (m *Memcached) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	status, err := m.store(ctx, "set", key, value, memcachedExptime(ttl), 0)
+	if err != nil {
+		return err
+	}
+	if status != "STORED" {
+		return memcachedReply(status)
+	}
+	return nil
+}
+
+// SetNX maps to add, which only stores keys that do not exist.
+func (m *Memcached) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	status, err := m.store(ctx, "add", key, value, memcachedExptime(ttl), 0)
+	if err != nil {
+		return false, err
+	}
+	return status == "STORED", nil
+}
+
+func (m *Memcached) Get(ctx context.Context, key string) ([]byte, error) {
+	items, err := m.retrieve(ctx, "get", []string{key})
+	if err != nil {
+		return nil, err
+	}
+	item, ok := items[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return item.value, nil
+}
+
+func (m *Memcached) Delete(ctx context.Context, key string) error {
+	line, err := m.simple(ctx, key, "delete %s\r\n", key)
+	if err != nil {
+		return err
+	}
+	if line != "DELETED" && line != "NOT_FOUND" {
+		return memcachedReplyError(line)
+	}
+	return nil
+}
+
+func (m *Memcached) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := m.Get(ctx, key)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// MGet retrieves multiple keys with multi-key get commands.
+func (m *Memcached) MGet(ctx context.Context, keys []string) (map[string][]byte, error) {
+	result := make(map[string][]byte, len(keys))
+	for start := 0; start < len(keys); start += memcachedBatchSize {
+		end := min(start+memcachedBatchSize, len(keys))
+		items, err := m.retrieve(ctx, "get", keys[start:end])
+		if err != nil {
+			return nil, err
+		}
+		for k, item := range items {
+			result[k] = item.value
+		}
+	}
+	return result, nil
+}
+
+// MSet pipelines one set per pair. memcached has no multi-key atomicity, so a
+// failure part way through can leave some pairs written.
+func (m *Memcached) MSet(ctx context.Context, pairs map[string][]byte, ttl time.Duration) error {
+	if len(pairs) == 0 {
+		return nil
+	}
+	for k := range pairs {
+		if err := memcachedValidateKey(k); err != nil {
+			return err
+		}
+	}
+
+	exptime := memcachedExptime(ttl)
+	return m.with(ctx, func(c *poolConn) error {
+		for k, v := range pairs {
+			memcachedWriteStorage(c, "set", k, v, exptime, 0)
+		}
+		if err := c.bw.Flush(); err != nil {
+			return err
+		}
+		var firstErr error
+		for range pairs {
+			line, err := memcachedReadLine(c)
+			if err != nil {
+				return err
+			}
+			if line != "STORED" && firstErr == nil {
+				firstErr = memcachedReplyError(line)
+			}
+		}
+		return firstErr
+	})
+}
+
+// MDel pipelines one delete per key.
+func (m *Memcached) MDel(ctx context.Context, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	for _, k := range keys {
+		if err := memcachedValidateKey(k); err != nil {
+			return err
+		}
+	}
+
+	return m.with(ctx, func(c *poolConn) error {
+		for _, k := range keys {
+			fmt.Fprintf(c.bw, "delete %s\r\n", k)
+		}
+		if err := c.bw.Flush(); err != nil {
+			return err
+		}
+		var firstErr error
+		for range keys {
+			line, err := memcachedReadLine(c)
+			if err != nil {
+				return err
+			}
+			if line != "DELETED" && line != "NOT_FOUND" && firstErr == nil {
+				firstErr = memcachedReplyError(line)
+			}
+		}
+		return firstErr
+	})
+}
+
+// TTL is not supported: the text protocol cannot report remaining lifetimes.
+func (m *Memcached) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return 0, fmt.Errorf("%w: memcached cannot report TTL", ErrUnsupported)
+}
+
+// Expire maps to touch. A non-positive ttl clears the expiration.
+func (m *Memcached) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	line, err := m.simple(ctx, key, "touch %s %d\r\n", key, memcachedExptime(ttl))
+	if err != nil {
+		return err
+	}
+	switch line {
+	case "TOUCHED":
+		return nil
+	case "NOT_FOUND":
+		return ErrNotFound
+	}
+	return memcachedReplyError(line)
+}
+
+// Persist is not supported; use Expire with a zero ttl to clear an expiration.
+func (m *Memcached) Persist(ctx context.Context, key string) error {
+	return fmt.Errorf("%w: memcached cannot persist keys", ErrUnsupported)
+}
+
+// Keys is not supported: memcached cannot enumerate keys.
+func (m *Memcached) Keys(ctx context.Context, prefix, pattern string) ([]string, error) {
+	return nil, fmt.Errorf("%w: memcached cannot list keys", ErrUnsupported)
+}
+
+// Scan is not supported: memcached cannot enumerate keys.
+func (m *Memcached) Scan(ctx context.Context, prefix, pattern string, cursor uint64, count int) ([]string, uint64, error) {
+	return nil, 0, fmt.Errorf("%w: memcached cannot scan keys", ErrUnsupported)
+}
+
+// Clear is not supported: memcached cannot enumerate keys.
+func (m *Memcached) Clear(ctx context.Context, prefix string) error {
+	return fmt.Errorf("%w: memcached cannot clear a prefix", ErrUnsupported)
+}
+
+// Incr atomically adjusts a native memcached counter, creating it when missing.
+// Negative deltas use decr, which clamps at zero.
+func (m *Memcached) Incr(ctx context.Context, key string, delta int64) (int64, error) {
+	cmd, amount := "incr", uint64(delta)
+	if delta < 0 {
+		// -(delta+1)+1 avoids negating math.MinInt64.
+		cmd, amount = "decr", uint64(-(delta+1))+1
+	}
+
+	for i := 0; i < memcachedMaxRetries; i++ {
+		line, err := m.simple(ctx, key, "%s %s %d\r\n", cmd, key, amount)
+		if err != nil {
+			return 0, err
+		}
+		if line != "NOT_FOUND" {
+			n, perr := strconv.ParseUint(strings.TrimSpace(line), 10, 64)
+			if perr != nil {
+				return 0, memcachedReplyError(line)
+			}
+			return int64(n), nil
+		}
+
+		// Missing counters start from zero, so a decrement clamps to 0.
+		var initial int64
+		if delta > 0 {
+			initial = delta
+		}
+		status, err := m.store(ctx, "add", key, strconv.AppendInt(nil, initial, 10), 0, 0)
+		if err != nil {
+			return 0, err
+		}
+		if status == "STORED" {
+			return initial, nil
+		}
+		// Lost the race to create the counter; increment the winner's value.
+	}
+	return 0, errMemcachedContention
+}
+
+// Decr atomically decrements the counter, clamping at zero.
+func (m *Memcached) Decr(ctx context.Context, key string, delta int64) (int64, error) {
+	if delta == math.MinInt64 {
+		return 0, fmt.Errorf("namestore: memcached: decrement %d out of range", delta)
+	}
+	return m.Incr(ctx, key, -delta)
+}
+
+// GetSet atomically replaces the value using gets and cas, returning the old value.
+// Missing keys are created with add and report ErrNotFound, as with Memory.
+func (m *Memcached) GetSet(ctx context.Context, key string, value []byte) ([]byte, error) {
+	for i := 0; i < memcachedMaxRetries; i++ {
+		items, err := m.retrieve(ctx, "gets", []string{key})
+		if err != nil {
+			return nil, err
+		}
+
+		item, ok := items[key]
+		if !ok {
+			status, err := m.store(ctx, "add", key, value, 0, 0)
+			if err != nil {
+				return nil, err
+			}
+			if status == "STORED" {
+				return nil, ErrNotFound
+			}
+			continue
+		}
+
+		status, err := m.store(ctx, "cas", key, value, 0, item.cas)
+		if err != nil {
+			return nil, err
+		}
+		if status == "STORED" {
+			return item.value, nil
+		}
+	}
+	return nil, errMemcachedContention
+}
+
+// CompareAndSwap compares with gets and swaps with cas, retrying when another
+// writer changes the key in between.
+func (m *Memcached) CompareAndSwap(ctx context.Context, key string, oldValue, newValue []byte, ttl time.Duration) (bool, error) {
+	for i := 0; i < memcachedMaxRetries; i++ {
+		items, err := m.retrieve(ctx, "gets", []string{key})
+		if err != nil {
+			return false, err
+		}
+		item, ok := items[key]
+		if !ok || !bytes.Equal(item.value, oldValue) {
+			return false, nil
+		}
+
+		status, err := m.store(ctx, "cas", key, newValue, memcachedExptime(ttl), item.cas)
+		if err != nil {
+			return false, err
+		}
+		switch status {
+		case "STORED":
+			return true, nil
+		case "NOT_FOUND":
+			return false, nil
+		}
+	}
+	return false, errMemcachedContention
+}
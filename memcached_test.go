@@ -0,0 +1,477 @@
+package namestore
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeMemcachedItem struct {
+	value  []byte
+	cas    uint64
+	expire time.Time
+}
+
+// fakeMemcached is an in-process server implementing the memcached text
+// commands used by the Memcached driver.
+type fakeMemcached struct {
+	ln net.Listener
+
+	mu      sync.Mutex
+	items   map[string]fakeMemcachedItem
+	nextCAS uint64
+	conns   int
+}
+
+func newFakeMemcached(t *testing.T) *fakeMemcached {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	f := &fakeMemcached{ln: ln, items: make(map[string]fakeMemcachedItem)}
+	go f.serve()
+	t.Cleanup(func() { _ = ln.Close() })
+	return f
+}
+
+func (f *fakeMemcached) serve() {
+	for {
+		conn, err := f.ln.Accept()
+		if err != nil {
+			return
+		}
+		f.mu.Lock()
+		f.conns++
+		f.mu.Unlock()
+		go f.handle(conn)
+	}
+}
+
+func (f *fakeMemcached) handle(conn net.Conn) {
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	bw := bufio.NewWriter(conn)
+
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		var data []byte
+		switch fields[0] {
+		case "set", "add", "cas":
+			n, _ := strconv.Atoi(fields[4])
+			data = make([]byte, n+2)
+			if _, err := io.ReadFull(br, data); err != nil {
+				return
+			}
+			data = data[:n]
+		}
+
+		f.mu.Lock()
+		f.exec(bw, fields, data)
+		f.mu.Unlock()
+		if err := bw.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func (f *fakeMemcached) lookup(key string) (fakeMemcachedItem, bool) {
+	item, ok := f.items[key]
+	if ok && !item.expire.IsZero() && time.Now().After(item.expire) {
+		delete(f.items, key)
+		return item, false
+	}
+	return item, ok
+}
+
+func fakeMemcachedExpire(field string) time.Time {
+	secs, _ := strconv.ParseInt(field, 10, 64)
+	switch {
+	case secs == 0:
+		return time.Time{}
+	case secs > int64(memcachedMaxRelativeExpiry/time.Second):
+		return time.Unix(secs, 0)
+	default:
+		return time.Now().Add(time.Duration(secs) * time.Second)
+	}
+}
+
+func (f *fakeMemcached) put(key string, value []byte, expire time.Time) {
+	f.nextCAS++
+	f.items[key] = fakeMemcachedItem{value: value, cas: f.nextCAS, expire: expire}
+}
+
+//nolint:gocyclo,cyclop,funlen // A flat command switch reads best for a test fake.
+func (f *fakeMemcached) exec(w *bufio.Writer, fields []string, data []byte) {
+	switch fields[0] {
+	case "get", "gets":
+		for _, key := range fields[1:] {
+			item, ok := f.lookup(key)
+			if !ok {
+				continue
+			}
+			if fields[0] == "gets" {
+				fmt.Fprintf(w, "VALUE %s 0 %d %d\r\n", key, len(item.value), item.cas)
+			} else {
+				fmt.Fprintf(w, "VALUE %s 0 %d\r\n", key, len(item.value))
+			}
+			_, _ = w.Write(item.value)
+			_, _ = w.WriteString("\r\n")
+		}
+		_, _ = w.WriteString("END\r\n")
+	case "set":
+		f.put(fields[1], data, fakeMemcachedExpire(fields[3]))
+		_, _ = w.WriteString("STORED\r\n")
+	case "add":
+		if _, ok := f.lookup(fields[1]); ok {
+			_, _ = w.WriteString("NOT_STORED\r\n")
+			return
+		}
+		f.put(fields[1], data, fakeMemcachedExpire(fields[3]))
+		_, _ = w.WriteString("STORED\r\n")
+	case "cas":
+		item, ok := f.lookup(fields[1])
+		cas, _ := strconv.ParseUint(fields[5], 10, 64)
+		switch {
+		case !ok:
+			_, _ = w.WriteString("NOT_FOUND\r\n")
+		case item.cas != cas:
+			_, _ = w.WriteString("EXISTS\r\n")
+		default:
+			f.put(fields[1], data, fakeMemcachedExpire(fields[3]))
+			_, _ = w.WriteString("STORED\r\n")
+		}
+	case "incr", "decr":
+		item, ok := f.lookup(fields[1])
+		if !ok {
+			_, _ = w.WriteString("NOT_FOUND\r\n")
+			return
+		}
+		cur, err := strconv.ParseUint(string(item.value), 10, 64)
+		if err != nil {
+			_, _ = w.WriteString("CLIENT_ERROR cannot increment or decrement non-numeric value\r\n")
+			return
+		}
+		delta, _ := strconv.ParseUint(fields[2], 10, 64)
+		if fields[0] == "incr" {
+			cur += delta
+		} else if delta > cur {
+			cur = 0
+		} else {
+			cur -= delta
+		}
+		f.put(fields[1], []byte(strconv.FormatUint(cur, 10)), item.expire)
+		fmt.Fprintf(w, "%d\r\n", cur)
+	case "delete":
+		if _, ok := f.lookup(fields[1]); !ok {
+			_, _ = w.WriteString("NOT_FOUND\r\n")
+			return
+		}
+		delete(f.items, fields[1])
+		_, _ = w.WriteString("DELETED\r\n")
+	case "touch":
+		item, ok := f.lookup(fields[1])
+		if !ok {
+			_, _ = w.WriteString("NOT_FOUND\r\n")
+			return
+		}
+		item.expire = fakeMemcachedExpire(fields[2])
+		f.items[fields[1]] = item
+		_, _ = w.WriteString("TOUCHED\r\n")
+	default:
+		_, _ = w.WriteString("ERROR\r\n")
+	}
+}
+
+func (f *fakeMemcached) expireAt(key string) time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.items[key].expire
+}
+
+func newTestMemcached(t *testing.T, opts ...MemcachedOption) (*Memcached, *fakeMemcached) {
+	t.Helper()
+	f := newFakeMemcached(t)
+	m := NewMemcached(f.ln.Addr().String(), opts...)
+	t.Cleanup(func() { _ = m.Close() })
+	return m, f
+}
+
+func TestMemcached_BasicOperations(t *testing.T) {
+	m, _ := newTestMemcached(t)
+	ctx := context.Background()
+
+	if err := m.Set(ctx, "k", []byte("v"), 0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	got, err := m.Get(ctx, "k")
+	if err != nil || string(got) != "v" {
+		t.Fatalf("Get = %q, %v", got, err)
+	}
+	if _, err := m.Get(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get missing: expected ErrNotFound, got %v", err)
+	}
+
+	if exists, _ := m.Exists(ctx, "k"); !exists {
+		t.Error("Exists should report true")
+	}
+	if err := m.Delete(ctx, "k"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := m.Delete(ctx, "k"); err != nil {
+		t.Errorf("Delete of missing key should succeed, got %v", err)
+	}
+	if exists, _ := m.Exists(ctx, "k"); exists {
+		t.Error("key should be deleted")
+	}
+
+	if err := m.Set(ctx, "has space", []byte("v"), 0); err == nil {
+		t.Error("keys with whitespace should be rejected")
+	}
+}
+
+func TestMemcached_SetNX(t *testing.T) {
+	m, _ := newTestMemcached(t)
+	ctx := context.Background()
+
+	ok, err := m.SetNX(ctx, "k", []byte("v1"), 0)
+	if err != nil || !ok {
+		t.Fatalf("SetNX new key = %v, %v", ok, err)
+	}
+	ok, _ = m.SetNX(ctx, "k", []byte("v2"), 0)
+	if ok {
+		t.Error("SetNX should fail for existing key")
+	}
+}
+
+func TestMemcached_Expiry(t *testing.T) {
+	m, f := newTestMemcached(t)
+	ctx := context.Background()
+
+	_ = m.Set(ctx, "short", []byte("v"), 1500*time.Millisecond)
+	if exp := time.Until(f.expireAt("short")); exp <= time.Second || exp > 2*time.Second {
+		t.Errorf("sub-second TTLs should round up, expire in %v", exp)
+	}
+
+	_ = m.Set(ctx, "long", []byte("v"), 60*24*time.Hour)
+	if exp := time.Until(f.expireAt("long")); exp < 59*24*time.Hour {
+		t.Errorf("long TTLs should use absolute exptime, expire in %v", exp)
+	}
+
+	if err := m.Expire(ctx, "short", 0); err != nil {
+		t.Fatalf("Expire failed: %v", err)
+	}
+	if exp := f.expireAt("short"); !exp.IsZero() {
+		t.Errorf("Expire(0) should clear expiration, got %v", exp)
+	}
+	if err := m.Expire(ctx, "missing", time.Second); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expire missing: expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestMemcached_Unsupported(t *testing.T) {
+	m, _ := newTestMemcached(t)
+	ctx := context.Background()
+
+	if _, err := m.TTL(ctx, "k"); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("TTL: expected ErrUnsupported, got %v", err)
+	}
+	if err := m.Persist(ctx, "k"); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Persist: expected ErrUnsupported, got %v", err)
+	}
+	if _, err := m.Keys(ctx, "p", "*"); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Keys: expected ErrUnsupported, got %v", err)
+	}
+	if _, _, err := m.Scan(ctx, "p", "*", 0, 10); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Scan: expected ErrUnsupported, got %v", err)
+	}
+	if err := m.Clear(ctx, "p"); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Clear: expected ErrUnsupported, got %v", err)
+	}
+}
+
+func TestMemcached_Batch(t *testing.T) {
+	m, _ := newTestMemcached(t)
+	ctx := context.Background()
+
+	pairs := make(map[string][]byte)
+	keys := make([]string, 0, 150)
+	for i := 0; i < 150; i++ {
+		k := fmt.Sprintf("key:%d", i)
+		pairs[k] = []byte(strconv.Itoa(i))
+		keys = append(keys, k)
+	}
+	if err := m.MSet(ctx, pairs, time.Minute); err != nil {
+		t.Fatalf("MSet failed: %v", err)
+	}
+
+	got, err := m.MGet(ctx, append([]string{"missing"}, keys...))
+	if err != nil {
+		t.Fatalf("MGet failed: %v", err)
+	}
+	if len(got) != 150 || string(got["key:42"]) != "42" {
+		t.Errorf("MGet returned %d keys, key:42=%q", len(got), got["key:42"])
+	}
+
+	toDelete := append([]string{"missing"}, keys[:100]...)
+	if err := m.MDel(ctx, toDelete); err != nil {
+		t.Fatalf("MDel failed: %v", err)
+	}
+	got, _ = m.MGet(ctx, keys)
+	if len(got) != 50 {
+		t.Errorf("MGet after MDel returned %d keys, want 50", len(got))
+	}
+}
+
+func TestMemcached_IncrDecr(t *testing.T) {
+	m, _ := newTestMemcached(t)
+	ctx := context.Background()
+
+	n, err := m.Incr(ctx, "counter", 5)
+	if err != nil || n != 5 {
+		t.Fatalf("Incr on missing key = %d, %v", n, err)
+	}
+	n, _ = m.Incr(ctx, "counter", 3)
+	if n != 8 {
+		t.Errorf("Incr = %d, want 8", n)
+	}
+	n, _ = m.Decr(ctx, "counter", 10)
+	if n != 0 {
+		t.Errorf("Decr below zero = %d, want 0 (clamped)", n)
+	}
+	n, _ = m.Decr(ctx, "fresh", 1)
+	if n != 0 {
+		t.Errorf("Decr on missing key = %d, want 0", n)
+	}
+
+	_ = m.Set(ctx, "text", []byte("abc"), 0)
+	if _, err := m.Incr(ctx, "text", 1); !errors.Is(err, ErrTypeMismatch) {
+		t.Errorf("Incr on text: expected ErrTypeMismatch, got %v", err)
+	}
+	_ = m.Set(ctx, "big", []byte("100"), 0)
+	if n, err := m.Incr(ctx, "big", math.MinInt64); err != nil || n != 0 {
+		t.Errorf("Incr by MinInt64 = %d, %v, want 0 (clamped)", n, err)
+	}
+	if _, err := m.Decr(ctx, "big", math.MinInt64); err == nil {
+		t.Error("Decr by MinInt64 should fail")
+	}
+	if v, _ := m.Get(ctx, "big"); string(v) != "0" {
+		t.Errorf("value after rejected Decr = %q", v)
+	}
+}
+
+func TestMemcached_ConcurrentIncr(t *testing.T) {
+	m, _ := newTestMemcached(t, WithMemcachedPoolSize(4))
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 40; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := m.Incr(ctx, "counter", 1); err != nil {
+				t.Errorf("Incr failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	got, _ := m.Get(ctx, "counter")
+	if string(got) != "40" {
+		t.Errorf("counter = %q, want 40", got)
+	}
+}
+
+func TestMemcached_GetSet(t *testing.T) {
+	m, _ := newTestMemcached(t)
+	ctx := context.Background()
+
+	old, err := m.GetSet(ctx, "k", []byte("first"))
+	if !errors.Is(err, ErrNotFound) || old != nil {
+		t.Errorf("GetSet missing = %q, %v", old, err)
+	}
+	got, _ := m.Get(ctx, "k")
+	if string(got) != "first" {
+		t.Errorf("GetSet on missing key should still write, got %q", got)
+	}
+
+	old, err = m.GetSet(ctx, "k", []byte("second"))
+	if err != nil || string(old) != "first" {
+		t.Errorf("GetSet = %q, %v", old, err)
+	}
+}
+
+func TestMemcached_CompareAndSwap(t *testing.T) {
+	m, f := newTestMemcached(t)
+	ctx := context.Background()
+
+	_ = m.Set(ctx, "k", []byte("v1"), 0)
+	swapped, err := m.CompareAndSwap(ctx, "k", []byte("v1"), []byte("v2"), time.Minute)
+	if err != nil || !swapped {
+		t.Fatalf("CompareAndSwap = %v, %v", swapped, err)
+	}
+	if f.expireAt("k").IsZero() {
+		t.Error("CompareAndSwap should apply ttl")
+	}
+
+	swapped, _ = m.CompareAndSwap(ctx, "k", []byte("v1"), []byte("v3"), 0)
+	if swapped {
+		t.Error("CompareAndSwap should fail on mismatch")
+	}
+	swapped, _ = m.CompareAndSwap(ctx, "missing", nil, []byte("x"), 0)
+	if swapped {
+		t.Error("CompareAndSwap should fail on missing key")
+	}
+}
+
+func TestMemcached_ServerError(t *testing.T) {
+	m, f := newTestMemcached(t, WithMemcachedPoolSize(1))
+	ctx := context.Background()
+
+	// An unknown-command reply is a server error but keeps the connection usable.
+	if _, err := m.simple(ctx, "k", "bogus k\r\n"); err != nil {
+		t.Fatalf("simple failed: %v", err)
+	}
+	if err := m.Set(ctx, "k", []byte("v"), 0); err != nil {
+		t.Fatalf("Set after server error failed: %v", err)
+	}
+
+	f.mu.Lock()
+	conns := f.conns
+	f.mu.Unlock()
+	if conns != 1 {
+		t.Errorf("opened %d connections, want 1", conns)
+	}
+}
+
+func TestMemcached_WithClient(t *testing.T) {
+	m, _ := newTestMemcached(t)
+	c := New[string]("app", "sessions", WithDriver[string](m))
+	ctx := context.Background()
+
+	_ = c.Set(ctx, "abc", []byte("token"), time.Minute)
+	got, err := c.Get(ctx, "abc")
+	if err != nil || string(got) != "token" {
+		t.Errorf("Get = %q, %v", got, err)
+	}
+	if _, err := c.Keys(ctx, "*"); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Keys: expected ErrUnsupported, got %v", err)
+	}
+}
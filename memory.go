@@ -7,23 +7,105 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type entry struct {
-	value  []byte
-	expire time.Time
+	value   []byte
+	expire  time.Time
+	stats   *entryStats // access statistics, only tracked when limits are set
+	version uint64      // changes on every write, for transactions
 }
 
 // Memory implements Driver with thread-safe in-memory storage.
 type Memory struct {
 	mu   sync.RWMutex
 	data map[string]entry
+	used int64  // total bytes of keys and values in data
+	seq  uint64 // last assigned entry version
+
+	cfg     memoryConfig
+	janitor *periodic
+	scans   memoryScans
+	hub     *watchHub
+
+	snapshotPath string // set by OpenMemory
+	snapshotter  *periodic
+	log          *appendLog // set by OpenMemory
+	logBatch     []byte     // records of the operation in progress
+	logMaint     *periodic
+	compacting   atomic.Bool
+	closeOnce    sync.Once
+	closeErr     error
+}
+
+// MemoryOption customizes the Memory driver.
+type MemoryOption func(*memoryConfig)
+
+type memoryConfig struct {
+	janitorInterval time.Duration
+	maxEntries      int
+	maxBytes        int64
+	policy          EvictionPolicy
+	watchBuffer     int
+
+	snapshotPath     string
+	snapshotInterval time.Duration
+
+	logPath           string
+	logPolicy         FsyncPolicy
+	logCompactionSize int64
+
+	clock Clock
 }
 
 // NewMemory creates an in-memory Driver instance.
-func NewMemory() Driver {
-	return &Memory{data: make(map[string]entry)}
+func NewMemory(opts ...MemoryOption) Driver {
+	return newMemory(newMemoryConfig(opts))
+}
+
+func newMemoryConfig(opts []MemoryOption) memoryConfig {
+	var cfg memoryConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+func newMemory(cfg memoryConfig) *Memory {
+	m := &Memory{data: make(map[string]entry), cfg: cfg, hub: newWatchHub(cfg.watchBuffer)}
+	if cfg.janitorInterval > 0 {
+		m.janitor = startJanitor(cfg.janitorInterval, m.sweep)
+	}
+	return m
+}
+
+// Close stops background work such as the janitor, closes Watch channels
+// and, when opened with WithSnapshotFile, saves a final snapshot. With
+// WithAppendLog it syncs and closes the log, reporting any earlier write
+// error, and later writes are no longer logged. It is safe to call more than
+// once; the stored data remains readable afterwards.
+func (m *Memory) Close() error {
+	m.closeOnce.Do(func() {
+		m.janitor.stop()
+		m.snapshotter.stop()
+		m.logMaint.stop()
+		if m.snapshotPath != "" {
+			m.closeErr = m.SaveSnapshot(m.snapshotPath)
+		}
+		if m.log != nil {
+			m.mu.Lock()
+			err := m.log.close()
+			m.log = nil
+			m.mu.Unlock()
+			if m.closeErr == nil {
+				m.closeErr = err
+			}
+		}
+		m.hub.close()
+	})
+	return m.closeErr
 }
 
 // NewInMemoryDriver is an alias for NewMemory for backward compatibility.
@@ -34,23 +116,24 @@ func NewInMemoryDriver() Driver {
 
 func (m *Memory) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
 	m.mu.Lock()
-	defer m.mu.Unlock()
-	m.data[key] = entry{value: clone(value), expire: expiry(ttl)}
-	return nil
+	defer m.unlock()
+	return m.setLocked(key, value, expiry(m.cfg.now(), ttl), EventSet)
 }
 
 func (m *Memory) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
 	m.mu.Lock()
-	defer m.mu.Unlock()
+	defer m.unlock()
 	if entry, ok := m.data[key]; ok {
-		now := time.Now()
+		now := m.cfg.now()
 		if entry.expiredAt(now) {
-			delete(m.data, key)
+			m.removeLocked(key, EventExpired)
 		} else {
 			return false, nil
 		}
 	}
-	m.data[key] = entry{value: clone(value), expire: expiry(ttl)}
+	if err := m.setLocked(key, value, expiry(m.cfg.now(), ttl), EventSet); err != nil {
+		return false, err
+	}
 	return true, nil
 }
 
@@ -64,15 +147,16 @@ func (m *Memory) Get(ctx context.Context, key string) ([]byte, error) {
 		return nil, ErrNotFound
 	}
 
-	now := time.Now()
+	now := m.cfg.now()
 	// Check expiration without lock first.
 	if !e.expiredAt(now) {
+		e.stats.touch(now)
 		return clone(e.value), nil
 	}
 
 	// Slow path: entry expired, need write lock to delete.
 	m.mu.Lock()
-	defer m.mu.Unlock()
+	defer m.unlock()
 
 	// Re-check after acquiring write lock (double-check pattern).
 	e, ok = m.data[key]
@@ -80,19 +164,20 @@ func (m *Memory) Get(ctx context.Context, key string) ([]byte, error) {
 		return nil, ErrNotFound
 	}
 
-	now = time.Now()
+	now = m.cfg.now()
 	if e.expiredAt(now) {
-		delete(m.data, key)
+		m.removeLocked(key, EventExpired)
 		return nil, ErrNotFound
 	}
 
+	e.stats.touch(now)
 	return clone(e.value), nil
 }
 
 func (m *Memory) Delete(ctx context.Context, key string) error {
 	m.mu.Lock()
-	defer m.mu.Unlock()
-	delete(m.data, key)
+	defer m.unlock()
+	m.removeLocked(key, EventDel)
 	return nil
 }
 
@@ -106,7 +191,7 @@ func (m *Memory) Exists(ctx context.Context, key string) (bool, error) {
 		return false, nil
 	}
 
-	now := time.Now()
+	now := m.cfg.now()
 	// Check expiration without lock first.
 	if !e.expiredAt(now) {
 		return true, nil
@@ -114,7 +199,7 @@ func (m *Memory) Exists(ctx context.Context, key string) (bool, error) {
 
 	// Slow path: entry expired, need write lock to delete.
 	m.mu.Lock()
-	defer m.mu.Unlock()
+	defer m.unlock()
 
 	// Re-check after acquiring write lock.
 	e, ok = m.data[key]
@@ -122,9 +207,9 @@ func (m *Memory) Exists(ctx context.Context, key string) (bool, error) {
 		return false, nil
 	}
 
-	now = time.Now()
+	now = m.cfg.now()
 	if e.expiredAt(now) {
-		delete(m.data, key)
+		m.removeLocked(key, EventExpired)
 		return false, nil
 	}
 
@@ -138,17 +223,6 @@ func (e entry) expiredAt(now time.Time) bool {
 	return now.After(e.expire)
 }
 
-func (e entry) expired() bool {
-	return e.expiredAt(time.Now())
-}
-
-func expiry(ttl time.Duration) time.Time {
-	if ttl <= 0 {
-		return time.Time{}
-	}
-	return time.Now().Add(ttl)
-}
-
 func clone(src []byte) []byte {
 	if len(src) == 0 {
 		return nil
@@ -161,54 +235,144 @@ func clone(src []byte) []byte {
 // MGet retrieves multiple keys.
 func (m *Memory) MGet(ctx context.Context, keys []string) (map[string][]byte, error) {
 	m.mu.Lock()
-	defer m.mu.Unlock()
+	defer m.unlock()
 
-	now := time.Now()
+	now := m.cfg.now()
 	result := make(map[string][]byte, len(keys))
 	for _, key := range keys {
-		if entry, ok := m.data[key]; ok && !entry.expiredAt(now) {
-			result[key] = clone(entry.value)
-		}
+		m.mgetLocked(now, key, result)
 	}
 
 	return result, nil
 }
 
+func (m *Memory) mgetLocked(now time.Time, key string, result map[string][]byte) {
+	if entry, ok := m.data[key]; ok && !entry.expiredAt(now) {
+		entry.stats.touch(now)
+		result[key] = clone(entry.value)
+	}
+}
+
 // MSet sets multiple key-value pairs.
 func (m *Memory) MSet(ctx context.Context, pairs map[string][]byte, ttl time.Duration) error {
 	m.mu.Lock()
-	defer m.mu.Unlock()
+	defer m.unlock()
 
-	exp := expiry(ttl)
+	if m.cfg.limited() {
+		var grow growth
+		for key, value := range pairs {
+			grow.add(m, key, value)
+		}
+		if err := m.reserveLocked(grow, func(key string) bool { _, ok := pairs[key]; return ok }); err != nil {
+			return err
+		}
+	}
+
+	exp := expiry(m.cfg.now(), ttl)
 	for key, value := range pairs {
-		m.data[key] = entry{value: clone(value), expire: exp}
+		m.msetLocked(key, value, exp)
 	}
 
 	return nil
 }
 
+// msetLocked stores an entry whose space has already been reserved.
+func (m *Memory) msetLocked(key string, value []byte, exp time.Time) {
+	m.storeLocked(key, entry{value: clone(value), expire: exp}, EventSet)
+}
+
+// setLocked makes room for and stores a single entry.
+func (m *Memory) setLocked(key string, value []byte, exp time.Time, typ EventType) error {
+	if m.cfg.limited() {
+		var grow growth
+		grow.add(m, key, value)
+		if err := m.reserveLocked(grow, func(k string) bool { return k == key }); err != nil {
+			return err
+		}
+	}
+	m.storeLocked(key, entry{value: clone(value), expire: exp}, typ)
+	return nil
+}
+
+// storeLocked writes e under key, keeping the byte count and access
+// statistics up to date, and publishes typ to watchers. All writes to data
+// go through it.
+func (m *Memory) storeLocked(key string, e entry, typ EventType) {
+	old, ok := m.data[key]
+	if ok {
+		m.used -= entrySize(key, old.value)
+	}
+	m.used += entrySize(key, e.value)
+
+	if m.cfg.limited() {
+		now := m.cfg.now()
+		if ok && old.stats != nil {
+			e.stats = old.stats
+		} else {
+			e.stats = newEntryStats(now)
+		}
+		e.stats.touch(now)
+	}
+	m.seq++
+	e.version = m.seq
+	m.data[key] = e
+	m.logPutLocked(key, e)
+	m.hub.publish(typ, key)
+}
+
+// removeLocked deletes key and publishes typ to watchers if it was present.
+// Expiry is not written to the append log, since replay drops expired
+// entries by itself.
+func (m *Memory) removeLocked(key string, typ EventType) {
+	if m.deleteLocked(key) {
+		if typ != EventExpired {
+			m.logDelLocked(key)
+		}
+		m.hub.publish(typ, key)
+	}
+}
+
+// deleteLocked deletes key and releases its bytes, reporting whether it was
+// present. All deletes from data go through it.
+func (m *Memory) deleteLocked(key string) bool {
+	old, ok := m.data[key]
+	if ok {
+		m.used -= entrySize(key, old.value)
+		delete(m.data, key)
+	}
+	return ok
+}
+
+func entrySize(key string, value []byte) int64 {
+	return int64(len(key) + len(value))
+}
+
 // MDel deletes multiple keys.
 func (m *Memory) MDel(ctx context.Context, keys []string) error {
 	m.mu.Lock()
-	defer m.mu.Unlock()
+	defer m.unlock()
 
 	for _, key := range keys {
-		delete(m.data, key)
+		m.mdelLocked(key)
 	}
 
 	return nil
 }
 
+func (m *Memory) mdelLocked(key string) {
+	m.removeLocked(key, EventDel)
+}
+
 // TTL returns the remaining time-to-live. Returns -1 if key has no expiration, ErrNotFound if key doesn't exist.
 func (m *Memory) TTL(ctx context.Context, key string) (time.Duration, error) {
 	m.mu.Lock()
-	defer m.mu.Unlock()
+	defer m.unlock()
 
-	now := time.Now()
+	now := m.cfg.now()
 	entry, ok := m.data[key]
 	if !ok || entry.expiredAt(now) {
 		if ok {
-			delete(m.data, key)
+			m.removeLocked(key, EventExpired)
 		}
 		return 0, ErrNotFound
 	}
@@ -223,47 +387,52 @@ func (m *Memory) TTL(ctx context.Context, key string) (time.Duration, error) {
 // Expire sets or updates the TTL for a key.
 func (m *Memory) Expire(ctx context.Context, key string, ttl time.Duration) error {
 	m.mu.Lock()
-	defer m.mu.Unlock()
+	defer m.unlock()
 
-	now := time.Now()
+	now := m.cfg.now()
 	entry, ok := m.data[key]
 	if !ok || entry.expiredAt(now) {
 		if ok {
-			delete(m.data, key)
+			m.removeLocked(key, EventExpired)
 		}
 		return ErrNotFound
 	}
 
-	entry.expire = expiry(ttl)
-	m.data[key] = entry
+	entry.expire = expiry(m.cfg.now(), ttl)
+	typ := EventExpire
+	if entry.expire.IsZero() {
+		typ = EventPersist
+	}
+	m.storeLocked(key, entry, typ)
 	return nil
 }
 
 // Persist removes the expiration from a key.
 func (m *Memory) Persist(ctx context.Context, key string) error {
 	m.mu.Lock()
-	defer m.mu.Unlock()
+	defer m.unlock()
 
-	now := time.Now()
+	now := m.cfg.now()
 	entry, ok := m.data[key]
 	if !ok || entry.expiredAt(now) {
 		if ok {
-			delete(m.data, key)
+			m.removeLocked(key, EventExpired)
 		}
 		return ErrNotFound
 	}
 
 	entry.expire = time.Time{}
-	m.data[key] = entry
+	m.storeLocked(key, entry, EventPersist)
 	return nil
 }
 
-// Keys returns all keys matching the prefix and pattern.
+// Keys returns all keys matching the prefix and pattern. Prefer Scan for
+// large namespaces: Keys holds the read lock for the whole walk.
 func (m *Memory) Keys(ctx context.Context, prefix, pattern string) ([]string, error) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 
-	now := time.Now()
+	now := m.cfg.now()
 	var result []string
 	for key := range m.data {
 		if !strings.HasPrefix(key, prefix+":") {
@@ -291,8 +460,16 @@ func (m *Memory) Keys(ctx context.Context, prefix, pattern string) ([]string, er
 // Clear removes all keys with the given prefix.
 func (m *Memory) Clear(ctx context.Context, prefix string) error {
 	m.mu.Lock()
-	defer m.mu.Unlock()
+	defer m.unlock()
 
+	m.clearLocked(prefix)
+	m.hub.publish(EventClear, prefix)
+	return nil
+}
+
+// clearLocked removes the keys under prefix without publishing per-key
+// events; callers publish a single EventClear.
+func (m *Memory) clearLocked(prefix string) {
 	keysToDelete := make([]string, 0)
 	for key := range m.data {
 		if strings.HasPrefix(key, prefix+":") {
@@ -301,21 +478,20 @@ func (m *Memory) Clear(ctx context.Context, prefix string) error {
 	}
 
 	for _, key := range keysToDelete {
-		delete(m.data, key)
+		m.deleteLocked(key)
 	}
-
-	return nil
+	m.logClearLocked(prefix)
 }
 
 // Incr atomically increments the integer value.
 func (m *Memory) Incr(ctx context.Context, key string, delta int64) (int64, error) {
 	m.mu.Lock()
-	defer m.mu.Unlock()
+	defer m.unlock()
 
-	now := time.Now()
+	now := m.cfg.now()
 	e, ok := m.data[key]
 	if ok && e.expiredAt(now) {
-		delete(m.data, key)
+		m.removeLocked(key, EventExpired)
 		ok = false
 	}
 
@@ -331,13 +507,16 @@ func (m *Memory) Incr(ctx context.Context, key string, delta int64) (int64, erro
 	buf := make([]byte, 8)
 	binary.LittleEndian.PutUint64(buf, uint64(newValue))
 
-	if ok {
-		e.value = buf
-		m.data[key] = e
-	} else {
-		m.data[key] = entry{value: buf, expire: time.Time{}}
+	if !ok {
+		if err := m.setLocked(key, buf, time.Time{}, EventIncr); err != nil {
+			return 0, err
+		}
+		return newValue, nil
 	}
 
+	e.value = buf
+	m.storeLocked(key, e, EventIncr)
+
 	return newValue, nil
 }
 
@@ -349,21 +528,31 @@ func (m *Memory) Decr(ctx context.Context, key string, delta int64) (int64, erro
 // GetSet atomically sets a key to a new value and returns the old value.
 func (m *Memory) GetSet(ctx context.Context, key string, value []byte) ([]byte, error) {
 	m.mu.Lock()
-	defer m.mu.Unlock()
+	defer m.unlock()
 
-	now := time.Now()
+	now := m.cfg.now()
 	e, ok := m.data[key]
 	if !ok || e.expiredAt(now) {
 		if ok {
-			delete(m.data, key)
+			m.removeLocked(key, EventExpired)
+		}
+		if err := m.setLocked(key, value, time.Time{}, EventSet); err != nil {
+			return nil, err
 		}
-		m.data[key] = entry{value: clone(value), expire: time.Time{}}
 		return nil, ErrNotFound
 	}
 
+	if m.cfg.limited() {
+		var grow growth
+		grow.add(m, key, value)
+		if err := m.reserveLocked(grow, func(k string) bool { return k == key }); err != nil {
+			return nil, err
+		}
+	}
+
 	oldValue := clone(e.value)
 	e.value = clone(value)
-	m.data[key] = e
+	m.storeLocked(key, e, EventSet)
 
 	return oldValue, nil
 }
@@ -371,13 +560,13 @@ func (m *Memory) GetSet(ctx context.Context, key string, value []byte) ([]byte,
 // CompareAndSwap atomically compares and swaps if oldValue matches.
 func (m *Memory) CompareAndSwap(ctx context.Context, key string, oldValue, newValue []byte, ttl time.Duration) (bool, error) {
 	m.mu.Lock()
-	defer m.mu.Unlock()
+	defer m.unlock()
 
-	now := time.Now()
+	now := m.cfg.now()
 	e, ok := m.data[key]
 	if !ok || e.expiredAt(now) {
 		if ok {
-			delete(m.data, key)
+			m.removeLocked(key, EventExpired)
 		}
 		return false, nil
 	}
@@ -386,6 +575,8 @@ func (m *Memory) CompareAndSwap(ctx context.Context, key string, oldValue, newVa
 		return false, nil
 	}
 
-	m.data[key] = entry{value: clone(newValue), expire: expiry(ttl)}
+	if err := m.setLocked(key, newValue, expiry(m.cfg.now(), ttl), EventSet); err != nil {
+		return false, err
+	}
 	return true, nil
 }
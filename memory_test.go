@@ -291,6 +291,7 @@ func TestMemoryDriver_Exists_Expired(t *testing.T) {
 }
 
 func TestMemEntry_Expired(t *testing.T) {
+	now := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
 	tests := []struct {
 		name   string
 		entry  entry
@@ -303,21 +304,26 @@ func TestMemEntry_Expired(t *testing.T) {
 		},
 		{
 			name:   "future time not expired",
-			entry:  entry{expire: time.Now().Add(1 * time.Hour)},
+			entry:  entry{expire: now.Add(1 * time.Hour)},
+			expect: false,
+		},
+		{
+			name:   "expiration time not yet expired",
+			entry:  entry{expire: now},
 			expect: false,
 		},
 		{
 			name:   "past time expired",
-			entry:  entry{expire: time.Now().Add(-1 * time.Hour)},
+			entry:  entry{expire: now.Add(-1 * time.Hour)},
 			expect: true,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := tt.entry.expired()
+			result := tt.entry.expiredAt(now)
 			if result != tt.expect {
-				t.Errorf("expired() = %v, want %v", result, tt.expect)
+				t.Errorf("expiredAt() = %v, want %v", result, tt.expect)
 			}
 		})
 	}
@@ -353,7 +359,7 @@ func TestExpiry(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			before := time.Now()
-			result := expiry(tt.ttl)
+			result := expiry(time.Now(), tt.ttl)
 			after := time.Now()
 
 			if tt.isZero {
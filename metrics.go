@@ -0,0 +1,54 @@
+package namestore
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Observation describes one completed Client call.
+type Observation struct {
+	// Namespace is the client's "rootNS:domain" prefix.
+	Namespace string
+	// Op is the Client method, such as "Get" or "MSet".
+	Op       string
+	Duration time.Duration
+	// Err is the error the call returned, if any.
+	Err error
+	// Hits and Misses count the keys a read found and did not find. Get,
+	// Exists, MGet, TTL and GetSet report hits; every call that returns
+	// ErrNotFound reports a miss.
+	Hits, Misses int
+	// BatchSize is the number of keys of MGet, MSet and MDel, and the number
+	// of queued writes of Tx. It is 0 for other operations.
+	BatchSize int
+}
+
+// Failed reports whether the call failed. ErrNotFound is an expected
+// outcome, counted as a miss, and not a failure.
+func (o Observation) Failed() bool {
+	return o.Err != nil && !errors.Is(o.Err, ErrNotFound)
+}
+
+// Metrics receives an Observation for every Client call. Implementations
+// must be safe for concurrent use and should not block; see
+// PrometheusMetrics for the built-in one.
+type Metrics interface {
+	Observe(ctx context.Context, o Observation)
+}
+
+// WithMetrics reports every call of the client to m. Several clients may
+// share m; observations carry the namespace.
+func WithMetrics[TKey ~string](m Metrics) Option[TKey] {
+	return func(c *client[TKey]) {
+		c.metrics = m
+	}
+}
+
+// hit returns 1 if ok, else 0.
+func hit(ok bool) int {
+	if ok {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,113 @@
+package namestore
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingMetrics keeps every observation.
+type recordingMetrics struct {
+	mu  sync.Mutex
+	obs []Observation
+}
+
+func (r *recordingMetrics) Observe(ctx context.Context, o Observation) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.obs = append(r.obs, o)
+}
+
+// last returns the latest observation and forgets all of them.
+func (r *recordingMetrics) last(t *testing.T) Observation {
+	t.Helper()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.obs) == 0 {
+		t.Fatal("no observation recorded")
+	}
+	o := r.obs[len(r.obs)-1]
+	r.obs = nil
+	return o
+}
+
+func TestWithMetrics_Observations(t *testing.T) {
+	rec := &recordingMetrics{}
+	c := New[string]("app", "users", WithMetrics[string](rec))
+	ctx := context.Background()
+
+	expect := func(op string, hits, misses, batch int, failed bool) {
+		t.Helper()
+		o := rec.last(t)
+		if o.Namespace != "app:users" || o.Op != op || o.Hits != hits || o.Misses != misses ||
+			o.BatchSize != batch || o.Failed() != failed || o.Duration < 0 {
+			t.Errorf("observation = %+v; want op %s, hits %d, misses %d, batch %d, failed %v",
+				o, op, hits, misses, batch, failed)
+		}
+	}
+
+	_ = c.Set(ctx, "1", []byte("alice"), 0)
+	expect("Set", 0, 0, 0, false)
+	_, _ = c.Get(ctx, "1")
+	expect("Get", 1, 0, 0, false)
+	_, _ = c.Get(ctx, "missing")
+	expect("Get", 0, 1, 0, false)
+	_, _ = c.Exists(ctx, "1")
+	expect("Exists", 1, 0, 0, false)
+	_, _ = c.Exists(ctx, "missing")
+	expect("Exists", 0, 1, 0, false)
+
+	_ = c.MSet(ctx, map[string][]byte{"2": []byte("bob"), "3": []byte("carol")}, 0)
+	expect("MSet", 0, 0, 2, false)
+	_, _ = c.MGet(ctx, "1", "2", "missing")
+	expect("MGet", 2, 1, 3, false)
+	_ = c.MDel(ctx, "2", "3")
+	expect("MDel", 0, 0, 2, false)
+
+	_, _ = c.TTL(ctx, "1")
+	expect("TTL", 1, 0, 0, false)
+	_ = c.Expire(ctx, "missing", time.Minute)
+	expect("Expire", 0, 1, 0, false)
+	_ = c.Persist(ctx, "1")
+	expect("Persist", 0, 0, 0, false)
+	_, _ = c.GetSet(ctx, "new", []byte("v"))
+	expect("GetSet", 0, 1, 0, false)
+	_, _ = c.GetSet(ctx, "new", []byte("w"))
+	expect("GetSet", 1, 0, 0, false)
+
+	_, _ = c.Incr(ctx, "1", 1)
+	expect("Incr", 0, 0, 0, true)
+	_, _ = c.Keys(ctx, "[")
+	expect("Keys", 0, 0, 0, true)
+	_, _, _ = c.Scan(ctx, "*", 0, 10)
+	expect("Scan", 0, 0, 0, false)
+
+	err := c.Tx(ctx, func(tx Tx[string]) error {
+		tx.Set("a", []byte("1"), 0)
+		tx.Delete("b")
+		tx.Incr("n", 1)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect("Tx", 0, 0, 3, false)
+
+	_ = c.Clear(ctx)
+	expect("Clear", 0, 0, 0, false)
+}
+
+func TestWithMetrics_NilByDefault(t *testing.T) {
+	c := New[string]("app", "users").(*client[string])
+	if c.metrics != nil || c.tracer != nil {
+		t.Fatal("metrics and tracing should be off by default")
+	}
+	ctx := context.Background()
+	got, call := c.begin(ctx, "Get")
+	if got != ctx || !call.start.IsZero() || call.span != nil {
+		t.Error("begin should not touch ctx or read the clock without metrics")
+	}
+	// end is a no-op without metrics.
+	c.end(ctx, call, Observation{}, nil)
+}
@@ -0,0 +1,392 @@
+package namestore
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+)
+
+// Middleware wraps a Driver to intercept its operations, for cross-cutting
+// concerns such as logging, retries or access control. Embed DriverBase to
+// forward the methods a middleware does not change, or use Intercept to
+// handle every operation in one function.
+type Middleware func(Driver) Driver
+
+// WithMiddleware wraps the client's driver in mw. The first middleware is
+// the outermost: it sees each operation first and its result last. Repeated
+// options append to the chain, which is applied once all options are set,
+// so the order relative to WithDriver does not matter.
+//
+// Watch and Tx go through the chain like every other operation: the
+// outermost middleware must implement Watcher and TxDriver, as DriverBase
+// and Intercept do, or they return ErrUnsupported.
+func WithMiddleware[TKey ~string](mw ...Middleware) Option[TKey] {
+	return func(c *client[TKey]) {
+		c.middleware = append(c.middleware, mw...)
+	}
+}
+
+// Chain wraps d in mw, the first middleware outermost.
+func Chain(d Driver, mw ...Middleware) Driver {
+	for i := len(mw) - 1; i >= 0; i-- {
+		d = mw[i](d)
+	}
+	return d
+}
+
+// Unwrapper is implemented by drivers that wrap another driver, such as
+// middleware embedding DriverBase.
+type Unwrapper interface {
+	Unwrap() Driver
+}
+
+// DriverBase forwards every Driver method to Next. Embed it in a middleware
+// and override only the methods it needs:
+//
+//	type readOnly struct{ namestore.DriverBase }
+//
+//	func (r readOnly) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+//	    return errReadOnly
+//	}
+//
+//	mw := func(d namestore.Driver) namestore.Driver { return readOnly{namestore.DriverBase{Next: d}} }
+//
+// DriverBase also implements Watcher and TxDriver, forwarding to Next or
+// returning ErrUnsupported if Next does not implement them. Transactions
+// write through Commit, so a middleware that restricts writes must override
+// it as well.
+type DriverBase struct {
+	Next Driver
+}
+
+// Unwrap returns Next.
+func (b DriverBase) Unwrap() Driver { return b.Next }
+
+func (b DriverBase) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return b.Next.Set(ctx, key, value, ttl)
+}
+
+func (b DriverBase) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	return b.Next.SetNX(ctx, key, value, ttl)
+}
+
+func (b DriverBase) Get(ctx context.Context, key string) ([]byte, error) {
+	return b.Next.Get(ctx, key)
+}
+
+func (b DriverBase) Delete(ctx context.Context, key string) error {
+	return b.Next.Delete(ctx, key)
+}
+
+func (b DriverBase) Exists(ctx context.Context, key string) (bool, error) {
+	return b.Next.Exists(ctx, key)
+}
+
+func (b DriverBase) MGet(ctx context.Context, keys []string) (map[string][]byte, error) {
+	return b.Next.MGet(ctx, keys)
+}
+
+func (b DriverBase) MSet(ctx context.Context, pairs map[string][]byte, ttl time.Duration) error {
+	return b.Next.MSet(ctx, pairs, ttl)
+}
+
+func (b DriverBase) MDel(ctx context.Context, keys []string) error {
+	return b.Next.MDel(ctx, keys)
+}
+
+func (b DriverBase) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return b.Next.TTL(ctx, key)
+}
+
+func (b DriverBase) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	return b.Next.Expire(ctx, key, ttl)
+}
+
+func (b DriverBase) Persist(ctx context.Context, key string) error {
+	return b.Next.Persist(ctx, key)
+}
+
+func (b DriverBase) Keys(ctx context.Context, prefix, pattern string) ([]string, error) {
+	return b.Next.Keys(ctx, prefix, pattern)
+}
+
+func (b DriverBase) Scan(ctx context.Context, prefix, pattern string, cursor uint64, count int) ([]string, uint64, error) {
+	return b.Next.Scan(ctx, prefix, pattern, cursor, count)
+}
+
+func (b DriverBase) Clear(ctx context.Context, prefix string) error {
+	return b.Next.Clear(ctx, prefix)
+}
+
+func (b DriverBase) Incr(ctx context.Context, key string, delta int64) (int64, error) {
+	return b.Next.Incr(ctx, key, delta)
+}
+
+func (b DriverBase) Decr(ctx context.Context, key string, delta int64) (int64, error) {
+	return b.Next.Decr(ctx, key, delta)
+}
+
+func (b DriverBase) GetSet(ctx context.Context, key string, value []byte) ([]byte, error) {
+	return b.Next.GetSet(ctx, key, value)
+}
+
+func (b DriverBase) CompareAndSwap(ctx context.Context, key string, oldValue, newValue []byte, ttl time.Duration) (bool, error) {
+	return b.Next.CompareAndSwap(ctx, key, oldValue, newValue, ttl)
+}
+
+// Watch implements Watcher.
+func (b DriverBase) Watch(ctx context.Context, prefix, pattern string) (<-chan Event[string], error) {
+	w, ok := b.Next.(Watcher)
+	if !ok {
+		return nil, fmt.Errorf("%w: driver does not support Watch", ErrUnsupported)
+	}
+	return w.Watch(ctx, prefix, pattern)
+}
+
+// Versions implements TxDriver.
+func (b DriverBase) Versions(ctx context.Context, keys []string) (map[string]uint64, error) {
+	tx, ok := b.Next.(TxDriver)
+	if !ok {
+		return nil, fmt.Errorf("%w: driver does not support transactions", ErrUnsupported)
+	}
+	return tx.Versions(ctx, keys)
+}
+
+// Commit implements TxDriver.
+func (b DriverBase) Commit(ctx context.Context, watched map[string]uint64, ops []TxOp) error {
+	tx, ok := b.Next.(TxDriver)
+	if !ok {
+		return fmt.Errorf("%w: driver does not support transactions", ErrUnsupported)
+	}
+	return tx.Commit(ctx, watched, ops)
+}
+
+// OpInfo describes a Driver operation for generic interceptors.
+type OpInfo struct {
+	// Name is the Driver method, such as "Get" or "MSet", or the Watcher or
+	// TxDriver method: "Watch", "Versions" or "Commit".
+	Name string
+	// Keys are the full keys the operation addresses, sorted for MSet and
+	// Commit, where they are the watched and written keys. They are empty
+	// for Keys, Scan, Clear and Watch, which address Prefix instead.
+	Keys   []string
+	Prefix string
+}
+
+// ReadOnly reports whether the operation leaves the store unchanged.
+func (o OpInfo) ReadOnly() bool {
+	switch o.Name {
+	case "Get", "Exists", "MGet", "TTL", "Keys", "Scan", "Watch", "Versions":
+		return true
+	}
+	return false
+}
+
+// Idempotent reports whether repeating the operation has the same effect and
+// result as running it once, so it is safe to retry after an error that may
+// have come after the store applied it. SetNX, Incr, Decr, GetSet,
+// CompareAndSwap and Commit are not.
+func (o OpInfo) Idempotent() bool {
+	switch o.Name {
+	case "SetNX", "Incr", "Decr", "GetSet", "CompareAndSwap", "Commit":
+		return false
+	}
+	return true
+}
+
+// Interceptor handles one Driver operation. It calls next, possibly with a
+// different context, to perform the operation on the wrapped driver, and
+// returns its error or one of its own. If it returns without calling next,
+// the operation's other results are zero values; if it calls next more than
+// once, the last call's results are returned.
+type Interceptor func(ctx context.Context, op OpInfo, next func(ctx context.Context) error) error
+
+// Intercept returns a Middleware that passes every operation through fn:
+//
+//	logging := namestore.Intercept(func(ctx context.Context, op namestore.OpInfo, next func(context.Context) error) error {
+//	    err := next(ctx)
+//	    log.Printf("%s %v: %v", op.Name, op.Keys, err)
+//	    return err
+//	})
+func Intercept(fn Interceptor) Middleware {
+	return func(d Driver) Driver {
+		return interceptor{DriverBase{Next: d}, fn}
+	}
+}
+
+type interceptor struct {
+	DriverBase
+	fn Interceptor
+}
+
+func keyOp(name, key string) OpInfo {
+	return OpInfo{Name: name, Keys: []string{key}}
+}
+
+func (i interceptor) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return i.fn(ctx, keyOp("Set", key), func(ctx context.Context) error {
+		return i.Next.Set(ctx, key, value, ttl)
+	})
+}
+
+func (i interceptor) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (ok bool, err error) {
+	err = i.fn(ctx, keyOp("SetNX", key), func(ctx context.Context) (err error) {
+		ok, err = i.Next.SetNX(ctx, key, value, ttl)
+		return err
+	})
+	return ok, err
+}
+
+func (i interceptor) Get(ctx context.Context, key string) (value []byte, err error) {
+	err = i.fn(ctx, keyOp("Get", key), func(ctx context.Context) (err error) {
+		value, err = i.Next.Get(ctx, key)
+		return err
+	})
+	return value, err
+}
+
+func (i interceptor) Delete(ctx context.Context, key string) error {
+	return i.fn(ctx, keyOp("Delete", key), func(ctx context.Context) error {
+		return i.Next.Delete(ctx, key)
+	})
+}
+
+func (i interceptor) Exists(ctx context.Context, key string) (ok bool, err error) {
+	err = i.fn(ctx, keyOp("Exists", key), func(ctx context.Context) (err error) {
+		ok, err = i.Next.Exists(ctx, key)
+		return err
+	})
+	return ok, err
+}
+
+func (i interceptor) MGet(ctx context.Context, keys []string) (values map[string][]byte, err error) {
+	err = i.fn(ctx, OpInfo{Name: "MGet", Keys: keys}, func(ctx context.Context) (err error) {
+		values, err = i.Next.MGet(ctx, keys)
+		return err
+	})
+	return values, err
+}
+
+func (i interceptor) MSet(ctx context.Context, pairs map[string][]byte, ttl time.Duration) error {
+	keys := make([]string, 0, len(pairs))
+	for k := range pairs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return i.fn(ctx, OpInfo{Name: "MSet", Keys: keys}, func(ctx context.Context) error {
+		return i.Next.MSet(ctx, pairs, ttl)
+	})
+}
+
+func (i interceptor) MDel(ctx context.Context, keys []string) error {
+	return i.fn(ctx, OpInfo{Name: "MDel", Keys: keys}, func(ctx context.Context) error {
+		return i.Next.MDel(ctx, keys)
+	})
+}
+
+func (i interceptor) TTL(ctx context.Context, key string) (ttl time.Duration, err error) {
+	err = i.fn(ctx, keyOp("TTL", key), func(ctx context.Context) (err error) {
+		ttl, err = i.Next.TTL(ctx, key)
+		return err
+	})
+	return ttl, err
+}
+
+func (i interceptor) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	return i.fn(ctx, keyOp("Expire", key), func(ctx context.Context) error {
+		return i.Next.Expire(ctx, key, ttl)
+	})
+}
+
+func (i interceptor) Persist(ctx context.Context, key string) error {
+	return i.fn(ctx, keyOp("Persist", key), func(ctx context.Context) error {
+		return i.Next.Persist(ctx, key)
+	})
+}
+
+func (i interceptor) Keys(ctx context.Context, prefix, pattern string) (keys []string, err error) {
+	err = i.fn(ctx, OpInfo{Name: "Keys", Prefix: prefix}, func(ctx context.Context) (err error) {
+		keys, err = i.Next.Keys(ctx, prefix, pattern)
+		return err
+	})
+	return keys, err
+}
+
+func (i interceptor) Scan(ctx context.Context, prefix, pattern string, cursor uint64, count int) (keys []string, next uint64, err error) {
+	err = i.fn(ctx, OpInfo{Name: "Scan", Prefix: prefix}, func(ctx context.Context) (err error) {
+		keys, next, err = i.Next.Scan(ctx, prefix, pattern, cursor, count)
+		return err
+	})
+	return keys, next, err
+}
+
+func (i interceptor) Clear(ctx context.Context, prefix string) error {
+	return i.fn(ctx, OpInfo{Name: "Clear", Prefix: prefix}, func(ctx context.Context) error {
+		return i.Next.Clear(ctx, prefix)
+	})
+}
+
+func (i interceptor) Incr(ctx context.Context, key string, delta int64) (n int64, err error) {
+	err = i.fn(ctx, keyOp("Incr", key), func(ctx context.Context) (err error) {
+		n, err = i.Next.Incr(ctx, key, delta)
+		return err
+	})
+	return n, err
+}
+
+func (i interceptor) Decr(ctx context.Context, key string, delta int64) (n int64, err error) {
+	err = i.fn(ctx, keyOp("Decr", key), func(ctx context.Context) (err error) {
+		n, err = i.Next.Decr(ctx, key, delta)
+		return err
+	})
+	return n, err
+}
+
+func (i interceptor) GetSet(ctx context.Context, key string, value []byte) (old []byte, err error) {
+	err = i.fn(ctx, keyOp("GetSet", key), func(ctx context.Context) (err error) {
+		old, err = i.Next.GetSet(ctx, key, value)
+		return err
+	})
+	return old, err
+}
+
+func (i interceptor) CompareAndSwap(ctx context.Context, key string, oldValue, newValue []byte, ttl time.Duration) (ok bool, err error) {
+	err = i.fn(ctx, keyOp("CompareAndSwap", key), func(ctx context.Context) (err error) {
+		ok, err = i.Next.CompareAndSwap(ctx, key, oldValue, newValue, ttl)
+		return err
+	})
+	return ok, err
+}
+
+func (i interceptor) Watch(ctx context.Context, prefix, pattern string) (events <-chan Event[string], err error) {
+	err = i.fn(ctx, OpInfo{Name: "Watch", Prefix: prefix}, func(ctx context.Context) (err error) {
+		events, err = i.DriverBase.Watch(ctx, prefix, pattern)
+		return err
+	})
+	return events, err
+}
+
+func (i interceptor) Versions(ctx context.Context, keys []string) (versions map[string]uint64, err error) {
+	err = i.fn(ctx, OpInfo{Name: "Versions", Keys: keys}, func(ctx context.Context) (err error) {
+		versions, err = i.DriverBase.Versions(ctx, keys)
+		return err
+	})
+	return versions, err
+}
+
+func (i interceptor) Commit(ctx context.Context, watched map[string]uint64, ops []TxOp) error {
+	keys := make([]string, 0, len(watched)+len(ops))
+	for k := range watched {
+		keys = append(keys, k)
+	}
+	for _, op := range ops {
+		keys = append(keys, op.Key)
+	}
+	sort.Strings(keys)
+	keys = slices.Compact(keys)
+	return i.fn(ctx, OpInfo{Name: "Commit", Keys: keys}, func(ctx context.Context) error {
+		return i.DriverBase.Commit(ctx, watched, ops)
+	})
+}
@@ -0,0 +1,239 @@
+package namestore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tagging returns a middleware that records name before and after every
+// operation.
+func tagging(name string, trail *[]string) Middleware {
+	return Intercept(func(ctx context.Context, op OpInfo, next func(context.Context) error) error {
+		*trail = append(*trail, name+">")
+		err := next(ctx)
+		*trail = append(*trail, "<"+name)
+		return err
+	})
+}
+
+func TestWithMiddleware_Order(t *testing.T) {
+	var trail []string
+	c := New[string]("app", "users",
+		WithMiddleware[string](tagging("a", &trail), tagging("b", &trail)),
+		WithMiddleware[string](tagging("c", &trail)),
+		WithDriver[string](NewMemory()), // after WithMiddleware, still wrapped
+	)
+	if err := c.Set(context.Background(), "1", []byte("v"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(trail, " "); got != "a> b> c> <c <b <a" {
+		t.Errorf("trail = %s", got)
+	}
+}
+
+var errReadOnly = errors.New("read only")
+
+type readOnly struct{ DriverBase }
+
+func (readOnly) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return errReadOnly
+}
+
+func TestDriverBase_Forwards(t *testing.T) {
+	m := NewMemory()
+	ctx := context.Background()
+	_ = m.Set(ctx, "app:users:1", []byte("alice"), 0)
+
+	c := New[string]("app", "users", WithDriver[string](m), WithMiddleware[string](func(d Driver) Driver {
+		return readOnly{DriverBase{Next: d}}
+	}))
+	if err := c.Set(ctx, "2", []byte("bob"), 0); !errors.Is(err, errReadOnly) {
+		t.Errorf("Set = %v, want the override's error", err)
+	}
+	if v, err := c.Get(ctx, "1"); err != nil || string(v) != "alice" {
+		t.Errorf("Get = %q, %v", v, err)
+	}
+	if n, err := c.Incr(ctx, "n", 2); err != nil || n != 2 {
+		t.Errorf("Incr = %d, %v", n, err)
+	}
+	if keys, err := c.Keys(ctx, "*"); err != nil || len(keys) != 2 {
+		t.Errorf("Keys = %v, %v", keys, err)
+	}
+	if u, ok := c.(*client[string]).driver.(Unwrapper); !ok || u.Unwrap() != m {
+		t.Error("DriverBase should unwrap to Next")
+	}
+}
+
+func TestIntercept_AllOperations(t *testing.T) {
+	var ops []OpInfo
+	d := Chain(NewMemory(), Intercept(func(ctx context.Context, op OpInfo, next func(context.Context) error) error {
+		ops = append(ops, op)
+		return next(ctx)
+	}))
+	ctx := context.Background()
+
+	_ = d.Set(ctx, "a:b:k", []byte("v"), 0)
+	ok, _ := d.SetNX(ctx, "a:b:k", []byte("w"), 0)
+	v, _ := d.Get(ctx, "a:b:k")
+	exists, _ := d.Exists(ctx, "a:b:k")
+	_ = d.MSet(ctx, map[string][]byte{"a:b:y": []byte("1"), "a:b:x": []byte("2")}, 0)
+	got, _ := d.MGet(ctx, []string{"a:b:x", "a:b:y"})
+	_ = d.Expire(ctx, "a:b:k", time.Hour)
+	ttl, _ := d.TTL(ctx, "a:b:k")
+	_ = d.Persist(ctx, "a:b:k")
+	keys, _ := d.Keys(ctx, "a:b", "*")
+	page, _, _ := d.Scan(ctx, "a:b", "*", 0, 10)
+	n, _ := d.Incr(ctx, "a:b:n", 5)
+	n2, _ := d.Decr(ctx, "a:b:n", 2)
+	old, _ := d.GetSet(ctx, "a:b:k", []byte("v2"))
+	swapped, _ := d.CompareAndSwap(ctx, "a:b:k", []byte("v2"), []byte("v3"), 0)
+	_ = d.Delete(ctx, "a:b:k")
+	_ = d.MDel(ctx, []string{"a:b:x"})
+	_ = d.Clear(ctx, "a:b")
+
+	if ok || string(v) != "v" || !exists || len(got) != 2 || ttl <= 0 || len(keys) != 3 ||
+		len(page) != 3 || n != 5 || n2 != 3 || string(old) != "v" || !swapped {
+		t.Errorf("results: %v %q %v %v %v %v %v %d %d %q %v", ok, v, exists, got, ttl, keys, page, n, n2, old, swapped)
+	}
+
+	want := []OpInfo{
+		{Name: "Set", Keys: []string{"a:b:k"}},
+		{Name: "SetNX", Keys: []string{"a:b:k"}},
+		{Name: "Get", Keys: []string{"a:b:k"}},
+		{Name: "Exists", Keys: []string{"a:b:k"}},
+		{Name: "MSet", Keys: []string{"a:b:x", "a:b:y"}},
+		{Name: "MGet", Keys: []string{"a:b:x", "a:b:y"}},
+		{Name: "Expire", Keys: []string{"a:b:k"}},
+		{Name: "TTL", Keys: []string{"a:b:k"}},
+		{Name: "Persist", Keys: []string{"a:b:k"}},
+		{Name: "Keys", Prefix: "a:b"},
+		{Name: "Scan", Prefix: "a:b"},
+		{Name: "Incr", Keys: []string{"a:b:n"}},
+		{Name: "Decr", Keys: []string{"a:b:n"}},
+		{Name: "GetSet", Keys: []string{"a:b:k"}},
+		{Name: "CompareAndSwap", Keys: []string{"a:b:k"}},
+		{Name: "Delete", Keys: []string{"a:b:k"}},
+		{Name: "MDel", Keys: []string{"a:b:x"}},
+		{Name: "Clear", Prefix: "a:b"},
+	}
+	if !reflect.DeepEqual(ops, want) {
+		t.Errorf("ops =\n%v\nwant\n%v", ops, want)
+	}
+
+	readOnly := map[string]bool{"Get": true, "Exists": true, "MGet": true, "TTL": true, "Keys": true, "Scan": true}
+	for _, op := range want {
+		if op.ReadOnly() != readOnly[op.Name] {
+			t.Errorf("%s.ReadOnly() = %v", op.Name, op.ReadOnly())
+		}
+	}
+}
+
+func TestIntercept_ShortCircuitAndRepeat(t *testing.T) {
+	denied := errors.New("denied")
+	calls := 0
+	d := Chain(NewMemory(), Intercept(func(ctx context.Context, op OpInfo, next func(context.Context) error) error {
+		if strings.HasPrefix(op.Prefix, "secret") || (len(op.Keys) > 0 && strings.HasPrefix(op.Keys[0], "secret")) {
+			return denied
+		}
+		// Run writes twice; the second Incr's result is returned.
+		if !op.ReadOnly() {
+			calls++
+			if err := next(ctx); err != nil {
+				return err
+			}
+		}
+		return next(ctx)
+	}))
+	ctx := context.Background()
+
+	if v, err := d.Get(ctx, "secret:x:1"); !errors.Is(err, denied) || v != nil {
+		t.Errorf("Get = %q, %v", v, err)
+	}
+	if _, err := d.Keys(ctx, "secret:x", "*"); !errors.Is(err, denied) {
+		t.Errorf("Keys = %v", err)
+	}
+	if n, err := d.Incr(ctx, "app:x:n", 1); err != nil || n != 2 {
+		t.Errorf("Incr = %d, %v; want the second call's 2", n, err)
+	}
+	if calls != 1 {
+		t.Errorf("interceptor ran %d times for writes", calls)
+	}
+}
+
+func TestWithMiddleware_OptionalInterfaces(t *testing.T) {
+	var names []string
+	logging := Intercept(func(ctx context.Context, op OpInfo, next func(context.Context) error) error {
+		names = append(names, op.Name)
+		return next(ctx)
+	})
+	c := New[string]("app", "users", WithMiddleware[string](logging, logging))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Watch and Tx reach the Memory driver through the chain.
+	events, err := c.Watch(ctx, "*")
+	if err != nil {
+		t.Fatalf("Watch through middleware: %v", err)
+	}
+	err = c.Tx(ctx, func(tx Tx[string]) error {
+		if _, err := tx.Get(ctx, "1"); !errors.Is(err, ErrNotFound) {
+			return fmt.Errorf("tx.Get = %v", err)
+		}
+		tx.Set("1", []byte("v"), 0)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Tx through middleware: %v", err)
+	}
+	if ev := nextEvent(t, events); ev.Type != EventSet || ev.Key != "1" {
+		t.Errorf("event = %+v", ev)
+	}
+	// Watch and every step of Tx go through the chain.
+	if got := strings.Join(names, " "); got != "Watch Watch Versions Versions Get Get Commit Commit" {
+		t.Errorf("intercepted %s", got)
+	}
+
+	plain := New[string]("app", "users", WithDriver[string](readOnly{DriverBase{Next: &Redis{}}}))
+	if _, err := plain.Watch(ctx, "*"); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Watch without Watcher = %v, want ErrUnsupported", err)
+	}
+	if err := plain.Tx(ctx, func(tx Tx[string]) error { tx.Set("1", nil, 0); return nil }); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Tx without TxDriver = %v, want ErrUnsupported", err)
+	}
+}
+
+func TestWithMiddleware_ReadOnlyBlocksTx(t *testing.T) {
+	var ops []OpInfo
+	readOnly := Intercept(func(ctx context.Context, op OpInfo, next func(context.Context) error) error {
+		ops = append(ops, op)
+		if !op.ReadOnly() {
+			return errReadOnly
+		}
+		return next(ctx)
+	})
+	m := NewMemory()
+	c := New[string]("app", "users", WithDriver[string](m), WithMiddleware[string](readOnly))
+	ctx := context.Background()
+
+	err := c.Tx(ctx, func(tx Tx[string]) error {
+		tx.Set("2", []byte("b"), 0)
+		tx.Incr("1", 1)
+		return nil
+	}, "3")
+	if !errors.Is(err, errReadOnly) {
+		t.Fatalf("Tx = %v, want errReadOnly", err)
+	}
+	if n, _ := m.Keys(ctx, "app:users", "*"); len(n) != 0 {
+		t.Errorf("Tx wrote %v through a read-only middleware", n)
+	}
+	commit := ops[len(ops)-1]
+	if commit.Name != "Commit" || commit.Idempotent() ||
+		fmt.Sprint(commit.Keys) != "[app:users:1 app:users:2 app:users:3]" {
+		t.Errorf("commit op = %+v", commit)
+	}
+}
@@ -0,0 +1,53 @@
+package namestoretest
+
+import (
+	"sync"
+	"time"
+)
+
+// FakeClock is a namestore.Clock that only moves when told to, so tests can
+// expire keys deterministically:
+//
+//	clock := namestoretest.NewFakeClock(time.Time{})
+//	d := namestore.NewMemory(namestore.WithClock(clock))
+//	d.Set(ctx, "app:cache:k", v, time.Minute)
+//	clock.Advance(time.Minute + time.Nanosecond) // "app:cache:k" is gone
+//
+// A key expires once the clock is strictly past its expiration time. It is
+// safe for concurrent use.
+type FakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewFakeClock returns a FakeClock reading start. A zero start is replaced
+// by a fixed date in 2000, since drivers treat the zero time as "never".
+func NewFakeClock(start time.Time) *FakeClock {
+	if start.IsZero() {
+		start = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	}
+	return &FakeClock{now: start}
+}
+
+// Now returns the current fake time.
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by d and returns the new time. A negative
+// d moves it backward.
+func (c *FakeClock) Advance(d time.Duration) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	return c.now
+}
+
+// Set moves the clock to t.
+func (c *FakeClock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = t
+}
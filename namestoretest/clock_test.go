@@ -0,0 +1,34 @@
+package namestoretest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeClock(t *testing.T) {
+	clock := NewFakeClock(time.Time{})
+	start := clock.Now()
+	if start.IsZero() {
+		t.Fatal("NewFakeClock(zero) should start at a non-zero time")
+	}
+	if got := clock.Now(); !got.Equal(start) {
+		t.Fatalf("Now moved by itself: %v -> %v", start, got)
+	}
+
+	if got := clock.Advance(time.Minute); !got.Equal(start.Add(time.Minute)) {
+		t.Fatalf("Advance = %v, want %v", got, start.Add(time.Minute))
+	}
+	clock.Advance(-30 * time.Second)
+	if got := clock.Now(); !got.Equal(start.Add(30 * time.Second)) {
+		t.Fatalf("Now after moving back = %v", got)
+	}
+
+	at := time.Date(2030, time.June, 1, 12, 0, 0, 0, time.UTC)
+	clock.Set(at)
+	if got := clock.Now(); !got.Equal(at) {
+		t.Fatalf("Now after Set = %v, want %v", got, at)
+	}
+	if got := NewFakeClock(at).Now(); !got.Equal(at) {
+		t.Fatalf("NewFakeClock(at).Now() = %v", got)
+	}
+}
@@ -0,0 +1,943 @@
+// Package namestoretest provides a conformance suite for namestore drivers.
+// It checks that a Driver behaves like the built-in Memory driver, so a
+// Client works the same on top of it:
+//
+//	func TestMyDriver(t *testing.T) {
+//	    namestoretest.RunDriverSuite(t, func(t *testing.T) namestore.Driver {
+//	        d := mydriver.New()
+//	        t.Cleanup(func() { d.Close() })
+//	        return d
+//	    })
+//	}
+//
+// The suite relies on these behaviors of Memory:
+//
+//   - Get, TTL, Expire and Persist return ErrNotFound for missing and
+//     expired keys; Delete and MDel ignore them, and MGet omits them.
+//   - TTL returns -1 for a key without expiration. A non-positive TTL
+//     passed to Set, MSet or CompareAndSwap means no expiration, and passed
+//     to Expire removes it.
+//   - Values are copied: changing a slice after Set, or one returned by
+//     Get, does not change the stored value.
+//   - Counters are 8-byte little-endian integers. Incr and Decr start
+//     missing keys at 0 without expiration, keep an existing expiration,
+//     and return ErrTypeMismatch for values of any other length.
+//   - GetSet keeps the expiration. On a missing key it still stores the
+//     value but returns ErrNotFound.
+//   - CompareAndSwap on a missing key returns false.
+//   - Keys, Scan and Clear only see keys that start with prefix+":";
+//     patterns match the rest of the key with filepath.Match, and "" and
+//     "*" match everything. An invalid pattern yields ErrInvalidPattern.
+//     Prefixes other than the rootNS:domain form a Client uses may be
+//     rejected with ErrUnsupported.
+//   - Walking Scan from cursor 0 until it returns 0 yields every key that
+//     exists throughout the scan, possibly more than once.
+//   - All operations are safe for concurrent use, and Incr, SetNX, GetSet
+//     and CompareAndSwap are atomic.
+package namestoretest
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"code.byted.org/khicago/namestore"
+)
+
+// Factory returns a new, empty driver for one subtest. It should register
+// any cleanup, such as closing the driver, with t.Cleanup.
+type Factory func(t *testing.T) namestore.Driver
+
+// ClockFactory is a Factory for drivers that read the current time from
+// clock, such as Memory created with namestore.WithClock.
+type ClockFactory func(t *testing.T, clock namestore.Clock) namestore.Driver
+
+// RunDriverSuite runs the conformance suite against drivers created by
+// factory, each in its own subtest. Expiration is checked in real time, so
+// the tests that need it sleep for a few seconds.
+func RunDriverSuite(t *testing.T, factory Factory) {
+	t.Helper()
+	runSuite(t, func(t *testing.T) (namestore.Driver, func(time.Duration)) {
+		return factory(t), time.Sleep
+	})
+}
+
+// RunDriverSuiteWithClock runs the conformance suite like RunDriverSuite,
+// giving factory a FakeClock that the driver must read the time from. Keys
+// are expired by advancing the clock instead of sleeping, so the results do
+// not depend on scheduling.
+func RunDriverSuiteWithClock(t *testing.T, factory ClockFactory) {
+	t.Helper()
+	runSuite(t, func(t *testing.T) (namestore.Driver, func(time.Duration)) {
+		clock := NewFakeClock(time.Time{})
+		return factory(t, clock), func(d time.Duration) { clock.Advance(d) }
+	})
+}
+
+// runSuite runs every test on a driver from newDriver, which also returns
+// the way to let time pass for that driver.
+func runSuite(t *testing.T, newDriver func(t *testing.T) (namestore.Driver, func(time.Duration))) {
+	t.Helper()
+	for _, tc := range []struct {
+		name  string
+		run   func(t *testing.T, d namestore.Driver)
+		timed func(t *testing.T, d namestore.Driver, sleep func(time.Duration))
+	}{
+		{name: "SetGet", run: testSetGet},
+		{name: "SetNX", run: testSetNX},
+		{name: "DeleteExists", run: testDeleteExists},
+		{name: "ValueCopies", run: testValueCopies},
+		{name: "MGet", run: testMGet},
+		{name: "MSetMDel", run: testMSetMDel},
+		{name: "TTL", run: testTTL},
+		{name: "ExpirePersist", timed: testExpirePersist},
+		{name: "Expiration", timed: testExpiration},
+		{name: "Keys", run: testKeys},
+		{name: "Patterns", run: testPatterns},
+		{name: "Scan", run: testScan},
+		{name: "Clear", run: testClear},
+		{name: "Incr", run: testIncr},
+		{name: "GetSet", run: testGetSet},
+		{name: "CompareAndSwap", run: testCompareAndSwap},
+		{name: "ConcurrentIncr", run: testConcurrentIncr},
+		{name: "ConcurrentSetNX", run: testConcurrentSetNX},
+		{name: "ConcurrentCompareAndSwap", run: testConcurrentCompareAndSwap},
+		{name: "ConcurrentMixed", run: testConcurrentMixed},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			d, sleep := newDriver(t)
+			if tc.timed != nil {
+				tc.timed(t, d, sleep)
+				return
+			}
+			tc.run(t, d)
+		})
+	}
+}
+
+// shortTTL is long enough to outlast a few driver calls even on a loaded
+// machine, and expiredWait long enough for a key written with it to expire
+// on drivers that round TTLs to whole seconds.
+const (
+	shortTTL    = time.Second
+	expiredWait = 2500 * time.Millisecond
+)
+
+func counter(n int64) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, uint64(n))
+	return b
+}
+
+func mustSet(t *testing.T, d namestore.Driver, key, value string, ttl time.Duration) {
+	t.Helper()
+	if err := d.Set(context.Background(), key, []byte(value), ttl); err != nil {
+		t.Fatalf("Set(%q): %v", key, err)
+	}
+}
+
+func expectValue(t *testing.T, d namestore.Driver, key, want string) {
+	t.Helper()
+	got, err := d.Get(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Get(%q): %v", key, err)
+	}
+	if string(got) != want {
+		t.Fatalf("Get(%q) = %q, want %q", key, got, want)
+	}
+}
+
+func expectMissing(t *testing.T, d namestore.Driver, key string) {
+	t.Helper()
+	if got, err := d.Get(context.Background(), key); !errors.Is(err, namestore.ErrNotFound) {
+		t.Fatalf("Get(%q) = %q, %v; want ErrNotFound", key, got, err)
+	}
+}
+
+// expectTTL checks that key expires after more than 0 and at most max.
+func expectTTL(t *testing.T, d namestore.Driver, key string, max time.Duration) {
+	t.Helper()
+	ttl, err := d.TTL(context.Background(), key)
+	if err != nil {
+		t.Fatalf("TTL(%q): %v", key, err)
+	}
+	if ttl <= 0 || ttl > max {
+		t.Fatalf("TTL(%q) = %v, want in (0, %v]", key, ttl, max)
+	}
+}
+
+func expectNoTTL(t *testing.T, d namestore.Driver, key string) {
+	t.Helper()
+	if ttl, err := d.TTL(context.Background(), key); err != nil || ttl != -1 {
+		t.Fatalf("TTL(%q) = %v, %v; want -1", key, ttl, err)
+	}
+}
+
+func expectKeys(t *testing.T, what string, got []string, want ...string) {
+	t.Helper()
+	got = append([]string(nil), got...)
+	sort.Strings(got)
+	sort.Strings(want)
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("%s = %q, want %q", what, got, want)
+	}
+}
+
+func testSetGet(t *testing.T, d namestore.Driver) {
+	ctx := context.Background()
+
+	expectMissing(t, d, "ns:d:k")
+	mustSet(t, d, "ns:d:k", "v1", 0)
+	expectValue(t, d, "ns:d:k", "v1")
+	mustSet(t, d, "ns:d:k", "v2", 0)
+	expectValue(t, d, "ns:d:k", "v2")
+
+	// Empty values are stored, not treated as missing.
+	mustSet(t, d, "ns:d:empty", "", 0)
+	expectValue(t, d, "ns:d:empty", "")
+	if ok, err := d.Exists(ctx, "ns:d:empty"); err != nil || !ok {
+		t.Fatalf("Exists(empty) = %v, %v", ok, err)
+	}
+
+	// Binary values and unusual keys round-trip unchanged.
+	binary := []byte{0, 1, 2, 0xff, '\r', '\n', 0}
+	for _, key := range []string{"ns:d:a/b", "ns:d:sp ace", "ns:d:x:y:z", "ns:d:ünï", "ns:d:"} {
+		if err := d.Set(ctx, key, binary, 0); err != nil {
+			t.Fatalf("Set(%q): %v", key, err)
+		}
+		if got, err := d.Get(ctx, key); err != nil || !bytes.Equal(got, binary) {
+			t.Fatalf("Get(%q) = %v, %v", key, got, err)
+		}
+	}
+
+	// Set replaces the expiration.
+	mustSet(t, d, "ns:d:k", "v3", time.Minute)
+	expectTTL(t, d, "ns:d:k", time.Minute)
+	mustSet(t, d, "ns:d:k", "v4", 0)
+	expectNoTTL(t, d, "ns:d:k")
+}
+
+func testSetNX(t *testing.T, d namestore.Driver) {
+	ctx := context.Background()
+
+	ok, err := d.SetNX(ctx, "ns:d:k", []byte("first"), 0)
+	if err != nil || !ok {
+		t.Fatalf("SetNX new = %v, %v; want true", ok, err)
+	}
+	ok, err = d.SetNX(ctx, "ns:d:k", []byte("second"), 0)
+	if err != nil || ok {
+		t.Fatalf("SetNX existing = %v, %v; want false", ok, err)
+	}
+	expectValue(t, d, "ns:d:k", "first")
+	expectNoTTL(t, d, "ns:d:k")
+
+	if ok, err := d.SetNX(ctx, "ns:d:ttl", []byte("v"), time.Minute); err != nil || !ok {
+		t.Fatalf("SetNX with TTL = %v, %v", ok, err)
+	}
+	expectTTL(t, d, "ns:d:ttl", time.Minute)
+}
+
+func testDeleteExists(t *testing.T, d namestore.Driver) {
+	ctx := context.Background()
+
+	if ok, err := d.Exists(ctx, "ns:d:k"); err != nil || ok {
+		t.Fatalf("Exists missing = %v, %v", ok, err)
+	}
+	mustSet(t, d, "ns:d:k", "v", 0)
+	if ok, err := d.Exists(ctx, "ns:d:k"); err != nil || !ok {
+		t.Fatalf("Exists = %v, %v", ok, err)
+	}
+	if err := d.Delete(ctx, "ns:d:k"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if ok, err := d.Exists(ctx, "ns:d:k"); err != nil || ok {
+		t.Fatalf("Exists after Delete = %v, %v", ok, err)
+	}
+	expectMissing(t, d, "ns:d:k")
+
+	if err := d.Delete(ctx, "ns:d:missing"); err != nil {
+		t.Fatalf("Delete missing: %v", err)
+	}
+}
+
+func testValueCopies(t *testing.T, d namestore.Driver) {
+	ctx := context.Background()
+
+	value := []byte("original")
+	if err := d.Set(ctx, "ns:d:k", value, 0); err != nil {
+		t.Fatal(err)
+	}
+	copy(value, "mutated!")
+	expectValue(t, d, "ns:d:k", "original")
+
+	got, _ := d.Get(ctx, "ns:d:k")
+	copy(got, "mutated!")
+	expectValue(t, d, "ns:d:k", "original")
+
+	pairs := map[string][]byte{"ns:d:m": []byte("batch")}
+	if err := d.MSet(ctx, pairs, 0); err != nil {
+		t.Fatal(err)
+	}
+	copy(pairs["ns:d:m"], "BATCH")
+	found, _ := d.MGet(ctx, []string{"ns:d:m"})
+	copy(found["ns:d:m"], "BATCH")
+	expectValue(t, d, "ns:d:m", "batch")
+}
+
+func testMGet(t *testing.T, d namestore.Driver) {
+	ctx := context.Background()
+
+	got, err := d.MGet(ctx, nil)
+	if err != nil || len(got) != 0 {
+		t.Fatalf("MGet(nil) = %v, %v", got, err)
+	}
+
+	mustSet(t, d, "ns:d:a", "1", 0)
+	mustSet(t, d, "ns:d:b", "2", 0)
+	mustSet(t, d, "ns:d:empty", "", 0)
+	got, err = d.MGet(ctx, []string{"ns:d:a", "ns:d:missing", "ns:d:b", "ns:d:a", "ns:d:empty"})
+	if err != nil {
+		t.Fatalf("MGet: %v", err)
+	}
+	if len(got) != 3 || string(got["ns:d:a"]) != "1" || string(got["ns:d:b"]) != "2" {
+		t.Fatalf("MGet = %q", got)
+	}
+	if v, ok := got["ns:d:empty"]; !ok || len(v) != 0 {
+		t.Fatalf("MGet empty value = %q, %v", v, ok)
+	}
+	if _, ok := got["ns:d:missing"]; ok {
+		t.Fatal("MGet should omit missing keys")
+	}
+}
+
+func testMSetMDel(t *testing.T, d namestore.Driver) {
+	ctx := context.Background()
+
+	if err := d.MSet(ctx, map[string][]byte{}, 0); err != nil {
+		t.Fatalf("MSet(empty): %v", err)
+	}
+	if err := d.MDel(ctx, nil); err != nil {
+		t.Fatalf("MDel(nil): %v", err)
+	}
+
+	pairs := map[string][]byte{
+		"ns:d:a":    []byte("1"),
+		"ns:d:b":    []byte("2"),
+		"ns:e:c":    []byte("3"),
+		"other:x:y": []byte("4"),
+	}
+	if err := d.MSet(ctx, pairs, 0); err != nil {
+		t.Fatalf("MSet: %v", err)
+	}
+	for k, v := range pairs {
+		expectValue(t, d, k, string(v))
+		expectNoTTL(t, d, k)
+	}
+
+	if err := d.MSet(ctx, map[string][]byte{"ns:d:a": []byte("10"), "ns:d:t": []byte("t")}, time.Minute); err != nil {
+		t.Fatalf("MSet with TTL: %v", err)
+	}
+	expectValue(t, d, "ns:d:a", "10")
+	expectTTL(t, d, "ns:d:a", time.Minute)
+	expectTTL(t, d, "ns:d:t", time.Minute)
+
+	if err := d.MDel(ctx, []string{"ns:d:a", "ns:e:c", "ns:d:missing"}); err != nil {
+		t.Fatalf("MDel: %v", err)
+	}
+	expectMissing(t, d, "ns:d:a")
+	expectMissing(t, d, "ns:e:c")
+	expectValue(t, d, "ns:d:b", "2")
+	expectValue(t, d, "other:x:y", "4")
+}
+
+func testTTL(t *testing.T, d namestore.Driver) {
+	ctx := context.Background()
+
+	if _, err := d.TTL(ctx, "ns:d:missing"); !errors.Is(err, namestore.ErrNotFound) {
+		t.Fatalf("TTL missing = %v, want ErrNotFound", err)
+	}
+	mustSet(t, d, "ns:d:forever", "v", 0)
+	expectNoTTL(t, d, "ns:d:forever")
+	mustSet(t, d, "ns:d:negative", "v", -time.Second)
+	expectNoTTL(t, d, "ns:d:negative")
+
+	mustSet(t, d, "ns:d:minute", "v", time.Minute)
+	expectTTL(t, d, "ns:d:minute", time.Minute)
+	if ttl, _ := d.TTL(ctx, "ns:d:minute"); ttl < 50*time.Second {
+		t.Fatalf("TTL = %v, want about a minute", ttl)
+	}
+}
+
+func testExpirePersist(t *testing.T, d namestore.Driver, sleep func(time.Duration)) {
+	ctx := context.Background()
+
+	if err := d.Expire(ctx, "ns:d:missing", time.Minute); !errors.Is(err, namestore.ErrNotFound) {
+		t.Fatalf("Expire missing = %v, want ErrNotFound", err)
+	}
+	if err := d.Persist(ctx, "ns:d:missing"); !errors.Is(err, namestore.ErrNotFound) {
+		t.Fatalf("Persist missing = %v, want ErrNotFound", err)
+	}
+
+	mustSet(t, d, "ns:d:k", "v", 0)
+	if err := d.Expire(ctx, "ns:d:k", time.Minute); err != nil {
+		t.Fatalf("Expire: %v", err)
+	}
+	expectTTL(t, d, "ns:d:k", time.Minute)
+	expectValue(t, d, "ns:d:k", "v")
+
+	if err := d.Persist(ctx, "ns:d:k"); err != nil {
+		t.Fatalf("Persist: %v", err)
+	}
+	expectNoTTL(t, d, "ns:d:k")
+	// Persist on a key without expiration is not an error.
+	if err := d.Persist(ctx, "ns:d:k"); err != nil {
+		t.Fatalf("Persist again: %v", err)
+	}
+
+	_ = d.Expire(ctx, "ns:d:k", time.Minute)
+	if err := d.Expire(ctx, "ns:d:k", 0); err != nil {
+		t.Fatalf("Expire(0): %v", err)
+	}
+	expectNoTTL(t, d, "ns:d:k")
+	expectValue(t, d, "ns:d:k", "v")
+
+	if err := d.Expire(ctx, "ns:d:k", shortTTL); err != nil {
+		t.Fatalf("Expire short: %v", err)
+	}
+	expectTTL(t, d, "ns:d:k", shortTTL)
+	expectValue(t, d, "ns:d:k", "v")
+	sleep(expiredWait)
+	expectMissing(t, d, "ns:d:k")
+}
+
+// testExpiration checks that every operation treats an expired key as
+// missing.
+func testExpiration(t *testing.T, d namestore.Driver, sleep func(time.Duration)) {
+	ctx := context.Background()
+
+	keys := []string{"ns:d:get", "ns:d:exists", "ns:d:ttl", "ns:d:expire", "ns:d:persist",
+		"ns:d:mget", "ns:d:setnx", "ns:d:incr", "ns:d:getset", "ns:d:cas", "ns:d:keys"}
+	for _, k := range keys {
+		if err := d.Set(ctx, k, counter(7), shortTTL); err != nil {
+			t.Fatalf("Set(%q): %v", k, err)
+		}
+	}
+	mustSet(t, d, "ns:d:live", "v", 0)
+	expectTTL(t, d, "ns:d:get", shortTTL)
+	sleep(expiredWait)
+
+	expectMissing(t, d, "ns:d:get")
+	if ok, err := d.Exists(ctx, "ns:d:exists"); err != nil || ok {
+		t.Errorf("Exists expired = %v, %v", ok, err)
+	}
+	if _, err := d.TTL(ctx, "ns:d:ttl"); !errors.Is(err, namestore.ErrNotFound) {
+		t.Errorf("TTL expired = %v, want ErrNotFound", err)
+	}
+	if err := d.Expire(ctx, "ns:d:expire", time.Minute); !errors.Is(err, namestore.ErrNotFound) {
+		t.Errorf("Expire expired = %v, want ErrNotFound", err)
+	}
+	if err := d.Persist(ctx, "ns:d:persist"); !errors.Is(err, namestore.ErrNotFound) {
+		t.Errorf("Persist expired = %v, want ErrNotFound", err)
+	}
+	if got, err := d.MGet(ctx, []string{"ns:d:mget", "ns:d:live"}); err != nil || len(got) != 1 {
+		t.Errorf("MGet expired = %q, %v", got, err)
+	}
+	if ok, err := d.SetNX(ctx, "ns:d:setnx", []byte("new"), 0); err != nil || !ok {
+		t.Errorf("SetNX over expired = %v, %v; want true", ok, err)
+	}
+	if n, err := d.Incr(ctx, "ns:d:incr", 1); err != nil || n != 1 {
+		t.Errorf("Incr expired = %d, %v; want 1", n, err)
+	}
+	if _, err := d.GetSet(ctx, "ns:d:getset", []byte("new")); !errors.Is(err, namestore.ErrNotFound) {
+		t.Errorf("GetSet expired = %v, want ErrNotFound", err)
+	}
+	if ok, err := d.CompareAndSwap(ctx, "ns:d:cas", counter(7), []byte("new"), 0); err != nil || ok {
+		t.Errorf("CompareAndSwap expired = %v, %v; want false", ok, err)
+	}
+	if got, err := d.Keys(ctx, "ns:d", "k*"); err != nil || len(got) != 0 {
+		t.Errorf("Keys expired = %q, %v", got, err)
+	}
+	if t.Failed() {
+		return
+	}
+
+	// The writes above start from scratch, without the old expiration.
+	expectValue(t, d, "ns:d:setnx", "new")
+	expectNoTTL(t, d, "ns:d:setnx")
+	expectNoTTL(t, d, "ns:d:incr")
+	expectValue(t, d, "ns:d:getset", "new")
+}
+
+func seed(t *testing.T, d namestore.Driver, keys ...string) {
+	t.Helper()
+	for _, k := range keys {
+		mustSet(t, d, k, "v", 0)
+	}
+}
+
+func testKeys(t *testing.T, d namestore.Driver) {
+	ctx := context.Background()
+
+	if got, err := d.Keys(ctx, "ns:d", "*"); err != nil || len(got) != 0 {
+		t.Fatalf("Keys on empty driver = %q, %v", got, err)
+	}
+	seed(t, d, "ns:d:a", "ns:d:b", "ns:d:sub:c", "ns:dd:x", "ns:e:a", "xns:d:a")
+
+	for _, pattern := range []string{"", "*"} {
+		got, err := d.Keys(ctx, "ns:d", pattern)
+		if err != nil {
+			t.Fatalf("Keys(%q): %v", pattern, err)
+		}
+		expectKeys(t, fmt.Sprintf("Keys(ns:d, %q)", pattern), got, "ns:d:a", "ns:d:b", "ns:d:sub:c")
+	}
+
+	got, _ := d.Keys(ctx, "missing:d", "*")
+	expectKeys(t, "Keys(missing:d, *)", got)
+
+	// Client prefixes are always rootNS:domain; drivers that cannot list
+	// other prefixes may return ErrUnsupported for them.
+	for _, tc := range []struct {
+		prefix string
+		want   []string
+	}{
+		{"ns", []string{"ns:d:a", "ns:d:b", "ns:d:sub:c", "ns:dd:x", "ns:e:a"}},
+		{"ns:d:sub", []string{"ns:d:sub:c"}},
+	} {
+		got, err := d.Keys(ctx, tc.prefix, "*")
+		if errors.Is(err, namestore.ErrUnsupported) {
+			t.Logf("Keys(%q): %v", tc.prefix, err)
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Keys(%q): %v", tc.prefix, err)
+		}
+		expectKeys(t, fmt.Sprintf("Keys(%s, *)", tc.prefix), got, tc.want...)
+	}
+}
+
+func testPatterns(t *testing.T, d namestore.Driver) {
+	ctx := context.Background()
+	seed(t, d, "ns:d:user:1", "ns:d:user:2", "ns:d:user:10", "ns:d:order:1", "ns:d:a?b", "ns:d:a[b")
+
+	for _, tc := range []struct {
+		pattern string
+		want    []string
+	}{
+		{"user:*", []string{"ns:d:user:1", "ns:d:user:2", "ns:d:user:10"}},
+		{"user:?", []string{"ns:d:user:1", "ns:d:user:2"}},
+		{"user:[12]", []string{"ns:d:user:1", "ns:d:user:2"}},
+		{"user:[^1]", []string{"ns:d:user:2"}},
+		{"*:1", []string{"ns:d:user:1", "ns:d:order:1"}},
+		{"order:1", []string{"ns:d:order:1"}},
+		{`a\?b`, []string{"ns:d:a?b"}},
+		{`a\[b`, []string{"ns:d:a[b"}},
+		{"nothing*", nil},
+	} {
+		got, err := d.Keys(ctx, "ns:d", tc.pattern)
+		if err != nil {
+			t.Fatalf("Keys(%q): %v", tc.pattern, err)
+		}
+		expectKeys(t, fmt.Sprintf("Keys(ns:d, %q)", tc.pattern), got, tc.want...)
+	}
+
+	for _, pattern := range []string{"[", "user:[1", `user:\`} {
+		if _, err := d.Keys(ctx, "ns:d", pattern); !errors.Is(err, namestore.ErrInvalidPattern) {
+			t.Errorf("Keys(%q) = %v, want ErrInvalidPattern", pattern, err)
+		}
+		if _, _, err := d.Scan(ctx, "ns:d", pattern, 0, 10); !errors.Is(err, namestore.ErrInvalidPattern) {
+			t.Errorf("Scan(%q) = %v, want ErrInvalidPattern", pattern, err)
+		}
+	}
+}
+
+// scanAll walks a scan to the end, returning the distinct keys.
+func scanAll(t *testing.T, d namestore.Driver, prefix, pattern string, count int) []string {
+	t.Helper()
+	seen := make(map[string]bool)
+	var keys []string
+	var cursor uint64
+	for calls := 0; ; calls++ {
+		if calls > 10000 {
+			t.Fatal("Scan did not finish")
+		}
+		page, next, err := d.Scan(context.Background(), prefix, pattern, cursor, count)
+		if err != nil {
+			t.Fatalf("Scan(%q, %q, %d): %v", prefix, pattern, cursor, err)
+		}
+		for _, k := range page {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+		if cursor = next; cursor == 0 {
+			return keys
+		}
+	}
+}
+
+func testScan(t *testing.T, d namestore.Driver) {
+	ctx := context.Background()
+
+	if got := scanAll(t, d, "ns:d", "*", 10); len(got) != 0 {
+		t.Fatalf("Scan on empty driver = %q", got)
+	}
+
+	var want, users []string
+	pairs := make(map[string][]byte)
+	for i := 0; i < 120; i++ {
+		k := fmt.Sprintf("ns:d:item:%03d", i)
+		pairs[k] = []byte("v")
+		want = append(want, k)
+		if i < 5 {
+			u := fmt.Sprintf("ns:d:user:%d", i)
+			pairs[u] = []byte("v")
+			want = append(want, u)
+			users = append(users, u)
+		}
+	}
+	pairs["ns:other:item:1"] = []byte("v")
+	pairs["ns:dd:item:1"] = []byte("v")
+	if err := d.MSet(ctx, pairs, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, count := range []int{0, 1, 7, 1000} {
+		expectKeys(t, fmt.Sprintf("Scan(ns:d, *, count %d)", count), scanAll(t, d, "ns:d", "*", count), want...)
+	}
+	expectKeys(t, "Scan(ns:d, user:*)", scanAll(t, d, "ns:d", "user:*", 3), users...)
+	expectKeys(t, "Scan(ns:d, none*)", scanAll(t, d, "ns:d", "none*", 3))
+
+	// Keys that exist throughout a scan are returned even if others change.
+	var cursor uint64
+	seen := make(map[string]bool)
+	for i := 0; ; i++ {
+		page, next, err := d.Scan(ctx, "ns:d", "item:*", cursor, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, k := range page {
+			seen[k] = true
+		}
+		if i == 1 {
+			_ = d.Delete(ctx, "ns:d:user:0")
+			mustSet(t, d, "ns:d:item:new", "v", 0)
+		}
+		if cursor = next; cursor == 0 {
+			break
+		}
+	}
+	for i := 0; i < 120; i++ {
+		if k := fmt.Sprintf("ns:d:item:%03d", i); !seen[k] {
+			t.Fatalf("Scan with concurrent writes missed %q", k)
+		}
+	}
+}
+
+func testClear(t *testing.T, d namestore.Driver) {
+	ctx := context.Background()
+
+	if err := d.Clear(ctx, "ns:empty"); err != nil {
+		t.Fatalf("Clear empty namespace: %v", err)
+	}
+
+	seed(t, d, "ns:d:a", "ns:d:b", "ns:d:sub:c", "ns:dd:x", "ns:e:a", "xns:d:a")
+	if err := d.Clear(ctx, "ns:d"); err != nil {
+		t.Fatalf("Clear: %v", err)
+	}
+	for _, k := range []string{"ns:d:a", "ns:d:b", "ns:d:sub:c"} {
+		expectMissing(t, d, k)
+	}
+	for _, k := range []string{"ns:dd:x", "ns:e:a", "xns:d:a"} {
+		expectValue(t, d, k, "v")
+	}
+	if got, _ := d.Keys(ctx, "ns:d", "*"); len(got) != 0 {
+		t.Fatalf("Keys after Clear = %q", got)
+	}
+
+	// The namespace is usable again.
+	mustSet(t, d, "ns:d:a", "again", 0)
+	expectValue(t, d, "ns:d:a", "again")
+}
+
+func testIncr(t *testing.T, d namestore.Driver) {
+	ctx := context.Background()
+
+	if n, err := d.Incr(ctx, "ns:d:n", 5); err != nil || n != 5 {
+		t.Fatalf("Incr new = %d, %v; want 5", n, err)
+	}
+	expectNoTTL(t, d, "ns:d:n")
+	if n, err := d.Incr(ctx, "ns:d:n", -7); err != nil || n != -2 {
+		t.Fatalf("Incr negative = %d, %v; want -2", n, err)
+	}
+	if n, err := d.Decr(ctx, "ns:d:n", 3); err != nil || n != -5 {
+		t.Fatalf("Decr = %d, %v; want -5", n, err)
+	}
+	if n, err := d.Decr(ctx, "ns:d:new", 2); err != nil || n != -2 {
+		t.Fatalf("Decr new = %d, %v; want -2", n, err)
+	}
+	if n, err := d.Incr(ctx, "ns:d:n", 0); err != nil || n != -5 {
+		t.Fatalf("Incr(0) = %d, %v; want -5", n, err)
+	}
+
+	// Counters are 8-byte little-endian values.
+	expectValue(t, d, "ns:d:n", string(counter(-5)))
+	if err := d.Set(ctx, "ns:d:set", counter(41), 0); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := d.Incr(ctx, "ns:d:set", 1); err != nil || n != 42 {
+		t.Fatalf("Incr on a stored counter = %d, %v; want 42", n, err)
+	}
+
+	// Incr keeps the expiration.
+	if err := d.Set(ctx, "ns:d:ttl", counter(1), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := d.Incr(ctx, "ns:d:ttl", 1); err != nil || n != 2 {
+		t.Fatalf("Incr with TTL = %d, %v", n, err)
+	}
+	expectTTL(t, d, "ns:d:ttl", time.Minute)
+
+	for _, value := range []string{"", "1", "42", "1234567", "123456789"} {
+		mustSet(t, d, "ns:d:bad", value, 0)
+		if _, err := d.Incr(ctx, "ns:d:bad", 1); !errors.Is(err, namestore.ErrTypeMismatch) {
+			t.Errorf("Incr on %q = %v, want ErrTypeMismatch", value, err)
+		}
+		if _, err := d.Decr(ctx, "ns:d:bad", 1); !errors.Is(err, namestore.ErrTypeMismatch) {
+			t.Errorf("Decr on %q = %v, want ErrTypeMismatch", value, err)
+		}
+		expectValue(t, d, "ns:d:bad", value)
+	}
+}
+
+func testGetSet(t *testing.T, d namestore.Driver) {
+	ctx := context.Background()
+
+	old, err := d.GetSet(ctx, "ns:d:k", []byte("first"))
+	if !errors.Is(err, namestore.ErrNotFound) {
+		t.Fatalf("GetSet missing = %q, %v; want ErrNotFound", old, err)
+	}
+	// The value is written anyway.
+	expectValue(t, d, "ns:d:k", "first")
+	expectNoTTL(t, d, "ns:d:k")
+
+	old, err = d.GetSet(ctx, "ns:d:k", []byte("second"))
+	if err != nil || string(old) != "first" {
+		t.Fatalf("GetSet = %q, %v; want first", old, err)
+	}
+	expectValue(t, d, "ns:d:k", "second")
+
+	// GetSet keeps the expiration.
+	mustSet(t, d, "ns:d:ttl", "a", time.Minute)
+	if old, err := d.GetSet(ctx, "ns:d:ttl", []byte("b")); err != nil || string(old) != "a" {
+		t.Fatalf("GetSet with TTL = %q, %v", old, err)
+	}
+	expectTTL(t, d, "ns:d:ttl", time.Minute)
+	expectValue(t, d, "ns:d:ttl", "b")
+}
+
+func testCompareAndSwap(t *testing.T, d namestore.Driver) {
+	ctx := context.Background()
+
+	if ok, err := d.CompareAndSwap(ctx, "ns:d:missing", nil, []byte("v"), 0); err != nil || ok {
+		t.Fatalf("CompareAndSwap missing = %v, %v; want false", ok, err)
+	}
+	expectMissing(t, d, "ns:d:missing")
+
+	mustSet(t, d, "ns:d:k", "a", 0)
+	if ok, err := d.CompareAndSwap(ctx, "ns:d:k", []byte("x"), []byte("b"), 0); err != nil || ok {
+		t.Fatalf("CompareAndSwap mismatch = %v, %v; want false", ok, err)
+	}
+	expectValue(t, d, "ns:d:k", "a")
+
+	if ok, err := d.CompareAndSwap(ctx, "ns:d:k", []byte("a"), []byte("b"), time.Minute); err != nil || !ok {
+		t.Fatalf("CompareAndSwap = %v, %v; want true", ok, err)
+	}
+	expectValue(t, d, "ns:d:k", "b")
+	expectTTL(t, d, "ns:d:k", time.Minute)
+
+	// Without a TTL the new value does not expire.
+	if ok, err := d.CompareAndSwap(ctx, "ns:d:k", []byte("b"), []byte("c"), 0); err != nil || !ok {
+		t.Fatalf("CompareAndSwap without TTL = %v, %v", ok, err)
+	}
+	expectNoTTL(t, d, "ns:d:k")
+
+	// Empty values compare equal to each other.
+	mustSet(t, d, "ns:d:empty", "", 0)
+	if ok, err := d.CompareAndSwap(ctx, "ns:d:empty", []byte{}, []byte("full"), 0); err != nil || !ok {
+		t.Fatalf("CompareAndSwap on empty value = %v, %v", ok, err)
+	}
+	expectValue(t, d, "ns:d:empty", "full")
+}
+
+// concurrency is the number of goroutines in the concurrent tests.
+const concurrency = 8
+
+// parallel runs fn on concurrency goroutines and waits for them.
+func parallel(fn func(worker int)) {
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+func testConcurrentIncr(t *testing.T, d namestore.Driver) {
+	ctx := context.Background()
+	const perWorker = 100
+
+	errs := make(chan error, concurrency)
+	parallel(func(worker int) {
+		for i := 0; i < perWorker; i++ {
+			delta := int64(1)
+			if worker%2 == 1 {
+				delta = 2
+			}
+			if _, err := d.Incr(ctx, "ns:d:n", delta); err != nil {
+				errs <- err
+				return
+			}
+		}
+	})
+	close(errs)
+	for err := range errs {
+		t.Fatalf("Incr: %v", err)
+	}
+
+	want := int64(perWorker * (concurrency/2*1 + concurrency/2*2))
+	if n, err := d.Incr(ctx, "ns:d:n", 0); err != nil || n != want {
+		t.Fatalf("counter = %d, %v; want %d", n, err, want)
+	}
+}
+
+func testConcurrentSetNX(t *testing.T, d namestore.Driver) {
+	ctx := context.Background()
+
+	for round := 0; round < 20; round++ {
+		key := fmt.Sprintf("ns:d:lock:%d", round)
+		var mu sync.Mutex
+		var winners []int
+		parallel(func(worker int) {
+			ok, err := d.SetNX(ctx, key, []byte{byte(worker)}, 0)
+			if err != nil {
+				t.Errorf("SetNX: %v", err)
+				return
+			}
+			if ok {
+				mu.Lock()
+				winners = append(winners, worker)
+				mu.Unlock()
+			}
+		})
+		if len(winners) != 1 {
+			t.Fatalf("round %d: %d SetNX calls succeeded, want 1", round, len(winners))
+		}
+		expectValue(t, d, key, string([]byte{byte(winners[0])}))
+	}
+}
+
+// testConcurrentCompareAndSwap increments a decimal counter with
+// read-compare-swap loops, which loses updates unless CompareAndSwap is
+// atomic.
+func testConcurrentCompareAndSwap(t *testing.T, d namestore.Driver) {
+	ctx := context.Background()
+	const perWorker = 50
+	mustSet(t, d, "ns:d:n", "0", 0)
+
+	errs := make(chan error, concurrency)
+	parallel(func(worker int) {
+		for i := 0; i < perWorker; {
+			cur, err := d.Get(ctx, "ns:d:n")
+			if err != nil {
+				errs <- err
+				return
+			}
+			var n int
+			fmt.Sscan(string(cur), &n)
+			ok, err := d.CompareAndSwap(ctx, "ns:d:n", cur, []byte(fmt.Sprint(n+1)), 0)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if ok {
+				i++
+			}
+		}
+	})
+	close(errs)
+	for err := range errs {
+		t.Fatalf("CompareAndSwap loop: %v", err)
+	}
+	expectValue(t, d, "ns:d:n", fmt.Sprint(concurrency*perWorker))
+}
+
+// testConcurrentMixed runs every kind of operation at once on overlapping
+// keys; it checks for errors and, under the race detector, data races.
+func testConcurrentMixed(t *testing.T, d namestore.Driver) {
+	ctx := context.Background()
+	const rounds = 100
+
+	errs := make(chan error, concurrency)
+	parallel(func(worker int) {
+		fail := func(op string, err error) bool {
+			if err != nil && !errors.Is(err, namestore.ErrNotFound) && !errors.Is(err, namestore.ErrTypeMismatch) {
+				errs <- fmt.Errorf("%s: %w", op, err)
+				return true
+			}
+			return false
+		}
+		for i := 0; i < rounds; i++ {
+			key := fmt.Sprintf("ns:d:k%d", i%10)
+			value := []byte(fmt.Sprintf("w%d-%d", worker, i))
+			var err error
+			switch (worker + i) % 12 {
+			case 0:
+				err = d.Set(ctx, key, value, time.Minute)
+			case 1:
+				_, err = d.Get(ctx, key)
+			case 2:
+				err = d.Delete(ctx, key)
+			case 3:
+				_, err = d.MGet(ctx, []string{key, "ns:d:k1", "ns:d:k2"})
+			case 4:
+				err = d.MSet(ctx, map[string][]byte{key: value, "ns:d:k3": value}, 0)
+			case 5:
+				_, err = d.Incr(ctx, "ns:d:counter", 1)
+			case 6:
+				_, err = d.GetSet(ctx, key, value)
+			case 7:
+				_, err = d.CompareAndSwap(ctx, key, value, value, 0)
+			case 8:
+				_, err = d.Keys(ctx, "ns:d", "k*")
+			case 9:
+				_, _, err = d.Scan(ctx, "ns:d", "*", 0, 5)
+			case 10:
+				err = d.Expire(ctx, key, time.Minute)
+			case 11:
+				_, err = d.TTL(ctx, key)
+			}
+			if fail(fmt.Sprint((worker+i)%12), err) {
+				return
+			}
+		}
+	})
+	close(errs)
+	for err := range errs {
+		t.Fatalf("concurrent operation %v", err)
+	}
+	if n, err := d.Incr(ctx, "ns:d:counter", 0); err != nil || n == 0 {
+		t.Fatalf("counter = %d, %v", n, err)
+	}
+}
@@ -0,0 +1,110 @@
+package namestoretest
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"code.byted.org/khicago/namestore"
+	"code.byted.org/khicago/namestore/nshttp"
+)
+
+// closing registers d's Close method, if it has one, as a cleanup.
+func closing(t *testing.T, d namestore.Driver) namestore.Driver {
+	if c, ok := d.(io.Closer); ok {
+		t.Cleanup(func() { c.Close() })
+	}
+	return d
+}
+
+// TestMemory checks expiration in real time; the other drivers use a fake
+// clock to keep the suite fast.
+func TestMemory(t *testing.T) {
+	RunDriverSuite(t, func(t *testing.T) namestore.Driver {
+		return closing(t, namestore.NewMemory())
+	})
+}
+
+func TestMemoryWithClock(t *testing.T) {
+	RunDriverSuiteWithClock(t, func(t *testing.T, clock namestore.Clock) namestore.Driver {
+		return closing(t, namestore.NewMemory(namestore.WithClock(clock)))
+	})
+}
+
+func TestShardedMemory(t *testing.T) {
+	RunDriverSuiteWithClock(t, func(t *testing.T, clock namestore.Clock) namestore.Driver {
+		return closing(t, namestore.NewShardedMemory(4, namestore.WithClock(clock)))
+	})
+}
+
+func TestFileDriver(t *testing.T) {
+	RunDriverSuiteWithClock(t, func(t *testing.T, clock namestore.Clock) namestore.Driver {
+		d, err := namestore.NewFileDriver(t.TempDir(), namestore.WithFileClock(clock))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return closing(t, d)
+	})
+}
+
+func TestNearCache(t *testing.T) {
+	RunDriverSuiteWithClock(t, func(t *testing.T, clock namestore.Clock) namestore.Driver {
+		l2 := closing(t, namestore.NewMemory(namestore.WithClock(clock)))
+		// Local copies read from l2 are kept for the TTL cap, which must
+		// therefore not outlast the suite's expirations.
+		return closing(t, namestore.NewNearCache(l2,
+			namestore.WithNearCacheTTL(shortTTL),
+			namestore.WithNearCacheMemory(namestore.WithClock(clock))))
+	})
+}
+
+func TestRing(t *testing.T) {
+	RunDriverSuiteWithClock(t, func(t *testing.T, clock namestore.Clock) namestore.Driver {
+		d, err := namestore.NewRing([]namestore.RingNode{
+			{Name: "a", Driver: closing(t, namestore.NewMemory(namestore.WithClock(clock)))},
+			{Name: "b", Driver: closing(t, namestore.NewMemory(namestore.WithClock(clock)))},
+			{Name: "c", Driver: closing(t, namestore.NewMemory(namestore.WithClock(clock)))},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	})
+}
+
+func TestReplicated(t *testing.T) {
+	RunDriverSuiteWithClock(t, func(t *testing.T, clock namestore.Clock) namestore.Driver {
+		primary := closing(t, namestore.NewMemory(namestore.WithClock(clock)))
+		replica := closing(t, namestore.NewMemory(namestore.WithClock(clock)))
+		return closing(t, namestore.NewReplicated(primary, []namestore.Driver{replica}))
+	})
+}
+
+func TestMiddleware(t *testing.T) {
+	passThrough := namestore.Intercept(func(ctx context.Context, op namestore.OpInfo, next func(context.Context) error) error {
+		return next(ctx)
+	})
+	base := func(d namestore.Driver) namestore.Driver { return namestore.DriverBase{Next: d} }
+	RunDriverSuiteWithClock(t, func(t *testing.T, clock namestore.Clock) namestore.Driver {
+		return namestore.Chain(closing(t, namestore.NewMemory(namestore.WithClock(clock))), passThrough, base)
+	})
+}
+
+func TestRetryAndBreaker(t *testing.T) {
+	RunDriverSuiteWithClock(t, func(t *testing.T, clock namestore.Clock) namestore.Driver {
+		return namestore.Chain(closing(t, namestore.NewMemory(namestore.WithClock(clock))), namestore.Retry(), namestore.NewCircuitBreaker().Wrap)
+	})
+}
+
+func TestHTTPDriver(t *testing.T) {
+	RunDriverSuiteWithClock(t, func(t *testing.T, clock namestore.Clock) namestore.Driver {
+		srv := httptest.NewServer(nshttp.NewHandler(closing(t, namestore.NewMemory(namestore.WithClock(clock)))))
+		t.Cleanup(srv.Close)
+		d, err := nshttp.NewDriver(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	})
+}
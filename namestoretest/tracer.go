@@ -0,0 +1,106 @@
+package namestoretest
+
+import (
+	"context"
+	"sync"
+
+	"code.byted.org/khicago/namestore"
+)
+
+// RecordedSpan is a span kept by RecordingTracer.
+type RecordedSpan struct {
+	// ID numbers spans from 1 in start order. ParentID is the ID of the span
+	// in the context passed to Start, or 0.
+	ID, ParentID int
+	Name         string
+	Attributes   map[string]any
+	Errors       []error
+	Ended        bool
+}
+
+// RecordingTracer is a namestore.Tracer that keeps every span in memory,
+// for tests:
+//
+//	tracer := namestoretest.NewRecordingTracer()
+//	c := namestore.New[string]("app", "users", namestore.WithTracer[string](tracer))
+//	c.Get(ctx, "1")
+//	span := tracer.Spans()[0] // Name "namestore.Get", Attributes[namestore.AttrHit] false
+//
+// It is safe for concurrent use.
+type RecordingTracer struct {
+	mu     sync.Mutex
+	spans  []*RecordedSpan
+	lastID int
+}
+
+type spanKey struct{}
+
+// NewRecordingTracer returns a RecordingTracer with no spans.
+func NewRecordingTracer() *RecordingTracer {
+	return &RecordingTracer{}
+}
+
+// Start implements namestore.Tracer.
+func (t *RecordingTracer) Start(ctx context.Context, name string) (context.Context, namestore.Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lastID++
+	s := &RecordedSpan{ID: t.lastID, ParentID: SpanID(ctx), Name: name, Attributes: make(map[string]any)}
+	t.spans = append(t.spans, s)
+	return context.WithValue(ctx, spanKey{}, s.ID), &recordingSpan{t: t, s: s}
+}
+
+// Spans returns copies of the spans started so far, in start order.
+func (t *RecordingTracer) Spans() []RecordedSpan {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make([]RecordedSpan, len(t.spans))
+	for i, s := range t.spans {
+		out[i] = *s
+		out[i].Attributes = make(map[string]any, len(s.Attributes))
+		for k, v := range s.Attributes {
+			out[i].Attributes[k] = v
+		}
+		out[i].Errors = append([]error(nil), s.Errors...)
+	}
+	return out
+}
+
+// Reset forgets all spans. Span IDs keep counting.
+func (t *RecordingTracer) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.spans = nil
+}
+
+// SpanID returns the ID of the RecordingTracer span in ctx, or 0. Drivers
+// can use it to check that they receive the context of the client's span.
+func SpanID(ctx context.Context) int {
+	id, _ := ctx.Value(spanKey{}).(int)
+	return id
+}
+
+type recordingSpan struct {
+	t *RecordingTracer
+	s *RecordedSpan
+}
+
+func (r *recordingSpan) SetAttributes(attrs ...namestore.Attribute) {
+	r.t.mu.Lock()
+	defer r.t.mu.Unlock()
+	for _, a := range attrs {
+		r.s.Attributes[a.Key] = a.Value
+	}
+}
+
+func (r *recordingSpan) RecordError(err error) {
+	r.t.mu.Lock()
+	defer r.t.mu.Unlock()
+	r.s.Errors = append(r.s.Errors, err)
+}
+
+func (r *recordingSpan) End() {
+	r.t.mu.Lock()
+	defer r.t.mu.Unlock()
+	r.s.Ended = true
+}
@@ -0,0 +1,52 @@
+package namestoretest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"code.byted.org/khicago/namestore"
+)
+
+func TestRecordingTracer(t *testing.T) {
+	tracer := NewRecordingTracer()
+	if got := SpanID(context.Background()); got != 0 {
+		t.Fatalf("SpanID without span = %d", got)
+	}
+
+	ctx, root := tracer.Start(context.Background(), "root")
+	childCtx, child := tracer.Start(ctx, "child")
+	if SpanID(ctx) != 1 || SpanID(childCtx) != 2 {
+		t.Fatalf("span IDs = %d, %d", SpanID(ctx), SpanID(childCtx))
+	}
+	child.SetAttributes(namestore.Attribute{Key: "k", Value: 1}, namestore.Attribute{Key: "k", Value: 2})
+	child.RecordError(errors.New("boom"))
+	child.End()
+
+	spans := tracer.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans", len(spans))
+	}
+	if s := spans[0]; s.Name != "root" || s.ParentID != 0 || s.Ended {
+		t.Errorf("root = %+v", s)
+	}
+	if s := spans[1]; s.Name != "child" || s.ParentID != 1 || !s.Ended || s.Attributes["k"] != 2 || len(s.Errors) != 1 {
+		t.Errorf("child = %+v", s)
+	}
+
+	// Spans returns copies.
+	spans[1].Attributes["k"] = 3
+	root.SetAttributes(namestore.Attribute{Key: "late", Value: true})
+	root.End()
+	if s := tracer.Spans(); s[1].Attributes["k"] != 2 || s[0].Attributes["late"] != true || !s[0].Ended {
+		t.Errorf("spans after update = %+v", s)
+	}
+
+	tracer.Reset()
+	if len(tracer.Spans()) != 0 {
+		t.Fatal("Reset should forget spans")
+	}
+	if ctx, _ := tracer.Start(context.Background(), "next"); SpanID(ctx) != 3 {
+		t.Errorf("ID after Reset = %d, want 3", SpanID(ctx))
+	}
+}
@@ -0,0 +1,351 @@
+package namestore
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultNearCacheTTL caps how long NearCache keeps a value locally, and
+	// so how stale it can be after a write made through another client.
+	DefaultNearCacheTTL = 5 * time.Second
+	// DefaultNearCacheEntries bounds the local tier unless WithNearCacheMemory
+	// sets other limits.
+	DefaultNearCacheEntries = 10000
+
+	// nearCacheStripes is the number of invalidation counters keys are
+	// hashed onto.
+	nearCacheStripes = 1024
+)
+
+// NearCacheMode selects how NearCache treats the local tier on writes.
+type NearCacheMode int
+
+const (
+	// WriteThrough writes to the remote driver and then caches the value
+	// locally, so a process reads its own writes from memory.
+	WriteThrough NearCacheMode = iota
+	// WriteAround writes to the remote driver and drops the local copy; the
+	// next read fetches it. Suits keys that are written more than read.
+	WriteAround
+)
+
+// NearCacheOption customizes the NearCache driver.
+type NearCacheOption func(*nearCacheConfig)
+
+type nearCacheConfig struct {
+	ttl  time.Duration
+	mode NearCacheMode
+	l1   []MemoryOption
+}
+
+// WithNearCacheTTL caps the lifetime of local copies. Values written with a
+// shorter TTL keep it. Defaults to DefaultNearCacheTTL.
+func WithNearCacheTTL(ttl time.Duration) NearCacheOption {
+	return func(c *nearCacheConfig) {
+		if ttl > 0 {
+			c.ttl = ttl
+		}
+	}
+}
+
+// WithNearCacheMode sets the write mode. Defaults to WriteThrough.
+func WithNearCacheMode(mode NearCacheMode) NearCacheOption {
+	return func(c *nearCacheConfig) {
+		c.mode = mode
+	}
+}
+
+// WithNearCacheMemory configures the local Memory tier, typically with
+// WithMaxEntries or WithMaxBytes. The tier defaults to DefaultNearCacheEntries
+// entries with AllKeysLRU eviction; these options are applied on top.
+func WithNearCacheMemory(opts ...MemoryOption) NearCacheOption {
+	return func(c *nearCacheConfig) {
+		c.l1 = append(c.l1, opts...)
+	}
+}
+
+// NearCache implements Driver with a bounded local Memory (L1) in front of
+// another driver (L2). Reads are served from L1 when possible, and MGet only
+// fetches L1 misses from L2. Writes always go to L2 first; atomic operations
+// (SetNX, Incr, Decr, GetSet, CompareAndSwap) run on L2 only and drop the
+// local copy, so their semantics are exactly those of L2. TTL, Keys, Scan
+// and Clear are answered by L2 as well.
+//
+// Writes through this NearCache invalidate L1 consistently, even when they
+// race with reads filling it. Writes made by other clients of L2 are not
+// seen until the local copy expires, after at most the TTL cap. A value read
+// from L2 is kept for the full TTL cap, without asking L2 for its TTL, so it
+// may outlive a key that expires sooner in L2.
+type NearCache struct {
+	l1   *Memory
+	l2   Driver
+	ttl  time.Duration
+	mode NearCacheMode
+
+	// Every change to L2 bumps the counter of its key's stripe before and
+	// after the write; Clear bumps epoch. L1 is only filled if neither moved
+	// while the value was read, and cleared otherwise.
+	stripes [nearCacheStripes]atomic.Uint64
+	epoch   atomic.Uint64
+}
+
+// NewNearCache layers a local cache over l2. Closing the NearCache stops the
+// local tier but leaves l2 open.
+func NewNearCache(l2 Driver, opts ...NearCacheOption) *NearCache {
+	cfg := nearCacheConfig{ttl: DefaultNearCacheTTL}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	l1Opts := append([]MemoryOption{WithMaxEntries(DefaultNearCacheEntries), WithEvictionPolicy(AllKeysLRU)}, cfg.l1...)
+	return &NearCache{
+		l1:   newMemory(newMemoryConfig(l1Opts)),
+		l2:   l2,
+		ttl:  cfg.ttl,
+		mode: cfg.mode,
+	}
+}
+
+// Close stops the background work of the local tier.
+func (n *NearCache) Close() error {
+	return n.l1.Close()
+}
+
+func (n *NearCache) stripe(key string) *atomic.Uint64 {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return &n.stripes[h%nearCacheStripes]
+}
+
+// localTTL caps ttl, where 0 means no expiry, at the configured maximum.
+func (n *NearCache) localTTL(ttl time.Duration) time.Duration {
+	if ttl <= 0 || ttl > n.ttl {
+		return n.ttl
+	}
+	return ttl
+}
+
+// nearCacheMark records the invalidation counters of a key at some point.
+type nearCacheMark struct {
+	stripe, epoch uint64
+}
+
+func (n *NearCache) mark(key string) nearCacheMark {
+	return nearCacheMark{n.stripe(key).Load(), n.epoch.Load()}
+}
+
+// fill caches a value read from L2 at mark, unless the key was written
+// meanwhile. The value is stored first and removed again if a write slipped
+// in, since the check and the store cannot happen atomically.
+func (n *NearCache) fill(ctx context.Context, key string, value []byte, at nearCacheMark) {
+	if n.mark(key) != at {
+		return
+	}
+	_ = n.l1.Set(ctx, key, value, n.ttl)
+	if n.mark(key) != at {
+		_ = n.l1.Delete(ctx, key)
+	}
+}
+
+// write runs op against L2 and then updates L1: with value cached (if
+// cache is set) when no other write on the key overlapped op, and dropped
+// otherwise.
+func (n *NearCache) write(ctx context.Context, key string, op func() error, cache bool, value []byte, ttl time.Duration) error {
+	s := n.stripe(key)
+	before := nearCacheMark{s.Add(1), n.epoch.Load()}
+	err := op()
+	after := nearCacheMark{s.Add(1), n.epoch.Load()}
+
+	if err == nil && cache && n.mode == WriteThrough && after == (nearCacheMark{before.stripe + 1, before.epoch}) {
+		_ = n.l1.Set(ctx, key, value, n.localTTL(ttl))
+		if n.mark(key) == after {
+			return nil
+		}
+	}
+	_ = n.l1.Delete(ctx, key)
+	return err
+}
+
+// invalidate runs op against L2 and drops the local copy of key.
+func (n *NearCache) invalidate(ctx context.Context, key string, op func() error) error {
+	return n.write(ctx, key, op, false, nil, 0)
+}
+
+func (n *NearCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return n.write(ctx, key, func() error {
+		return n.l2.Set(ctx, key, value, ttl)
+	}, true, value, ttl)
+}
+
+func (n *NearCache) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	var ok bool
+	err := n.write(ctx, key, func() (err error) {
+		ok, err = n.l2.SetNX(ctx, key, value, ttl)
+		return err
+	}, false, nil, 0)
+	return ok, err
+}
+
+func (n *NearCache) Get(ctx context.Context, key string) ([]byte, error) {
+	if value, err := n.l1.Get(ctx, key); err == nil {
+		return value, nil
+	}
+
+	at := n.mark(key)
+	value, err := n.l2.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	n.fill(ctx, key, value, at)
+	return value, nil
+}
+
+func (n *NearCache) Delete(ctx context.Context, key string) error {
+	return n.invalidate(ctx, key, func() error {
+		return n.l2.Delete(ctx, key)
+	})
+}
+
+func (n *NearCache) Exists(ctx context.Context, key string) (bool, error) {
+	if ok, _ := n.l1.Exists(ctx, key); ok {
+		return true, nil
+	}
+	return n.l2.Exists(ctx, key)
+}
+
+// MGet serves keys from L1 and fetches only the misses from L2, in one call.
+func (n *NearCache) MGet(ctx context.Context, keys []string) (map[string][]byte, error) {
+	result, _ := n.l1.MGet(ctx, keys)
+
+	misses := make([]string, 0, len(keys)-len(result))
+	marks := make(map[string]nearCacheMark, len(keys)-len(result))
+	for _, k := range keys {
+		if _, ok := result[k]; !ok {
+			if _, seen := marks[k]; !seen {
+				misses = append(misses, k)
+				marks[k] = n.mark(k)
+			}
+		}
+	}
+	if len(misses) == 0 {
+		return result, nil
+	}
+
+	fetched, err := n.l2.MGet(ctx, misses)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range fetched {
+		result[k] = v
+		n.fill(ctx, k, v, marks[k])
+	}
+	return result, nil
+}
+
+// MSet writes pairs to L2 in one call, then updates L1 key by key.
+func (n *NearCache) MSet(ctx context.Context, pairs map[string][]byte, ttl time.Duration) error {
+	before := make(map[string]nearCacheMark, len(pairs))
+	for k := range pairs {
+		before[k] = nearCacheMark{n.stripe(k).Add(1), n.epoch.Load()}
+	}
+	err := n.l2.MSet(ctx, pairs, ttl)
+	for k, v := range pairs {
+		after := nearCacheMark{n.stripe(k).Add(1), n.epoch.Load()}
+		b := before[k]
+		if err == nil && n.mode == WriteThrough && after == (nearCacheMark{b.stripe + 1, b.epoch}) {
+			_ = n.l1.Set(ctx, k, v, n.localTTL(ttl))
+			if n.mark(k) == after {
+				continue
+			}
+		}
+		_ = n.l1.Delete(ctx, k)
+	}
+	return err
+}
+
+// MDel deletes keys from L2 in one call and drops their local copies.
+func (n *NearCache) MDel(ctx context.Context, keys []string) error {
+	for _, k := range keys {
+		n.stripe(k).Add(1)
+	}
+	err := n.l2.MDel(ctx, keys)
+	for _, k := range keys {
+		n.stripe(k).Add(1)
+	}
+	_ = n.l1.MDel(ctx, keys)
+	return err
+}
+
+func (n *NearCache) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return n.l2.TTL(ctx, key)
+}
+
+func (n *NearCache) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	return n.invalidate(ctx, key, func() error {
+		return n.l2.Expire(ctx, key, ttl)
+	})
+}
+
+func (n *NearCache) Persist(ctx context.Context, key string) error {
+	return n.invalidate(ctx, key, func() error {
+		return n.l2.Persist(ctx, key)
+	})
+}
+
+func (n *NearCache) Keys(ctx context.Context, prefix, pattern string) ([]string, error) {
+	return n.l2.Keys(ctx, prefix, pattern)
+}
+
+func (n *NearCache) Scan(ctx context.Context, prefix, pattern string, cursor uint64, count int) ([]string, uint64, error) {
+	return n.l2.Scan(ctx, prefix, pattern, cursor, count)
+}
+
+// Clear clears the namespace in L2 and drops it from L1.
+func (n *NearCache) Clear(ctx context.Context, prefix string) error {
+	n.epoch.Add(1)
+	err := n.l2.Clear(ctx, prefix)
+	n.epoch.Add(1)
+	_ = n.l1.Clear(ctx, prefix)
+	return err
+}
+
+func (n *NearCache) Incr(ctx context.Context, key string, delta int64) (int64, error) {
+	var v int64
+	err := n.invalidate(ctx, key, func() (err error) {
+		v, err = n.l2.Incr(ctx, key, delta)
+		return err
+	})
+	return v, err
+}
+
+func (n *NearCache) Decr(ctx context.Context, key string, delta int64) (int64, error) {
+	var v int64
+	err := n.invalidate(ctx, key, func() (err error) {
+		v, err = n.l2.Decr(ctx, key, delta)
+		return err
+	})
+	return v, err
+}
+
+func (n *NearCache) GetSet(ctx context.Context, key string, value []byte) ([]byte, error) {
+	var old []byte
+	err := n.invalidate(ctx, key, func() (err error) {
+		old, err = n.l2.GetSet(ctx, key, value)
+		return err
+	})
+	return old, err
+}
+
+func (n *NearCache) CompareAndSwap(ctx context.Context, key string, oldValue, newValue []byte, ttl time.Duration) (bool, error) {
+	var swapped bool
+	err := n.invalidate(ctx, key, func() (err error) {
+		swapped, err = n.l2.CompareAndSwap(ctx, key, oldValue, newValue, ttl)
+		return err
+	})
+	return swapped, err
+}
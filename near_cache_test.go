@@ -0,0 +1,277 @@
+package namestore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingDriver records the keys read from the wrapped Memory.
+type countingDriver struct {
+	*Memory
+	gets  atomic.Int64
+	ttls  atomic.Int64
+	mgets [][]string
+	mu    sync.Mutex
+}
+
+func newCountingDriver() *countingDriver {
+	return &countingDriver{Memory: NewMemory().(*Memory)}
+}
+
+func (d *countingDriver) Get(ctx context.Context, key string) ([]byte, error) {
+	d.gets.Add(1)
+	return d.Memory.Get(ctx, key)
+}
+
+func (d *countingDriver) MGet(ctx context.Context, keys []string) (map[string][]byte, error) {
+	d.mu.Lock()
+	d.mgets = append(d.mgets, append([]string(nil), keys...))
+	d.mu.Unlock()
+	return d.Memory.MGet(ctx, keys)
+}
+
+func (d *countingDriver) TTL(ctx context.Context, key string) (time.Duration, error) {
+	d.ttls.Add(1)
+	return d.Memory.TTL(ctx, key)
+}
+
+func TestNearCache_ReadsFromL1(t *testing.T) {
+	l2 := newCountingDriver()
+	n := NewNearCache(l2)
+	defer n.Close()
+	ctx := context.Background()
+
+	_ = l2.Memory.Set(ctx, "k", []byte("v"), 0)
+	for i := 0; i < 5; i++ {
+		if got, err := n.Get(ctx, "k"); err != nil || string(got) != "v" {
+			t.Fatalf("Get = %q, %v", got, err)
+		}
+	}
+	if g := l2.gets.Load(); g != 1 {
+		t.Errorf("L2 Get called %d times, want 1", g)
+	}
+
+	if _, err := n.Get(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestNearCache_MGetFetchesOnlyMisses(t *testing.T) {
+	l2 := newCountingDriver()
+	n := NewNearCache(l2)
+	defer n.Close()
+	ctx := context.Background()
+
+	_ = l2.Memory.MSet(ctx, map[string][]byte{"a": []byte("1"), "b": []byte("2"), "c": []byte("3")}, 0)
+	_, _ = n.Get(ctx, "a")
+
+	got, err := n.MGet(ctx, []string{"a", "b", "c", "missing", "b"})
+	if err != nil || len(got) != 3 || string(got["c"]) != "3" {
+		t.Fatalf("MGet = %v, %v", got, err)
+	}
+	if len(l2.mgets) != 1 {
+		t.Fatalf("L2 MGet called %d times, want 1", len(l2.mgets))
+	}
+	fetched := l2.mgets[0]
+	sort.Strings(fetched)
+	if fmt.Sprint(fetched) != "[b c missing]" {
+		t.Errorf("L2 MGet fetched %v, want [b c missing]", fetched)
+	}
+
+	got, _ = n.MGet(ctx, []string{"a", "b", "c"})
+	if len(got) != 3 || len(l2.mgets) != 1 {
+		t.Errorf("second MGet should be served from L1, L2 called %d times", len(l2.mgets))
+	}
+}
+
+func TestNearCache_WriteModes(t *testing.T) {
+	ctx := context.Background()
+
+	l2 := newCountingDriver()
+	through := NewNearCache(l2, WithNearCacheMode(WriteThrough))
+	defer through.Close()
+	_ = through.Set(ctx, "k", []byte("v"), 0)
+	_ = through.MSet(ctx, map[string][]byte{"m": []byte("1")}, 0)
+	_, _ = through.Get(ctx, "k")
+	_, _ = through.MGet(ctx, []string{"m"})
+	if l2.gets.Load() != 0 || len(l2.mgets) != 0 {
+		t.Error("write-through should serve its own writes from L1")
+	}
+
+	l2 = newCountingDriver()
+	around := NewNearCache(l2, WithNearCacheMode(WriteAround))
+	defer around.Close()
+	_ = around.Set(ctx, "k", []byte("v"), 0)
+	if got, _ := around.Get(ctx, "k"); string(got) != "v" || l2.gets.Load() != 1 {
+		t.Errorf("write-around should read through once, got %q after %d L2 reads", got, l2.gets.Load())
+	}
+	_ = around.Set(ctx, "k", []byte("v2"), 0)
+	if got, _ := around.Get(ctx, "k"); string(got) != "v2" {
+		t.Errorf("write-around should drop the local copy, got %q", got)
+	}
+}
+
+func TestNearCache_TTLCap(t *testing.T) {
+	l2 := newCountingDriver()
+	n := NewNearCache(l2, WithNearCacheTTL(20*time.Millisecond))
+	defer n.Close()
+	ctx := context.Background()
+
+	_ = n.Set(ctx, "k", []byte("v"), time.Hour)
+	_ = l2.Memory.Set(ctx, "k", []byte("changed elsewhere"), 0)
+	if got, _ := n.Get(ctx, "k"); string(got) != "v" {
+		t.Errorf("Get = %q, want the local copy", got)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if got, _ := n.Get(ctx, "k"); string(got) != "changed elsewhere" {
+		t.Errorf("Get after TTL cap = %q", got)
+	}
+
+	_ = n.Set(ctx, "short", []byte("v"), 5*time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	if _, err := n.Get(ctx, "short"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("shorter TTLs should be kept locally, got %v", err)
+	}
+}
+
+func TestNearCache_FillUsesTTLCap(t *testing.T) {
+	l2 := newCountingDriver()
+	n := NewNearCache(l2, WithNearCacheTTL(time.Minute))
+	defer n.Close()
+	ctx := context.Background()
+
+	// Written by another client, so the local copies come from reads.
+	_ = l2.Memory.Set(ctx, "short", []byte("v"), time.Second)
+	_ = l2.Memory.Set(ctx, "long", []byte("v"), 0)
+	_, _ = n.Get(ctx, "short")
+	_, _ = n.MGet(ctx, []string{"long"})
+
+	if got := l2.ttls.Load(); got != 0 {
+		t.Errorf("reads asked L2 for %d TTLs, want none", got)
+	}
+	for _, key := range []string{"short", "long"} {
+		if ttl, err := n.l1.TTL(ctx, key); err != nil || ttl <= time.Second || ttl > time.Minute {
+			t.Errorf("local TTL of %s = %v, %v, want the TTL cap", key, ttl, err)
+		}
+	}
+}
+
+func TestNearCache_Invalidation(t *testing.T) {
+	ctx := context.Background()
+	const key = "ns:k"
+	tests := []struct {
+		name   string
+		change func(n *NearCache)
+		want   string // empty means not found
+	}{
+		{"Delete", func(n *NearCache) { _ = n.Delete(ctx, key) }, ""},
+		{"MDel", func(n *NearCache) { _ = n.MDel(ctx, []string{key, "other"}) }, ""},
+		{"Clear", func(n *NearCache) { _ = n.Clear(ctx, "ns") }, ""},
+		{"GetSet", func(n *NearCache) { _, _ = n.GetSet(ctx, key, []byte("new")) }, "new"},
+		{"CompareAndSwap", func(n *NearCache) { _, _ = n.CompareAndSwap(ctx, key, []byte("v"), []byte("cas"), 0) }, "cas"},
+		{"Expire", func(n *NearCache) { _ = n.Expire(ctx, key, time.Nanosecond); time.Sleep(time.Millisecond) }, ""},
+		{"SetNX", func(n *NearCache) { _ = n.Delete(ctx, key); _, _ = n.SetNX(ctx, key, []byte("nx"), 0) }, "nx"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := NewNearCache(NewMemory())
+			defer n.Close()
+
+			_ = n.Set(ctx, key, []byte("v"), 0)
+			_, _ = n.Get(ctx, key)
+			tt.change(n)
+
+			got, err := n.Get(ctx, key)
+			if tt.want == "" {
+				if !errors.Is(err, ErrNotFound) {
+					t.Errorf("Get = %q, %v, want ErrNotFound", got, err)
+				}
+			} else if string(got) != tt.want {
+				t.Errorf("Get = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNearCache_AtomicOpsUseL2(t *testing.T) {
+	l2 := newCountingDriver()
+	n := NewNearCache(l2)
+	defer n.Close()
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if _, err := n.Incr(ctx, "counter", 1); err != nil {
+			t.Fatal(err)
+		}
+		// Another client of the same backend.
+		_, _ = l2.Incr(ctx, "counter", 10)
+	}
+	if v, _ := n.Decr(ctx, "counter", 3); v != 30 {
+		t.Errorf("counter = %d, want 30", v)
+	}
+	if got, _ := n.Get(ctx, "counter"); len(got) != 8 {
+		t.Errorf("counter value not read from L2: %q", got)
+	}
+
+	if ok, _ := n.SetNX(ctx, "counter", []byte("x"), 0); ok {
+		t.Error("SetNX should consult L2")
+	}
+}
+
+func TestNearCache_ConcurrentReadsAndWrites(t *testing.T) {
+	l2 := NewMemory()
+	n := NewNearCache(l2)
+	defer n.Close()
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				key := fmt.Sprintf("k%d", i%4)
+				switch (g + i) % 4 {
+				case 0:
+					_ = n.Set(ctx, key, []byte(fmt.Sprint(g, i)), 0)
+				case 1:
+					_ = n.Delete(ctx, key)
+				default:
+					_, _ = n.Get(ctx, key)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	// Whatever interleaving happened, L1 must agree with L2.
+	for i := 0; i < 4; i++ {
+		key := fmt.Sprintf("k%d", i)
+		want, wantErr := l2.Get(ctx, key)
+		got, err := n.Get(ctx, key)
+		if string(got) != string(want) || errors.Is(err, ErrNotFound) != errors.Is(wantErr, ErrNotFound) {
+			t.Errorf("%s: near cache has %q (%v), backend %q (%v)", key, got, err, want, wantErr)
+		}
+	}
+}
+
+func TestNearCache_WithClient(t *testing.T) {
+	c := New[string]("app", "users", WithDriver[string](NewNearCache(NewMemory())))
+	ctx := context.Background()
+
+	_ = c.Set(ctx, "1", []byte("a"), 0)
+	keys, _ := c.Keys(ctx, "*")
+	if len(keys) != 1 || keys[0] != "1" {
+		t.Errorf("Keys = %v", keys)
+	}
+	if ttl, _ := c.TTL(ctx, "1"); ttl != -1 {
+		t.Errorf("TTL = %v, want -1 from the backend", ttl)
+	}
+}
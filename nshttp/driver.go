@@ -0,0 +1,467 @@
+package nshttp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.byted.org/khicago/namestore"
+)
+
+// DriverOption customizes the HTTP Driver.
+type DriverOption func(*driverConfig)
+
+type driverConfig struct {
+	client *http.Client
+	header http.Header
+}
+
+// WithHTTPClient sets the client used for requests. Defaults to
+// http.DefaultClient.
+func WithHTTPClient(client *http.Client) DriverOption {
+	return func(c *driverConfig) {
+		if client != nil {
+			c.client = client
+		}
+	}
+}
+
+// WithHeader adds a header to every request, such as credentials for a
+// proxy in front of the Handler.
+func WithHeader(key, value string) DriverOption {
+	return func(c *driverConfig) {
+		c.header.Add(key, value)
+	}
+}
+
+// Driver implements namestore.Driver against a Handler. Keys must have the
+// "ns:domain:key" form used by namestore.Client, and Keys, Scan and Clear
+// prefixes the "ns:domain" form.
+//
+// Batch operations send one request per namespace, so MSet and MDel are
+// atomic per namespace only. Scan cursors are those of the driver behind
+// the Handler.
+type Driver struct {
+	base   string
+	client *http.Client
+	header http.Header
+}
+
+// NewDriver creates a Driver for the Handler served at baseURL, which may
+// include a path prefix.
+func NewDriver(baseURL string, opts ...DriverOption) (*Driver, error) {
+	cfg := driverConfig{client: http.DefaultClient, header: make(http.Header)}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("nshttp: base URL %q must be an absolute http or https URL", baseURL)
+	}
+	return &Driver{base: strings.TrimSuffix(baseURL, "/"), client: cfg.client, header: cfg.header}, nil
+}
+
+// splitKey splits a key into its namespace prefix and the rest.
+func splitKey(key string) (prefix, rest string, err error) {
+	i := strings.IndexByte(key, ':')
+	if i > 0 {
+		if j := strings.IndexByte(key[i+1:], ':'); j > 0 {
+			return key[:i+1+j], key[i+j+2:], nil
+		}
+	}
+	return "", "", fmt.Errorf("%w: key %q is not of the form ns:domain:key", namestore.ErrUnsupported, key)
+}
+
+// namespaceURL returns the URL of the namespace prefix.
+func (d *Driver) namespaceURL(prefix string) (string, error) {
+	ns, domain, ok := strings.Cut(prefix, ":")
+	if !ok || ns == "" || domain == "" {
+		return "", fmt.Errorf("%w: prefix %q is not of the form ns:domain", namestore.ErrUnsupported, prefix)
+	}
+	return d.base + "/" + url.PathEscape(ns) + "/" + url.PathEscape(domain), nil
+}
+
+func (d *Driver) keyURL(key string) (string, error) {
+	prefix, rest, err := splitKey(key)
+	if err != nil {
+		return "", err
+	}
+	u, err := d.namespaceURL(prefix)
+	if err != nil {
+		return "", err
+	}
+	return u + "/" + url.PathEscape(rest), nil
+}
+
+// remoteError is an error reported by the Handler. It wraps the matching
+// namestore sentinel, if any.
+type remoteError struct {
+	msg string
+	err error
+}
+
+func (e *remoteError) Error() string { return e.msg }
+func (e *remoteError) Unwrap() error { return e.err }
+
+// do sends a request and returns the response if its status is one of ok.
+// Error responses are decoded into errors.
+func (d *Driver) do(ctx context.Context, method, u string, body io.Reader, header http.Header, ok ...int) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range d.header {
+		req.Header[k] = v
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, status := range ok {
+		if resp.StatusCode == status {
+			return resp, nil
+		}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound && method == http.MethodHead {
+		return nil, namestore.ErrNotFound
+	}
+	var e errorResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&e); err != nil || e.Code == "" {
+		return nil, fmt.Errorf("nshttp: %s %s: %s", method, u, resp.Status)
+	}
+	sentinel := codeError(e.Code)
+	if sentinel == namestore.ErrNotFound {
+		return nil, namestore.ErrNotFound
+	}
+	return nil, &remoteError{msg: e.Error, err: sentinel}
+}
+
+// call posts a JSON operation and decodes the JSON reply into out, if set.
+func (d *Driver) call(ctx context.Context, prefix, op string, in, out any) error {
+	u, err := d.namespaceURL(prefix)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	header := http.Header{"Content-Type": {"application/json"}}
+	resp, err := d.do(ctx, http.MethodPost, u+"/"+op, bytes.NewReader(body), header, http.StatusOK, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("nshttp: decoding %s reply: %w", op, err)
+	}
+	return nil
+}
+
+// callKey is call for an operation on a single key.
+func (d *Driver) callKey(ctx context.Context, key, op string, in func(rest string) any, out any) error {
+	prefix, rest, err := splitKey(key)
+	if err != nil {
+		return err
+	}
+	return d.call(ctx, prefix, op, in(rest), out)
+}
+
+func ttlHeader(ttl time.Duration) http.Header {
+	if ttl <= 0 {
+		return nil
+	}
+	return http.Header{TTLHeader: {strconv.FormatInt(millis(ttl), 10)}}
+}
+
+func (d *Driver) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	u, err := d.keyURL(key)
+	if err != nil {
+		return err
+	}
+	resp, err := d.do(ctx, http.MethodPut, u, bytes.NewReader(value), ttlHeader(ttl), http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (d *Driver) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	u, err := d.keyURL(key)
+	if err != nil {
+		return false, err
+	}
+	header := ttlHeader(ttl)
+	if header == nil {
+		header = make(http.Header)
+	}
+	header.Set("If-None-Match", "*")
+	resp, err := d.do(ctx, http.MethodPut, u, bytes.NewReader(value), header, http.StatusCreated, http.StatusPreconditionFailed)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusCreated, nil
+}
+
+func (d *Driver) Get(ctx context.Context, key string) ([]byte, error) {
+	u, err := d.keyURL(key)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.do(ctx, http.MethodGet, u, nil, nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	value, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func (d *Driver) Delete(ctx context.Context, key string) error {
+	u, err := d.keyURL(key)
+	if err != nil {
+		return err
+	}
+	resp, err := d.do(ctx, http.MethodDelete, u, nil, nil, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (d *Driver) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := d.TTL(ctx, key)
+	if errors.Is(err, namestore.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// groupKeys splits keys by namespace prefix, keeping the rest of each key.
+func groupKeys(keys []string) (map[string][]string, error) {
+	groups := make(map[string][]string)
+	for _, k := range keys {
+		prefix, rest, err := splitKey(k)
+		if err != nil {
+			return nil, err
+		}
+		groups[prefix] = append(groups[prefix], rest)
+	}
+	return groups, nil
+}
+
+// MGet fetches the keys of each namespace with one request.
+func (d *Driver) MGet(ctx context.Context, keys []string) (map[string][]byte, error) {
+	groups, err := groupKeys(keys)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string][]byte, len(keys))
+	for prefix, rest := range groups {
+		var resp mgetResponse
+		if err := d.call(ctx, prefix, "mget", mgetRequest{Keys: rest}, &resp); err != nil {
+			return nil, err
+		}
+		for k, v := range resp.Values {
+			result[prefix+":"+k] = v
+		}
+	}
+	return result, nil
+}
+
+// MSet writes the pairs of each namespace with one request.
+func (d *Driver) MSet(ctx context.Context, pairs map[string][]byte, ttl time.Duration) error {
+	groups := make(map[string]map[string][]byte)
+	for k, v := range pairs {
+		prefix, rest, err := splitKey(k)
+		if err != nil {
+			return err
+		}
+		if groups[prefix] == nil {
+			groups[prefix] = make(map[string][]byte)
+		}
+		groups[prefix][rest] = v
+	}
+	for prefix, values := range groups {
+		if err := d.call(ctx, prefix, "mset", msetRequest{Values: values, TTL: millis(ttl)}, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MDel deletes the keys of each namespace with one request.
+func (d *Driver) MDel(ctx context.Context, keys []string) error {
+	groups, err := groupKeys(keys)
+	if err != nil {
+		return err
+	}
+	for prefix, rest := range groups {
+		if err := d.call(ctx, prefix, "mdel", mdelRequest{Keys: rest}, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TTL reads the TTL header of a HEAD request.
+func (d *Driver) TTL(ctx context.Context, key string) (time.Duration, error) {
+	u, err := d.keyURL(key)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := d.do(ctx, http.MethodHead, u, nil, nil, http.StatusOK)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	ms, err := strconv.ParseInt(resp.Header.Get(TTLHeader), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("nshttp: invalid %s header %q", TTLHeader, resp.Header.Get(TTLHeader))
+	}
+	if ms < 0 {
+		return -1, nil
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+func (d *Driver) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	return d.callKey(ctx, key, "expire", func(rest string) any {
+		return expireRequest{Key: rest, TTL: millis(ttl)}
+	}, nil)
+}
+
+func (d *Driver) Persist(ctx context.Context, key string) error {
+	return d.callKey(ctx, key, "persist", func(rest string) any {
+		return expireRequest{Key: rest}
+	}, nil)
+}
+
+// list requests the keys of a namespace with the given query.
+func (d *Driver) list(ctx context.Context, prefix string, query url.Values) (keysResponse, error) {
+	u, err := d.namespaceURL(prefix)
+	if err != nil {
+		return keysResponse{}, err
+	}
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	resp, err := d.do(ctx, http.MethodGet, u, nil, nil, http.StatusOK)
+	if err != nil {
+		return keysResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	var keys keysResponse
+	if err := json.NewDecoder(resp.Body).Decode(&keys); err != nil {
+		return keysResponse{}, fmt.Errorf("nshttp: decoding key list: %w", err)
+	}
+	for i, k := range keys.Keys {
+		keys.Keys[i] = prefix + ":" + k
+	}
+	return keys, nil
+}
+
+func (d *Driver) Keys(ctx context.Context, prefix, pattern string) ([]string, error) {
+	query := url.Values{}
+	if pattern != "" {
+		query.Set("pattern", pattern)
+	}
+	resp, err := d.list(ctx, prefix, query)
+	return resp.Keys, err
+}
+
+func (d *Driver) Scan(ctx context.Context, prefix, pattern string, cursor uint64, count int) ([]string, uint64, error) {
+	query := url.Values{"cursor": {strconv.FormatUint(cursor, 10)}}
+	if pattern != "" {
+		query.Set("pattern", pattern)
+	}
+	if count > 0 {
+		query.Set("count", strconv.Itoa(count))
+	}
+	resp, err := d.list(ctx, prefix, query)
+	if err != nil {
+		return nil, 0, err
+	}
+	next, err := strconv.ParseUint(resp.Cursor, 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("nshttp: invalid cursor %q", resp.Cursor)
+	}
+	return resp.Keys, next, nil
+}
+
+func (d *Driver) Clear(ctx context.Context, prefix string) error {
+	u, err := d.namespaceURL(prefix)
+	if err != nil {
+		return err
+	}
+	resp, err := d.do(ctx, http.MethodDelete, u, nil, nil, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (d *Driver) Incr(ctx context.Context, key string, delta int64) (int64, error) {
+	var resp incrResponse
+	err := d.callKey(ctx, key, "incr", func(rest string) any {
+		return incrRequest{Key: rest, Delta: delta}
+	}, &resp)
+	return resp.Value, err
+}
+
+func (d *Driver) Decr(ctx context.Context, key string, delta int64) (int64, error) {
+	var resp incrResponse
+	err := d.callKey(ctx, key, "decr", func(rest string) any {
+		return incrRequest{Key: rest, Delta: delta}
+	}, &resp)
+	return resp.Value, err
+}
+
+// GetSet returns ErrNotFound, having set the value, if the key did not
+// exist.
+func (d *Driver) GetSet(ctx context.Context, key string, value []byte) ([]byte, error) {
+	var resp getSetResponse
+	err := d.callKey(ctx, key, "getset", func(rest string) any {
+		return getSetRequest{Key: rest, Value: value}
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Value == nil {
+		return nil, namestore.ErrNotFound
+	}
+	return resp.Value, nil
+}
+
+func (d *Driver) CompareAndSwap(ctx context.Context, key string, oldValue, newValue []byte, ttl time.Duration) (bool, error) {
+	var resp casResponse
+	err := d.callKey(ctx, key, "cas", func(rest string) any {
+		return casRequest{Key: rest, Old: oldValue, New: newValue, TTL: millis(ttl)}
+	}, &resp)
+	return resp.Swapped, err
+}
@@ -0,0 +1,286 @@
+package nshttp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"code.byted.org/khicago/namestore"
+)
+
+// newTestDriver returns a Driver talking to a Handler over a Memory.
+func newTestDriver(t *testing.T, opts ...Option) (*Driver, namestore.Driver) {
+	t.Helper()
+	backend := namestore.NewMemory()
+	srv := httptest.NewServer(NewHandler(backend, opts...))
+	t.Cleanup(srv.Close)
+
+	d, err := NewDriver(srv.URL, WithHTTPClient(srv.Client()))
+	if err != nil {
+		t.Fatalf("NewDriver: %v", err)
+	}
+	return d, backend
+}
+
+func TestDriver_Basic(t *testing.T) {
+	d, backend := newTestDriver(t)
+	ctx := context.Background()
+
+	if err := d.Set(ctx, "app:users:1", []byte("alice"), 0); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if v, err := backend.Get(ctx, "app:users:1"); err != nil || string(v) != "alice" {
+		t.Fatalf("backend Get = %q, %v", v, err)
+	}
+	if v, err := d.Get(ctx, "app:users:1"); err != nil || string(v) != "alice" {
+		t.Fatalf("Get = %q, %v", v, err)
+	}
+	if _, err := d.Get(ctx, "app:users:2"); !errors.Is(err, namestore.ErrNotFound) {
+		t.Errorf("Get missing = %v, want ErrNotFound", err)
+	}
+
+	if ok, err := d.SetNX(ctx, "app:users:1", []byte("bob"), 0); err != nil || ok {
+		t.Errorf("SetNX existing = %v, %v", ok, err)
+	}
+	if ok, err := d.SetNX(ctx, "app:users:2", []byte("bob"), time.Minute); err != nil || !ok {
+		t.Errorf("SetNX new = %v, %v", ok, err)
+	}
+
+	if ok, err := d.Exists(ctx, "app:users:2"); err != nil || !ok {
+		t.Errorf("Exists = %v, %v", ok, err)
+	}
+	if err := d.Delete(ctx, "app:users:2"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if ok, err := d.Exists(ctx, "app:users:2"); err != nil || ok {
+		t.Errorf("Exists after Delete = %v, %v", ok, err)
+	}
+
+	// Empty values and keys that need escaping survive the round trip.
+	for _, key := range []string{"app:users:", "app:users:a/b c?d#e%f", "app:users:x:y"} {
+		if err := d.Set(ctx, key, []byte{}, 0); err != nil {
+			t.Fatalf("Set %q: %v", key, err)
+		}
+		if v, err := d.Get(ctx, key); err != nil || len(v) != 0 {
+			t.Errorf("Get %q = %q, %v", key, v, err)
+		}
+		if ok, _ := backend.Exists(ctx, key); !ok {
+			t.Errorf("backend is missing %q", key)
+		}
+	}
+
+	if err := d.Set(ctx, "nonamespace", []byte("v"), 0); !errors.Is(err, namestore.ErrUnsupported) {
+		t.Errorf("Set without namespace = %v, want ErrUnsupported", err)
+	}
+}
+
+func TestDriver_TTL(t *testing.T) {
+	d, _ := newTestDriver(t)
+	ctx := context.Background()
+
+	_ = d.Set(ctx, "app:s:1", []byte("v"), time.Minute)
+	if ttl, err := d.TTL(ctx, "app:s:1"); err != nil || ttl <= 59*time.Second || ttl > time.Minute {
+		t.Errorf("TTL = %v, %v", ttl, err)
+	}
+	if err := d.Persist(ctx, "app:s:1"); err != nil {
+		t.Fatalf("Persist: %v", err)
+	}
+	if ttl, err := d.TTL(ctx, "app:s:1"); err != nil || ttl != -1 {
+		t.Errorf("TTL after Persist = %v, %v", ttl, err)
+	}
+	if err := d.Expire(ctx, "app:s:1", 30*time.Second); err != nil {
+		t.Fatalf("Expire: %v", err)
+	}
+	if ttl, _ := d.TTL(ctx, "app:s:1"); ttl <= 29*time.Second || ttl > 30*time.Second {
+		t.Errorf("TTL after Expire = %v", ttl)
+	}
+
+	if _, err := d.TTL(ctx, "app:s:missing"); !errors.Is(err, namestore.ErrNotFound) {
+		t.Errorf("TTL missing = %v", err)
+	}
+	if err := d.Expire(ctx, "app:s:missing", time.Second); !errors.Is(err, namestore.ErrNotFound) {
+		t.Errorf("Expire missing = %v", err)
+	}
+	if err := d.Persist(ctx, "app:s:missing"); !errors.Is(err, namestore.ErrNotFound) {
+		t.Errorf("Persist missing = %v", err)
+	}
+
+	_ = d.Set(ctx, "app:s:short", []byte("v"), 20*time.Millisecond)
+	time.Sleep(40 * time.Millisecond)
+	if _, err := d.Get(ctx, "app:s:short"); !errors.Is(err, namestore.ErrNotFound) {
+		t.Errorf("expired Get = %v", err)
+	}
+}
+
+func TestDriver_Batch(t *testing.T) {
+	d, _ := newTestDriver(t)
+	ctx := context.Background()
+
+	pairs := map[string][]byte{
+		"app:a:1": []byte("1"),
+		"app:a:2": []byte("2"),
+		"app:b:1": []byte("3"),
+	}
+	if err := d.MSet(ctx, pairs, time.Minute); err != nil {
+		t.Fatalf("MSet: %v", err)
+	}
+	got, err := d.MGet(ctx, []string{"app:a:1", "app:a:2", "app:b:1", "app:a:missing"})
+	if err != nil || len(got) != 3 || string(got["app:b:1"]) != "3" {
+		t.Fatalf("MGet = %v, %v", got, err)
+	}
+	if ttl, _ := d.TTL(ctx, "app:b:1"); ttl <= 0 {
+		t.Errorf("MSet TTL = %v", ttl)
+	}
+
+	if err := d.MDel(ctx, []string{"app:a:1", "app:b:1"}); err != nil {
+		t.Fatalf("MDel: %v", err)
+	}
+	got, _ = d.MGet(ctx, []string{"app:a:1", "app:a:2", "app:b:1"})
+	if len(got) != 1 || string(got["app:a:2"]) != "2" {
+		t.Errorf("MGet after MDel = %v", got)
+	}
+}
+
+func TestDriver_KeysScanClear(t *testing.T) {
+	d, _ := newTestDriver(t)
+	ctx := context.Background()
+
+	for _, k := range []string{"a", "b", "c", "user:1", "user:2"} {
+		_ = d.Set(ctx, "app:ns:"+k, []byte("v"), 0)
+	}
+	_ = d.Set(ctx, "app:other:a", []byte("v"), 0)
+
+	keys, err := d.Keys(ctx, "app:ns", "user:*")
+	sort.Strings(keys)
+	if err != nil || strings.Join(keys, ",") != "app:ns:user:1,app:ns:user:2" {
+		t.Errorf("Keys = %v, %v", keys, err)
+	}
+	if keys, _ := d.Keys(ctx, "app:ns", ""); len(keys) != 5 {
+		t.Errorf("Keys all = %v", keys)
+	}
+	if _, err := d.Keys(ctx, "app:ns", "["); !errors.Is(err, namestore.ErrInvalidPattern) {
+		t.Errorf("Keys bad pattern = %v, want ErrInvalidPattern", err)
+	}
+
+	seen := map[string]bool{}
+	var cursor uint64
+	for {
+		page, next, err := d.Scan(ctx, "app:ns", "*", cursor, 2)
+		if err != nil {
+			t.Fatalf("Scan: %v", err)
+		}
+		for _, k := range page {
+			seen[k] = true
+		}
+		if cursor = next; cursor == 0 {
+			break
+		}
+	}
+	if len(seen) != 5 || !seen["app:ns:a"] {
+		t.Errorf("Scan saw %v", seen)
+	}
+	if _, _, err := d.Scan(ctx, "app:ns", "*", 12345, 2); !errors.Is(err, namestore.ErrInvalidCursor) {
+		t.Errorf("Scan bad cursor = %v, want ErrInvalidCursor", err)
+	}
+
+	if err := d.Clear(ctx, "app:ns"); err != nil {
+		t.Fatalf("Clear: %v", err)
+	}
+	if keys, _ := d.Keys(ctx, "app:ns", ""); len(keys) != 0 {
+		t.Errorf("Keys after Clear = %v", keys)
+	}
+	if ok, _ := d.Exists(ctx, "app:other:a"); !ok {
+		t.Error("Clear should not touch other namespaces")
+	}
+}
+
+func TestDriver_Atomic(t *testing.T) {
+	d, _ := newTestDriver(t)
+	ctx := context.Background()
+
+	if n, err := d.Incr(ctx, "app:c:n", 5); err != nil || n != 5 {
+		t.Errorf("Incr = %d, %v", n, err)
+	}
+	if n, err := d.Decr(ctx, "app:c:n", 2); err != nil || n != 3 {
+		t.Errorf("Decr = %d, %v", n, err)
+	}
+	_ = d.Set(ctx, "app:c:s", []byte("text"), 0)
+	if _, err := d.Incr(ctx, "app:c:s", 1); !errors.Is(err, namestore.ErrTypeMismatch) {
+		t.Errorf("Incr on text = %v, want ErrTypeMismatch", err)
+	}
+
+	if old, err := d.GetSet(ctx, "app:c:g", []byte("a")); !errors.Is(err, namestore.ErrNotFound) || old != nil {
+		t.Errorf("GetSet new = %q, %v", old, err)
+	}
+	if old, err := d.GetSet(ctx, "app:c:g", []byte("b")); err != nil || string(old) != "a" {
+		t.Errorf("GetSet = %q, %v", old, err)
+	}
+	_ = d.Set(ctx, "app:c:e", []byte{}, 0)
+	if old, err := d.GetSet(ctx, "app:c:e", []byte("x")); err != nil || old == nil || len(old) != 0 {
+		t.Errorf("GetSet empty = %q, %v", old, err)
+	}
+
+	if ok, err := d.CompareAndSwap(ctx, "app:c:g", []byte("a"), []byte("c"), 0); err != nil || ok {
+		t.Errorf("CAS mismatch = %v, %v", ok, err)
+	}
+	if ok, err := d.CompareAndSwap(ctx, "app:c:g", []byte("b"), []byte("c"), time.Minute); err != nil || !ok {
+		t.Errorf("CAS = %v, %v", ok, err)
+	}
+	if ttl, _ := d.TTL(ctx, "app:c:g"); ttl <= 0 {
+		t.Errorf("CAS TTL = %v", ttl)
+	}
+}
+
+func TestDriver_WithClient(t *testing.T) {
+	d, _ := newTestDriver(t, WithNamespace("app", "users"))
+	ctx := context.Background()
+
+	users := namestore.New[string]("app", "users", namestore.WithDriver[string](d))
+	if err := users.Set(ctx, "1", []byte("alice"), 0); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if v, err := users.Get(ctx, "1"); err != nil || string(v) != "alice" {
+		t.Errorf("Get = %q, %v", v, err)
+	}
+	if keys, err := users.Keys(ctx, "*"); err != nil || len(keys) != 1 || keys[0] != "1" {
+		t.Errorf("Keys = %v, %v", keys, err)
+	}
+
+	// Other namespaces are not served.
+	orders := namestore.New[string]("app", "orders", namestore.WithDriver[string](d))
+	if err := orders.Set(ctx, "1", []byte("x"), 0); err == nil || !strings.Contains(err.Error(), "not served") {
+		t.Errorf("Set outside the namespace = %v", err)
+	}
+}
+
+func TestDriver_PathPrefix(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/kv/", http.StripPrefix("/kv", NewHandler(namestore.NewMemory())))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	d, err := NewDriver(srv.URL+"/kv/", WithHeader("X-Test", "1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := d.Set(ctx, "app:p:k", []byte("v"), 0); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if v, err := d.Get(ctx, "app:p:k"); err != nil || string(v) != "v" {
+		t.Errorf("Get = %q, %v", v, err)
+	}
+}
+
+func TestNewDriver_InvalidURL(t *testing.T) {
+	for _, u := range []string{"", "localhost:8080", "ftp://host", "http://"} {
+		if _, err := NewDriver(u); err == nil {
+			t.Errorf("NewDriver(%q) should fail", u)
+		}
+	}
+}
@@ -0,0 +1,467 @@
+package nshttp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.byted.org/khicago/namestore"
+)
+
+// DefaultMaxBodySize bounds request bodies unless WithMaxBodySize is used.
+const DefaultMaxBodySize = 32 << 20
+
+// Option customizes a Handler.
+type Option func(*config)
+
+type config struct {
+	maxBody    int64
+	namespaces map[string]bool
+	logger     namestore.Logger
+}
+
+// WithMaxBodySize limits request bodies, and so values, to n bytes. Larger
+// requests are rejected with 413. Defaults to DefaultMaxBodySize.
+func WithMaxBodySize(n int64) Option {
+	return func(c *config) {
+		if n > 0 {
+			c.maxBody = n
+		}
+	}
+}
+
+// WithNamespace restricts the Handler to the namespace of a Client created
+// with New(rootNS, domain). It can be given several times to serve several
+// namespaces. Requests for other namespaces are rejected with 403. By
+// default every namespace of the driver is served.
+func WithNamespace(rootNS, domain string) Option {
+	return func(c *config) {
+		if c.namespaces == nil {
+			c.namespaces = make(map[string]bool)
+		}
+		c.namespaces[rootNS+":"+domain] = true
+	}
+}
+
+// WithLogger sets the logger for driver failures reported as 500.
+func WithLogger(logger namestore.Logger) Option {
+	return func(c *config) {
+		c.logger = logger
+	}
+}
+
+// Handler serves a Driver over HTTP. It can be mounted under a path prefix
+// with http.StripPrefix.
+type Handler struct {
+	driver namestore.Driver
+	cfg    config
+	mux    *http.ServeMux
+}
+
+// NewHandler creates a Handler for driver.
+func NewHandler(driver namestore.Driver, opts ...Option) *Handler {
+	cfg := config{maxBody: DefaultMaxBodySize}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	h := &Handler{driver: driver, cfg: cfg, mux: http.NewServeMux()}
+	h.mux.HandleFunc("GET /{ns}/{domain}/{key...}", h.get)
+	h.mux.HandleFunc("HEAD /{ns}/{domain}/{key...}", h.head)
+	h.mux.HandleFunc("PUT /{ns}/{domain}/{key...}", h.put)
+	h.mux.HandleFunc("DELETE /{ns}/{domain}/{key...}", h.delete)
+	h.mux.HandleFunc("GET /{ns}/{domain}", h.list)
+	h.mux.HandleFunc("DELETE /{ns}/{domain}", h.clear)
+	h.mux.HandleFunc("POST /{ns}/{domain}/{op}", h.op)
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// namespace returns the "ns:domain" prefix addressed by r, or replies with
+// 403 if it is not served.
+func (h *Handler) namespace(w http.ResponseWriter, r *http.Request) (string, bool) {
+	prefix := r.PathValue("ns") + ":" + r.PathValue("domain")
+	if h.cfg.namespaces != nil && !h.cfg.namespaces[prefix] {
+		writeJSON(w, http.StatusForbidden, errorResponse{Error: "namespace " + prefix + " is not served", Code: "forbidden"})
+		return "", false
+	}
+	return prefix, true
+}
+
+func (h *Handler) key(w http.ResponseWriter, r *http.Request) (string, bool) {
+	prefix, ok := h.namespace(w, r)
+	return prefix + ":" + r.PathValue("key"), ok
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func badRequest(w http.ResponseWriter, format string, args ...any) {
+	writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf(format, args...), Code: "bad_request"})
+}
+
+// fail replies with the status and code for a driver error.
+func (h *Handler) fail(w http.ResponseWriter, r *http.Request, err error) {
+	code, status := errorCode(err)
+	if status == http.StatusInternalServerError && h.cfg.logger != nil {
+		h.cfg.logger.Error(r.Context(), "nshttp: %s %s: %v", r.Method, r.URL.Path, err)
+	}
+	writeJSON(w, status, errorResponse{Error: err.Error(), Code: code})
+}
+
+// readBody reads the request body up to the configured limit.
+func (h *Handler) readBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, h.cfg.maxBody))
+	if err != nil {
+		h.bodyError(w, err)
+		return nil, false
+	}
+	return body, true
+}
+
+// decode reads a JSON request body into v.
+func (h *Handler) decode(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, h.cfg.maxBody)).Decode(v); err != nil {
+		h.bodyError(w, err)
+		return false
+	}
+	return true
+}
+
+func (h *Handler) bodyError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeJSON(w, http.StatusRequestEntityTooLarge, errorResponse{Error: err.Error(), Code: "too_large"})
+		return
+	}
+	badRequest(w, "invalid request body: %v", err)
+}
+
+// setTTL reports ttl in the TTL header, or -1 if the key does not expire.
+func setTTL(w http.ResponseWriter, ttl time.Duration) {
+	ms := int64(-1)
+	if ttl >= 0 {
+		ms = millis(ttl)
+	}
+	w.Header().Set(TTLHeader, strconv.FormatInt(ms, 10))
+}
+
+// parseTTL parses the TTL header, where a missing header or 0 means no
+// expiration.
+func parseTTL(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	ms, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid TTL %q", s)
+	}
+	return ttlMillis(ms)
+}
+
+// ttlMillis converts a TTL in milliseconds, where 0 means no expiration.
+func ttlMillis(ms int64) (time.Duration, error) {
+	if ms < 0 || ms > math.MaxInt64/int64(time.Millisecond) {
+		return 0, fmt.Errorf("invalid TTL %d", ms)
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+func (h *Handler) get(w http.ResponseWriter, r *http.Request) {
+	key, ok := h.key(w, r)
+	if !ok {
+		return
+	}
+	// The TTL is read first, so a key that expires in between is reported
+	// missing rather than served without its TTL. Other TTL errors only
+	// leave the header out.
+	ttl, ttlErr := h.driver.TTL(r.Context(), key)
+	if errors.Is(ttlErr, namestore.ErrNotFound) {
+		h.fail(w, r, ttlErr)
+		return
+	}
+	value, err := h.driver.Get(r.Context(), key)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	if ttlErr == nil {
+		setTTL(w, ttl)
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(len(value)))
+	_, _ = w.Write(value)
+}
+
+func (h *Handler) head(w http.ResponseWriter, r *http.Request) {
+	key, ok := h.key(w, r)
+	if !ok {
+		return
+	}
+	ttl, err := h.driver.TTL(r.Context(), key)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	setTTL(w, ttl)
+	w.WriteHeader(http.StatusOK)
+}
+
+// put sets the key to the request body. With If-None-Match: * it only
+// creates the key, replying 201 if it did and 412 if the key exists.
+func (h *Handler) put(w http.ResponseWriter, r *http.Request) {
+	key, ok := h.key(w, r)
+	if !ok {
+		return
+	}
+	ttl, err := parseTTL(r.Header.Get(TTLHeader))
+	if err != nil {
+		badRequest(w, "%v", err)
+		return
+	}
+	value, ok := h.readBody(w, r)
+	if !ok {
+		return
+	}
+
+	if r.Header.Get("If-None-Match") == "*" {
+		created, err := h.driver.SetNX(r.Context(), key, value, ttl)
+		switch {
+		case err != nil:
+			h.fail(w, r, err)
+		case created:
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusPreconditionFailed)
+		}
+		return
+	}
+	if err := h.driver.Set(r.Context(), key, value, ttl); err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) delete(w http.ResponseWriter, r *http.Request) {
+	key, ok := h.key(w, r)
+	if !ok {
+		return
+	}
+	if err := h.driver.Delete(r.Context(), key); err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// list returns the keys matching ?pattern, all at once or, if ?cursor is
+// given, one Scan page of about ?count keys.
+func (h *Handler) list(w http.ResponseWriter, r *http.Request) {
+	prefix, ok := h.namespace(w, r)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	pattern := q.Get("pattern")
+
+	var (
+		keys []string
+		resp keysResponse
+		err  error
+	)
+	if q.Has("cursor") {
+		cursor, perr := strconv.ParseUint(q.Get("cursor"), 10, 64)
+		if perr != nil {
+			h.fail(w, r, namestore.ErrInvalidCursor)
+			return
+		}
+		var count int
+		if s := q.Get("count"); s != "" {
+			if count, err = strconv.Atoi(s); err != nil {
+				badRequest(w, "invalid count %q", s)
+				return
+			}
+		}
+		var next uint64
+		keys, next, err = h.driver.Scan(r.Context(), prefix, pattern, cursor, count)
+		resp.Cursor = strconv.FormatUint(next, 10)
+	} else {
+		keys, err = h.driver.Keys(r.Context(), prefix, pattern)
+	}
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+
+	resp.Keys = make([]string, 0, len(keys))
+	for _, k := range keys {
+		resp.Keys = append(resp.Keys, strings.TrimPrefix(k, prefix+":"))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (h *Handler) clear(w http.ResponseWriter, r *http.Request) {
+	prefix, ok := h.namespace(w, r)
+	if !ok {
+		return
+	}
+	if err := h.driver.Clear(r.Context(), prefix); err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// op runs a batch or atomic operation described by a JSON body.
+func (h *Handler) op(w http.ResponseWriter, r *http.Request) {
+	prefix, ok := h.namespace(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	full := func(key string) string { return prefix + ":" + key }
+
+	switch op := r.PathValue("op"); op {
+	case "mget":
+		var req mgetRequest
+		if !h.decode(w, r, &req) {
+			return
+		}
+		keys := make([]string, len(req.Keys))
+		for i, k := range req.Keys {
+			keys[i] = full(k)
+		}
+		found, err := h.driver.MGet(ctx, keys)
+		if err != nil {
+			h.fail(w, r, err)
+			return
+		}
+		resp := mgetResponse{Values: make(map[string][]byte, len(found))}
+		for k, v := range found {
+			if v == nil {
+				v = []byte{}
+			}
+			resp.Values[strings.TrimPrefix(k, prefix+":")] = v
+		}
+		writeJSON(w, http.StatusOK, resp)
+
+	case "mset":
+		var req msetRequest
+		if !h.decode(w, r, &req) {
+			return
+		}
+		ttl, err := ttlMillis(req.TTL)
+		if err != nil {
+			badRequest(w, "%v", err)
+			return
+		}
+		pairs := make(map[string][]byte, len(req.Values))
+		for k, v := range req.Values {
+			pairs[full(k)] = v
+		}
+		h.noContent(w, r, h.driver.MSet(ctx, pairs, ttl))
+
+	case "mdel":
+		var req mdelRequest
+		if !h.decode(w, r, &req) {
+			return
+		}
+		keys := make([]string, len(req.Keys))
+		for i, k := range req.Keys {
+			keys[i] = full(k)
+		}
+		h.noContent(w, r, h.driver.MDel(ctx, keys))
+
+	case "incr", "decr":
+		var req incrRequest
+		if !h.decode(w, r, &req) {
+			return
+		}
+		incr := h.driver.Incr
+		if op == "decr" {
+			incr = h.driver.Decr
+		}
+		n, err := incr(ctx, full(req.Key), req.Delta)
+		if err != nil {
+			h.fail(w, r, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, incrResponse{Value: n})
+
+	case "getset":
+		var req getSetRequest
+		if !h.decode(w, r, &req) {
+			return
+		}
+		old, err := h.driver.GetSet(ctx, full(req.Key), req.Value)
+		if err != nil && !errors.Is(err, namestore.ErrNotFound) {
+			h.fail(w, r, err)
+			return
+		}
+		// A null value means the key did not exist before.
+		if err == nil && old == nil {
+			old = []byte{}
+		}
+		writeJSON(w, http.StatusOK, getSetResponse{Value: old})
+
+	case "cas":
+		var req casRequest
+		if !h.decode(w, r, &req) {
+			return
+		}
+		ttl, err := ttlMillis(req.TTL)
+		if err != nil {
+			badRequest(w, "%v", err)
+			return
+		}
+		swapped, err := h.driver.CompareAndSwap(ctx, full(req.Key), req.Old, req.New, ttl)
+		if err != nil {
+			h.fail(w, r, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, casResponse{Swapped: swapped})
+
+	case "expire":
+		var req expireRequest
+		if !h.decode(w, r, &req) {
+			return
+		}
+		ttl, err := ttlMillis(req.TTL)
+		if err != nil {
+			badRequest(w, "%v", err)
+			return
+		}
+		h.noContent(w, r, h.driver.Expire(ctx, full(req.Key), ttl))
+
+	case "persist":
+		var req expireRequest
+		if !h.decode(w, r, &req) {
+			return
+		}
+		h.noContent(w, r, h.driver.Persist(ctx, full(req.Key)))
+
+	default:
+		badRequest(w, "unknown operation %q", op)
+	}
+}
+
+func (h *Handler) noContent(w http.ResponseWriter, r *http.Request, err error) {
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
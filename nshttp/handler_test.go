@@ -0,0 +1,215 @@
+package nshttp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"code.byted.org/khicago/namestore"
+)
+
+// serve runs one request against h and returns the recorded response.
+func serve(h http.Handler, method, target, body string, header ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func expectStatus(t *testing.T, rec *httptest.ResponseRecorder, want int) {
+	t.Helper()
+	if rec.Code != want {
+		t.Fatalf("status = %d, want %d; body %s", rec.Code, want, rec.Body)
+	}
+}
+
+func expectErrorCode(t *testing.T, rec *httptest.ResponseRecorder, status int, code string) {
+	t.Helper()
+	expectStatus(t, rec, status)
+	var e errorResponse
+	if err := json.NewDecoder(rec.Body).Decode(&e); err != nil || e.Code != code {
+		t.Fatalf("error = %+v, %v; want code %q", e, err, code)
+	}
+}
+
+func TestHandler_KeyEndpoints(t *testing.T) {
+	backend := namestore.NewMemory()
+	h := NewHandler(backend)
+
+	expectStatus(t, serve(h, "PUT", "/app/users/1", "alice", TTLHeader, "60000"), http.StatusNoContent)
+	if v, _ := backend.Get(context.Background(), "app:users:1"); string(v) != "alice" {
+		t.Fatalf("stored %q", v)
+	}
+
+	rec := serve(h, "GET", "/app/users/1", "")
+	expectStatus(t, rec, http.StatusOK)
+	if rec.Body.String() != "alice" || rec.Header().Get("Content-Type") != "application/octet-stream" {
+		t.Errorf("GET = %q (%s)", rec.Body, rec.Header().Get("Content-Type"))
+	}
+	if ttl := rec.Header().Get(TTLHeader); ttl == "" || ttl == "-1" {
+		t.Errorf("TTL header = %q", ttl)
+	}
+
+	rec = serve(h, "HEAD", "/app/users/1", "")
+	expectStatus(t, rec, http.StatusOK)
+	if rec.Body.Len() != 0 || rec.Header().Get(TTLHeader) == "" {
+		t.Errorf("HEAD body %q, TTL %q", rec.Body, rec.Header().Get(TTLHeader))
+	}
+	expectStatus(t, serve(h, "HEAD", "/app/users/2", ""), http.StatusNotFound)
+
+	expectStatus(t, serve(h, "PUT", "/app/users/1", "bob", "If-None-Match", "*"), http.StatusPreconditionFailed)
+	expectStatus(t, serve(h, "PUT", "/app/users/2", "bob", "If-None-Match", "*"), http.StatusCreated)
+	if rec := serve(h, "GET", "/app/users/2", ""); rec.Header().Get(TTLHeader) != "-1" {
+		t.Errorf("TTL header without expiry = %q", rec.Header().Get(TTLHeader))
+	}
+
+	expectStatus(t, serve(h, "DELETE", "/app/users/2", ""), http.StatusNoContent)
+	expectErrorCode(t, serve(h, "GET", "/app/users/2", ""), http.StatusNotFound, "not_found")
+
+	expectErrorCode(t, serve(h, "PUT", "/app/users/3", "v", TTLHeader, "soon"), http.StatusBadRequest, "bad_request")
+	expectErrorCode(t, serve(h, "PUT", "/app/users/3", "v", TTLHeader, "-5"), http.StatusBadRequest, "bad_request")
+	expectStatus(t, serve(h, "PATCH", "/app/users/1", ""), http.StatusMethodNotAllowed)
+}
+
+func TestHandler_ListAndClear(t *testing.T) {
+	backend := namestore.NewMemory()
+	h := NewHandler(backend)
+	ctx := context.Background()
+	for _, k := range []string{"a", "b", "c"} {
+		_ = backend.Set(ctx, "app:l:"+k, []byte("v"), 0)
+	}
+
+	rec := serve(h, "GET", "/app/l?pattern=a", "")
+	expectStatus(t, rec, http.StatusOK)
+	var keys keysResponse
+	if err := json.NewDecoder(rec.Body).Decode(&keys); err != nil || len(keys.Keys) != 1 || keys.Keys[0] != "a" || keys.Cursor != "" {
+		t.Fatalf("list = %+v, %v", keys, err)
+	}
+
+	rec = serve(h, "GET", "/app/l?cursor=0&count=10", "")
+	keys = keysResponse{}
+	if err := json.NewDecoder(rec.Body).Decode(&keys); err != nil || len(keys.Keys) != 3 || keys.Cursor != "0" {
+		t.Fatalf("scan = %+v, %v", keys, err)
+	}
+	expectErrorCode(t, serve(h, "GET", "/app/l?cursor=x", ""), http.StatusBadRequest, "invalid_cursor")
+	expectErrorCode(t, serve(h, "GET", "/app/l?cursor=0&count=x", ""), http.StatusBadRequest, "bad_request")
+
+	expectStatus(t, serve(h, "DELETE", "/app/l", ""), http.StatusNoContent)
+	if keys, _ := backend.Keys(ctx, "app:l", ""); len(keys) != 0 {
+		t.Errorf("keys after clear = %v", keys)
+	}
+}
+
+func TestHandler_Operations(t *testing.T) {
+	backend := namestore.NewMemory()
+	h := NewHandler(backend)
+
+	expectStatus(t, serve(h, "POST", "/app/o/mset", `{"values":{"a":"MQ==","b":"Mg=="},"ttl":60000}`), http.StatusNoContent)
+	rec := serve(h, "POST", "/app/o/mget", `{"keys":["a","b","missing"]}`)
+	expectStatus(t, rec, http.StatusOK)
+	if got := strings.TrimSpace(rec.Body.String()); got != `{"values":{"a":"MQ==","b":"Mg=="}}` {
+		t.Errorf("mget = %s", got)
+	}
+	expectStatus(t, serve(h, "POST", "/app/o/mdel", `{"keys":["a"]}`), http.StatusNoContent)
+	if ok, _ := backend.Exists(context.Background(), "app:o:a"); ok {
+		t.Error("mdel should delete app:o:a")
+	}
+
+	rec = serve(h, "POST", "/app/o/incr", `{"key":"n","delta":7}`)
+	if got := strings.TrimSpace(rec.Body.String()); got != `{"value":7}` {
+		t.Errorf("incr = %s", got)
+	}
+	rec = serve(h, "POST", "/app/o/getset", `{"key":"g","value":"eA=="}`)
+	if got := strings.TrimSpace(rec.Body.String()); got != `{"value":null}` {
+		t.Errorf("getset new = %s", got)
+	}
+	rec = serve(h, "POST", "/app/o/cas", `{"key":"g","old":"eA==","new":"eQ=="}`)
+	if got := strings.TrimSpace(rec.Body.String()); got != `{"swapped":true}` {
+		t.Errorf("cas = %s", got)
+	}
+	expectStatus(t, serve(h, "POST", "/app/o/expire", `{"key":"g","ttl":1000}`), http.StatusNoContent)
+	expectStatus(t, serve(h, "POST", "/app/o/persist", `{"key":"g"}`), http.StatusNoContent)
+	expectErrorCode(t, serve(h, "POST", "/app/o/persist", `{"key":"missing"}`), http.StatusNotFound, "not_found")
+
+	expectErrorCode(t, serve(h, "POST", "/app/o/incr", `{"key":"b","delta":1}`), http.StatusConflict, "type_mismatch")
+	expectErrorCode(t, serve(h, "POST", "/app/o/mset", `{"values":{},"ttl":-1}`), http.StatusBadRequest, "bad_request")
+	expectErrorCode(t, serve(h, "POST", "/app/o/incr", `{"key":`), http.StatusBadRequest, "bad_request")
+	expectErrorCode(t, serve(h, "POST", "/app/o/frobnicate", `{}`), http.StatusBadRequest, "bad_request")
+}
+
+func TestHandler_Limits(t *testing.T) {
+	backend := namestore.NewMemory()
+	h := NewHandler(backend, WithMaxBodySize(8), WithNamespace("app", "users"))
+
+	expectStatus(t, serve(h, "PUT", "/app/users/1", "12345678"), http.StatusNoContent)
+	expectErrorCode(t, serve(h, "PUT", "/app/users/1", "123456789"), http.StatusRequestEntityTooLarge, "too_large")
+	expectErrorCode(t, serve(h, "POST", "/app/users/mget", `{"keys":["1","2"]}`), http.StatusRequestEntityTooLarge, "too_large")
+
+	expectErrorCode(t, serve(h, "GET", "/app/orders/1", ""), http.StatusForbidden, "forbidden")
+	expectErrorCode(t, serve(h, "DELETE", "/app/orders", ""), http.StatusForbidden, "forbidden")
+}
+
+// failingDriver fails every Get with an unexpected error.
+type failingDriver struct {
+	namestore.Driver
+}
+
+func (failingDriver) Get(ctx context.Context, key string) ([]byte, error) {
+	return nil, io.ErrUnexpectedEOF
+}
+
+func TestHandler_InternalError(t *testing.T) {
+	logger := &testLogger{}
+	backend := namestore.NewMemory()
+	_ = backend.Set(context.Background(), "app:x:1", []byte("v"), 0)
+	h := NewHandler(failingDriver{backend}, WithLogger(logger))
+
+	expectErrorCode(t, serve(h, "GET", "/app/x/1", ""), http.StatusInternalServerError, "internal")
+	if len(logger.errors) != 1 || !strings.Contains(logger.errors[0], "unexpected EOF") {
+		t.Errorf("logged %v", logger.errors)
+	}
+
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	d, _ := NewDriver(srv.URL)
+	if _, err := d.Get(context.Background(), "app:x:1"); err == nil || !strings.Contains(err.Error(), "unexpected EOF") {
+		t.Errorf("remote Get = %v", err)
+	}
+}
+
+// expiringDriver reports every key as gone by the time its TTL is read.
+type expiringDriver struct {
+	namestore.Driver
+}
+
+func (expiringDriver) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return 0, namestore.ErrNotFound
+}
+
+func TestHandler_GetExpiringKey(t *testing.T) {
+	backend := namestore.NewMemory()
+	_ = backend.Set(context.Background(), "app:x:1", []byte("v"), time.Minute)
+	h := NewHandler(expiringDriver{backend})
+
+	expectErrorCode(t, serve(h, "GET", "/app/x/1", ""), http.StatusNotFound, "not_found")
+}
+
+type testLogger struct {
+	errors []string
+}
+
+func (l *testLogger) Info(ctx context.Context, format string, args ...interface{}) {}
+func (l *testLogger) Warn(ctx context.Context, format string, args ...interface{}) {}
+func (l *testLogger) Error(ctx context.Context, format string, args ...interface{}) {
+	l.errors = append(l.errors, fmt.Sprintf(format, args...))
+}
+func (l *testLogger) Debug(ctx context.Context, format string, args ...interface{}) {}
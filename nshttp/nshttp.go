@@ -0,0 +1,144 @@
+// Package nshttp exposes a namestore Driver as an HTTP/JSON API and provides
+// a Driver that talks to it, so services not written in Go can share a store
+// and Go services can use it remotely.
+//
+// Keys are addressed as /{ns}/{domain}/{key}, matching the "ns:domain:key"
+// layout of namestore.Client:
+//
+//	GET    /{ns}/{domain}/{key}   value as the body, 404 if missing
+//	HEAD   /{ns}/{domain}/{key}   existence and TTL only
+//	PUT    /{ns}/{domain}/{key}   set to the body; If-None-Match: * sets only if absent
+//	DELETE /{ns}/{domain}/{key}   delete
+//	GET    /{ns}/{domain}         list keys: ?pattern=, and ?cursor= &count= to scan
+//	DELETE /{ns}/{domain}         clear the namespace
+//	POST   /{ns}/{domain}/{op}    batch and atomic operations with JSON bodies
+//
+// The X-Namestore-TTL header carries TTLs in milliseconds: on PUT it sets
+// the expiration, and GET and HEAD report the remaining time, or -1 if the
+// key does not expire.
+//
+// The operations are mget, mset, mdel, incr, decr, getset, cas, expire and
+// persist. Values are base64 in JSON, as encoding/json encodes []byte, and
+// keys in bodies and listings are relative to the namespace. Errors are
+// JSON objects with a message and a code that maps back to the namestore
+// sentinel errors.
+package nshttp
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"code.byted.org/khicago/namestore"
+)
+
+// TTLHeader carries a TTL in milliseconds.
+const TTLHeader = "X-Namestore-TTL"
+
+type mgetRequest struct {
+	Keys []string `json:"keys"`
+}
+
+type mgetResponse struct {
+	Values map[string][]byte `json:"values"`
+}
+
+type msetRequest struct {
+	Values map[string][]byte `json:"values"`
+	TTL    int64             `json:"ttl,omitempty"`
+}
+
+type mdelRequest struct {
+	Keys []string `json:"keys"`
+}
+
+type incrRequest struct {
+	Key   string `json:"key"`
+	Delta int64  `json:"delta"`
+}
+
+type incrResponse struct {
+	Value int64 `json:"value"`
+}
+
+type getSetRequest struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
+}
+
+type getSetResponse struct {
+	Value []byte `json:"value"`
+}
+
+type casRequest struct {
+	Key string `json:"key"`
+	Old []byte `json:"old"`
+	New []byte `json:"new"`
+	TTL int64  `json:"ttl,omitempty"`
+}
+
+type casResponse struct {
+	Swapped bool `json:"swapped"`
+}
+
+type expireRequest struct {
+	Key string `json:"key"`
+	TTL int64  `json:"ttl,omitempty"`
+}
+
+type keysResponse struct {
+	Keys []string `json:"keys"`
+	// Cursor is set when scanning; "0" means the scan is complete. It is a
+	// string because cursors may not fit in a JSON number.
+	Cursor string `json:"cursor,omitempty"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+}
+
+// errorCodes maps sentinel errors to their codes and HTTP statuses.
+var errorCodes = []struct {
+	err    error
+	code   string
+	status int
+}{
+	{namestore.ErrNotFound, "not_found", http.StatusNotFound},
+	{namestore.ErrTypeMismatch, "type_mismatch", http.StatusConflict},
+	{namestore.ErrInvalidPattern, "invalid_pattern", http.StatusBadRequest},
+	{namestore.ErrInvalidCursor, "invalid_cursor", http.StatusBadRequest},
+	{namestore.ErrUnsupported, "unsupported", http.StatusNotImplemented},
+	{namestore.ErrOutOfMemory, "out_of_memory", http.StatusInsufficientStorage},
+	{namestore.ErrTxConflict, "tx_conflict", http.StatusConflict},
+	{namestore.ErrClosed, "closed", http.StatusServiceUnavailable},
+}
+
+// errorCode returns the code and HTTP status for err.
+func errorCode(err error) (string, int) {
+	for _, e := range errorCodes {
+		if errors.Is(err, e.err) {
+			return e.code, e.status
+		}
+	}
+	return "internal", http.StatusInternalServerError
+}
+
+// codeError returns the sentinel error for code, or nil.
+func codeError(code string) error {
+	for _, e := range errorCodes {
+		if e.code == code {
+			return e.err
+		}
+	}
+	return nil
+}
+
+// millis converts ttl to milliseconds, rounding up so a positive TTL never
+// becomes 0.
+func millis(ttl time.Duration) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+	return int64((ttl + time.Millisecond - 1) / time.Millisecond)
+}
@@ -0,0 +1,751 @@
+package nsresp
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.byted.org/khicago/namestore"
+)
+
+// command is a handler and its arity, which as in Redis counts the command
+// name and is exact if positive and a minimum if negative.
+type command struct {
+	arity int
+	run   func(c *conn, args [][]byte)
+}
+
+var commands = map[string]command{
+	"PING":    {-1, (*conn).ping},
+	"ECHO":    {2, (*conn).echo},
+	"SELECT":  {2, (*conn).selectDB},
+	"HELLO":   {-1, (*conn).hello},
+	"CLIENT":  {-2, (*conn).client},
+	"COMMAND": {-1, (*conn).command},
+	"AUTH":    {-2, (*conn).auth},
+
+	"GET":     {2, (*conn).get},
+	"SET":     {-3, (*conn).set},
+	"GETSET":  {3, (*conn).getSet},
+	"DEL":     {-2, (*conn).del},
+	"EXISTS":  {-2, (*conn).exists},
+	"MGET":    {-2, (*conn).mget},
+	"MSET":    {-3, (*conn).mset},
+	"TTL":     {2, (*conn).ttl},
+	"PTTL":    {2, (*conn).pttl},
+	"EXPIRE":  {3, (*conn).expire},
+	"PEXPIRE": {3, (*conn).pexpire},
+	"PERSIST": {2, (*conn).persist},
+	"KEYS":    {2, (*conn).keys},
+	"SCAN":    {-2, (*conn).scan},
+	"INCR":    {2, (*conn).incr},
+	"DECR":    {2, (*conn).decr},
+	"INCRBY":  {3, (*conn).incrBy},
+	"DECRBY":  {3, (*conn).decrBy},
+	"CAS":     {-4, (*conn).cas},
+}
+
+// replyError is an error sent to the client as is, starting with its code.
+type replyError string
+
+func (e replyError) Error() string { return string(e) }
+
+const (
+	errSyntax        replyError = "ERR syntax error"
+	errNotInteger    replyError = "ERR value is not an integer or out of range"
+	errOverflow      replyError = "ERR increment or decrement would overflow"
+	errNoNamespace   replyError = "ERR pattern must start with a namespace, such as user:*"
+	errInvalidCursor replyError = "ERR invalid cursor"
+	errContention    replyError = "ERR too much contention on the key, try again"
+)
+
+// maxCASTries bounds the compare-and-swap loops of commands that the Driver
+// interface has no single call for, as the memcached driver does.
+const maxCASTries = 16
+
+// exec runs one command and reports whether the connection stays open.
+func (c *conn) exec(args [][]byte) bool {
+	name := strings.ToUpper(string(args[0]))
+	if name == "QUIT" {
+		c.w.WriteSimple("OK")
+		return false
+	}
+
+	cmd, ok := commands[name]
+	if !ok {
+		c.w.WriteError("ERR unknown command '" + sanitize(string(args[0])) + "'")
+		return true
+	}
+	if cmd.arity > 0 && len(args) != cmd.arity || cmd.arity < 0 && len(args) < -cmd.arity {
+		c.w.WriteError("ERR wrong number of arguments for '" + strings.ToLower(name) + "' command")
+		return true
+	}
+	cmd.run(c, args)
+	return true
+}
+
+// fail replies with err, mapping driver errors onto Redis error codes.
+func (c *conn) fail(err error) {
+	var re replyError
+	switch {
+	case errors.As(err, &re):
+		c.w.WriteError(string(re))
+	case errors.Is(err, namestore.ErrTypeMismatch):
+		c.w.WriteError("WRONGTYPE " + sanitize(err.Error()))
+	case errors.Is(err, namestore.ErrOutOfMemory):
+		c.w.WriteError("OOM " + sanitize(err.Error()))
+	case errors.Is(err, namestore.ErrInvalidPattern):
+		c.w.WriteError("ERR invalid pattern")
+	case errors.Is(err, namestore.ErrInvalidCursor):
+		c.w.WriteError(string(errInvalidCursor))
+	default:
+		c.w.WriteError("ERR " + sanitize(err.Error()))
+	}
+}
+
+// sanitize makes s safe to send in a simple string or error reply.
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\r' || r == '\n' {
+			return ' '
+		}
+		return r
+	}, s)
+}
+
+func (c *conn) bulkOrNull(value []byte, err error) {
+	switch {
+	case err == nil:
+		c.w.WriteBulk(value)
+	case errors.Is(err, namestore.ErrNotFound):
+		c.w.WriteNull()
+	default:
+		c.fail(err)
+	}
+}
+
+func (c *conn) bulks(values [][]byte) {
+	c.w.WriteArrayHeader(len(values))
+	for _, v := range values {
+		if v == nil {
+			c.w.WriteNull()
+		} else {
+			c.w.WriteBulk(v)
+		}
+	}
+}
+
+func (c *conn) boolean(ok bool, err error) {
+	switch {
+	case err != nil:
+		c.fail(err)
+	case ok:
+		c.w.WriteInteger(1)
+	default:
+		c.w.WriteInteger(0)
+	}
+}
+
+func parseInt(b []byte) (int64, error) {
+	n, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil {
+		return 0, errNotInteger
+	}
+	return n, nil
+}
+
+// parseTTL converts an expiration given in units of unit, which must be
+// positive.
+func parseTTL(b []byte, unit time.Duration, cmd string) (time.Duration, error) {
+	n, err := parseInt(b)
+	if err != nil {
+		return 0, err
+	}
+	if n <= 0 || n > math.MaxInt64/int64(unit) {
+		return 0, replyError("ERR invalid expire time in '" + cmd + "' command")
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// parseExpiry parses the optional "EX seconds" or "PX milliseconds" at
+// args[i], reporting whether it matched.
+func parseExpiry(args [][]byte, i int, cmd string) (time.Duration, bool, error) {
+	var unit time.Duration
+	switch strings.ToUpper(string(args[i])) {
+	case "EX":
+		unit = time.Second
+	case "PX":
+		unit = time.Millisecond
+	default:
+		return 0, false, nil
+	}
+	if i+1 >= len(args) {
+		return 0, true, errSyntax
+	}
+	ttl, err := parseTTL(args[i+1], unit, cmd)
+	return ttl, true, err
+}
+
+func (c *conn) ping(args [][]byte) {
+	switch len(args) {
+	case 1:
+		c.w.WriteSimple("PONG")
+	case 2:
+		c.w.WriteBulk(args[1])
+	default:
+		c.w.WriteError("ERR wrong number of arguments for 'ping' command")
+	}
+}
+
+func (c *conn) echo(args [][]byte) {
+	c.w.WriteBulk(args[1])
+}
+
+// selectDB accepts database 0 only; namespaces take the place of databases.
+func (c *conn) selectDB(args [][]byte) {
+	if string(args[1]) != "0" {
+		c.w.WriteError("ERR DB index is out of range")
+		return
+	}
+	c.w.WriteSimple("OK")
+}
+
+// hello reports the server properties for RESP2. Clients asking for RESP3
+// get NOPROTO and fall back to RESP2.
+func (c *conn) hello(args [][]byte) {
+	if len(args) > 1 && string(args[1]) != "2" {
+		if _, err := parseInt(args[1]); err != nil {
+			c.w.WriteError("ERR Protocol version is not an integer or out of range")
+		} else {
+			c.w.WriteError("NOPROTO unsupported protocol version")
+		}
+		return
+	}
+	c.w.WriteArrayHeader(10)
+	c.w.WriteBulk([]byte("server"))
+	c.w.WriteBulk([]byte("namestore"))
+	c.w.WriteBulk([]byte("proto"))
+	c.w.WriteInteger(2)
+	c.w.WriteBulk([]byte("id"))
+	c.w.WriteInteger(c.id)
+	c.w.WriteBulk([]byte("mode"))
+	c.w.WriteBulk([]byte("standalone"))
+	c.w.WriteBulk([]byte("role"))
+	c.w.WriteBulk([]byte("master"))
+}
+
+// client accepts the subcommands client libraries send on connect.
+func (c *conn) client(args [][]byte) {
+	switch strings.ToUpper(string(args[1])) {
+	case "SETNAME", "SETINFO":
+		c.w.WriteSimple("OK")
+	case "GETNAME":
+		c.w.WriteNull()
+	case "ID":
+		c.w.WriteInteger(c.id)
+	default:
+		c.w.WriteError("ERR unknown subcommand '" + sanitize(string(args[1])) + "'")
+	}
+}
+
+// command answers COMMAND and its subcommands with an empty list, which
+// redis-cli accepts.
+func (c *conn) command(args [][]byte) {
+	c.w.WriteArrayHeader(0)
+}
+
+func (c *conn) auth(args [][]byte) {
+	c.w.WriteError("ERR AUTH called without any password configured")
+}
+
+func (c *conn) get(args [][]byte) {
+	c.bulkOrNull(c.srv.driver.Get(c.srv.ctx, string(args[1])))
+}
+
+// set implements SET key value [NX] [EX seconds | PX milliseconds | KEEPTTL] [GET].
+func (c *conn) set(args [][]byte) {
+	var (
+		ttl              time.Duration
+		nx, keepTTL, get bool
+		hasTTL           bool
+	)
+	for i := 3; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "NX":
+			nx = true
+		case "KEEPTTL":
+			keepTTL = true
+		case "GET":
+			get = true
+		default:
+			var ok bool
+			var err error
+			if ttl, ok, err = parseExpiry(args, i, "set"); !ok || err != nil || hasTTL {
+				if err == nil {
+					err = errSyntax
+				}
+				c.fail(err)
+				return
+			}
+			hasTTL = true
+			i++
+		}
+	}
+	if hasTTL && keepTTL || nx && get {
+		c.fail(errSyntax)
+		return
+	}
+
+	key, value := string(args[1]), args[2]
+	switch {
+	case nx:
+		ok, err := c.srv.driver.SetNX(c.srv.ctx, key, value, ttl)
+		if err != nil {
+			c.fail(err)
+		} else if ok {
+			c.w.WriteSimple("OK")
+		} else {
+			c.w.WriteNull()
+		}
+	case keepTTL:
+		// The driver's GetSet keeps the expiration.
+		old, err := c.srv.driver.GetSet(c.srv.ctx, key, value)
+		if get {
+			c.bulkOrNull(old, err)
+		} else if err != nil && !errors.Is(err, namestore.ErrNotFound) {
+			c.fail(err)
+		} else {
+			c.w.WriteSimple("OK")
+		}
+	case get:
+		c.bulkOrNull(c.swap(key, value, ttl))
+	default:
+		if err := c.srv.driver.Set(c.srv.ctx, key, value, ttl); err != nil {
+			c.fail(err)
+			return
+		}
+		c.w.WriteSimple("OK")
+	}
+}
+
+// getSet replaces the value and, as in Redis, its expiration.
+func (c *conn) getSet(args [][]byte) {
+	c.bulkOrNull(c.swap(string(args[1]), args[2], 0))
+}
+
+// swap sets key to value with ttl and returns the previous value, or
+// ErrNotFound if there was none. The driver's GetSet keeps the expiration,
+// so this runs as a compare-and-swap loop, giving up after maxCASTries
+// attempts.
+func (c *conn) swap(key string, value []byte, ttl time.Duration) ([]byte, error) {
+	d, ctx := c.srv.driver, c.srv.ctx
+	for i := 0; i < maxCASTries; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		old, err := d.Get(ctx, key)
+		if errors.Is(err, namestore.ErrNotFound) {
+			ok, err := d.SetNX(ctx, key, value, ttl)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				return nil, namestore.ErrNotFound
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		swapped, err := d.CompareAndSwap(ctx, key, old, value, ttl)
+		if err != nil {
+			return nil, err
+		}
+		if swapped {
+			return old, nil
+		}
+	}
+	return nil, errContention
+}
+
+func stringArgs(args [][]byte) []string {
+	keys := make([]string, len(args))
+	for i, a := range args {
+		keys[i] = string(a)
+	}
+	return keys
+}
+
+// del counts the keys that exist and then deletes them in one batch. The
+// count is best-effort (see the package doc).
+func (c *conn) del(args [][]byte) {
+	keys := stringArgs(args[1:])
+	found, err := c.srv.driver.MGet(c.srv.ctx, keys)
+	if err != nil {
+		c.fail(err)
+		return
+	}
+	if err := c.srv.driver.MDel(c.srv.ctx, keys); err != nil {
+		c.fail(err)
+		return
+	}
+	c.w.WriteInteger(int64(len(found)))
+}
+
+// exists counts existing keys, a key given twice counting twice as in Redis.
+func (c *conn) exists(args [][]byte) {
+	keys := stringArgs(args[1:])
+	found, err := c.srv.driver.MGet(c.srv.ctx, keys)
+	if err != nil {
+		c.fail(err)
+		return
+	}
+	var n int64
+	for _, k := range keys {
+		if _, ok := found[k]; ok {
+			n++
+		}
+	}
+	c.w.WriteInteger(n)
+}
+
+func (c *conn) mget(args [][]byte) {
+	keys := stringArgs(args[1:])
+	found, err := c.srv.driver.MGet(c.srv.ctx, keys)
+	if err != nil {
+		c.fail(err)
+		return
+	}
+	values := make([][]byte, len(keys))
+	for i, k := range keys {
+		if v, ok := found[k]; ok {
+			if v == nil {
+				v = []byte{}
+			}
+			values[i] = v
+		}
+	}
+	c.bulks(values)
+}
+
+func (c *conn) mset(args [][]byte) {
+	if len(args)%2 == 0 {
+		c.w.WriteError("ERR wrong number of arguments for 'mset' command")
+		return
+	}
+	pairs := make(map[string][]byte, len(args)/2)
+	for i := 1; i < len(args); i += 2 {
+		pairs[string(args[i])] = args[i+1]
+	}
+	if err := c.srv.driver.MSet(c.srv.ctx, pairs, 0); err != nil {
+		c.fail(err)
+		return
+	}
+	c.w.WriteSimple("OK")
+}
+
+// remaining returns the TTL of key in the Redis encoding: -2 if the key
+// does not exist and -1 if it has no expiration.
+func (c *conn) remaining(key string) (time.Duration, error) {
+	ttl, err := c.srv.driver.TTL(c.srv.ctx, key)
+	switch {
+	case errors.Is(err, namestore.ErrNotFound):
+		return -2, nil
+	case err != nil:
+		return 0, err
+	case ttl < 0:
+		return -1, nil
+	}
+	return ttl, nil
+}
+
+func (c *conn) ttl(args [][]byte) {
+	ttl, err := c.remaining(string(args[1]))
+	switch {
+	case err != nil:
+		c.fail(err)
+	case ttl < 0:
+		c.w.WriteInteger(int64(ttl))
+	default:
+		c.w.WriteInteger(int64((ttl + time.Second/2) / time.Second))
+	}
+}
+
+func (c *conn) pttl(args [][]byte) {
+	ttl, err := c.remaining(string(args[1]))
+	switch {
+	case err != nil:
+		c.fail(err)
+	case ttl < 0:
+		c.w.WriteInteger(int64(ttl))
+	default:
+		c.w.WriteInteger(ttl.Milliseconds())
+	}
+}
+
+func (c *conn) expire(args [][]byte) {
+	c.expireIn(args, time.Second)
+}
+
+func (c *conn) pexpire(args [][]byte) {
+	c.expireIn(args, time.Millisecond)
+}
+
+// expireIn sets a TTL given in units of unit. As in Redis, a non-positive
+// TTL deletes the key.
+func (c *conn) expireIn(args [][]byte, unit time.Duration) {
+	key := string(args[1])
+	n, err := parseInt(args[2])
+	if err != nil {
+		c.fail(err)
+		return
+	}
+	if n <= 0 {
+		// Best-effort like DEL: the key may change between the two calls.
+		ok, err := c.srv.driver.Exists(c.srv.ctx, key)
+		if err == nil && ok {
+			err = c.srv.driver.Delete(c.srv.ctx, key)
+		}
+		c.boolean(ok, err)
+		return
+	}
+	if n > math.MaxInt64/int64(unit) {
+		c.w.WriteError("ERR invalid expire time in '" + strings.ToLower(string(args[0])) + "' command")
+		return
+	}
+
+	err = c.srv.driver.Expire(c.srv.ctx, key, time.Duration(n)*unit)
+	if errors.Is(err, namestore.ErrNotFound) {
+		c.w.WriteInteger(0)
+		return
+	}
+	c.boolean(true, err)
+}
+
+// persist replies 1 if it removed an expiration and 0 if the key is missing
+// or has none.
+func (c *conn) persist(args [][]byte) {
+	key := string(args[1])
+	ttl, err := c.remaining(key)
+	if err != nil || ttl < 0 {
+		c.boolean(false, err)
+		return
+	}
+	err = c.srv.driver.Persist(c.srv.ctx, key)
+	if errors.Is(err, namestore.ErrNotFound) {
+		c.w.WriteInteger(0)
+		return
+	}
+	c.boolean(true, err)
+}
+
+// splitPattern splits a Redis glob such as "user:*" into the namespace
+// prefix "user" and the pattern for the rest of the key. The prefix is the
+// literal text up to the last ':' before the first wildcard.
+func splitPattern(p string) (prefix, pattern string, err error) {
+	var lit []byte
+	sep, litSep := -1, 0
+scan:
+	for i := 0; i < len(p); i++ {
+		switch p[i] {
+		case '*', '?', '[':
+			break scan
+		case '\\':
+			if i+1 < len(p) {
+				i++
+			}
+			lit = append(lit, p[i])
+		case ':':
+			sep, litSep = i, len(lit)
+			lit = append(lit, ':')
+		default:
+			lit = append(lit, p[i])
+		}
+	}
+	if sep <= 0 {
+		return "", "", errNoNamespace
+	}
+	return string(lit[:litSep]), p[sep+1:], nil
+}
+
+func (c *conn) keys(args [][]byte) {
+	prefix, pattern, err := splitPattern(string(args[1]))
+	if err != nil {
+		c.fail(err)
+		return
+	}
+	keys, err := c.srv.driver.Keys(c.srv.ctx, prefix, pattern)
+	if err != nil {
+		c.fail(err)
+		return
+	}
+	c.bulks(keysBytes(keys))
+}
+
+func keysBytes(keys []string) [][]byte {
+	out := make([][]byte, len(keys))
+	for i, k := range keys {
+		out[i] = []byte(k)
+	}
+	return out
+}
+
+// scan implements SCAN cursor MATCH pattern [COUNT count] over the driver's
+// Scan. The cursor is the driver's own.
+func (c *conn) scan(args [][]byte) {
+	cursor, err := strconv.ParseUint(string(args[1]), 10, 64)
+	if err != nil {
+		c.fail(errInvalidCursor)
+		return
+	}
+	var (
+		match string
+		count int
+	)
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			c.fail(errSyntax)
+			return
+		}
+		switch strings.ToUpper(string(args[i])) {
+		case "MATCH":
+			match = string(args[i+1])
+		case "COUNT":
+			n, err := parseInt(args[i+1])
+			if err != nil || n < 1 || n > math.MaxInt32 {
+				c.fail(errSyntax)
+				return
+			}
+			count = int(n)
+		default:
+			c.fail(errSyntax)
+			return
+		}
+	}
+	prefix, pattern, err := splitPattern(match)
+	if err != nil {
+		c.fail(err)
+		return
+	}
+
+	keys, next, err := c.srv.driver.Scan(c.srv.ctx, prefix, pattern, cursor, count)
+	if err != nil {
+		c.fail(err)
+		return
+	}
+	c.w.WriteArrayHeader(2)
+	c.w.WriteBulk(strconv.AppendUint(nil, next, 10))
+	c.bulks(keysBytes(keys))
+}
+
+func (c *conn) incr(args [][]byte) {
+	c.count(string(args[1]), 1, nil)
+}
+
+func (c *conn) decr(args [][]byte) {
+	c.count(string(args[1]), -1, nil)
+}
+
+func (c *conn) incrBy(args [][]byte) {
+	delta, err := parseInt(args[2])
+	c.count(string(args[1]), delta, err)
+}
+
+func (c *conn) decrBy(args [][]byte) {
+	delta, err := parseInt(args[2])
+	if err == nil && delta == math.MinInt64 {
+		err = errOverflow
+	}
+	c.count(string(args[1]), -delta, err)
+}
+
+func (c *conn) count(key string, delta int64, err error) {
+	if err == nil {
+		var n int64
+		if n, err = c.add(key, delta); err == nil {
+			c.w.WriteInteger(n)
+			return
+		}
+	}
+	c.fail(err)
+}
+
+// add adds delta to the counter at key. Counters are stored as decimal
+// strings, as in Redis, rather than in the driver's own encoding used by
+// its Incr, and updated with compare-and-swap, keeping their expiration.
+// It gives up after maxCASTries attempts.
+func (c *conn) add(key string, delta int64) (int64, error) {
+	d, ctx := c.srv.driver, c.srv.ctx
+	for i := 0; i < maxCASTries; i++ {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		old, err := d.Get(ctx, key)
+		if errors.Is(err, namestore.ErrNotFound) {
+			ok, err := d.SetNX(ctx, key, strconv.AppendInt(nil, delta, 10), 0)
+			if err != nil {
+				return 0, err
+			}
+			if ok {
+				return delta, nil
+			}
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		n, err := parseInt(old)
+		if err != nil {
+			return 0, err
+		}
+		if delta > 0 && n > math.MaxInt64-delta || delta < 0 && n < math.MinInt64-delta {
+			return 0, errOverflow
+		}
+		ttl, err := c.remaining(key)
+		if err != nil {
+			return 0, err
+		}
+		if ttl == -2 {
+			continue
+		}
+		if ttl < 0 {
+			ttl = 0
+		}
+
+		swapped, err := d.CompareAndSwap(ctx, key, old, strconv.AppendInt(nil, n+delta, 10), ttl)
+		if err != nil {
+			return 0, err
+		}
+		if swapped {
+			return n + delta, nil
+		}
+	}
+	return 0, errContention
+}
+
+// cas implements CAS key old new [EX seconds | PX milliseconds], replying 1
+// if the value was old and has been replaced. Without a TTL the new value
+// does not expire.
+func (c *conn) cas(args [][]byte) {
+	var ttl time.Duration
+	switch len(args) {
+	case 4:
+	case 6:
+		var ok bool
+		var err error
+		if ttl, ok, err = parseExpiry(args, 4, "cas"); !ok || err != nil {
+			if err == nil {
+				err = errSyntax
+			}
+			c.fail(err)
+			return
+		}
+	default:
+		c.fail(errSyntax)
+		return
+	}
+	c.boolean(c.srv.driver.CompareAndSwap(c.srv.ctx, string(args[1]), args[2], args[3], ttl))
+}
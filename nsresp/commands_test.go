@@ -0,0 +1,368 @@
+package nsresp
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"code.byted.org/khicago/namestore"
+	"code.byted.org/khicago/namestore/internal/resp"
+)
+
+func newTestClient(t *testing.T) *testClient {
+	t.Helper()
+	_, addr := startServer(t, namestore.NewMemory())
+	return dial(t, addr)
+}
+
+func expectStatus(t *testing.T, v resp.Value, want string) {
+	t.Helper()
+	if v.Kind != resp.SimpleString || string(v.Str) != want {
+		t.Fatalf("got %c %q, want +%s", v.Kind, v.Str, want)
+	}
+}
+
+func expectBulk(t *testing.T, v resp.Value, want string) {
+	t.Helper()
+	if v.Kind != resp.BulkString || v.IsNull || string(v.Str) != want {
+		t.Fatalf("got %c %q (null %v), want $%q", v.Kind, v.Str, v.IsNull, want)
+	}
+}
+
+func expectNull(t *testing.T, v resp.Value) {
+	t.Helper()
+	if !v.IsNull {
+		t.Fatalf("got %c %q, want null", v.Kind, v.Str)
+	}
+}
+
+func expectInt(t *testing.T, v resp.Value, want int64) {
+	t.Helper()
+	if v.Kind != resp.Integer || v.Int != want {
+		t.Fatalf("got %c %q %d, want :%d", v.Kind, v.Str, v.Int, want)
+	}
+}
+
+func expectError(t *testing.T, v resp.Value, prefix string) {
+	t.Helper()
+	if v.Kind != resp.Error || !strings.HasPrefix(string(v.Str), prefix) {
+		t.Fatalf("got %c %q, want an error starting with %q", v.Kind, v.Str, prefix)
+	}
+}
+
+func elems(v resp.Value) []string {
+	out := make([]string, len(v.Elems))
+	for i, e := range v.Elems {
+		out[i] = string(e.Str)
+	}
+	return out
+}
+
+func TestCommands_GetSet(t *testing.T) {
+	c := newTestClient(t)
+
+	expectStatus(t, c.do("SET", "u:1", "alice"), "OK")
+	expectBulk(t, c.do("GET", "u:1"), "alice")
+	expectNull(t, c.do("GET", "u:2"))
+
+	expectNull(t, c.do("SET", "u:1", "bob", "NX"))
+	expectStatus(t, c.do("SET", "u:2", "bob", "nx", "EX", "100"), "OK")
+	expectInt(t, c.do("TTL", "u:2"), 100)
+
+	expectStatus(t, c.do("SET", "u:3", "carol", "PX", "100000"), "OK")
+	if v := c.do("PTTL", "u:3"); v.Int <= 99000 || v.Int > 100000 {
+		t.Errorf("PTTL = %d", v.Int)
+	}
+
+	expectError(t, c.do("SET", "u:1", "x", "EX", "0"), "ERR invalid expire time")
+	expectError(t, c.do("SET", "u:1", "x", "EX"), "ERR syntax error")
+	expectError(t, c.do("SET", "u:1", "x", "EX", "1", "KEEPTTL"), "ERR syntax error")
+	expectError(t, c.do("SET", "u:1", "x", "BOGUS"), "ERR syntax error")
+	expectError(t, c.do("GET"), "ERR wrong number of arguments for 'get' command")
+	expectError(t, c.do("NOPE"), "ERR unknown command 'NOPE'")
+}
+
+func TestCommands_SetKeepTTLAndGet(t *testing.T) {
+	c := newTestClient(t)
+
+	c.do("SET", "k:1", "a", "EX", "100")
+	expectStatus(t, c.do("SET", "k:1", "b", "KEEPTTL"), "OK")
+	expectInt(t, c.do("TTL", "k:1"), 100)
+
+	expectBulk(t, c.do("SET", "k:1", "c", "KEEPTTL", "GET"), "b")
+	expectInt(t, c.do("TTL", "k:1"), 100)
+
+	// Without KEEPTTL the expiration is replaced, as in Redis.
+	expectBulk(t, c.do("SET", "k:1", "d", "GET"), "c")
+	expectInt(t, c.do("TTL", "k:1"), -1)
+	expectNull(t, c.do("SET", "k:2", "e", "GET", "PX", "5000"))
+	expectBulk(t, c.do("GET", "k:2"), "e")
+
+	c.do("SET", "k:3", "old", "EX", "100")
+	expectBulk(t, c.do("GETSET", "k:3", "new"), "old")
+	expectBulk(t, c.do("GET", "k:3"), "new")
+	expectInt(t, c.do("TTL", "k:3"), -1)
+	expectNull(t, c.do("GETSET", "k:4", "v"))
+}
+
+func TestCommands_Batch(t *testing.T) {
+	c := newTestClient(t)
+
+	expectStatus(t, c.do("MSET", "b:1", "one", "b:2", "two", "b:3", ""), "OK")
+	expectError(t, c.do("MSET", "b:1", "one", "b:2"), "ERR wrong number of arguments")
+
+	v := c.do("MGET", "b:1", "missing", "b:3", "b:2")
+	if len(v.Elems) != 4 {
+		t.Fatalf("MGET returned %d elements", len(v.Elems))
+	}
+	expectBulk(t, v.Elems[0], "one")
+	expectNull(t, v.Elems[1])
+	expectBulk(t, v.Elems[2], "")
+	expectBulk(t, v.Elems[3], "two")
+
+	expectInt(t, c.do("EXISTS", "b:1", "b:1", "missing"), 2)
+	expectInt(t, c.do("DEL", "b:1", "b:2", "missing"), 2)
+	expectInt(t, c.do("EXISTS", "b:1", "b:2", "b:3"), 1)
+}
+
+func TestCommands_Expiration(t *testing.T) {
+	c := newTestClient(t)
+
+	expectInt(t, c.do("TTL", "e:missing"), -2)
+	expectInt(t, c.do("PTTL", "e:missing"), -2)
+	expectInt(t, c.do("EXPIRE", "e:missing", "10"), 0)
+	expectInt(t, c.do("PERSIST", "e:missing"), 0)
+
+	c.do("SET", "e:1", "v")
+	expectInt(t, c.do("TTL", "e:1"), -1)
+	expectInt(t, c.do("PERSIST", "e:1"), 0)
+
+	expectInt(t, c.do("EXPIRE", "e:1", "50"), 1)
+	expectInt(t, c.do("TTL", "e:1"), 50)
+	expectInt(t, c.do("PEXPIRE", "e:1", "20000"), 1)
+	expectInt(t, c.do("TTL", "e:1"), 20)
+	expectInt(t, c.do("PERSIST", "e:1"), 1)
+	expectInt(t, c.do("TTL", "e:1"), -1)
+
+	// A non-positive TTL deletes the key.
+	expectInt(t, c.do("EXPIRE", "e:1", "0"), 1)
+	expectNull(t, c.do("GET", "e:1"))
+	expectError(t, c.do("EXPIRE", "e:1", "soon"), "ERR value is not an integer")
+
+	c.do("SET", "e:2", "v", "PX", "20")
+	time.Sleep(40 * time.Millisecond)
+	expectNull(t, c.do("GET", "e:2"))
+}
+
+func TestCommands_Counters(t *testing.T) {
+	c := newTestClient(t)
+
+	expectInt(t, c.do("INCR", "c:n"), 1)
+	expectInt(t, c.do("INCRBY", "c:n", "10"), 11)
+	expectInt(t, c.do("DECR", "c:n"), 10)
+	expectInt(t, c.do("DECRBY", "c:n", "4"), 6)
+	expectBulk(t, c.do("GET", "c:n"), "6")
+
+	// Counters keep their expiration.
+	c.do("EXPIRE", "c:n", "100")
+	expectInt(t, c.do("INCR", "c:n"), 7)
+	expectInt(t, c.do("TTL", "c:n"), 100)
+
+	c.do("SET", "c:s", "abc")
+	expectError(t, c.do("INCR", "c:s"), "ERR value is not an integer")
+	expectError(t, c.do("INCRBY", "c:n", "x"), "ERR value is not an integer")
+
+	c.do("SET", "c:max", strconv.FormatInt(1<<63-1, 10))
+	expectError(t, c.do("INCR", "c:max"), "ERR increment or decrement would overflow")
+	expectError(t, c.do("DECRBY", "c:n", "-9223372036854775808"), "ERR increment or decrement would overflow")
+}
+
+// losingDriver loses every compare-and-swap, as if another writer always
+// got there first.
+type losingDriver struct {
+	namestore.DriverBase
+	tries atomic.Int32
+}
+
+func (d *losingDriver) CompareAndSwap(ctx context.Context, key string, oldValue, newValue []byte, ttl time.Duration) (bool, error) {
+	d.tries.Add(1)
+	return false, nil
+}
+
+func TestCommands_ContentionGivesUp(t *testing.T) {
+	d := &losingDriver{DriverBase: namestore.DriverBase{Next: namestore.NewMemory()}}
+	_, addr := startServer(t, d)
+	c := dial(t, addr)
+
+	c.do("SET", "c:n", "1")
+	expectError(t, c.do("INCR", "c:n"), "ERR too much contention")
+	expectError(t, c.do("GETSET", "c:n", "2"), "ERR too much contention")
+	if n := d.tries.Load(); n != 2*maxCASTries {
+		t.Errorf("tried %d swaps, want %d per command", n, maxCASTries)
+	}
+	expectBulk(t, c.do("GET", "c:n"), "1")
+}
+
+func TestCommands_CAS(t *testing.T) {
+	c := newTestClient(t)
+
+	c.do("SET", "x:1", "a")
+	expectInt(t, c.do("CAS", "x:1", "b", "c"), 0)
+	expectInt(t, c.do("CAS", "x:1", "a", "b"), 1)
+	expectBulk(t, c.do("GET", "x:1"), "b")
+	expectInt(t, c.do("CAS", "x:missing", "", "v"), 0)
+
+	expectInt(t, c.do("CAS", "x:1", "b", "c", "EX", "100"), 1)
+	expectInt(t, c.do("TTL", "x:1"), 100)
+	expectError(t, c.do("CAS", "x:1", "c", "d", "EX"), "ERR syntax error")
+	expectError(t, c.do("CAS", "x:1", "c", "d", "AB", "1"), "ERR syntax error")
+}
+
+func TestCommands_KeysAndScan(t *testing.T) {
+	c := newTestClient(t)
+	for i := 0; i < 25; i++ {
+		c.do("SET", "user:"+strconv.Itoa(i), "v")
+	}
+	c.do("SET", "user:admin:1", "v")
+	c.do("SET", "order:1", "v")
+
+	got := elems(c.do("KEYS", "user:1*"))
+	sort.Strings(got)
+	want := []string{"user:1", "user:10", "user:11", "user:12", "user:13", "user:14", "user:15", "user:16", "user:17", "user:18", "user:19"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("KEYS user:1* = %v", got)
+	}
+	if got := elems(c.do("KEYS", "user:admin:*")); len(got) != 1 || got[0] != "user:admin:1" {
+		t.Errorf("KEYS user:admin:* = %v", got)
+	}
+	expectError(t, c.do("KEYS", "*"), "ERR pattern must start with a namespace")
+
+	seen := map[string]bool{}
+	cursor := "0"
+	for {
+		v := c.do("SCAN", cursor, "MATCH", "user:*", "COUNT", "10")
+		if len(v.Elems) != 2 {
+			t.Fatalf("SCAN reply has %d elements", len(v.Elems))
+		}
+		for _, k := range elems(v.Elems[1]) {
+			seen[k] = true
+		}
+		if cursor = string(v.Elems[0].Str); cursor == "0" {
+			break
+		}
+	}
+	if len(seen) != 26 || seen["order:1"] {
+		t.Errorf("SCAN returned %d keys", len(seen))
+	}
+	expectError(t, c.do("SCAN", "0"), "ERR pattern must start with a namespace")
+	expectError(t, c.do("SCAN", "x", "MATCH", "user:*"), "ERR invalid cursor")
+	expectError(t, c.do("SCAN", "0", "COUNT"), "ERR syntax error")
+}
+
+func TestSplitPattern(t *testing.T) {
+	tests := []struct {
+		in, prefix, pattern string
+		ok                  bool
+	}{
+		{"user:*", "user", "*", true},
+		{"user:1", "user", "1", true},
+		{"a:b:c*", "a:b", "c*", true},
+		{"a:*:c", "a", "*:c", true},
+		{`we\*ird:*`, "we*ird", "*", true},
+		{`a\:b:*`, "a:b", "*", true},
+		{"*", "", "", false},
+		{"user*", "", "", false},
+		{":x", "", "", false},
+		{"", "", "", false},
+	}
+	for _, tt := range tests {
+		prefix, pattern, err := splitPattern(tt.in)
+		if (err == nil) != tt.ok || prefix != tt.prefix || pattern != tt.pattern {
+			t.Errorf("splitPattern(%q) = %q, %q, %v", tt.in, prefix, pattern, err)
+		}
+	}
+}
+
+func TestCommands_Connection(t *testing.T) {
+	c := newTestClient(t)
+
+	expectStatus(t, c.do("PING"), "PONG")
+	expectBulk(t, c.do("PING", "hi"), "hi")
+	expectBulk(t, c.do("ECHO", "hello"), "hello")
+	expectStatus(t, c.do("SELECT", "0"), "OK")
+	expectError(t, c.do("SELECT", "1"), "ERR DB index is out of range")
+	expectStatus(t, c.do("CLIENT", "SETNAME", "test"), "OK")
+	expectError(t, c.do("HELLO", "3"), "NOPROTO")
+	if v := c.do("HELLO", "2"); len(v.Elems) != 10 || string(v.Elems[1].Str) != "namestore" {
+		t.Errorf("HELLO 2 = %v", elems(v))
+	}
+	if v := c.do("COMMAND", "DOCS"); v.Kind != resp.Array {
+		t.Errorf("COMMAND = %c", v.Kind)
+	}
+
+	expectStatus(t, c.do("QUIT"), "OK")
+	if _, err := c.read(); err == nil {
+		t.Error("connection should be closed after QUIT")
+	}
+}
+
+func TestCommands_DriverErrors(t *testing.T) {
+	c := newTestClient(t)
+	c.do("SET", "user:1", "v")
+	expectError(t, c.do("KEYS", "user:["), "ERR invalid pattern")
+}
+
+// TestCommands_RedisDriver checks that namestore's own Redis driver works
+// against the server, apart from the operations that need Lua scripts.
+func TestCommands_RedisDriver(t *testing.T) {
+	_, addr := startServer(t, namestore.NewMemory())
+	r := namestore.NewRedis(addr)
+	t.Cleanup(func() { _ = r.Close() })
+	ctx := context.Background()
+
+	if err := r.Set(ctx, "r:a", []byte("1"), time.Minute); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if v, err := r.Get(ctx, "r:a"); err != nil || string(v) != "1" {
+		t.Fatalf("Get = %q, %v", v, err)
+	}
+	if _, err := r.Get(ctx, "r:missing"); !errors.Is(err, namestore.ErrNotFound) {
+		t.Errorf("Get missing = %v", err)
+	}
+	if ttl, err := r.TTL(ctx, "r:a"); err != nil || ttl <= 0 || ttl > time.Minute {
+		t.Errorf("TTL = %v, %v", ttl, err)
+	}
+	if ok, err := r.SetNX(ctx, "r:a", []byte("2"), 0); err != nil || ok {
+		t.Errorf("SetNX = %v, %v", ok, err)
+	}
+	if err := r.MSet(ctx, map[string][]byte{"r:b": []byte("2"), "r:c": []byte("3")}, 0); err != nil {
+		t.Fatalf("MSet: %v", err)
+	}
+	if got, err := r.MGet(ctx, []string{"r:a", "r:b", "r:x"}); err != nil || len(got) != 2 {
+		t.Errorf("MGet = %v, %v", got, err)
+	}
+	if n, err := r.Incr(ctx, "r:n", 5); err != nil || n != 5 {
+		t.Errorf("Incr = %d, %v", n, err)
+	}
+	if old, err := r.GetSet(ctx, "r:a", []byte("9")); err != nil || string(old) != "1" {
+		t.Errorf("GetSet = %q, %v", old, err)
+	}
+	if err := r.Expire(ctx, "r:b", time.Minute); err != nil {
+		t.Errorf("Expire: %v", err)
+	}
+	keys, err := r.Keys(ctx, "r", "*")
+	if err != nil || len(keys) != 4 {
+		t.Errorf("Keys = %v, %v", keys, err)
+	}
+	if err := r.Clear(ctx, "r"); err != nil {
+		t.Fatalf("Clear: %v", err)
+	}
+	if ok, _ := r.Exists(ctx, "r:a"); ok {
+		t.Error("Clear should remove r:a")
+	}
+}
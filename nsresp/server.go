@@ -0,0 +1,353 @@
+// Package nsresp serves a namestore Driver over the Redis protocol (RESP2),
+// so redis-cli, Redis client libraries and namestore's own Redis driver can
+// talk to any backend. It is meant for local development and integration
+// tests rather than as a Redis replacement: it speaks a subset of the
+// commands, and KEYS and SCAN patterns must start with a namespace, since
+// drivers list keys per prefix.
+//
+// Replies that count keys are best-effort where the Driver interface has no
+// single call for them: DEL counts the keys found just before deleting them,
+// and EXPIRE with a non-positive time checks for the key before deleting it,
+// so a concurrent write can make the count off by the keys it touched.
+package nsresp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"code.byted.org/khicago/namestore"
+	"code.byted.org/khicago/namestore/internal/resp"
+)
+
+// DefaultMaxConns is the default limit on open client connections, the same
+// as Redis' maxclients.
+const DefaultMaxConns = 10000
+
+// ErrServerClosed is returned by Serve and ListenAndServe after Shutdown or
+// Close.
+var ErrServerClosed = errors.New("nsresp: server closed")
+
+// Option customizes a Server.
+type Option func(*config)
+
+type config struct {
+	maxConns    int
+	idleTimeout time.Duration
+	logger      namestore.Logger
+}
+
+// WithMaxConns limits the number of open client connections. Connections
+// beyond the limit receive an error reply and are closed. Defaults to
+// DefaultMaxConns.
+func WithMaxConns(n int) Option {
+	return func(c *config) {
+		if n > 0 {
+			c.maxConns = n
+		}
+	}
+}
+
+// WithIdleTimeout closes connections that send no command for d. By default
+// idle connections are kept open.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(c *config) {
+		if d > 0 {
+			c.idleTimeout = d
+		}
+	}
+}
+
+// WithLogger sets the logger for connection errors and rejected clients.
+func WithLogger(logger namestore.Logger) Option {
+	return func(c *config) {
+		if logger != nil {
+			c.logger = logger
+		}
+	}
+}
+
+// Server serves a Driver over RESP. Each connection runs its commands in
+// order, and pipelined replies are flushed together. The Server does not
+// own the driver: closing the Server leaves it open.
+type Server struct {
+	driver namestore.Driver
+	cfg    config
+
+	// ctx is passed to the driver and canceled by Close.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu        sync.Mutex
+	closing   bool
+	listeners map[net.Listener]struct{}
+	conns     map[*conn]struct{}
+	nextID    int64
+	wg        sync.WaitGroup // one per connection
+}
+
+// NewServer creates a Server for driver.
+func NewServer(driver namestore.Driver, opts ...Option) *Server {
+	cfg := config{maxConns: DefaultMaxConns, logger: nopLogger{}}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Server{
+		driver:    driver,
+		cfg:       cfg,
+		ctx:       ctx,
+		cancel:    cancel,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[*conn]struct{}),
+	}
+}
+
+// ListenAndServe listens on the TCP address addr and calls Serve.
+func (s *Server) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// Serve accepts connections on ln until it fails or the Server is shut
+// down, in which case it returns ErrServerClosed. ln is closed on return.
+func (s *Server) Serve(ln net.Listener) error {
+	if !s.trackListener(ln) {
+		ln.Close()
+		return ErrServerClosed
+	}
+	defer s.untrackListener(ln)
+
+	for {
+		nc, err := ln.Accept()
+		if err != nil {
+			if s.isClosing() {
+				return ErrServerClosed
+			}
+			return err
+		}
+
+		c, full := s.newConn(nc)
+		if full {
+			s.cfg.logger.Warn(s.ctx, "nsresp: rejecting %s: max number of clients reached", nc.RemoteAddr())
+			nc.SetWriteDeadline(time.Now().Add(time.Second))
+			io.WriteString(nc, "-ERR max number of clients reached\r\n")
+			nc.Close()
+			continue
+		}
+		if c == nil {
+			nc.Close()
+			continue
+		}
+		go c.serve()
+	}
+}
+
+// Shutdown stops the Server gracefully: it closes the listeners, lets
+// connections finish the commands they are running and then closes them.
+// If ctx ends first, the remaining connections are closed and ctx's error
+// is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	for _, c := range s.stop() {
+		c.interrupt()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		s.cancel()
+		return nil
+	case <-ctx.Done():
+		s.Close()
+		return ctx.Err()
+	}
+}
+
+// Close closes the listeners and all connections immediately, canceling
+// the driver calls in flight.
+func (s *Server) Close() error {
+	s.cancel()
+	for _, c := range s.stop() {
+		c.nc.Close()
+	}
+	return nil
+}
+
+// stop marks the Server as closing, closes its listeners and returns the
+// open connections.
+func (s *Server) stop() []*conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closing = true
+	for ln := range s.listeners {
+		ln.Close()
+	}
+	conns := make([]*conn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+func (s *Server) isClosing() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closing
+}
+
+func (s *Server) trackListener(ln net.Listener) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closing {
+		return false
+	}
+	s.listeners[ln] = struct{}{}
+	return true
+}
+
+func (s *Server) untrackListener(ln net.Listener) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.listeners[ln]; ok {
+		delete(s.listeners, ln)
+		ln.Close()
+	}
+}
+
+// newConn registers nc. It returns a nil conn if the Server is closing, and
+// full if the connection limit is reached.
+func (s *Server) newConn(nc net.Conn) (c *conn, full bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closing {
+		return nil, false
+	}
+	if len(s.conns) >= s.cfg.maxConns {
+		return nil, true
+	}
+
+	s.nextID++
+	br := bufio.NewReader(nc)
+	c = &conn{
+		srv: s,
+		id:  s.nextID,
+		nc:  nc,
+		br:  br,
+		r:   resp.NewReader(br),
+		w:   resp.NewWriter(nc),
+	}
+	s.conns[c] = struct{}{}
+	s.wg.Add(1)
+	return c, false
+}
+
+func (s *Server) removeConn(c *conn) {
+	s.mu.Lock()
+	delete(s.conns, c)
+	s.mu.Unlock()
+	c.nc.Close()
+	s.wg.Done()
+}
+
+// conn is a client connection.
+type conn struct {
+	srv *Server
+	id  int64
+	nc  net.Conn
+	br  *bufio.Reader
+	r   *resp.Reader
+	w   *resp.Writer
+
+	// mu orders the read deadline set before waiting for a command against
+	// the one set by interrupt, so Shutdown cannot be missed.
+	mu sync.Mutex
+}
+
+func (c *conn) serve() {
+	defer c.srv.removeConn(c)
+
+	for {
+		// Reply to a pipeline once all of it has been read.
+		if c.br.Buffered() == 0 {
+			if c.w.Flush() != nil || !c.wait() {
+				return
+			}
+		}
+
+		v, err := c.r.ReadValue()
+		if err != nil {
+			if errors.Is(err, resp.ErrProtocol) {
+				c.w.WriteError("ERR Protocol error: " + sanitize(err.Error()))
+				c.w.Flush()
+			}
+			return
+		}
+		args, ok := commandArgs(v)
+		if !ok {
+			c.w.WriteError("ERR Protocol error: expected an array of bulk strings")
+			c.w.Flush()
+			return
+		}
+		if !c.exec(args) {
+			c.w.Flush()
+			return
+		}
+	}
+}
+
+// wait prepares to block for the next command, reporting false if the
+// Server is shutting down.
+func (c *conn) wait() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.srv.isClosing() {
+		return false
+	}
+	var deadline time.Time
+	if c.srv.cfg.idleTimeout > 0 {
+		deadline = time.Now().Add(c.srv.cfg.idleTimeout)
+	}
+	return c.nc.SetReadDeadline(deadline) == nil
+}
+
+// interrupt wakes a connection waiting for a command so it sees that the
+// Server is shutting down. A command being run is finished first.
+func (c *conn) interrupt() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nc.SetReadDeadline(time.Now())
+}
+
+// commandArgs extracts the arguments of a command sent as an array of bulk
+// strings.
+func commandArgs(v resp.Value) ([][]byte, bool) {
+	if v.Kind != resp.Array || v.IsNull || len(v.Elems) == 0 {
+		return nil, false
+	}
+	args := make([][]byte, len(v.Elems))
+	for i, e := range v.Elems {
+		if e.Kind != resp.BulkString || e.IsNull {
+			return nil, false
+		}
+		args[i] = e.Str
+	}
+	return args, true
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Info(ctx context.Context, format string, args ...interface{})  {}
+func (nopLogger) Warn(ctx context.Context, format string, args ...interface{})  {}
+func (nopLogger) Error(ctx context.Context, format string, args ...interface{}) {}
+func (nopLogger) Debug(ctx context.Context, format string, args ...interface{}) {}
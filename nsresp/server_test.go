@@ -0,0 +1,286 @@
+package nsresp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"code.byted.org/khicago/namestore"
+	"code.byted.org/khicago/namestore/internal/resp"
+)
+
+// startServer serves driver on a loopback port until the test ends.
+func startServer(t *testing.T, driver namestore.Driver, opts ...Option) (*Server, string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	srv := NewServer(driver, opts...)
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(ln) }()
+	t.Cleanup(func() {
+		_ = srv.Close()
+		if err := <-done; !errors.Is(err, ErrServerClosed) {
+			t.Errorf("Serve returned %v", err)
+		}
+	})
+	return srv, ln.Addr().String()
+}
+
+// testClient speaks RESP to a server.
+type testClient struct {
+	t  *testing.T
+	nc net.Conn
+	r  *resp.Reader
+	w  *resp.Writer
+}
+
+func dial(t *testing.T, addr string) *testClient {
+	t.Helper()
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { _ = nc.Close() })
+	return &testClient{t: t, nc: nc, r: resp.NewReader(bufio.NewReader(nc)), w: resp.NewWriter(nc)}
+}
+
+func (c *testClient) send(args ...string) {
+	c.t.Helper()
+	cmd := make([][]byte, len(args))
+	for i, a := range args {
+		cmd[i] = []byte(a)
+	}
+	if err := c.w.WriteCommand(cmd...); err != nil {
+		c.t.Fatalf("write: %v", err)
+	}
+}
+
+func (c *testClient) read() (resp.Value, error) {
+	_ = c.nc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return c.r.ReadValue()
+}
+
+func (c *testClient) do(args ...string) resp.Value {
+	c.t.Helper()
+	c.send(args...)
+	if err := c.w.Flush(); err != nil {
+		c.t.Fatalf("flush: %v", err)
+	}
+	v, err := c.read()
+	if err != nil {
+		c.t.Fatalf("%s: %v", strings.Join(args, " "), err)
+	}
+	return v
+}
+
+// blockingDriver blocks Get until release is closed.
+type blockingDriver struct {
+	namestore.Driver
+	started chan struct{}
+	release chan struct{}
+}
+
+func (d *blockingDriver) Get(ctx context.Context, key string) ([]byte, error) {
+	close(d.started)
+	select {
+	case <-d.release:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return d.Driver.Get(ctx, key)
+}
+
+func TestServer_Pipelining(t *testing.T) {
+	_, addr := startServer(t, namestore.NewMemory())
+	c := dial(t, addr)
+
+	c.send("SET", "p:a", "1")
+	c.send("SET", "p:b", "2")
+	c.send("MGET", "p:a", "p:b")
+	if err := c.w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{"OK", "OK"} {
+		if v, err := c.read(); err != nil || string(v.Str) != want {
+			t.Fatalf("reply %d = %q, %v", i, v.Str, err)
+		}
+	}
+	v, err := c.read()
+	if err != nil || len(v.Elems) != 2 || string(v.Elems[0].Str) != "1" || string(v.Elems[1].Str) != "2" {
+		t.Fatalf("MGET = %+v, %v", v, err)
+	}
+}
+
+func TestServer_MaxConns(t *testing.T) {
+	_, addr := startServer(t, namestore.NewMemory(), WithMaxConns(1))
+
+	first := dial(t, addr)
+	if v := first.do("PING"); string(v.Str) != "PONG" {
+		t.Fatalf("PING = %q", v.Str)
+	}
+
+	second := dial(t, addr)
+	v, err := second.read()
+	if err != nil || v.Kind != resp.Error || !strings.Contains(string(v.Str), "max number of clients") {
+		t.Fatalf("expected a max clients error, got %+v, %v", v, err)
+	}
+	if _, err := second.read(); err == nil {
+		t.Error("rejected connection should be closed")
+	}
+
+	// Closing the first connection frees its slot.
+	_ = first.nc.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		c := dial(t, addr)
+		c.send("PING")
+		_ = c.w.Flush()
+		if v, err := c.read(); err == nil && string(v.Str) == "PONG" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("slot was not freed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServer_IdleTimeout(t *testing.T) {
+	_, addr := startServer(t, namestore.NewMemory(), WithIdleTimeout(50*time.Millisecond))
+	c := dial(t, addr)
+	c.do("PING")
+
+	time.Sleep(150 * time.Millisecond)
+	if _, err := c.read(); err == nil {
+		t.Fatal("idle connection should be closed")
+	}
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	driver := &blockingDriver{
+		Driver:  namestore.NewMemory(),
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	srv, addr := startServer(t, driver)
+	_ = driver.Set(context.Background(), "s:k", []byte("v"), 0)
+
+	idle := dial(t, addr)
+	idle.do("PING")
+	busy := dial(t, addr)
+	busy.send("GET", "s:k")
+	_ = busy.w.Flush()
+	<-driver.started
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- srv.Shutdown(context.Background()) }()
+
+	// The idle connection is closed without waiting for the busy one.
+	if _, err := idle.read(); err == nil {
+		t.Fatal("idle connection should be closed on shutdown")
+	}
+	select {
+	case err := <-shutdown:
+		t.Fatalf("Shutdown returned %v before the command finished", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// The command in flight completes before its connection is closed.
+	close(driver.release)
+	if v, err := busy.read(); err != nil || string(v.Str) != "v" {
+		t.Fatalf("GET = %q, %v", v.Str, err)
+	}
+	if err := <-shutdown; err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if _, err := busy.read(); err == nil {
+		t.Error("connection should be closed after shutdown")
+	}
+	if _, err := net.Dial("tcp", addr); err == nil {
+		t.Error("listener should be closed after shutdown")
+	}
+}
+
+func TestServer_ShutdownTimeout(t *testing.T) {
+	driver := &blockingDriver{
+		Driver:  namestore.NewMemory(),
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	srv, addr := startServer(t, driver)
+
+	c := dial(t, addr)
+	c.send("GET", "s:k")
+	_ = c.w.Flush()
+	<-driver.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := srv.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown = %v, want DeadlineExceeded", err)
+	}
+	if _, err := c.read(); err == nil {
+		t.Error("connection should be closed when shutdown times out")
+	}
+}
+
+func TestServer_ProtocolError(t *testing.T) {
+	_, addr := startServer(t, namestore.NewMemory())
+	c := dial(t, addr)
+
+	if _, err := c.nc.Write([]byte("PING\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	v, err := c.read()
+	if err != nil || v.Kind != resp.Error || !strings.HasPrefix(string(v.Str), "ERR Protocol error") {
+		t.Fatalf("expected a protocol error, got %+v, %v", v, err)
+	}
+	if _, err := c.read(); err == nil {
+		t.Error("connection should be closed after a protocol error")
+	}
+}
+
+func TestServer_ConcurrentClients(t *testing.T) {
+	_, addr := startServer(t, namestore.NewMemory())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		c := dial(t, addr)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_ = c.w.WriteCommand([]byte("INCR"), []byte("cc:n"))
+				_ = c.w.Flush()
+				if v, err := c.read(); err != nil || v.Kind != resp.Integer {
+					t.Errorf("INCR = %+v, %v", v, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if v := dial(t, addr).do("GET", "cc:n"); string(v.Str) != "800" {
+		t.Fatalf("counter = %q, want 800", v.Str)
+	}
+}
+
+func TestServer_ServeAfterClose(t *testing.T) {
+	srv := NewServer(namestore.NewMemory())
+	_ = srv.Close()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Serve(ln); !errors.Is(err, ErrServerClosed) {
+		t.Fatalf("Serve = %v, want ErrServerClosed", err)
+	}
+}
@@ -0,0 +1,120 @@
+package namestore
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+var errPoolClosed = errors.New("namestore: connection pool closed")
+
+// poolConn is a pooled network connection with buffered I/O.
+type poolConn struct {
+	net.Conn
+	br *bufio.Reader
+	bw *bufio.Writer
+}
+
+func newPoolConn(conn net.Conn) *poolConn {
+	return &poolConn{Conn: conn, br: bufio.NewReader(conn), bw: bufio.NewWriter(conn)}
+}
+
+// setDeadline bounds the next round trip by the context deadline, falling back to timeout.
+func (c *poolConn) setDeadline(ctx context.Context, timeout time.Duration) error {
+	deadline, ok := ctx.Deadline()
+	if !ok && timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	return c.SetDeadline(deadline)
+}
+
+// connPool is a bounded pool of network connections shared by the network drivers.
+// At most size connections exist at any time; callers wait for a free slot.
+type connPool struct {
+	dial  func(ctx context.Context) (*poolConn, error)
+	slots chan struct{}
+	idle  chan *poolConn
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func newConnPool(size int, dial func(ctx context.Context) (*poolConn, error)) *connPool {
+	if size <= 0 {
+		size = 1
+	}
+	return &connPool{
+		dial:  dial,
+		slots: make(chan struct{}, size),
+		idle:  make(chan *poolConn, size),
+	}
+}
+
+// get returns an idle connection or dials a new one, waiting for a free slot.
+func (p *connPool) get(ctx context.Context) (*poolConn, error) {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	p.mu.Lock()
+	closed := p.closed
+	p.mu.Unlock()
+	if closed {
+		<-p.slots
+		return nil, errPoolClosed
+	}
+
+	select {
+	case c := <-p.idle:
+		return c, nil
+	default:
+	}
+
+	c, err := p.dial(ctx)
+	if err != nil {
+		<-p.slots
+		return nil, err
+	}
+	return c, nil
+}
+
+// put returns c to the pool. Connections that saw a transport or protocol
+// error must be released with healthy=false so they are closed instead.
+func (p *connPool) put(c *poolConn, healthy bool) {
+	defer func() { <-p.slots }()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !healthy || p.closed {
+		_ = c.Close()
+		return
+	}
+	select {
+	case p.idle <- c:
+	default:
+		_ = c.Close()
+	}
+}
+
+// close closes idle connections; connections in use are closed when returned.
+func (p *connPool) close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	for {
+		select {
+		case c := <-p.idle:
+			_ = c.Close()
+		default:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package namestore
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	// DefaultLatencyBuckets are the upper bounds, in seconds, of the
+	// PrometheusMetrics latency histogram: 100µs to 1s.
+	DefaultLatencyBuckets = []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1}
+	// DefaultBatchSizeBuckets are the upper bounds of the PrometheusMetrics
+	// batch size histogram.
+	DefaultBatchSizeBuckets = []float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000}
+)
+
+// PrometheusOption customizes PrometheusMetrics.
+type PrometheusOption func(*prometheusConfig)
+
+type prometheusConfig struct {
+	latency []float64
+	batch   []float64
+}
+
+// WithLatencyBuckets sets the latency histogram's upper bounds, in seconds.
+// Defaults to DefaultLatencyBuckets.
+func WithLatencyBuckets(bounds ...float64) PrometheusOption {
+	return func(c *prometheusConfig) {
+		c.latency = bounds
+	}
+}
+
+// WithBatchSizeBuckets sets the batch size histogram's upper bounds.
+// Defaults to DefaultBatchSizeBuckets.
+func WithBatchSizeBuckets(bounds ...float64) PrometheusOption {
+	return func(c *prometheusConfig) {
+		c.batch = bounds
+	}
+}
+
+// PrometheusMetrics is a Metrics that aggregates observations in memory and
+// serves them in the Prometheus text exposition format, without depending
+// on the Prometheus client library. Mount it on a metrics endpoint:
+//
+//	m := namestore.NewPrometheusMetrics()
+//	http.Handle("/metrics", m)
+//
+// Every series is labeled with namespace and op:
+//
+//	namestore_client_calls_total           calls
+//	namestore_client_errors_total          failed calls, see Observation.Failed
+//	namestore_client_hits_total            keys found by reads
+//	namestore_client_misses_total          keys not found
+//	namestore_client_duration_seconds      latency histogram
+//	namestore_client_batch_size            keys per batch, for batch operations
+type PrometheusMetrics struct {
+	latency []float64
+	batch   []float64
+
+	mu     sync.RWMutex
+	series map[seriesKey]*opSeries
+}
+
+type seriesKey struct {
+	namespace, op string
+}
+
+// opSeries holds the metrics of one namespace and operation.
+type opSeries struct {
+	mu sync.Mutex
+	opCounts
+}
+
+type opCounts struct {
+	calls, errors  uint64
+	hits, misses   uint64
+	latency, batch histogram
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative; the last is +Inf
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds []float64) histogram {
+	return histogram{counts: make([]uint64, len(bounds)+1)}
+}
+
+func (h *histogram) observe(bounds []float64, v float64) {
+	h.counts[sort.SearchFloat64s(bounds, v)]++
+	h.sum += v
+	h.count++
+}
+
+// NewPrometheusMetrics creates an empty PrometheusMetrics.
+func NewPrometheusMetrics(opts ...PrometheusOption) *PrometheusMetrics {
+	cfg := prometheusConfig{latency: DefaultLatencyBuckets, batch: DefaultBatchSizeBuckets}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return &PrometheusMetrics{
+		latency: sortedBounds(cfg.latency),
+		batch:   sortedBounds(cfg.batch),
+		series:  make(map[seriesKey]*opSeries),
+	}
+}
+
+// sortedBounds copies bounds in ascending order, without duplicates, which
+// would repeat a bucket, and without +Inf, which every histogram has
+// implicitly.
+func sortedBounds(bounds []float64) []float64 {
+	out := make([]float64, 0, len(bounds))
+	for _, b := range bounds {
+		if !math.IsInf(b, +1) && !math.IsNaN(b) {
+			out = append(out, b)
+		}
+	}
+	sort.Float64s(out)
+	return slices.Compact(out)
+}
+
+// Observe implements Metrics.
+func (p *PrometheusMetrics) Observe(ctx context.Context, o Observation) {
+	s := p.get(seriesKey{o.Namespace, o.Op})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	if o.Failed() {
+		s.errors++
+	}
+	s.hits += uint64(o.Hits)
+	s.misses += uint64(o.Misses)
+	s.latency.observe(p.latency, o.Duration.Seconds())
+	if o.BatchSize > 0 {
+		s.batch.observe(p.batch, float64(o.BatchSize))
+	}
+}
+
+func (p *PrometheusMetrics) get(k seriesKey) *opSeries {
+	p.mu.RLock()
+	s, ok := p.series[k]
+	p.mu.RUnlock()
+	if ok {
+		return s
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if s, ok = p.series[k]; !ok {
+		s = &opSeries{opCounts: opCounts{latency: newHistogram(p.latency), batch: newHistogram(p.batch)}}
+		p.series[k] = s
+	}
+	return s
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format.
+func (p *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = p.WriteTo(w)
+}
+
+// seriesSnapshot is a copy of one series taken for writing.
+type seriesSnapshot struct {
+	labels string
+	opCounts
+}
+
+// WriteTo writes the metrics in the Prometheus text format, with series
+// sorted by namespace and operation.
+func (p *PrometheusMetrics) WriteTo(w io.Writer) (int64, error) {
+	p.mu.RLock()
+	keys := make([]seriesKey, 0, len(p.series))
+	for k := range p.series {
+		keys = append(keys, k)
+	}
+	p.mu.RUnlock()
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].namespace != keys[j].namespace {
+			return keys[i].namespace < keys[j].namespace
+		}
+		return keys[i].op < keys[j].op
+	})
+
+	snaps := make([]seriesSnapshot, len(keys))
+	for i, k := range keys {
+		s := p.get(k)
+		s.mu.Lock()
+		snaps[i] = seriesSnapshot{
+			labels:   `namespace="` + escapeLabel(k.namespace) + `",op="` + escapeLabel(k.op) + `"`,
+			opCounts: s.opCounts,
+		}
+		snaps[i].latency = s.latency.clone()
+		snaps[i].batch = s.batch.clone()
+		s.mu.Unlock()
+	}
+
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	writeCounter := func(name, help string, value func(*seriesSnapshot) uint64) {
+		cw.printf("# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+		for i := range snaps {
+			cw.printf("%s{%s} %d\n", name, snaps[i].labels, value(&snaps[i]))
+		}
+	}
+	writeHistogram := func(name, help string, bounds []float64, h func(*seriesSnapshot) *histogram) {
+		cw.printf("# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+		for i := range snaps {
+			s := &snaps[i]
+			hs := h(s)
+			if hs.count == 0 {
+				continue
+			}
+			var cum uint64
+			for j, b := range bounds {
+				cum += hs.counts[j]
+				cw.printf("%s_bucket{%s,le=\"%s\"} %d\n", name, s.labels, formatFloat(b), cum)
+			}
+			cw.printf("%s_bucket{%s,le=\"+Inf\"} %d\n", name, s.labels, hs.count)
+			cw.printf("%s_sum{%s} %s\n", name, s.labels, formatFloat(hs.sum))
+			cw.printf("%s_count{%s} %d\n", name, s.labels, hs.count)
+		}
+	}
+
+	writeCounter("namestore_client_calls_total", "Client calls.",
+		func(s *seriesSnapshot) uint64 { return s.calls })
+	writeCounter("namestore_client_errors_total", "Client calls that failed, not counting ErrNotFound.",
+		func(s *seriesSnapshot) uint64 { return s.errors })
+	writeCounter("namestore_client_hits_total", "Keys found by Client reads.",
+		func(s *seriesSnapshot) uint64 { return s.hits })
+	writeCounter("namestore_client_misses_total", "Keys not found by Client calls.",
+		func(s *seriesSnapshot) uint64 { return s.misses })
+	writeHistogram("namestore_client_duration_seconds", "Client call latency.", p.latency,
+		func(s *seriesSnapshot) *histogram { return &s.latency })
+	writeHistogram("namestore_client_batch_size", "Keys per Client batch call.", p.batch,
+		func(s *seriesSnapshot) *histogram { return &s.batch })
+
+	if cw.err == nil {
+		cw.err = cw.w.Flush()
+	}
+	return cw.n, cw.err
+}
+
+func (h histogram) clone() histogram {
+	h.counts = append([]uint64(nil), h.counts...)
+	return h
+}
+
+// countingWriter formats into a buffered writer, keeping the first error.
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) printf(format string, args ...interface{}) {
+	if c.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(c.w, format, args...)
+	c.n += int64(n)
+	c.err = err
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,125 @@
+package namestore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPrometheusMetrics_Exposition(t *testing.T) {
+	p := NewPrometheusMetrics(WithLatencyBuckets(1, 0.1), WithBatchSizeBuckets(10))
+	ctx := context.Background()
+
+	p.Observe(ctx, Observation{Namespace: "app:users", Op: "Get", Duration: 50 * time.Millisecond, Hits: 1})
+	p.Observe(ctx, Observation{Namespace: "app:users", Op: "Get", Duration: 2 * time.Second, Misses: 1, Err: ErrNotFound})
+	p.Observe(ctx, Observation{Namespace: "app:users", Op: "Get", Duration: 500 * time.Millisecond, Err: errors.New("boom")})
+	p.Observe(ctx, Observation{Namespace: "app:users", Op: "MGet", Duration: time.Millisecond, Hits: 3, Misses: 2, BatchSize: 5})
+	p.Observe(ctx, Observation{Namespace: `a"b\c`, Op: "Set"})
+
+	var sb strings.Builder
+	n, err := p.WriteTo(&sb)
+	if err != nil || n != int64(sb.Len()) {
+		t.Fatalf("WriteTo = %d, %v; wrote %d bytes", n, err, sb.Len())
+	}
+	out := sb.String()
+
+	for _, line := range []string{
+		"# TYPE namestore_client_calls_total counter",
+		`namestore_client_calls_total{namespace="app:users",op="Get"} 3`,
+		`namestore_client_calls_total{namespace="app:users",op="MGet"} 1`,
+		`namestore_client_calls_total{namespace="a\"b\\c",op="Set"} 1`,
+		`namestore_client_errors_total{namespace="app:users",op="Get"} 1`,
+		`namestore_client_hits_total{namespace="app:users",op="Get"} 1`,
+		`namestore_client_misses_total{namespace="app:users",op="Get"} 1`,
+		`namestore_client_hits_total{namespace="app:users",op="MGet"} 3`,
+		`namestore_client_misses_total{namespace="app:users",op="MGet"} 2`,
+		"# TYPE namestore_client_duration_seconds histogram",
+		`namestore_client_duration_seconds_bucket{namespace="app:users",op="Get",le="0.1"} 1`,
+		`namestore_client_duration_seconds_bucket{namespace="app:users",op="Get",le="1"} 2`,
+		`namestore_client_duration_seconds_bucket{namespace="app:users",op="Get",le="+Inf"} 3`,
+		`namestore_client_duration_seconds_sum{namespace="app:users",op="Get"} 2.55`,
+		`namestore_client_duration_seconds_count{namespace="app:users",op="Get"} 3`,
+		"# TYPE namestore_client_batch_size histogram",
+		`namestore_client_batch_size_bucket{namespace="app:users",op="MGet",le="10"} 1`,
+		`namestore_client_batch_size_sum{namespace="app:users",op="MGet"} 5`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("output lacks %q:\n%s", line, out)
+		}
+	}
+	// Operations without batches have no batch size series.
+	if strings.Contains(out, `namestore_client_batch_size_count{namespace="app:users",op="Get"}`) {
+		t.Error("Get should have no batch size histogram")
+	}
+	// Series are sorted by namespace, then operation.
+	if i, j := strings.Index(out, `op="Get"} 3`), strings.Index(out, `op="MGet"} 1`); i < 0 || j < i {
+		t.Error("series are not sorted")
+	}
+}
+
+func TestPrometheusMetrics_DuplicateBuckets(t *testing.T) {
+	p := NewPrometheusMetrics(WithLatencyBuckets(1, 0.1, 1, math.Inf(+1)))
+	p.Observe(context.Background(), Observation{Namespace: "app:users", Op: "Get", Duration: 500 * time.Millisecond})
+
+	var sb strings.Builder
+	_, _ = p.WriteTo(&sb)
+	out := sb.String()
+	for le, want := range map[string]int{"0.1": 1, "1": 1, "+Inf": 1} {
+		line := fmt.Sprintf(`namestore_client_duration_seconds_bucket{namespace="app:users",op="Get",le="%s"}`, le)
+		if n := strings.Count(out, line); n != want {
+			t.Errorf("bucket le=%s appears %d times, want %d:\n%s", le, n, want, out)
+		}
+	}
+}
+
+func TestPrometheusMetrics_ServeHTTP(t *testing.T) {
+	p := NewPrometheusMetrics()
+	c := New[string]("app", "users", WithMetrics[string](p))
+	_, _ = c.Get(context.Background(), "missing")
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, `namestore_client_misses_total{namespace="app:users",op="Get"} 1`) {
+		t.Errorf("body:\n%s", body)
+	}
+}
+
+func TestPrometheusMetrics_Concurrent(t *testing.T) {
+	p := NewPrometheusMetrics()
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c := New[string]("app", fmt.Sprint(i%2), WithMetrics[string](p))
+			for j := 0; j < 100; j++ {
+				_ = c.Set(ctx, "k", []byte("v"), 0)
+				_, _ = c.MGet(ctx, "k", "x")
+				if j%10 == 0 {
+					_, _ = p.WriteTo(&strings.Builder{})
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	var sb strings.Builder
+	_, _ = p.WriteTo(&sb)
+	for _, ns := range []string{"0", "1"} {
+		want := fmt.Sprintf(`namestore_client_calls_total{namespace="app:%s",op="Set"} 400`, ns)
+		if !strings.Contains(sb.String(), want) {
+			t.Errorf("output lacks %q", want)
+		}
+	}
+}
@@ -0,0 +1,650 @@
+package namestore
+
+import (
+	"context"
+	"crypto/sha1" //nolint:gosec // SHA-1 is mandated by EVALSHA, not used for security.
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.byted.org/khicago/namestore/internal/resp"
+)
+
+// RedisOption customizes the Redis driver.
+type RedisOption func(*redisConfig)
+
+type redisConfig struct {
+	username    string
+	password    string
+	db          int
+	protocol    int
+	poolSize    int
+	scanCount   int
+	dialTimeout time.Duration
+	ioTimeout   time.Duration
+	dialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// WithRedisAuth authenticates new connections. Leave username empty for
+// password-only (requirepass) authentication.
+func WithRedisAuth(username, password string) RedisOption {
+	return func(c *redisConfig) {
+		c.username = username
+		c.password = password
+	}
+}
+
+// WithRedisDB selects the logical database on new connections.
+func WithRedisDB(db int) RedisOption {
+	return func(c *redisConfig) {
+		c.db = db
+	}
+}
+
+// WithRedisProtocol selects RESP2 (2) or RESP3 (3). RESP3 is negotiated via HELLO.
+// Defaults to 2, which every Redis version supports.
+func WithRedisProtocol(version int) RedisOption {
+	return func(c *redisConfig) {
+		if version == 2 || version == 3 {
+			c.protocol = version
+		}
+	}
+}
+
+// WithRedisPoolSize limits the number of open connections. Defaults to 10.
+func WithRedisPoolSize(size int) RedisOption {
+	return func(c *redisConfig) {
+		if size > 0 {
+			c.poolSize = size
+		}
+	}
+}
+
+// WithRedisTimeouts sets the dial timeout and the per-command I/O timeout used
+// when the context carries no deadline. Zero leaves a value unchanged.
+func WithRedisTimeouts(dial, io time.Duration) RedisOption {
+	return func(c *redisConfig) {
+		if dial > 0 {
+			c.dialTimeout = dial
+		}
+		if io > 0 {
+			c.ioTimeout = io
+		}
+	}
+}
+
+// WithRedisScanCount sets the COUNT hint used by SCAN in Keys and Clear. Defaults to 100.
+func WithRedisScanCount(count int) RedisOption {
+	return func(c *redisConfig) {
+		if count > 0 {
+			c.scanCount = count
+		}
+	}
+}
+
+// WithRedisDialer replaces the network dialer, e.g. for TLS or unix sockets.
+func WithRedisDialer(dial func(ctx context.Context, network, addr string) (net.Conn, error)) RedisOption {
+	return func(c *redisConfig) {
+		if dial != nil {
+			c.dialContext = dial
+		}
+	}
+}
+
+// redisScript is a Lua script invoked through EVALSHA with EVAL fallback.
+type redisScript struct {
+	src string
+	sha string
+}
+
+func newRedisScript(src string) *redisScript {
+	sum := sha1.Sum([]byte(src)) //nolint:gosec // See import comment.
+	return &redisScript{src: src, sha: hex.EncodeToString(sum[:])}
+}
+
+var (
+	// redisCASScript sets KEYS[1] to ARGV[2] with a PX of ARGV[3] (0 = no expiry)
+	// only when its current value equals ARGV[1].
+	redisCASScript = newRedisScript(`local cur = redis.call('GET', KEYS[1])
+if cur == false or cur ~= ARGV[1] then return 0 end
+if tonumber(ARGV[3]) > 0 then
+  redis.call('SET', KEYS[1], ARGV[2], 'PX', ARGV[3])
+else
+  redis.call('SET', KEYS[1], ARGV[2])
+end
+return 1`)
+
+	// redisPersistScript removes the expiry of KEYS[1], distinguishing a
+	// missing key (0) from a key that had no expiry (1), unlike PERSIST.
+	redisPersistScript = newRedisScript(`if redis.call('EXISTS', KEYS[1]) == 0 then return 0 end
+redis.call('PERSIST', KEYS[1])
+return 1`)
+)
+
+// Redis implements Driver on top of a Redis server, speaking RESP2 or RESP3
+// directly over a pool of connections.
+//
+// Values are stored as Redis strings. Counters use Redis' native decimal
+// representation, so Incr works on values written as decimal text and a value
+// written by Incr reads back as decimal text rather than Memory's 8-byte encoding.
+type Redis struct {
+	addr string
+	cfg  redisConfig
+	pool *connPool
+}
+
+// NewRedis creates a Redis Driver for the server at addr ("host:port").
+// Connections are dialed lazily; call Close to release them.
+func NewRedis(addr string, opts ...RedisOption) *Redis {
+	cfg := redisConfig{
+		protocol:    2,
+		poolSize:    10,
+		scanCount:   100,
+		dialTimeout: 5 * time.Second,
+		ioTimeout:   5 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.dialContext == nil {
+		dialer := &net.Dialer{Timeout: cfg.dialTimeout}
+		cfg.dialContext = dialer.DialContext
+	}
+
+	r := &Redis{addr: addr, cfg: cfg}
+	r.pool = newConnPool(cfg.poolSize, r.dial)
+	return r
+}
+
+// Close closes all pooled connections. Subsequent operations fail.
+func (r *Redis) Close() error {
+	return r.pool.close()
+}
+
+func (r *Redis) dial(ctx context.Context) (*poolConn, error) {
+	dialCtx, cancel := context.WithTimeout(ctx, r.cfg.dialTimeout)
+	defer cancel()
+
+	conn, err := r.cfg.dialContext(dialCtx, "tcp", r.addr)
+	if err != nil {
+		return nil, fmt.Errorf("namestore: redis dial %s: %w", r.addr, err)
+	}
+	c := newPoolConn(conn)
+	if err := r.handshake(ctx, c); err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (r *Redis) handshake(ctx context.Context, c *poolConn) error {
+	var cmds [][]any
+	if r.cfg.protocol == 3 {
+		hello := []any{"HELLO", "3"}
+		if r.cfg.password != "" {
+			user := r.cfg.username
+			if user == "" {
+				user = "default"
+			}
+			hello = append(hello, "AUTH", user, r.cfg.password)
+		}
+		cmds = append(cmds, hello)
+	} else if r.cfg.password != "" {
+		if r.cfg.username != "" {
+			cmds = append(cmds, []any{"AUTH", r.cfg.username, r.cfg.password})
+		} else {
+			cmds = append(cmds, []any{"AUTH", r.cfg.password})
+		}
+	}
+	if r.cfg.db != 0 {
+		cmds = append(cmds, []any{"SELECT", r.cfg.db})
+	}
+	if len(cmds) == 0 {
+		return nil
+	}
+
+	replies, err := r.roundTrip(ctx, c, cmds)
+	if err != nil {
+		return err
+	}
+	for _, v := range replies {
+		if err := v.Err(); err != nil {
+			return fmt.Errorf("namestore: redis handshake: %w", err)
+		}
+	}
+	return nil
+}
+
+// roundTrip pipelines cmds on c and reads one reply per command.
+func (r *Redis) roundTrip(ctx context.Context, c *poolConn, cmds [][]any) ([]resp.Value, error) {
+	if err := c.setDeadline(ctx, r.cfg.ioTimeout); err != nil {
+		return nil, err
+	}
+	w := resp.NewWriter(c.bw)
+	for _, cmd := range cmds {
+		if err := w.WriteCommand(redisArgs(cmd)...); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+
+	rd := resp.NewReader(c.br)
+	replies := make([]resp.Value, len(cmds))
+	for i := range replies {
+		v, err := rd.ReadValue()
+		if err != nil {
+			return nil, err
+		}
+		replies[i] = v
+	}
+	return replies, nil
+}
+
+// pipeline sends cmds in one round trip on a pooled connection.
+// Error replies are returned as values; only transport failures are errors.
+func (r *Redis) pipeline(ctx context.Context, cmds ...[]any) ([]resp.Value, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	c, err := r.pool.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	replies, err := r.roundTrip(ctx, c, cmds)
+	r.pool.put(c, err == nil)
+	if err != nil {
+		return nil, fmt.Errorf("namestore: redis: %w", err)
+	}
+	return replies, nil
+}
+
+// do runs a single command and converts error replies into errors.
+func (r *Redis) do(ctx context.Context, args ...any) (resp.Value, error) {
+	replies, err := r.pipeline(ctx, args)
+	if err != nil {
+		return resp.Value{}, err
+	}
+	if err := replies[0].Err(); err != nil {
+		return resp.Value{}, redisError(err)
+	}
+	return replies[0], nil
+}
+
+func (r *Redis) eval(ctx context.Context, script *redisScript, keys []string, args ...any) (resp.Value, error) {
+	cmd := make([]any, 0, 3+len(keys)+len(args))
+	cmd = append(cmd, "EVALSHA", script.sha, len(keys))
+	for _, k := range keys {
+		cmd = append(cmd, k)
+	}
+	cmd = append(cmd, args...)
+
+	v, err := r.do(ctx, cmd...)
+	if err != nil && strings.Contains(err.Error(), "NOSCRIPT") {
+		cmd[0], cmd[1] = "EVAL", script.src
+		v, err = r.do(ctx, cmd...)
+	}
+	return v, err
+}
+
+// redisError maps server error replies onto namestore sentinels where possible.
+func redisError(err error) error {
+	msg := err.Error()
+	if strings.HasPrefix(msg, "WRONGTYPE") || strings.Contains(msg, "not an integer") {
+		return fmt.Errorf("%w: %s", ErrTypeMismatch, msg)
+	}
+	return fmt.Errorf("namestore: redis: %w", err)
+}
+
+func redisArgs(args []any) [][]byte {
+	out := make([][]byte, len(args))
+	for i, a := range args {
+		switch v := a.(type) {
+		case string:
+			out[i] = []byte(v)
+		case []byte:
+			out[i] = v
+		case int:
+			out[i] = strconv.AppendInt(nil, int64(v), 10)
+		case int64:
+			out[i] = strconv.AppendInt(nil, v, 10)
+		case uint64:
+			out[i] = strconv.AppendUint(nil, v, 10)
+		default:
+			out[i] = []byte(fmt.Sprint(v))
+		}
+	}
+	return out
+}
+
+// redisMillis converts ttl to whole milliseconds, rounding sub-millisecond values up.
+func redisMillis(ttl time.Duration) int64 {
+	return int64((ttl + time.Millisecond - 1) / time.Millisecond)
+}
+
+// redisGlobEscape escapes glob metacharacters so s matches literally in MATCH.
+func redisGlobEscape(s string) string {
+	if !strings.ContainsAny(s, `*?[]\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func redisBytes(v resp.Value) ([]byte, error) {
+	if v.IsNull {
+		return nil, ErrNotFound
+	}
+	return clone(v.Str), nil
+}
+
+func (r *Redis) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	args := []any{"SET", key, value}
+	if ttl > 0 {
+		args = append(args, "PX", redisMillis(ttl))
+	}
+	_, err := r.do(ctx, args...)
+	return err
+}
+
+func (r *Redis) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	args := []any{"SET", key, value, "NX"}
+	if ttl > 0 {
+		args = append(args, "PX", redisMillis(ttl))
+	}
+	v, err := r.do(ctx, args...)
+	if err != nil {
+		return false, err
+	}
+	return !v.IsNull, nil
+}
+
+func (r *Redis) Get(ctx context.Context, key string) ([]byte, error) {
+	v, err := r.do(ctx, "GET", key)
+	if err != nil {
+		return nil, err
+	}
+	return redisBytes(v)
+}
+
+func (r *Redis) Delete(ctx context.Context, key string) error {
+	_, err := r.do(ctx, "DEL", key)
+	return err
+}
+
+func (r *Redis) Exists(ctx context.Context, key string) (bool, error) {
+	v, err := r.do(ctx, "EXISTS", key)
+	if err != nil {
+		return false, err
+	}
+	return v.Int > 0, nil
+}
+
+// MGet retrieves multiple keys with MGET. Missing keys are omitted from the result.
+func (r *Redis) MGet(ctx context.Context, keys []string) (map[string][]byte, error) {
+	result := make(map[string][]byte, len(keys))
+	if len(keys) == 0 {
+		return result, nil
+	}
+
+	args := make([]any, 0, len(keys)+1)
+	args = append(args, "MGET")
+	for _, k := range keys {
+		args = append(args, k)
+	}
+	v, err := r.do(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	for i, elem := range v.Elems {
+		if i < len(keys) && !elem.IsNull {
+			result[keys[i]] = clone(elem.Str)
+		}
+	}
+	return result, nil
+}
+
+// MSet sets multiple keys. With a TTL the SETs run inside MULTI/EXEC so the
+// batch stays atomic, since MSET itself cannot set expirations.
+func (r *Redis) MSet(ctx context.Context, pairs map[string][]byte, ttl time.Duration) error {
+	if len(pairs) == 0 {
+		return nil
+	}
+
+	if ttl <= 0 {
+		args := make([]any, 0, 2*len(pairs)+1)
+		args = append(args, "MSET")
+		for k, v := range pairs {
+			args = append(args, k, v)
+		}
+		_, err := r.do(ctx, args...)
+		return err
+	}
+
+	ms := redisMillis(ttl)
+	cmds := make([][]any, 0, len(pairs)+2)
+	cmds = append(cmds, []any{"MULTI"})
+	for k, v := range pairs {
+		cmds = append(cmds, []any{"SET", k, v, "PX", ms})
+	}
+	cmds = append(cmds, []any{"EXEC"})
+
+	replies, err := r.pipeline(ctx, cmds...)
+	if err != nil {
+		return err
+	}
+	for _, v := range replies {
+		if err := v.Err(); err != nil {
+			return redisError(err)
+		}
+	}
+	exec := replies[len(replies)-1]
+	if exec.IsNull {
+		return errors.New("namestore: redis: MSET transaction aborted")
+	}
+	// EXEC succeeds even when commands inside it fail.
+	for _, v := range exec.Elems {
+		if err := v.Err(); err != nil {
+			return redisError(err)
+		}
+	}
+	return nil
+}
+
+// MDel deletes multiple keys with a single DEL.
+func (r *Redis) MDel(ctx context.Context, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	args := make([]any, 0, len(keys)+1)
+	args = append(args, "DEL")
+	for _, k := range keys {
+		args = append(args, k)
+	}
+	_, err := r.do(ctx, args...)
+	return err
+}
+
+// TTL returns the remaining time-to-live. Returns -1 if key has no expiration, ErrNotFound if key doesn't exist.
+func (r *Redis) TTL(ctx context.Context, key string) (time.Duration, error) {
+	v, err := r.do(ctx, "PTTL", key)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case v.Int == -2:
+		return 0, ErrNotFound
+	case v.Int < 0:
+		return -1, nil
+	default:
+		return time.Duration(v.Int) * time.Millisecond, nil
+	}
+}
+
+// Expire sets or updates the TTL for a key. A non-positive ttl removes the expiration.
+func (r *Redis) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return r.Persist(ctx, key)
+	}
+	v, err := r.do(ctx, "PEXPIRE", key, redisMillis(ttl))
+	if err != nil {
+		return err
+	}
+	if v.Int == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Persist removes the expiration from a key.
+func (r *Redis) Persist(ctx context.Context, key string) error {
+	v, err := r.eval(ctx, redisPersistScript, []string{key})
+	if err != nil {
+		return err
+	}
+	if v.Int == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// scan walks all keys under prefix with SCAN, calling fn once per page of
+// matching keys. Matching uses the same filepath.Match rules as Memory.
+func (r *Redis) scan(ctx context.Context, prefix, pattern string, fn func(keys []string) error) error {
+	var cursor uint64
+	for {
+		page, next, err := r.Scan(ctx, prefix, pattern, cursor, r.cfg.scanCount)
+		if err != nil {
+			return err
+		}
+		if err := fn(page); err != nil {
+			return err
+		}
+		if cursor = next; cursor == 0 {
+			return nil
+		}
+	}
+}
+
+// Scan returns one page of keys under prefix using a single SCAN call; the
+// cursor is Redis' own. As with SCAN, a page may be empty before the scan
+// completes, and keys may be returned more than once.
+func (r *Redis) Scan(ctx context.Context, prefix, pattern string, cursor uint64, count int) ([]string, uint64, error) {
+	if pattern != "" && pattern != "*" {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, 0, ErrInvalidPattern
+		}
+	}
+	if count <= 0 {
+		count = r.cfg.scanCount
+	}
+
+	match := redisGlobEscape(prefix+":") + "*"
+	v, err := r.do(ctx, "SCAN", strconv.FormatUint(cursor, 10), "MATCH", match, "COUNT", count)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(v.Elems) != 2 {
+		return nil, 0, fmt.Errorf("namestore: redis: unexpected SCAN reply with %d elements", len(v.Elems))
+	}
+	next, err := strconv.ParseUint(string(v.Elems[0].Str), 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("namestore: redis: invalid SCAN cursor %q", v.Elems[0].Str)
+	}
+
+	page := make([]string, 0, len(v.Elems[1].Elems))
+	for _, elem := range v.Elems[1].Elems {
+		key := string(elem.Str)
+		if !strings.HasPrefix(key, prefix+":") {
+			continue
+		}
+		if pattern != "" && pattern != "*" {
+			if matched, _ := filepath.Match(pattern, key[len(prefix)+1:]); !matched {
+				continue
+			}
+		}
+		page = append(page, key)
+	}
+	return page, next, nil
+}
+
+// Keys returns all keys matching the prefix and pattern, using SCAN rather than KEYS.
+func (r *Redis) Keys(ctx context.Context, prefix, pattern string) ([]string, error) {
+	seen := make(map[string]struct{})
+	var result []string
+	err := r.scan(ctx, prefix, pattern, func(keys []string) error {
+		for _, k := range keys {
+			// SCAN may return a key more than once.
+			if _, dup := seen[k]; !dup {
+				seen[k] = struct{}{}
+				result = append(result, k)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Clear removes all keys with the given prefix, deleting one SCAN page at a time.
+func (r *Redis) Clear(ctx context.Context, prefix string) error {
+	return r.scan(ctx, prefix, "", func(keys []string) error {
+		return r.MDel(ctx, keys)
+	})
+}
+
+// Incr atomically increments the integer value with INCRBY.
+func (r *Redis) Incr(ctx context.Context, key string, delta int64) (int64, error) {
+	v, err := r.do(ctx, "INCRBY", key, delta)
+	if err != nil {
+		return 0, err
+	}
+	return v.Int, nil
+}
+
+// Decr atomically decrements the integer value with DECRBY.
+func (r *Redis) Decr(ctx context.Context, key string, delta int64) (int64, error) {
+	v, err := r.do(ctx, "DECRBY", key, delta)
+	if err != nil {
+		return 0, err
+	}
+	return v.Int, nil
+}
+
+// GetSet atomically sets a key to a new value and returns the old value.
+// Like Memory, an existing expiration is kept (SET ... KEEPTTL GET, Redis 6.2+).
+func (r *Redis) GetSet(ctx context.Context, key string, value []byte) ([]byte, error) {
+	v, err := r.do(ctx, "SET", key, value, "KEEPTTL", "GET")
+	if err != nil {
+		return nil, err
+	}
+	return redisBytes(v)
+}
+
+// CompareAndSwap atomically compares and swaps if oldValue matches, using a Lua script.
+func (r *Redis) CompareAndSwap(ctx context.Context, key string, oldValue, newValue []byte, ttl time.Duration) (bool, error) {
+	var ms int64
+	if ttl > 0 {
+		ms = redisMillis(ttl)
+	}
+	v, err := r.eval(ctx, redisCASScript, []string{key}, oldValue, newValue, ms)
+	if err != nil {
+		return false, err
+	}
+	return v.Int == 1, nil
+}
@@ -0,0 +1,769 @@
+package namestore
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"code.byted.org/khicago/namestore/internal/resp"
+)
+
+type fakeRedisEntry struct {
+	value  []byte
+	expire time.Time
+}
+
+// fakeRedis is an in-process RESP server implementing the commands used by the Redis driver.
+// Lua scripts are recognized by source and executed natively.
+type fakeRedis struct {
+	ln       net.Listener
+	password string
+
+	mu      sync.Mutex
+	setErrs map[string]string // error replies for SET on these keys
+	cursors []string
+	dbs     map[int]map[string]fakeRedisEntry
+	conns   int
+	evals   int
+}
+
+type (
+	fakeStatus string
+	fakeError  string
+)
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	t.Helper()
+	return startFakeRedis(t, "")
+}
+
+// startFakeRedis starts a fake that requires password when it is non-empty.
+func startFakeRedis(t *testing.T, password string) *fakeRedis {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	f := &fakeRedis{ln: ln, password: password, cursors: []string{""}, dbs: map[int]map[string]fakeRedisEntry{}}
+	go f.serve()
+	t.Cleanup(func() { _ = ln.Close() })
+	return f
+}
+
+func (f *fakeRedis) addr() string { return f.ln.Addr().String() }
+
+func (f *fakeRedis) serve() {
+	for {
+		conn, err := f.ln.Accept()
+		if err != nil {
+			return
+		}
+		f.mu.Lock()
+		f.conns++
+		f.mu.Unlock()
+		go f.handle(conn)
+	}
+}
+
+type fakeRedisSession struct {
+	proto  int
+	authed bool
+	db     int
+	multi  [][]string
+	inTx   bool
+}
+
+func (f *fakeRedis) handle(conn net.Conn) {
+	defer conn.Close()
+	r := resp.NewReader(conn)
+	bw := bufio.NewWriter(conn)
+	w := resp.NewWriter(bw)
+	s := &fakeRedisSession{proto: 2, authed: f.password == ""}
+
+	for {
+		v, err := r.ReadValue()
+		if err != nil {
+			return
+		}
+		args := make([]string, len(v.Elems))
+		for i, e := range v.Elems {
+			args[i] = string(e.Str)
+		}
+		f.writeReply(bw, w, s.proto, f.dispatch(s, args))
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func (f *fakeRedis) writeReply(bw *bufio.Writer, w *resp.Writer, proto int, reply any) {
+	switch v := reply.(type) {
+	case nil:
+		if proto == 3 {
+			_, _ = bw.WriteString("_\r\n")
+		} else {
+			_ = w.WriteNull()
+		}
+	case fakeStatus:
+		_ = w.WriteSimple(string(v))
+	case fakeError:
+		_ = w.WriteError(string(v))
+	case int64:
+		_ = w.WriteInteger(v)
+	case []byte:
+		_ = w.WriteBulk(v)
+	case []any:
+		_ = w.WriteArrayHeader(len(v))
+		for _, e := range v {
+			f.writeReply(bw, w, proto, e)
+		}
+	}
+}
+
+func (f *fakeRedis) dispatch(s *fakeRedisSession, args []string) any {
+	if len(args) == 0 {
+		return fakeError("ERR empty command")
+	}
+	cmd := strings.ToUpper(args[0])
+
+	switch cmd {
+	case "HELLO":
+		if len(args) >= 2 {
+			s.proto, _ = strconv.Atoi(args[1])
+		}
+		if len(args) >= 5 && strings.ToUpper(args[2]) == "AUTH" {
+			if args[4] != f.password {
+				return fakeError("WRONGPASS invalid username-password pair")
+			}
+			s.authed = true
+		}
+		if !s.authed {
+			return fakeError("NOAUTH HELLO must be called with the client already authenticated")
+		}
+		return []any{[]byte("server"), []byte("fake"), []byte("proto"), int64(s.proto)}
+	case "AUTH":
+		if args[len(args)-1] != f.password {
+			return fakeError("WRONGPASS invalid username-password pair")
+		}
+		s.authed = true
+		return fakeStatus("OK")
+	}
+	if !s.authed {
+		return fakeError("NOAUTH Authentication required.")
+	}
+
+	switch cmd {
+	case "SELECT":
+		s.db, _ = strconv.Atoi(args[1])
+		return fakeStatus("OK")
+	case "MULTI":
+		s.inTx, s.multi = true, nil
+		return fakeStatus("OK")
+	case "EXEC":
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		results := make([]any, 0, len(s.multi))
+		for _, queued := range s.multi {
+			results = append(results, f.execLocked(s, queued))
+		}
+		s.inTx, s.multi = false, nil
+		return results
+	}
+	if s.inTx {
+		s.multi = append(s.multi, args)
+		return fakeStatus("QUEUED")
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.execLocked(s, args)
+}
+
+func (f *fakeRedis) db(s *fakeRedisSession) map[string]fakeRedisEntry {
+	d, ok := f.dbs[s.db]
+	if !ok {
+		d = make(map[string]fakeRedisEntry)
+		f.dbs[s.db] = d
+	}
+	return d
+}
+
+func (f *fakeRedis) lookup(s *fakeRedisSession, key string) (fakeRedisEntry, bool) {
+	d := f.db(s)
+	e, ok := d[key]
+	if ok && !e.expire.IsZero() && time.Now().After(e.expire) {
+		delete(d, key)
+		return e, false
+	}
+	return e, ok
+}
+
+//nolint:gocyclo,cyclop,funlen // A flat command switch reads best for a test fake.
+func (f *fakeRedis) execLocked(s *fakeRedisSession, args []string) any {
+	d := f.db(s)
+	cmd := strings.ToUpper(args[0])
+	switch cmd {
+	case "PING":
+		return fakeStatus("PONG")
+	case "SET":
+		key, val := args[1], []byte(args[2])
+		if msg, ok := f.setErrs[key]; ok {
+			return fakeError(msg)
+		}
+		var nx, keepTTL, get bool
+		var expire time.Time
+		for i := 3; i < len(args); i++ {
+			switch strings.ToUpper(args[i]) {
+			case "NX":
+				nx = true
+			case "KEEPTTL":
+				keepTTL = true
+			case "GET":
+				get = true
+			case "PX":
+				ms, _ := strconv.ParseInt(args[i+1], 10, 64)
+				expire = time.Now().Add(time.Duration(ms) * time.Millisecond)
+				i++
+			}
+		}
+		old, exists := f.lookup(s, key)
+		if nx && exists {
+			return nil
+		}
+		if keepTTL && exists {
+			expire = old.expire
+		}
+		d[key] = fakeRedisEntry{value: val, expire: expire}
+		if get {
+			if !exists {
+				return nil
+			}
+			return old.value
+		}
+		return fakeStatus("OK")
+	case "GET":
+		if e, ok := f.lookup(s, args[1]); ok {
+			return e.value
+		}
+		return nil
+	case "DEL":
+		var n int64
+		for _, k := range args[1:] {
+			if _, ok := f.lookup(s, k); ok {
+				delete(d, k)
+				n++
+			}
+		}
+		return n
+	case "EXISTS":
+		var n int64
+		for _, k := range args[1:] {
+			if _, ok := f.lookup(s, k); ok {
+				n++
+			}
+		}
+		return n
+	case "MGET":
+		out := make([]any, 0, len(args)-1)
+		for _, k := range args[1:] {
+			if e, ok := f.lookup(s, k); ok {
+				out = append(out, e.value)
+			} else {
+				out = append(out, nil)
+			}
+		}
+		return out
+	case "MSET":
+		for i := 1; i+1 < len(args); i += 2 {
+			d[args[i]] = fakeRedisEntry{value: []byte(args[i+1])}
+		}
+		return fakeStatus("OK")
+	case "PTTL":
+		e, ok := f.lookup(s, args[1])
+		switch {
+		case !ok:
+			return int64(-2)
+		case e.expire.IsZero():
+			return int64(-1)
+		default:
+			return time.Until(e.expire).Milliseconds()
+		}
+	case "PEXPIRE":
+		e, ok := f.lookup(s, args[1])
+		if !ok {
+			return int64(0)
+		}
+		ms, _ := strconv.ParseInt(args[2], 10, 64)
+		e.expire = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		d[args[1]] = e
+		return int64(1)
+	case "INCRBY", "DECRBY":
+		delta, _ := strconv.ParseInt(args[2], 10, 64)
+		if cmd == "DECRBY" {
+			delta = -delta
+		}
+		e, ok := f.lookup(s, args[1])
+		var cur int64
+		if ok {
+			n, err := strconv.ParseInt(string(e.value), 10, 64)
+			if err != nil {
+				return fakeError("ERR value is not an integer or out of range")
+			}
+			cur = n
+		}
+		cur += delta
+		e.value = []byte(strconv.FormatInt(cur, 10))
+		d[args[1]] = e
+		return cur
+	case "SCAN":
+		return f.scanLocked(s, args)
+	case "EVALSHA":
+		return fakeError("NOSCRIPT No matching script. Please use EVAL.")
+	case "EVAL":
+		f.evals++
+		return f.evalLocked(s, args)
+	}
+	return fakeError("ERR unknown command '" + args[0] + "'")
+}
+
+// scanLocked pages through keys in sorted order. Cursors remember the last
+// key returned, so deleting keys mid-scan does not skip others, as with Redis.
+func (f *fakeRedis) scanLocked(s *fakeRedisSession, args []string) any {
+	cursor, _ := strconv.Atoi(args[1])
+	match, count := "*", 10
+	for i := 2; i+1 < len(args); i += 2 {
+		switch strings.ToUpper(args[i]) {
+		case "MATCH":
+			match = args[i+1]
+		case "COUNT":
+			count, _ = strconv.Atoi(args[i+1])
+		}
+	}
+
+	after := f.cursors[cursor]
+	keys := make([]string, 0, len(f.db(s)))
+	for k := range f.db(s) {
+		if cursor == 0 || k > after {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	page := []any{}
+	n := 0
+	for ; n < len(keys) && n < count; n++ {
+		if ok, _ := filepath.Match(match, keys[n]); ok {
+			if _, live := f.lookup(s, keys[n]); live {
+				page = append(page, []byte(keys[n]))
+			}
+		}
+	}
+	next := 0
+	if n < len(keys) {
+		f.cursors = append(f.cursors, keys[n-1])
+		next = len(f.cursors) - 1
+	}
+	return []any{[]byte(strconv.Itoa(next)), page}
+}
+
+func (f *fakeRedis) evalLocked(s *fakeRedisSession, args []string) any {
+	script, key := args[1], args[3]
+	d := f.db(s)
+	switch script {
+	case redisCASScript.src:
+		e, ok := f.lookup(s, key)
+		if !ok || string(e.value) != args[4] {
+			return int64(0)
+		}
+		ms, _ := strconv.ParseInt(args[6], 10, 64)
+		var expire time.Time
+		if ms > 0 {
+			expire = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		}
+		d[key] = fakeRedisEntry{value: []byte(args[5]), expire: expire}
+		return int64(1)
+	case redisPersistScript.src:
+		e, ok := f.lookup(s, key)
+		if !ok {
+			return int64(0)
+		}
+		e.expire = time.Time{}
+		d[key] = e
+		return int64(1)
+	}
+	return fakeError("ERR unknown script")
+}
+
+func newTestRedis(t *testing.T, opts ...RedisOption) (*Redis, *fakeRedis) {
+	t.Helper()
+	f := newFakeRedis(t)
+	r := NewRedis(f.addr(), opts...)
+	t.Cleanup(func() { _ = r.Close() })
+	return r, f
+}
+
+func TestRedis_BasicOperations(t *testing.T) {
+	r, _ := newTestRedis(t)
+	ctx := context.Background()
+
+	if err := r.Set(ctx, "k", []byte("v"), 0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	got, err := r.Get(ctx, "k")
+	if err != nil || string(got) != "v" {
+		t.Fatalf("Get = %q, %v", got, err)
+	}
+
+	if _, err := r.Get(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get missing: expected ErrNotFound, got %v", err)
+	}
+
+	exists, _ := r.Exists(ctx, "k")
+	if !exists {
+		t.Error("Exists should report true")
+	}
+
+	_ = r.Delete(ctx, "k")
+	exists, _ = r.Exists(ctx, "k")
+	if exists {
+		t.Error("key should be deleted")
+	}
+}
+
+func TestRedis_SetNX(t *testing.T) {
+	r, _ := newTestRedis(t)
+	ctx := context.Background()
+
+	ok, err := r.SetNX(ctx, "k", []byte("v1"), time.Minute)
+	if err != nil || !ok {
+		t.Fatalf("SetNX new key = %v, %v", ok, err)
+	}
+	ok, _ = r.SetNX(ctx, "k", []byte("v2"), 0)
+	if ok {
+		t.Error("SetNX should fail for existing key")
+	}
+	got, _ := r.Get(ctx, "k")
+	if string(got) != "v1" {
+		t.Errorf("SetNX overwrote value: %q", got)
+	}
+}
+
+func TestRedis_TTLManagement(t *testing.T) {
+	r, _ := newTestRedis(t)
+	ctx := context.Background()
+
+	_ = r.Set(ctx, "k", []byte("v"), time.Minute)
+	ttl, err := r.TTL(ctx, "k")
+	if err != nil || ttl <= 0 || ttl > time.Minute {
+		t.Errorf("TTL = %v, %v", ttl, err)
+	}
+
+	if err := r.Persist(ctx, "k"); err != nil {
+		t.Fatalf("Persist failed: %v", err)
+	}
+	if ttl, _ := r.TTL(ctx, "k"); ttl != -1 {
+		t.Errorf("TTL after Persist = %v, want -1", ttl)
+	}
+
+	if err := r.Expire(ctx, "k", 10*time.Millisecond); err != nil {
+		t.Fatalf("Expire failed: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, err := r.Get(ctx, "k"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("key should expire, got %v", err)
+	}
+
+	if _, err := r.TTL(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("TTL missing: expected ErrNotFound, got %v", err)
+	}
+	if err := r.Expire(ctx, "missing", time.Second); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expire missing: expected ErrNotFound, got %v", err)
+	}
+	if err := r.Expire(ctx, "missing", 0); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expire(0) missing: expected ErrNotFound, got %v", err)
+	}
+	if err := r.Persist(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Persist missing: expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestRedis_Batch(t *testing.T) {
+	r, _ := newTestRedis(t)
+	ctx := context.Background()
+
+	if err := r.MSet(ctx, map[string][]byte{"a": []byte("1"), "b": []byte("2")}, 0); err != nil {
+		t.Fatalf("MSet failed: %v", err)
+	}
+	if err := r.MSet(ctx, map[string][]byte{"c": []byte("3")}, time.Minute); err != nil {
+		t.Fatalf("MSet with TTL failed: %v", err)
+	}
+	if ttl, _ := r.TTL(ctx, "c"); ttl <= 0 {
+		t.Errorf("MSet with TTL should set expiry, got %v", ttl)
+	}
+
+	got, err := r.MGet(ctx, []string{"a", "b", "c", "missing"})
+	if err != nil {
+		t.Fatalf("MGet failed: %v", err)
+	}
+	if len(got) != 3 || string(got["a"]) != "1" || string(got["c"]) != "3" {
+		t.Errorf("MGet = %v", got)
+	}
+
+	if err := r.MDel(ctx, []string{"a", "b"}); err != nil {
+		t.Fatalf("MDel failed: %v", err)
+	}
+	got, _ = r.MGet(ctx, []string{"a", "b", "c"})
+	if len(got) != 1 {
+		t.Errorf("MGet after MDel = %v", got)
+	}
+}
+
+func TestRedis_MSetTTLReportsQueuedErrors(t *testing.T) {
+	r, f := newTestRedis(t)
+	f.mu.Lock()
+	f.setErrs = map[string]string{"bad": "WRONGTYPE Operation against a key holding the wrong kind of value"}
+	f.mu.Unlock()
+
+	err := r.MSet(context.Background(), map[string][]byte{"good": []byte("1"), "bad": []byte("2")}, time.Minute)
+	if !errors.Is(err, ErrTypeMismatch) {
+		t.Errorf("MSet with a failing SET inside EXEC = %v, want ErrTypeMismatch", err)
+	}
+}
+
+func TestRedis_KeysAndClear(t *testing.T) {
+	r, _ := newTestRedis(t, WithRedisScanCount(2))
+	ctx := context.Background()
+
+	for _, k := range []string{"app:users:1", "app:users:2", "app:users:admin", "app:other:1", "app:users*:x"} {
+		_ = r.Set(ctx, k, []byte("v"), 0)
+	}
+
+	keys, err := r.Keys(ctx, "app:users", "*")
+	if err != nil {
+		t.Fatalf("Keys failed: %v", err)
+	}
+	sort.Strings(keys)
+	want := []string{"app:users:1", "app:users:2", "app:users:admin"}
+	if strings.Join(keys, ",") != strings.Join(want, ",") {
+		t.Errorf("Keys = %v, want %v", keys, want)
+	}
+
+	keys, _ = r.Keys(ctx, "app:users", "[0-9]")
+	if len(keys) != 2 {
+		t.Errorf("Keys with pattern = %v", keys)
+	}
+
+	if _, err := r.Keys(ctx, "app:users", "[invalid"); !errors.Is(err, ErrInvalidPattern) {
+		t.Errorf("expected ErrInvalidPattern, got %v", err)
+	}
+
+	if err := r.Clear(ctx, "app:users"); err != nil {
+		t.Fatalf("Clear failed: %v", err)
+	}
+	keys, _ = r.Keys(ctx, "app:users", "*")
+	if len(keys) != 0 {
+		t.Errorf("Keys after Clear = %v", keys)
+	}
+	if exists, _ := r.Exists(ctx, "app:other:1"); !exists {
+		t.Error("Clear should not touch other namespaces")
+	}
+	if exists, _ := r.Exists(ctx, "app:users*:x"); !exists {
+		t.Error("Clear should treat the prefix literally")
+	}
+}
+
+func TestRedis_Scan(t *testing.T) {
+	r, _ := newTestRedis(t)
+	ctx := context.Background()
+
+	for i := 0; i < 25; i++ {
+		_ = r.Set(ctx, fmt.Sprintf("app:users:%02d", i), []byte("v"), 0)
+	}
+	_ = r.Set(ctx, "app:other:1", []byte("v"), 0)
+
+	var all []string
+	var cursor uint64
+	pages := 0
+	for {
+		keys, next, err := r.Scan(ctx, "app:users", "*", cursor, 10)
+		if err != nil {
+			t.Fatalf("Scan failed: %v", err)
+		}
+		all = append(all, keys...)
+		pages++
+		if cursor = next; cursor == 0 {
+			break
+		}
+	}
+	if len(all) != 25 || pages < 3 {
+		t.Errorf("Scan returned %d keys in %d pages", len(all), pages)
+	}
+
+	if _, _, err := r.Scan(ctx, "app:users", "[invalid", 0, 10); !errors.Is(err, ErrInvalidPattern) {
+		t.Errorf("expected ErrInvalidPattern, got %v", err)
+	}
+}
+
+func TestRedis_Atomic(t *testing.T) {
+	r, f := newTestRedis(t)
+	ctx := context.Background()
+
+	n, err := r.Incr(ctx, "counter", 5)
+	if err != nil || n != 5 {
+		t.Fatalf("Incr = %d, %v", n, err)
+	}
+	n, _ = r.Decr(ctx, "counter", 2)
+	if n != 3 {
+		t.Errorf("Decr = %d, want 3", n)
+	}
+
+	_ = r.Set(ctx, "text", []byte("abc"), 0)
+	if _, err := r.Incr(ctx, "text", 1); !errors.Is(err, ErrTypeMismatch) {
+		t.Errorf("Incr on text: expected ErrTypeMismatch, got %v", err)
+	}
+
+	old, err := r.GetSet(ctx, "gs", []byte("first"))
+	if !errors.Is(err, ErrNotFound) || old != nil {
+		t.Errorf("GetSet missing = %q, %v", old, err)
+	}
+	_ = r.Expire(ctx, "gs", time.Minute)
+	old, err = r.GetSet(ctx, "gs", []byte("second"))
+	if err != nil || string(old) != "first" {
+		t.Errorf("GetSet = %q, %v", old, err)
+	}
+	if ttl, _ := r.TTL(ctx, "gs"); ttl <= 0 {
+		t.Errorf("GetSet should keep TTL, got %v", ttl)
+	}
+
+	swapped, err := r.CompareAndSwap(ctx, "gs", []byte("second"), []byte("third"), time.Minute)
+	if err != nil || !swapped {
+		t.Fatalf("CompareAndSwap = %v, %v", swapped, err)
+	}
+	swapped, _ = r.CompareAndSwap(ctx, "gs", []byte("wrong"), []byte("x"), 0)
+	if swapped {
+		t.Error("CompareAndSwap should fail on mismatch")
+	}
+	swapped, _ = r.CompareAndSwap(ctx, "missing", nil, []byte("x"), 0)
+	if swapped {
+		t.Error("CompareAndSwap should fail on missing key")
+	}
+
+	f.mu.Lock()
+	evals := f.evals
+	f.mu.Unlock()
+	if evals == 0 {
+		t.Error("expected EVAL fallback after NOSCRIPT")
+	}
+}
+
+func TestRedis_RESP3(t *testing.T) {
+	r, _ := newTestRedis(t, WithRedisProtocol(3))
+	ctx := context.Background()
+
+	if _, err := r.Get(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("RESP3 null should map to ErrNotFound, got %v", err)
+	}
+	_ = r.Set(ctx, "k", []byte("v"), 0)
+	got, _ := r.MGet(ctx, []string{"k", "missing"})
+	if len(got) != 1 || string(got["k"]) != "v" {
+		t.Errorf("RESP3 MGet = %v", got)
+	}
+	ok, _ := r.SetNX(ctx, "k", []byte("x"), 0)
+	if ok {
+		t.Error("RESP3 SetNX should fail for existing key")
+	}
+}
+
+func TestRedis_AuthAndDB(t *testing.T) {
+	f := startFakeRedis(t, "secret")
+	ctx := context.Background()
+
+	bad := NewRedis(f.addr(), WithRedisAuth("", "wrong"))
+	defer bad.Close()
+	if err := bad.Set(ctx, "k", []byte("v"), 0); err == nil {
+		t.Error("expected authentication failure")
+	}
+
+	db1 := NewRedis(f.addr(), WithRedisAuth("", "secret"), WithRedisDB(1))
+	defer db1.Close()
+	if err := db1.Set(ctx, "k", []byte("db1"), 0); err != nil {
+		t.Fatalf("Set on db1 failed: %v", err)
+	}
+
+	db0 := NewRedis(f.addr(), WithRedisAuth("default", "secret"), WithRedisProtocol(3))
+	defer db0.Close()
+	if _, err := db0.Get(ctx, "k"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("databases should be isolated, got %v", err)
+	}
+}
+
+func TestRedis_Pool(t *testing.T) {
+	r, f := newTestRedis(t, WithRedisPoolSize(2))
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := r.Incr(ctx, "counter", 1); err != nil {
+				t.Errorf("Incr failed: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	got, _ := r.Get(ctx, "counter")
+	if string(got) != "50" {
+		t.Errorf("counter = %q, want 50", got)
+	}
+
+	f.mu.Lock()
+	conns := f.conns
+	f.mu.Unlock()
+	if conns > 2 {
+		t.Errorf("pool opened %d connections, want <= 2", conns)
+	}
+
+	_ = r.Close()
+	if err := r.Set(ctx, "k", []byte("v"), 0); err == nil {
+		t.Error("operations after Close should fail")
+	}
+}
+
+func TestRedis_ContextCanceled(t *testing.T) {
+	r, _ := newTestRedis(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := r.Set(ctx, "k", []byte("v"), 0); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestRedis_WithClient(t *testing.T) {
+	r, _ := newTestRedis(t)
+	c := New[string]("app", "users", WithDriver[string](r))
+	ctx := context.Background()
+
+	_ = c.Set(ctx, "1", []byte("alice"), 0)
+	_ = c.Set(ctx, "2", []byte("bob"), 0)
+
+	keys, err := c.Keys(ctx, "*")
+	if err != nil {
+		t.Fatalf("Keys failed: %v", err)
+	}
+	sort.Strings(keys)
+	if strings.Join(keys, ",") != "1,2" {
+		t.Errorf("Keys = %v", keys)
+	}
+}
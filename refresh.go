@@ -0,0 +1,271 @@
+package namestore
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultEarlyRefreshBeta is the XFetch beta of a RefreshCache. Higher
+	// values refresh earlier.
+	DefaultEarlyRefreshBeta = 1.0
+	// DefaultRefreshTimeout bounds a background refresh.
+	DefaultRefreshTimeout = 10 * time.Second
+
+	// refreshEntryVersion marks the encoding of RefreshCache entries: the
+	// version byte, the soft expiry in Unix nanoseconds and the load time in
+	// nanoseconds, both big-endian, then the value.
+	refreshEntryVersion = 1
+	refreshHeaderSize   = 17
+)
+
+// RefreshCache serves values through a Client with stale-while-revalidate
+// semantics. Each entry carries a soft expiry, fresh after it is loaded,
+// stored alongside the value. Past the soft expiry, Get still returns the
+// stale value but starts a refresh in the background; the entry's TTL in the
+// store, fresh plus the stale window, bounds how stale a value can get.
+//
+// To spread out the refreshes of entries loaded together, Get may also
+// refresh an entry before its soft expiry, with a probability that grows as
+// the expiry nears and with the time the entry took to load, following the
+// XFetch algorithm (Vattani et al., "Optimal Probabilistic Cache Stampede
+// Prevention").
+//
+// Entries are stored in an encoded form: read and write them through the
+// RefreshCache, not the Client.
+type RefreshCache[TKey ~string] interface {
+	// Get returns the value of key, calling load to compute it if the key is
+	// missing, and in the background if it is stale or due for an early
+	// refresh. Missing keys are loaded with Client.GetOrLoad, so concurrent
+	// loads are shared and WithNegativeCache applies.
+	Get(ctx context.Context, key TKey, load Loader[TKey]) ([]byte, error)
+	// Set stores a fresh value.
+	Set(ctx context.Context, key TKey, value []byte) error
+	// Delete removes key.
+	Delete(ctx context.Context, key TKey) error
+
+	// Client returns the underlying Client.
+	Client() Client[TKey]
+}
+
+// RefreshOption customizes a RefreshCache.
+type RefreshOption func(*refreshConfig)
+
+type refreshConfig struct {
+	stale   time.Duration
+	beta    float64
+	timeout time.Duration
+	clock   Clock
+	logger  Logger
+}
+
+// WithStaleFor sets how long past its soft expiry a value may be served
+// while it is refreshed. Defaults to the fresh duration.
+func WithStaleFor(d time.Duration) RefreshOption {
+	return func(c *refreshConfig) {
+		if d > 0 {
+			c.stale = d
+		}
+	}
+}
+
+// WithEarlyRefresh sets the XFetch beta. 0 disables early refreshes, so
+// entries are refreshed only once stale. Defaults to
+// DefaultEarlyRefreshBeta.
+func WithEarlyRefresh(beta float64) RefreshOption {
+	return func(c *refreshConfig) {
+		if beta >= 0 {
+			c.beta = beta
+		}
+	}
+}
+
+// WithRefreshTimeout bounds each background refresh. Defaults to
+// DefaultRefreshTimeout.
+func WithRefreshTimeout(d time.Duration) RefreshOption {
+	return func(c *refreshConfig) {
+		if d > 0 {
+			c.timeout = d
+		}
+	}
+}
+
+// WithRefreshClock makes the cache read the time from clock, both to set
+// soft expiries and to time loads.
+func WithRefreshClock(clock Clock) RefreshOption {
+	return func(c *refreshConfig) {
+		c.clock = clock
+	}
+}
+
+// WithRefreshLogger sets the logger for failed background refreshes, which
+// have no caller to return their error to. If not provided, they are not
+// logged.
+func WithRefreshLogger(logger Logger) RefreshOption {
+	return func(c *refreshConfig) {
+		if logger != nil {
+			c.logger = logger
+		}
+	}
+}
+
+type refreshCache[TKey ~string] struct {
+	client Client[TKey]
+	fresh  time.Duration
+	cfg    refreshConfig
+
+	mu         sync.Mutex
+	refreshing map[TKey]struct{}
+}
+
+// NewRefreshCache creates a RefreshCache over c whose entries stay fresh for
+// fresh after they are loaded. Each key has at most one background refresh
+// at a time per RefreshCache.
+func NewRefreshCache[TKey ~string](c Client[TKey], fresh time.Duration, opts ...RefreshOption) RefreshCache[TKey] {
+	cfg := refreshConfig{
+		stale:   fresh,
+		beta:    DefaultEarlyRefreshBeta,
+		timeout: DefaultRefreshTimeout,
+		logger:  defaultLogger,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return &refreshCache[TKey]{
+		client:     c,
+		fresh:      fresh,
+		cfg:        cfg,
+		refreshing: make(map[TKey]struct{}),
+	}
+}
+
+func (r *refreshCache[TKey]) Client() Client[TKey] {
+	return r.client
+}
+
+func (r *refreshCache[TKey]) now() time.Time {
+	if r.cfg.clock == nil {
+		return time.Now()
+	}
+	return r.cfg.clock.Now()
+}
+
+// ttl is the TTL of entries in the store.
+func (r *refreshCache[TKey]) ttl() time.Duration {
+	return r.fresh + r.cfg.stale
+}
+
+func (r *refreshCache[TKey]) Get(ctx context.Context, key TKey, load Loader[TKey]) ([]byte, error) {
+	data, err := r.client.GetOrLoad(ctx, key, r.ttl(), func(ctx context.Context, key TKey) ([]byte, error) {
+		return r.load(ctx, key, load)
+	})
+	if err != nil {
+		return nil, err
+	}
+	e, err := decodeRefreshEntry(key, data)
+	if err != nil {
+		return nil, err
+	}
+	if r.due(e) {
+		r.refresh(ctx, key, load)
+	}
+	return e.value, nil
+}
+
+func (r *refreshCache[TKey]) Set(ctx context.Context, key TKey, value []byte) error {
+	return r.client.Set(ctx, key, encodeRefreshEntry(refreshEntry{value: value, expiry: r.now().Add(r.fresh)}), r.ttl())
+}
+
+func (r *refreshCache[TKey]) Delete(ctx context.Context, key TKey) error {
+	return r.client.Delete(ctx, key)
+}
+
+// load calls load and encodes its value as a fresh entry.
+func (r *refreshCache[TKey]) load(ctx context.Context, key TKey, load Loader[TKey]) ([]byte, error) {
+	start := r.now()
+	value, err := load(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	end := r.now()
+	return encodeRefreshEntry(refreshEntry{value: value, expiry: end.Add(r.fresh), delta: end.Sub(start)}), nil
+}
+
+// due reports whether e is stale, or selected for an early refresh: XFetch
+// refreshes when now - delta*beta*ln(rand) reaches the expiry.
+func (r *refreshCache[TKey]) due(e refreshEntry) bool {
+	left := e.expiry.Sub(r.now())
+	if left <= 0 {
+		return true
+	}
+	if r.cfg.beta == 0 || e.delta <= 0 {
+		return false
+	}
+	return float64(e.delta)*r.cfg.beta*-math.Log(rand.Float64()) >= float64(left)
+}
+
+// refresh reloads key in the background unless a refresh is in flight. The
+// refresh keeps the values of ctx but not its cancellation.
+func (r *refreshCache[TKey]) refresh(ctx context.Context, key TKey, load Loader[TKey]) {
+	r.mu.Lock()
+	if _, ok := r.refreshing[key]; ok {
+		r.mu.Unlock()
+		return
+	}
+	r.refreshing[key] = struct{}{}
+	r.mu.Unlock()
+
+	go func() {
+		defer func() {
+			r.mu.Lock()
+			delete(r.refreshing, key)
+			r.mu.Unlock()
+		}()
+
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), r.cfg.timeout)
+		defer cancel()
+		data, err := r.load(ctx, key, load)
+		switch {
+		case err == nil:
+			err = r.client.Set(ctx, key, data, r.ttl())
+		case errors.Is(err, ErrNotFound):
+			err = r.client.Delete(ctx, key)
+		}
+		if err != nil {
+			r.cfg.logger.Error(ctx, "namestore: refresh %s failed: %v", key, err)
+		}
+	}()
+}
+
+type refreshEntry struct {
+	value  []byte
+	expiry time.Time
+	// delta is how long the value took to load.
+	delta time.Duration
+}
+
+func encodeRefreshEntry(e refreshEntry) []byte {
+	b := make([]byte, refreshHeaderSize+len(e.value))
+	b[0] = refreshEntryVersion
+	binary.BigEndian.PutUint64(b[1:9], uint64(e.expiry.UnixNano()))
+	binary.BigEndian.PutUint64(b[9:17], uint64(e.delta))
+	copy(b[refreshHeaderSize:], e.value)
+	return b
+}
+
+func decodeRefreshEntry[TKey ~string](key TKey, b []byte) (refreshEntry, error) {
+	if len(b) < refreshHeaderSize || b[0] != refreshEntryVersion {
+		return refreshEntry{}, fmt.Errorf("%w: key %q is not a refresh cache entry", ErrDecode, key)
+	}
+	return refreshEntry{
+		value:  b[refreshHeaderSize:],
+		expiry: time.Unix(0, int64(binary.BigEndian.Uint64(b[1:9]))),
+		delta:  time.Duration(binary.BigEndian.Uint64(b[9:17])),
+	}, nil
+}
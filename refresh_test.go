@@ -0,0 +1,191 @@
+package namestore_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"code.byted.org/khicago/namestore"
+	"code.byted.org/khicago/namestore/namestoretest"
+)
+
+// versionLoader returns "v1", "v2", ... on successive loads, after
+// receiving from gate if it is set.
+type versionLoader struct {
+	n    atomic.Int32
+	gate chan struct{}
+}
+
+func (l *versionLoader) load(ctx context.Context, key string) ([]byte, error) {
+	if l.gate != nil {
+		<-l.gate
+	}
+	return []byte(fmt.Sprintf("v%d", l.n.Add(1))), nil
+}
+
+func newRefreshCache(opts ...namestore.RefreshOption) (namestore.RefreshCache[string], *namestoretest.FakeClock) {
+	clock := namestoretest.NewFakeClock(time.Time{})
+	c := namestore.New[string]("app", "refresh", namestore.WithDriver[string](namestore.NewMemory(namestore.WithClock(clock))))
+	opts = append([]namestore.RefreshOption{namestore.WithRefreshClock(clock)}, opts...)
+	return namestore.NewRefreshCache(c, time.Minute, opts...), clock
+}
+
+// eventually fails the test unless cond holds within a few seconds.
+func eventually(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func expectValue(t *testing.T, cache namestore.RefreshCache[string], key string, load namestore.Loader[string], want string) {
+	t.Helper()
+	if v, err := cache.Get(context.Background(), key, load); err != nil || string(v) != want {
+		t.Fatalf("Get(%s) = %q, %v, want %q", key, v, err, want)
+	}
+}
+
+func TestRefreshCache_StaleWhileRevalidate(t *testing.T) {
+	cache, clock := newRefreshCache(namestore.WithEarlyRefresh(0), namestore.WithStaleFor(time.Hour))
+	l := &versionLoader{}
+
+	expectValue(t, cache, "k", l.load, "v1")
+	if ttl, _ := cache.Client().TTL(context.Background(), "k"); ttl != time.Minute+time.Hour {
+		t.Errorf("store TTL = %v, want fresh plus stale", ttl)
+	}
+	clock.Advance(59 * time.Second)
+	expectValue(t, cache, "k", l.load, "v1")
+	if n := l.n.Load(); n != 1 {
+		t.Fatalf("loaded %d times while fresh", n)
+	}
+
+	// Stale: served while one refresh runs.
+	l.gate = make(chan struct{})
+	clock.Advance(time.Second)
+	expectValue(t, cache, "k", l.load, "v1")
+	expectValue(t, cache, "k", l.load, "v1")
+	l.gate <- struct{}{}
+	eventually(t, func() bool {
+		v, _ := cache.Get(context.Background(), "k", l.load)
+		return string(v) == "v2"
+	})
+	close(l.gate)
+	if n := l.n.Load(); n != 2 {
+		t.Errorf("loaded %d times, want one refresh", n)
+	}
+
+	// Past the stale window, the key is loaded again in the foreground.
+	clock.Advance(2 * time.Hour)
+	expectValue(t, cache, "k", l.load, "v3")
+}
+
+func TestRefreshCache_EarlyRefresh(t *testing.T) {
+	// A load taking a second makes early refresh near certain at this beta.
+	cache, clock := newRefreshCache(namestore.WithEarlyRefresh(1e9))
+	var loads atomic.Int32
+	slow := func(ctx context.Context, key string) ([]byte, error) {
+		clock.Advance(time.Second)
+		return []byte(fmt.Sprintf("v%d", loads.Add(1))), nil
+	}
+
+	expectValue(t, cache, "slow", slow, "v1")
+	eventually(t, func() bool { return loads.Load() >= 2 })
+
+	// Values that loaded instantly, or were Set, wait for the soft expiry.
+	var fastLoads atomic.Int32
+	fast := func(ctx context.Context, key string) ([]byte, error) {
+		return []byte(fmt.Sprintf("v%d", fastLoads.Add(1))), nil
+	}
+	expectValue(t, cache, "fast", fast, "v1")
+	if err := cache.Set(context.Background(), "set", []byte("s")); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(59 * time.Second)
+	for i := 0; i < 100; i++ {
+		expectValue(t, cache, "fast", fast, "v1")
+		expectValue(t, cache, "set", fast, "s")
+	}
+	if n := fastLoads.Load(); n != 1 {
+		t.Errorf("instant loads refreshed early: %d loads", n)
+	}
+}
+
+type refreshLogger struct {
+	mu   sync.Mutex
+	msgs []string
+}
+
+func (l *refreshLogger) Info(ctx context.Context, format string, args ...interface{})  {}
+func (l *refreshLogger) Warn(ctx context.Context, format string, args ...interface{})  {}
+func (l *refreshLogger) Debug(ctx context.Context, format string, args ...interface{}) {}
+func (l *refreshLogger) Error(ctx context.Context, format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.msgs = append(l.msgs, fmt.Sprintf(format, args...))
+}
+
+func (l *refreshLogger) messages() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.msgs...)
+}
+
+func TestRefreshCache_RefreshFailures(t *testing.T) {
+	logger := &refreshLogger{}
+	cache, clock := newRefreshCache(namestore.WithEarlyRefresh(0), namestore.WithRefreshLogger(logger))
+	ctx := context.Background()
+	_ = cache.Set(ctx, "a", []byte("old"))
+	_ = cache.Set(ctx, "b", []byte("old"))
+	clock.Advance(time.Minute)
+
+	// A failed refresh keeps serving the stale value, and is logged.
+	boom := func(ctx context.Context, key string) ([]byte, error) { return nil, errors.New("boom") }
+	expectValue(t, cache, "a", boom, "old")
+	eventually(t, func() bool { return len(logger.messages()) == 1 })
+	if msg := logger.messages()[0]; !strings.Contains(msg, "refresh a failed: boom") {
+		t.Errorf("logged %q", msg)
+	}
+	expectValue(t, cache, "a", boom, "old")
+
+	// A key the loader no longer finds is removed.
+	gone := func(ctx context.Context, key string) ([]byte, error) { return nil, namestore.ErrNotFound }
+	expectValue(t, cache, "b", gone, "old")
+	eventually(t, func() bool {
+		ok, _ := cache.Client().Exists(ctx, "b")
+		return !ok
+	})
+	if _, err := cache.Get(ctx, "b", gone); !errors.Is(err, namestore.ErrNotFound) {
+		t.Errorf("Get after removal = %v", err)
+	}
+}
+
+func TestRefreshCache_Errors(t *testing.T) {
+	cache, _ := newRefreshCache()
+	ctx := context.Background()
+	boom := errors.New("boom")
+	if _, err := cache.Get(ctx, "k", func(ctx context.Context, key string) ([]byte, error) { return nil, boom }); !errors.Is(err, boom) {
+		t.Errorf("Get with failing loader = %v", err)
+	}
+
+	_ = cache.Client().Set(ctx, "raw", []byte("x"), 0)
+	if _, err := cache.Get(ctx, "raw", nil); !errors.Is(err, namestore.ErrDecode) {
+		t.Errorf("Get of a raw value = %v, want ErrDecode", err)
+	}
+
+	_ = cache.Set(ctx, "k", []byte("v"))
+	if err := cache.Delete(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := cache.Client().Exists(ctx, "k"); ok {
+		t.Error("Delete left the key")
+	}
+}
@@ -0,0 +1,605 @@
+package namestore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultReplicationQueue is the number of writes that may wait for each
+	// replica in AsyncReplication mode before writers block.
+	DefaultReplicationQueue = 1024
+	// DefaultReplicationLagWarning is the replication lag above which a
+	// warning is logged.
+	DefaultReplicationLagWarning = time.Second
+
+	// replicatedStripes is the number of locks that order writes to the same
+	// key across primary and replicas.
+	replicatedStripes = 256
+	// nearestProbeEvery sends one read in this many to the next backend in
+	// turn, so ReadNearest keeps measuring backends it does not prefer.
+	nearestProbeEvery = 16
+	// latencyDecay is the weight of the newest sample in the latency average.
+	latencyDecay = 0.2
+)
+
+// ReplicationMode selects when replicas receive writes.
+type ReplicationMode int
+
+const (
+	// SyncReplication applies each write to all replicas before returning.
+	SyncReplication ReplicationMode = iota
+	// AsyncReplication returns once the primary is written and mirrors the
+	// write to the replicas in the background, in order.
+	AsyncReplication
+)
+
+// ReadPolicy selects which backend serves reads.
+type ReadPolicy int
+
+const (
+	// ReadPrimary sends every read to the primary.
+	ReadPrimary ReadPolicy = iota
+	// ReadReplicaPreferred spreads reads over the replicas round-robin.
+	ReadReplicaPreferred
+	// ReadNearest sends reads to the backend, primary included, with the
+	// lowest average latency.
+	ReadNearest
+)
+
+// ReplicatedOption customizes the Replicated driver.
+type ReplicatedOption func(*replicatedConfig)
+
+type replicatedConfig struct {
+	mode       ReplicationMode
+	policy     ReadPolicy
+	logger     Logger
+	queue      int
+	lagWarning time.Duration
+}
+
+// WithReplicationMode sets how writes reach replicas. Defaults to
+// SyncReplication.
+func WithReplicationMode(mode ReplicationMode) ReplicatedOption {
+	return func(c *replicatedConfig) {
+		c.mode = mode
+	}
+}
+
+// WithReadPolicy sets which backend serves reads. Defaults to ReadPrimary.
+func WithReadPolicy(policy ReadPolicy) ReplicatedOption {
+	return func(c *replicatedConfig) {
+		c.policy = policy
+	}
+}
+
+// WithReplicationLogger reports replica failures, read fallbacks and
+// replication lag. If not provided, nothing is logged.
+func WithReplicationLogger(logger Logger) ReplicatedOption {
+	return func(c *replicatedConfig) {
+		if logger != nil {
+			c.logger = logger
+		}
+	}
+}
+
+// WithReplicationQueue sets the per-replica queue length for
+// AsyncReplication. Defaults to DefaultReplicationQueue. A write that finds
+// a queue full waits for room even after its context is done, so replicas
+// never miss a write the primary accepted.
+func WithReplicationQueue(n int) ReplicatedOption {
+	return func(c *replicatedConfig) {
+		if n > 0 {
+			c.queue = n
+		}
+	}
+}
+
+// WithReplicationLagWarning logs a warning whenever an asynchronous write
+// reaches a replica later than d after it was made. Defaults to
+// DefaultReplicationLagWarning.
+func WithReplicationLagWarning(d time.Duration) ReplicatedOption {
+	return func(c *replicatedConfig) {
+		if d > 0 {
+			c.lagWarning = d
+		}
+	}
+}
+
+// Replicated implements Driver by writing to a primary and mirroring every
+// successful write to replicas. Writes to the same key reach the replicas in
+// the order they reached the primary. Replicas replay the operation itself,
+// except that SetNX and CompareAndSwap are mirrored as Set when they took
+// effect, so replicas that start from the primary's state stay identical.
+//
+// A replica that fails a write is logged but does not fail the call: the
+// primary is the source of truth. Reads follow the ReadPolicy; a read that
+// fails on a replica is logged and retried on the primary. Replicas may
+// serve stale data, especially with AsyncReplication, and TTLs are relative
+// to when a write reaches each backend. Scan always uses the primary, since
+// cursors belong to one backend.
+type Replicated struct {
+	primary  Driver
+	replicas []*replica
+	cfg      replicatedConfig
+
+	stripes  [replicatedStripes]sync.Mutex
+	rr       atomic.Uint64 // round-robin position for reads
+	primaryL latency
+
+	closeMu sync.RWMutex
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+type replica struct {
+	index   int
+	driver  Driver
+	queue   chan replicaOp
+	lag     atomic.Int64 // nanoseconds, last observed
+	latency latency
+}
+
+// replicaOp is a write to mirror on a replica. A nil apply marks a Flush.
+type replicaOp struct {
+	name  string
+	apply func(ctx context.Context, d Driver) error
+	at    time.Time
+	done  chan struct{}
+}
+
+// latency is an exponentially weighted moving average of read latency.
+type latency struct {
+	bits atomic.Uint64 // float64 nanoseconds; 0 until the first sample
+}
+
+func (l *latency) observe(d time.Duration) {
+	for {
+		old := l.bits.Load()
+		avg := float64(d)
+		if old != 0 {
+			avg = latencyDecay*float64(d) + (1-latencyDecay)*math.Float64frombits(old)
+		}
+		if l.bits.CompareAndSwap(old, math.Float64bits(avg)) {
+			return
+		}
+	}
+}
+
+func (l *latency) value() float64 {
+	return math.Float64frombits(l.bits.Load())
+}
+
+// NewReplicated creates a Replicated driver. Close stops the replication
+// workers but leaves the primary and replicas open.
+func NewReplicated(primary Driver, replicas []Driver, opts ...ReplicatedOption) *Replicated {
+	cfg := replicatedConfig{
+		logger:     defaultLogger,
+		queue:      DefaultReplicationQueue,
+		lagWarning: DefaultReplicationLagWarning,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	r := &Replicated{primary: primary, cfg: cfg}
+	for i, d := range replicas {
+		rep := &replica{index: i, driver: d}
+		if cfg.mode == AsyncReplication {
+			rep.queue = make(chan replicaOp, cfg.queue)
+			r.wg.Add(1)
+			go r.run(rep)
+		}
+		r.replicas = append(r.replicas, rep)
+	}
+	return r
+}
+
+// Close flushes pending asynchronous writes and stops the workers. Writes
+// after Close return ErrClosed. It is safe to call more than once.
+func (r *Replicated) Close() error {
+	r.closeMu.Lock()
+	if !r.closed {
+		r.closed = true
+		for _, rep := range r.replicas {
+			if rep.queue != nil {
+				close(rep.queue)
+			}
+		}
+	}
+	r.closeMu.Unlock()
+	r.wg.Wait()
+	return nil
+}
+
+// Flush waits until every asynchronous write made before the call has been
+// applied to all replicas, or ctx is done. It returns at once in
+// SyncReplication mode.
+func (r *Replicated) Flush(ctx context.Context) error {
+	var pending []chan struct{}
+	r.closeMu.RLock()
+	if r.closed {
+		r.closeMu.RUnlock()
+		return ErrClosed
+	}
+	for _, rep := range r.replicas {
+		if rep.queue == nil {
+			continue
+		}
+		done := make(chan struct{})
+		select {
+		case rep.queue <- replicaOp{done: done}:
+			pending = append(pending, done)
+		case <-ctx.Done():
+			r.closeMu.RUnlock()
+			return ctx.Err()
+		}
+	}
+	r.closeMu.RUnlock()
+
+	for _, done := range pending {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// ReplicaLag returns, for each replica, how long the most recently applied
+// asynchronous write waited before reaching it.
+func (r *Replicated) ReplicaLag() []time.Duration {
+	lags := make([]time.Duration, len(r.replicas))
+	for i, rep := range r.replicas {
+		lags[i] = time.Duration(rep.lag.Load())
+	}
+	return lags
+}
+
+// run applies queued writes to one replica in order.
+func (r *Replicated) run(rep *replica) {
+	defer r.wg.Done()
+	for op := range rep.queue {
+		if op.apply == nil {
+			close(op.done)
+			continue
+		}
+		lag := time.Since(op.at)
+		rep.lag.Store(int64(lag))
+		if lag > r.cfg.lagWarning {
+			r.cfg.logger.Warn(context.Background(), "namestore: replica %d is %v behind the primary", rep.index, lag)
+		}
+		r.mirror(context.Background(), rep, op)
+	}
+}
+
+func (r *Replicated) mirror(ctx context.Context, rep *replica, op replicaOp) {
+	if err := op.apply(ctx, rep.driver); err != nil && !errors.Is(err, ErrNotFound) {
+		r.cfg.logger.Error(ctx, "namestore: replica %d: %s failed: %v", rep.index, op.name, err)
+	}
+}
+
+// stripeIndexes returns the sorted, deduplicated lock stripes of keys.
+func stripeIndexes(keys []string) []int {
+	idxs := make([]int, len(keys))
+	for i, k := range keys {
+		h := uint32(2166136261)
+		for j := 0; j < len(k); j++ {
+			h ^= uint32(k[j])
+			h *= 16777619
+		}
+		idxs[i] = int(h % replicatedStripes)
+	}
+	sort.Ints(idxs)
+	return slices.Compact(idxs)
+}
+
+// write runs op on the primary and, if it succeeds and mirror is non-nil,
+// replicates mirror. keys are the keys op touches; nil means all of them.
+// op returns the mirror to use, which lets conditional operations skip
+// replication when they had no effect.
+func (r *Replicated) write(ctx context.Context, name string, keys []string, op func() (func(context.Context, Driver) error, error)) error {
+	r.closeMu.RLock()
+	defer r.closeMu.RUnlock()
+	if r.closed {
+		return ErrClosed
+	}
+
+	var idxs []int
+	if keys == nil {
+		idxs = make([]int, replicatedStripes)
+		for i := range idxs {
+			idxs[i] = i
+		}
+	} else {
+		idxs = stripeIndexes(keys)
+	}
+	for _, i := range idxs {
+		r.stripes[i].Lock()
+	}
+	defer func() {
+		for _, i := range idxs {
+			r.stripes[i].Unlock()
+		}
+	}()
+
+	apply, err := op()
+	if err != nil || apply == nil {
+		return err
+	}
+
+	if r.cfg.mode == AsyncReplication {
+		rop := replicaOp{name: name, apply: apply, at: time.Now()}
+		for _, rep := range r.replicas {
+			// The primary has already been changed, so the write is queued
+			// even if ctx ends while waiting for room.
+			rep.queue <- rop
+		}
+		return nil
+	}
+
+	// Replicas are written without the caller's deadline: the primary has
+	// already been changed and they must follow.
+	rctx := context.WithoutCancel(ctx)
+	rop := replicaOp{name: name, apply: apply}
+	var wg sync.WaitGroup
+	for _, rep := range r.replicas {
+		wg.Add(1)
+		go func(rep *replica) {
+			defer wg.Done()
+			r.mirror(rctx, rep, rop)
+		}(rep)
+	}
+	wg.Wait()
+	return nil
+}
+
+// retain returns b, or a copy of it in AsyncReplication mode, where the
+// mirror runs after the call returns and the caller may reuse b.
+func (r *Replicated) retain(b []byte) []byte {
+	if r.cfg.mode != AsyncReplication {
+		return b
+	}
+	return bytes.Clone(b)
+}
+
+// retainPairs is retain for the pairs of MSet.
+func (r *Replicated) retainPairs(pairs map[string][]byte) map[string][]byte {
+	if r.cfg.mode != AsyncReplication {
+		return pairs
+	}
+	own := make(map[string][]byte, len(pairs))
+	for k, v := range pairs {
+		own[k] = bytes.Clone(v)
+	}
+	return own
+}
+
+// readTarget is a backend chosen for a read, or the primary if rep is nil.
+func (r *Replicated) readTarget() *replica {
+	if len(r.replicas) == 0 {
+		return nil
+	}
+	switch r.cfg.policy {
+	case ReadReplicaPreferred:
+		return r.replicas[r.rr.Add(1)%uint64(len(r.replicas))]
+	case ReadNearest:
+		n := r.rr.Add(1)
+		if n%nearestProbeEvery == 0 {
+			// Probe the backends in turn, the primary as index len(replicas).
+			if i := int(n/nearestProbeEvery) % (len(r.replicas) + 1); i < len(r.replicas) {
+				return r.replicas[i]
+			}
+			return nil
+		}
+		var best *replica
+		bestLatency := r.primaryL.value()
+		for _, rep := range r.replicas {
+			if l := rep.latency.value(); l < bestLatency {
+				best, bestLatency = rep, l
+			}
+		}
+		return best
+	default:
+		return nil
+	}
+}
+
+// read runs fn on the backend chosen by the read policy, falling back to
+// the primary if a replica fails with anything but ErrNotFound.
+func read[T any](ctx context.Context, r *Replicated, name string, fn func(Driver) (T, error)) (T, error) {
+	rep := r.readTarget()
+	if rep == nil {
+		start := time.Now()
+		v, err := fn(r.primary)
+		r.primaryL.observe(time.Since(start))
+		return v, err
+	}
+
+	start := time.Now()
+	v, err := fn(rep.driver)
+	rep.latency.observe(time.Since(start))
+	if err == nil || errors.Is(err, ErrNotFound) {
+		return v, err
+	}
+	r.cfg.logger.Warn(ctx, "namestore: replica %d: %s failed, reading from primary: %v", rep.index, name, err)
+	return fn(r.primary)
+}
+
+func (r *Replicated) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return r.write(ctx, "Set", []string{key}, func() (func(context.Context, Driver) error, error) {
+		mirrored := r.retain(value)
+		return func(ctx context.Context, d Driver) error {
+			return d.Set(ctx, key, mirrored, ttl)
+		}, r.primary.Set(ctx, key, value, ttl)
+	})
+}
+
+func (r *Replicated) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	var ok bool
+	err := r.write(ctx, "SetNX", []string{key}, func() (func(context.Context, Driver) error, error) {
+		var err error
+		if ok, err = r.primary.SetNX(ctx, key, value, ttl); !ok || err != nil {
+			return nil, err
+		}
+		mirrored := r.retain(value)
+		return func(ctx context.Context, d Driver) error {
+			return d.Set(ctx, key, mirrored, ttl)
+		}, nil
+	})
+	return ok, err
+}
+
+func (r *Replicated) Get(ctx context.Context, key string) ([]byte, error) {
+	return read(ctx, r, "Get", func(d Driver) ([]byte, error) {
+		return d.Get(ctx, key)
+	})
+}
+
+func (r *Replicated) Delete(ctx context.Context, key string) error {
+	return r.write(ctx, "Delete", []string{key}, func() (func(context.Context, Driver) error, error) {
+		return func(ctx context.Context, d Driver) error {
+			return d.Delete(ctx, key)
+		}, r.primary.Delete(ctx, key)
+	})
+}
+
+func (r *Replicated) Exists(ctx context.Context, key string) (bool, error) {
+	return read(ctx, r, "Exists", func(d Driver) (bool, error) {
+		return d.Exists(ctx, key)
+	})
+}
+
+func (r *Replicated) MGet(ctx context.Context, keys []string) (map[string][]byte, error) {
+	return read(ctx, r, "MGet", func(d Driver) (map[string][]byte, error) {
+		return d.MGet(ctx, keys)
+	})
+}
+
+func (r *Replicated) MSet(ctx context.Context, pairs map[string][]byte, ttl time.Duration) error {
+	keys := make([]string, 0, len(pairs))
+	for k := range pairs {
+		keys = append(keys, k)
+	}
+	return r.write(ctx, "MSet", keys, func() (func(context.Context, Driver) error, error) {
+		mirrored := r.retainPairs(pairs)
+		return func(ctx context.Context, d Driver) error {
+			return d.MSet(ctx, mirrored, ttl)
+		}, r.primary.MSet(ctx, pairs, ttl)
+	})
+}
+
+func (r *Replicated) MDel(ctx context.Context, keys []string) error {
+	return r.write(ctx, "MDel", keys, func() (func(context.Context, Driver) error, error) {
+		mirrored := keys
+		if r.cfg.mode == AsyncReplication {
+			mirrored = slices.Clone(keys)
+		}
+		return func(ctx context.Context, d Driver) error {
+			return d.MDel(ctx, mirrored)
+		}, r.primary.MDel(ctx, keys)
+	})
+}
+
+func (r *Replicated) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return read(ctx, r, "TTL", func(d Driver) (time.Duration, error) {
+		return d.TTL(ctx, key)
+	})
+}
+
+func (r *Replicated) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	return r.write(ctx, "Expire", []string{key}, func() (func(context.Context, Driver) error, error) {
+		return func(ctx context.Context, d Driver) error {
+			return d.Expire(ctx, key, ttl)
+		}, r.primary.Expire(ctx, key, ttl)
+	})
+}
+
+func (r *Replicated) Persist(ctx context.Context, key string) error {
+	return r.write(ctx, "Persist", []string{key}, func() (func(context.Context, Driver) error, error) {
+		return func(ctx context.Context, d Driver) error {
+			return d.Persist(ctx, key)
+		}, r.primary.Persist(ctx, key)
+	})
+}
+
+func (r *Replicated) Keys(ctx context.Context, prefix, pattern string) ([]string, error) {
+	return read(ctx, r, "Keys", func(d Driver) ([]string, error) {
+		return d.Keys(ctx, prefix, pattern)
+	})
+}
+
+// Scan always reads from the primary.
+func (r *Replicated) Scan(ctx context.Context, prefix, pattern string, cursor uint64, count int) ([]string, uint64, error) {
+	return r.primary.Scan(ctx, prefix, pattern, cursor, count)
+}
+
+func (r *Replicated) Clear(ctx context.Context, prefix string) error {
+	return r.write(ctx, "Clear", nil, func() (func(context.Context, Driver) error, error) {
+		return func(ctx context.Context, d Driver) error {
+			return d.Clear(ctx, prefix)
+		}, r.primary.Clear(ctx, prefix)
+	})
+}
+
+func (r *Replicated) Incr(ctx context.Context, key string, delta int64) (int64, error) {
+	var v int64
+	err := r.write(ctx, "Incr", []string{key}, func() (func(context.Context, Driver) error, error) {
+		var err error
+		v, err = r.primary.Incr(ctx, key, delta)
+		return func(ctx context.Context, d Driver) error {
+			_, err := d.Incr(ctx, key, delta)
+			return err
+		}, err
+	})
+	return v, err
+}
+
+func (r *Replicated) Decr(ctx context.Context, key string, delta int64) (int64, error) {
+	return r.Incr(ctx, key, -delta)
+}
+
+// GetSet returns ErrNotFound from the primary when the key was missing,
+// but the write still happened and is replicated.
+func (r *Replicated) GetSet(ctx context.Context, key string, value []byte) ([]byte, error) {
+	var old []byte
+	var getErr error
+	err := r.write(ctx, "GetSet", []string{key}, func() (func(context.Context, Driver) error, error) {
+		old, getErr = r.primary.GetSet(ctx, key, value)
+		if getErr != nil && !errors.Is(getErr, ErrNotFound) {
+			return nil, getErr
+		}
+		mirrored := r.retain(value)
+		return func(ctx context.Context, d Driver) error {
+			_, err := d.GetSet(ctx, key, mirrored)
+			return err
+		}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return old, getErr
+}
+
+func (r *Replicated) CompareAndSwap(ctx context.Context, key string, oldValue, newValue []byte, ttl time.Duration) (bool, error) {
+	var swapped bool
+	err := r.write(ctx, "CompareAndSwap", []string{key}, func() (func(context.Context, Driver) error, error) {
+		var err error
+		if swapped, err = r.primary.CompareAndSwap(ctx, key, oldValue, newValue, ttl); !swapped || err != nil {
+			return nil, err
+		}
+		mirrored := r.retain(newValue)
+		return func(ctx context.Context, d Driver) error {
+			return d.Set(ctx, key, mirrored, ttl)
+		}, nil
+	})
+	return swapped, err
+}
@@ -0,0 +1,326 @@
+package namestore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// slowDriver delays and counts reads of the wrapped Memory.
+type slowDriver struct {
+	*Memory
+	delay time.Duration
+	reads atomic.Int64
+}
+
+func (d *slowDriver) Get(ctx context.Context, key string) ([]byte, error) {
+	d.reads.Add(1)
+	time.Sleep(d.delay)
+	return d.Memory.Get(ctx, key)
+}
+
+// memoryContents returns the live values of m, for comparing backends.
+func memoryContents(m *Memory) map[string]string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	now := m.cfg.now()
+	contents := make(map[string]string, len(m.data))
+	for k, e := range m.data {
+		if !e.expiredAt(now) {
+			contents[k] = string(e.value)
+		}
+	}
+	return contents
+}
+
+func newMemories(n int) ([]*Memory, []Driver) {
+	mems := make([]*Memory, n)
+	drivers := make([]Driver, n)
+	for i := range mems {
+		mems[i] = NewMemory().(*Memory)
+		drivers[i] = mems[i]
+	}
+	return mems, drivers
+}
+
+func exerciseWrites(t *testing.T, d Driver) {
+	t.Helper()
+	ctx := context.Background()
+
+	_ = d.Set(ctx, "app:a", []byte("1"), 0)
+	_ = d.Set(ctx, "app:gone", []byte("x"), 0)
+	_ = d.Delete(ctx, "app:gone")
+	_ = d.MSet(ctx, map[string][]byte{"app:m1": []byte("m1"), "app:m2": []byte("m2")}, time.Hour)
+	_ = d.MDel(ctx, []string{"app:m2"})
+	_, _ = d.Incr(ctx, "app:n", 5)
+	_, _ = d.Decr(ctx, "app:n", 2)
+	if ok, _ := d.SetNX(ctx, "app:nx", []byte("first"), 0); !ok {
+		t.Error("SetNX should succeed")
+	}
+	_, _ = d.SetNX(ctx, "app:nx", []byte("second"), 0)
+	_, _ = d.GetSet(ctx, "app:a", []byte("2"))
+	if _, err := d.GetSet(ctx, "app:new", []byte("g")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetSet on a missing key: %v", err)
+	}
+	_, _ = d.CompareAndSwap(ctx, "app:m1", []byte("m1"), []byte("swapped"), 0)
+	_, _ = d.CompareAndSwap(ctx, "app:m1", []byte("nope"), []byte("ignored"), 0)
+	_ = d.Persist(ctx, "app:m1")
+	_ = d.Expire(ctx, "app:a", time.Hour)
+	_ = d.Set(ctx, "tmp:1", []byte("t"), 0)
+	_ = d.Clear(ctx, "tmp")
+}
+
+func TestReplicated_SyncMirrorsWrites(t *testing.T) {
+	mems, drivers := newMemories(3)
+	r := NewReplicated(drivers[0], drivers[1:])
+	defer r.Close()
+
+	exerciseWrites(t, r)
+
+	want := memoryContents(mems[0])
+	if len(want) != 5 {
+		t.Errorf("primary holds %v", want)
+	}
+	for i, m := range mems[1:] {
+		if got := memoryContents(m); !reflect.DeepEqual(got, want) {
+			t.Errorf("replica %d = %v, want %v", i, got, want)
+		}
+	}
+	if ttl, _ := mems[2].TTL(context.Background(), "app:a"); ttl <= 0 {
+		t.Errorf("replica TTL = %v, want Expire to be mirrored", ttl)
+	}
+}
+
+func TestReplicated_AsyncMirrorsWritesInOrder(t *testing.T) {
+	mems, drivers := newMemories(3)
+	r := NewReplicated(drivers[0], drivers[1:], WithReplicationMode(AsyncReplication), WithReplicationQueue(8))
+	defer r.Close()
+	ctx := context.Background()
+
+	exerciseWrites(t, r)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				key := fmt.Sprintf("race:%d", i%5)
+				_ = r.Set(ctx, key, []byte(fmt.Sprint(g, i)), 0)
+				_, _ = r.Incr(ctx, "race:counter", 1)
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if err := r.Flush(ctx); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	want := memoryContents(mems[0])
+	for i, m := range mems[1:] {
+		if got := memoryContents(m); !reflect.DeepEqual(got, want) {
+			t.Errorf("replica %d diverged from the primary", i)
+		}
+	}
+	if n, _ := mems[1].Incr(ctx, "race:counter", 0); n != 800 {
+		t.Errorf("replica counter = %d, want 800", n)
+	}
+}
+
+func TestReplicated_ReadPolicies(t *testing.T) {
+	ctx := context.Background()
+	primary := &slowDriver{Memory: NewMemory().(*Memory)}
+	replicas := []*slowDriver{{Memory: NewMemory().(*Memory)}, {Memory: NewMemory().(*Memory)}}
+
+	for _, tt := range []struct {
+		policy      ReadPolicy
+		wantPrimary bool
+	}{
+		{ReadPrimary, true},
+		{ReadReplicaPreferred, false},
+	} {
+		r := NewReplicated(primary, []Driver{replicas[0], replicas[1]}, WithReadPolicy(tt.policy))
+		_ = r.Set(ctx, "k", []byte("v"), 0)
+		primary.reads.Store(0)
+		replicas[0].reads.Store(0)
+		replicas[1].reads.Store(0)
+
+		for i := 0; i < 10; i++ {
+			if got, err := r.Get(ctx, "k"); err != nil || string(got) != "v" {
+				t.Fatalf("policy %d: Get = %q, %v", tt.policy, got, err)
+			}
+		}
+		if tt.wantPrimary && primary.reads.Load() != 10 {
+			t.Errorf("ReadPrimary: primary served %d of 10 reads", primary.reads.Load())
+		}
+		if !tt.wantPrimary && (primary.reads.Load() != 0 || replicas[0].reads.Load() != 5 || replicas[1].reads.Load() != 5) {
+			t.Errorf("ReadReplicaPreferred: reads %d/%d/%d", primary.reads.Load(), replicas[0].reads.Load(), replicas[1].reads.Load())
+		}
+		_ = r.Close()
+	}
+}
+
+func TestReplicated_ReadNearest(t *testing.T) {
+	ctx := context.Background()
+	primary := &slowDriver{Memory: NewMemory().(*Memory), delay: 2 * time.Millisecond}
+	slow := &slowDriver{Memory: NewMemory().(*Memory), delay: 2 * time.Millisecond}
+	fast := &slowDriver{Memory: NewMemory().(*Memory)}
+	r := NewReplicated(primary, []Driver{slow, fast}, WithReadPolicy(ReadNearest))
+	defer r.Close()
+
+	_ = r.Set(ctx, "k", []byte("v"), 0)
+	for i := 0; i < 100; i++ {
+		_, _ = r.Get(ctx, "k")
+	}
+	if n := fast.reads.Load(); n < 80 {
+		t.Errorf("fastest backend served %d of 100 reads (primary %d, slow %d)", n, primary.reads.Load(), slow.reads.Load())
+	}
+	if slow.reads.Load() == 0 || primary.reads.Load() == 0 {
+		t.Error("slower backends should still be probed")
+	}
+}
+
+func TestReplicated_ReplicaFailures(t *testing.T) {
+	ctx := context.Background()
+	logger := &mockLogger{}
+	primary := NewMemory()
+	r := NewReplicated(primary, []Driver{&errorDriver{}}, WithReadPolicy(ReadReplicaPreferred), WithReplicationLogger(logger))
+	defer r.Close()
+
+	if err := r.Set(ctx, "k", []byte("v"), 0); err != nil {
+		t.Fatalf("a failing replica must not fail the write: %v", err)
+	}
+	if !logger.contains("replica 0: Set failed") {
+		t.Errorf("replica failure not logged: %v", logger.getMessages())
+	}
+
+	if got, err := r.Get(ctx, "k"); err != nil || string(got) != "v" {
+		t.Errorf("Get = %q, %v, want fallback to the primary", got, err)
+	}
+	if !logger.contains("reading from primary") {
+		t.Errorf("fallback not logged: %v", logger.getMessages())
+	}
+}
+
+func TestReplicated_LagWarning(t *testing.T) {
+	logger := &mockLogger{}
+	_, drivers := newMemories(2)
+	r := NewReplicated(drivers[0], drivers[1:],
+		WithReplicationMode(AsyncReplication),
+		WithReplicationLagWarning(time.Nanosecond),
+		WithReplicationLogger(logger))
+	ctx := context.Background()
+
+	_ = r.Set(ctx, "k", []byte("v"), 0)
+	_ = r.Flush(ctx)
+	if !logger.contains("behind the primary") {
+		t.Errorf("lag not logged: %v", logger.getMessages())
+	}
+	if lag := r.ReplicaLag(); len(lag) != 1 || lag[0] <= 0 {
+		t.Errorf("ReplicaLag = %v", lag)
+	}
+
+	_ = r.Close()
+	if err := r.Set(ctx, "k", nil, 0); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+	if err := r.Flush(ctx); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed from Flush, got %v", err)
+	}
+}
+
+func TestReplicated_PrimaryErrors(t *testing.T) {
+	_, drivers := newMemories(1)
+	r := NewReplicated(&errorDriver{}, drivers)
+	defer r.Close()
+	ctx := context.Background()
+
+	if err := r.Set(ctx, "k", []byte("v"), 0); !errors.Is(err, errMockSet) {
+		t.Errorf("expected the primary's error, got %v", err)
+	}
+	if exists, _ := drivers[0].Exists(ctx, "k"); exists {
+		t.Error("failed writes must not be replicated")
+	}
+}
+
+// gatedDriver holds writes to the wrapped Memory until gate is closed.
+type gatedDriver struct {
+	*Memory
+	gate chan struct{}
+}
+
+func (d *gatedDriver) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	<-d.gate
+	return d.Memory.Set(ctx, key, value, ttl)
+}
+
+func (d *gatedDriver) MSet(ctx context.Context, pairs map[string][]byte, ttl time.Duration) error {
+	<-d.gate
+	return d.Memory.MSet(ctx, pairs, ttl)
+}
+
+func TestReplicated_AsyncCopiesValues(t *testing.T) {
+	mems, drivers := newMemories(2)
+	replica := &gatedDriver{Memory: mems[1], gate: make(chan struct{})}
+	r := NewReplicated(drivers[0], []Driver{replica}, WithReplicationMode(AsyncReplication))
+	defer r.Close()
+	ctx := context.Background()
+
+	buf := []byte("one")
+	_ = r.Set(ctx, "app:set", buf, 0)
+	copy(buf, "bad")
+	buf = []byte("two")
+	_, _ = r.SetNX(ctx, "app:nx", buf, 0)
+	copy(buf, "bad")
+	buf = []byte("old")
+	_ = r.Set(ctx, "app:cas", buf, 0)
+	swap := []byte("new")
+	_, _ = r.CompareAndSwap(ctx, "app:cas", buf, swap, 0)
+	copy(swap, "bad")
+	pairs := map[string][]byte{"app:m": []byte("m")}
+	_ = r.MSet(ctx, pairs, 0)
+	copy(pairs["app:m"], "x")
+	pairs["app:other"] = []byte("o")
+
+	close(replica.gate)
+	if err := r.Flush(ctx); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	want := map[string]string{"app:set": "one", "app:nx": "two", "app:cas": "new", "app:m": "m"}
+	if got := memoryContents(mems[1]); !reflect.DeepEqual(got, want) {
+		t.Errorf("replica = %v, want %v", got, want)
+	}
+}
+
+func TestReplicated_AsyncQueuesAfterCancel(t *testing.T) {
+	mems, drivers := newMemories(2)
+	replica := &gatedDriver{Memory: mems[1], gate: make(chan struct{})}
+	r := NewReplicated(drivers[0], []Driver{replica}, WithReplicationMode(AsyncReplication), WithReplicationQueue(1))
+	defer r.Close()
+
+	// The worker holds the first write and the queue holds the second, so
+	// the third waits for room after its context is canceled.
+	_ = r.Set(context.Background(), "app:1", []byte("1"), 0)
+	_ = r.Set(context.Background(), "app:2", []byte("2"), 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- r.Set(ctx, "app:3", []byte("3"), 0) }()
+	cancel()
+	time.Sleep(10 * time.Millisecond)
+	close(replica.gate)
+	if err := <-done; err != nil {
+		t.Fatalf("Set = %v", err)
+	}
+
+	if err := r.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if got, want := memoryContents(mems[1]), memoryContents(mems[0]); !reflect.DeepEqual(got, want) {
+		t.Errorf("replica = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,147 @@
+package namestore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"time"
+)
+
+const (
+	// DefaultRetryAttempts is the number of times Retry runs an operation,
+	// including the first.
+	DefaultRetryAttempts = 3
+	// DefaultRetryBaseDelay and DefaultRetryMaxDelay bound the backoff
+	// between attempts.
+	DefaultRetryBaseDelay = 20 * time.Millisecond
+	DefaultRetryMaxDelay  = time.Second
+)
+
+// RetryOption customizes the Retry middleware.
+type RetryOption func(*retryConfig)
+
+type retryConfig struct {
+	attempts  int
+	base, max time.Duration
+	retryable func(error) bool
+}
+
+// WithRetryAttempts sets how many times an operation runs at most, including
+// the first attempt. Defaults to DefaultRetryAttempts.
+func WithRetryAttempts(n int) RetryOption {
+	return func(c *retryConfig) {
+		if n > 0 {
+			c.attempts = n
+		}
+	}
+}
+
+// WithRetryBackoff sets the delay before the second attempt, which doubles
+// for each further attempt up to max. Each delay is jittered to between half
+// and all of its value. Defaults to DefaultRetryBaseDelay and
+// DefaultRetryMaxDelay.
+func WithRetryBackoff(base, max time.Duration) RetryOption {
+	return func(c *retryConfig) {
+		if base > 0 {
+			c.base = base
+		}
+		if max >= c.base {
+			c.max = max
+		}
+	}
+}
+
+// WithRetryIf sets the classifier deciding which errors are retried.
+// Defaults to IsTransient.
+func WithRetryIf(retryable func(error) bool) RetryOption {
+	return func(c *retryConfig) {
+		if retryable != nil {
+			c.retryable = retryable
+		}
+	}
+}
+
+// IsTransient reports whether err looks like a temporary failure to reach
+// the store, such as a network error or a connection closed mid-reply. It
+// is false for nil and for the package's sentinel errors, which describe the
+// data rather than the connection.
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne)
+}
+
+// Retry returns a Middleware that runs failed operations again when the
+// error is retryable, waiting with exponential backoff between attempts:
+//
+//	client := namestore.New[string]("myapp", "users",
+//	    namestore.WithDriver[string](redis),
+//	    namestore.WithMiddleware[string](namestore.Retry(namestore.WithRetryAttempts(5))),
+//	)
+//
+// Operations that are not idempotent (see OpInfo.Idempotent) are only
+// retried after a failed dial, when the request cannot have reached the
+// store. Retry gives up early, returning the last error, when ctx is done or
+// its deadline would pass during the next backoff. ErrCircuitOpen is never
+// retried.
+func Retry(opts ...RetryOption) Middleware {
+	cfg := retryConfig{
+		attempts:  DefaultRetryAttempts,
+		base:      DefaultRetryBaseDelay,
+		max:       DefaultRetryMaxDelay,
+		retryable: IsTransient,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return Intercept(cfg.intercept)
+}
+
+func (c retryConfig) intercept(ctx context.Context, op OpInfo, next func(context.Context) error) error {
+	for attempt := 1; ; attempt++ {
+		err := next(ctx)
+		if err == nil || attempt >= c.attempts || ctx.Err() != nil ||
+			errors.Is(err, ErrCircuitOpen) || !c.retryable(err) ||
+			(!op.Idempotent() && !dialFailed(err)) {
+			return err
+		}
+
+		delay := c.backoff(attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return err
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// backoff returns the jittered delay after the given attempt.
+func (c retryConfig) backoff(attempt int) time.Duration {
+	d := c.base
+	for i := 1; i < attempt && d < c.max; i++ {
+		d *= 2
+	}
+	if d > c.max {
+		d = c.max
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// dialFailed reports whether err comes from failing to connect, before any
+// request was sent.
+func dialFailed(err error) bool {
+	var op *net.OpError
+	return errors.As(err, &op) && op.Op == "dial"
+}
@@ -0,0 +1,157 @@
+package namestore_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"code.byted.org/khicago/namestore"
+)
+
+// failingDriver fails the next n calls of every method it overrides with
+// err, then forwards to the wrapped driver.
+type failingDriver struct {
+	namestore.DriverBase
+	n     int
+	err   error
+	calls int
+}
+
+func (d *failingDriver) fail() error {
+	d.calls++
+	if d.n > 0 {
+		d.n--
+		return d.err
+	}
+	return nil
+}
+
+func (d *failingDriver) Get(ctx context.Context, key string) ([]byte, error) {
+	if err := d.fail(); err != nil {
+		return nil, err
+	}
+	return d.Next.Get(ctx, key)
+}
+
+func (d *failingDriver) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if err := d.fail(); err != nil {
+		return err
+	}
+	return d.Next.Set(ctx, key, value, ttl)
+}
+
+func (d *failingDriver) Incr(ctx context.Context, key string, delta int64) (int64, error) {
+	if err := d.fail(); err != nil {
+		return 0, err
+	}
+	return d.Next.Incr(ctx, key, delta)
+}
+
+var (
+	errReset = fmt.Errorf("namestore: redis: %w", &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")})
+	errDial  = fmt.Errorf("namestore: redis dial: %w", &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")})
+)
+
+func retrying(d *failingDriver, opts ...namestore.RetryOption) namestore.Client[string] {
+	opts = append([]namestore.RetryOption{namestore.WithRetryBackoff(time.Millisecond, 4*time.Millisecond)}, opts...)
+	return namestore.New[string]("app", "users",
+		namestore.WithDriver[string](d),
+		namestore.WithMiddleware[string](namestore.Retry(opts...)))
+}
+
+func TestRetry_TransientErrors(t *testing.T) {
+	ctx := context.Background()
+	d := &failingDriver{DriverBase: namestore.DriverBase{Next: namestore.NewMemory()}, n: 2, err: errReset}
+	c := retrying(d)
+
+	if err := c.Set(ctx, "1", []byte("v"), 0); err != nil || d.calls != 3 {
+		t.Fatalf("Set = %v after %d calls, want success on the third", err, d.calls)
+	}
+
+	d.calls, d.n = 0, 5
+	if _, err := c.Get(ctx, "1"); !errors.Is(err, errReset) || d.calls != namestore.DefaultRetryAttempts {
+		t.Errorf("Get = %v after %d calls, want the last error after %d", err, d.calls, namestore.DefaultRetryAttempts)
+	}
+
+	// Not retried: data errors, a custom classifier's rejects.
+	d.calls, d.n = 0, 0
+	if _, err := c.Get(ctx, "missing"); !errors.Is(err, namestore.ErrNotFound) || d.calls != 1 {
+		t.Errorf("Get missing = %v after %d calls", err, d.calls)
+	}
+	d.calls, d.n = 0, 1
+	c = retrying(d, namestore.WithRetryIf(func(err error) bool { return false }))
+	if _, err := c.Get(ctx, "1"); !errors.Is(err, errReset) || d.calls != 1 {
+		t.Errorf("Get with WithRetryIf = %v after %d calls", err, d.calls)
+	}
+
+	d.calls, d.n = 0, 1
+	c = retrying(d, namestore.WithRetryAttempts(1))
+	if _, err := c.Get(ctx, "1"); !errors.Is(err, errReset) || d.calls != 1 {
+		t.Errorf("Get with one attempt = %v after %d calls", err, d.calls)
+	}
+}
+
+func TestRetry_NonIdempotent(t *testing.T) {
+	ctx := context.Background()
+	d := &failingDriver{DriverBase: namestore.DriverBase{Next: namestore.NewMemory()}, n: 1, err: errReset}
+	c := retrying(d)
+
+	// The reset may have come after the store applied the increment.
+	if _, err := c.Incr(ctx, "n", 1); !errors.Is(err, errReset) || d.calls != 1 {
+		t.Errorf("Incr = %v after %d calls, want no retry", err, d.calls)
+	}
+
+	// A failed dial sent nothing.
+	d.calls, d.n, d.err = 0, 1, errDial
+	if n, err := c.Incr(ctx, "n", 1); err != nil || n != 1 || d.calls != 2 {
+		t.Errorf("Incr = %d, %v after %d calls, want a retry after the dial error", n, err, d.calls)
+	}
+}
+
+func TestRetry_Context(t *testing.T) {
+	d := &failingDriver{DriverBase: namestore.DriverBase{Next: namestore.NewMemory()}, n: 10, err: errReset}
+	c := namestore.New[string]("app", "users",
+		namestore.WithDriver[string](d),
+		namestore.WithMiddleware[string](namestore.Retry(namestore.WithRetryAttempts(10), namestore.WithRetryBackoff(time.Hour, time.Hour))))
+
+	// The first backoff would outlive the deadline.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	start := time.Now()
+	if _, err := c.Get(ctx, "1"); !errors.Is(err, errReset) || d.calls != 1 || time.Since(start) > time.Second {
+		t.Errorf("Get = %v after %d calls and %v", err, d.calls, time.Since(start))
+	}
+
+	// Canceled while waiting.
+	d.calls = 0
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if _, err := c.Get(ctx, "1"); !errors.Is(err, errReset) || d.calls != 1 {
+		t.Errorf("Get = %v after %d calls", err, d.calls)
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{namestore.ErrNotFound, false},
+		{namestore.ErrTypeMismatch, false},
+		{namestore.ErrCircuitOpen, false},
+		{errors.New("boom"), false},
+		{errReset, true},
+		{errDial, true},
+		{fmt.Errorf("namestore: redis: %w", io.EOF), true},
+		{io.ErrUnexpectedEOF, true},
+	} {
+		if got := namestore.IsTransient(tt.err); got != tt.want {
+			t.Errorf("IsTransient(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
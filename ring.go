@@ -0,0 +1,342 @@
+package namestore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultRingReplicas is the number of virtual nodes per unit of weight.
+const DefaultRingReplicas = 128
+
+// ringScanShift places the node index in the high bits of a Scan cursor,
+// above the backend's own cursor.
+const ringScanShift = 48
+
+// RingNode is a backend of a Ring. Name identifies the node on the ring and
+// must stay the same across restarts and processes, since it determines
+// which keys the node owns. Weight scales the share of keys it receives;
+// values below 1 count as 1.
+type RingNode struct {
+	Name   string
+	Driver Driver
+	Weight int
+}
+
+// RingOption customizes the Ring driver.
+type RingOption func(*ringConfig)
+
+type ringConfig struct {
+	replicas int
+}
+
+// WithRingReplicas sets the number of virtual nodes per unit of weight.
+// More virtual nodes spread keys more evenly at the cost of a larger ring.
+// Defaults to DefaultRingReplicas.
+func WithRingReplicas(n int) RingOption {
+	return func(c *ringConfig) {
+		if n > 0 {
+			c.replicas = n
+		}
+	}
+}
+
+// Ring implements Driver by spreading keys over several backends with
+// consistent hashing, so adding or removing a node only moves the keys it
+// gains or loses. Single-key operations go to the owning node. MGet, MSet
+// and MDel are split by node and run in parallel; they are atomic per node
+// only. Keys and Clear fan out to every node, and Scan walks the nodes in
+// turn.
+//
+// A key containing a hash tag, a non-empty substring in braces such as
+// "{user:1}", is placed by the tag alone, so "{user:1}:profile" and
+// "{user:1}:settings" always share a node. As in Redis Cluster, the tag is
+// the text between the first '{' and the next '}'.
+type Ring struct {
+	nodes  []RingNode
+	points []ringPoint // sorted by hash
+}
+
+type ringPoint struct {
+	hash uint64
+	node int
+}
+
+// NewRing creates a Ring over nodes. Node names must be unique and
+// non-empty.
+func NewRing(nodes []RingNode, opts ...RingOption) (*Ring, error) {
+	cfg := ringConfig{replicas: DefaultRingReplicas}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if len(nodes) == 0 {
+		return nil, errors.New("namestore: ring needs at least one node")
+	}
+
+	r := &Ring{nodes: append([]RingNode(nil), nodes...)}
+	seen := make(map[string]bool, len(nodes))
+	for i, n := range r.nodes {
+		if n.Name == "" || n.Driver == nil {
+			return nil, fmt.Errorf("namestore: ring node %d needs a name and a driver", i)
+		}
+		if seen[n.Name] {
+			return nil, fmt.Errorf("namestore: duplicate ring node %q", n.Name)
+		}
+		seen[n.Name] = true
+
+		for v := 0; v < cfg.replicas*max(n.Weight, 1); v++ {
+			r.points = append(r.points, ringPoint{hash: ringHash(n.Name + "#" + strconv.Itoa(v)), node: i})
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool {
+		a, b := r.points[i], r.points[j]
+		// Break ties by node so placement does not depend on input order.
+		return a.hash < b.hash || a.hash == b.hash && r.nodes[a.node].Name < r.nodes[b.node].Name
+	})
+	return r, nil
+}
+
+// ringHash is 64-bit FNV-1a followed by the SplitMix64 finalizer, which
+// spreads the similar strings used for virtual nodes across the ring.
+func ringHash(s string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(s); i++ {
+		h ^= uint64(s[i])
+		h *= 1099511628211
+	}
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+	return h
+}
+
+// hashTag returns the part of key that determines its placement.
+func hashTag(key string) string {
+	if i := strings.IndexByte(key, '{'); i >= 0 {
+		if j := strings.IndexByte(key[i+1:], '}'); j > 0 {
+			return key[i+1 : i+1+j]
+		}
+	}
+	return key
+}
+
+func (r *Ring) index(key string) int {
+	h := ringHash(hashTag(key))
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i].hash >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.points[i].node
+}
+
+func (r *Ring) driver(key string) Driver {
+	return r.nodes[r.index(key)].Driver
+}
+
+// Locate returns the name of the node that owns key.
+func (r *Ring) Locate(key string) string {
+	return r.nodes[r.index(key)].Name
+}
+
+// group splits keys by owning node.
+func (r *Ring) group(keys []string) map[int][]string {
+	groups := make(map[int][]string)
+	for _, k := range keys {
+		i := r.index(k)
+		groups[i] = append(groups[i], k)
+	}
+	return groups
+}
+
+// parallel runs fn for each node index concurrently and joins the errors.
+func parallel(idxs []int, fn func(i int) error) error {
+	if len(idxs) == 1 {
+		return fn(idxs[0])
+	}
+
+	errs := make([]error, len(idxs))
+	var wg sync.WaitGroup
+	for n, i := range idxs {
+		wg.Add(1)
+		go func(n, i int) {
+			defer wg.Done()
+			errs[n] = fn(i)
+		}(n, i)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+func (r *Ring) all() []int {
+	idxs := make([]int, len(r.nodes))
+	for i := range idxs {
+		idxs[i] = i
+	}
+	return idxs
+}
+
+func nodeIndexes[V any](groups map[int]V) []int {
+	idxs := make([]int, 0, len(groups))
+	for i := range groups {
+		idxs = append(idxs, i)
+	}
+	return idxs
+}
+
+func (r *Ring) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return r.driver(key).Set(ctx, key, value, ttl)
+}
+
+func (r *Ring) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	return r.driver(key).SetNX(ctx, key, value, ttl)
+}
+
+func (r *Ring) Get(ctx context.Context, key string) ([]byte, error) {
+	return r.driver(key).Get(ctx, key)
+}
+
+func (r *Ring) Delete(ctx context.Context, key string) error {
+	return r.driver(key).Delete(ctx, key)
+}
+
+func (r *Ring) Exists(ctx context.Context, key string) (bool, error) {
+	return r.driver(key).Exists(ctx, key)
+}
+
+// MGet fetches the keys of every node in parallel and merges the results.
+func (r *Ring) MGet(ctx context.Context, keys []string) (map[string][]byte, error) {
+	groups := r.group(keys)
+	var mu sync.Mutex
+	result := make(map[string][]byte, len(keys))
+	err := parallel(nodeIndexes(groups), func(i int) error {
+		got, err := r.nodes[i].Driver.MGet(ctx, groups[i])
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for k, v := range got {
+			result[k] = v
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// MSet writes the pairs of every node in parallel. It is atomic per node: if
+// one node fails, the others may still have been written.
+func (r *Ring) MSet(ctx context.Context, pairs map[string][]byte, ttl time.Duration) error {
+	groups := make(map[int]map[string][]byte)
+	for k, v := range pairs {
+		i := r.index(k)
+		if groups[i] == nil {
+			groups[i] = make(map[string][]byte)
+		}
+		groups[i][k] = v
+	}
+	return parallel(nodeIndexes(groups), func(i int) error {
+		return r.nodes[i].Driver.MSet(ctx, groups[i], ttl)
+	})
+}
+
+// MDel deletes the keys of every node in parallel, atomically per node.
+func (r *Ring) MDel(ctx context.Context, keys []string) error {
+	groups := r.group(keys)
+	return parallel(nodeIndexes(groups), func(i int) error {
+		return r.nodes[i].Driver.MDel(ctx, groups[i])
+	})
+}
+
+func (r *Ring) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return r.driver(key).TTL(ctx, key)
+}
+
+func (r *Ring) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	return r.driver(key).Expire(ctx, key, ttl)
+}
+
+func (r *Ring) Persist(ctx context.Context, key string) error {
+	return r.driver(key).Persist(ctx, key)
+}
+
+// Keys queries every node in parallel and merges the results.
+func (r *Ring) Keys(ctx context.Context, prefix, pattern string) ([]string, error) {
+	results := make([][]string, len(r.nodes))
+	err := parallel(r.all(), func(i int) (err error) {
+		results[i], err = r.nodes[i].Driver.Keys(ctx, prefix, pattern)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for _, part := range results {
+		keys = append(keys, part...)
+	}
+	return keys, nil
+}
+
+// Scan walks the nodes in order, filling each page with up to count keys.
+// Backend cursors must fit in 48 bits, which holds for the built-in drivers.
+func (r *Ring) Scan(ctx context.Context, prefix, pattern string, cursor uint64, count int) ([]string, uint64, error) {
+	if count <= 0 {
+		count = DefaultScanCount
+	}
+	i, inner := int(cursor>>ringScanShift), cursor&(1<<ringScanShift-1)
+	if i >= len(r.nodes) {
+		return nil, 0, ErrInvalidCursor
+	}
+
+	var result []string
+	for i < len(r.nodes) {
+		keys, next, err := r.nodes[i].Driver.Scan(ctx, prefix, pattern, inner, count-len(result))
+		if err != nil {
+			return nil, 0, err
+		}
+		if next >= 1<<ringScanShift {
+			return nil, 0, fmt.Errorf("namestore: ring node %q returned a cursor wider than 48 bits", r.nodes[i].Name)
+		}
+		result = append(result, keys...)
+		if next != 0 {
+			return result, uint64(i)<<ringScanShift | next, nil
+		}
+		if i, inner = i+1, 0; len(result) >= count && i < len(r.nodes) {
+			return result, uint64(i) << ringScanShift, nil
+		}
+	}
+	return result, 0, nil
+}
+
+// Clear clears the namespace on every node in parallel.
+func (r *Ring) Clear(ctx context.Context, prefix string) error {
+	return parallel(r.all(), func(i int) error {
+		return r.nodes[i].Driver.Clear(ctx, prefix)
+	})
+}
+
+func (r *Ring) Incr(ctx context.Context, key string, delta int64) (int64, error) {
+	return r.driver(key).Incr(ctx, key, delta)
+}
+
+func (r *Ring) Decr(ctx context.Context, key string, delta int64) (int64, error) {
+	return r.driver(key).Decr(ctx, key, delta)
+}
+
+func (r *Ring) GetSet(ctx context.Context, key string, value []byte) ([]byte, error) {
+	return r.driver(key).GetSet(ctx, key, value)
+}
+
+func (r *Ring) CompareAndSwap(ctx context.Context, key string, oldValue, newValue []byte, ttl time.Duration) (bool, error) {
+	return r.driver(key).CompareAndSwap(ctx, key, oldValue, newValue, ttl)
+}
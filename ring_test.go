@@ -0,0 +1,203 @@
+package namestore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+)
+
+func newTestRing(t *testing.T, names ...string) (*Ring, map[string]*Memory) {
+	t.Helper()
+	backends := make(map[string]*Memory)
+	var nodes []RingNode
+	for _, name := range names {
+		m := NewMemory().(*Memory)
+		backends[name] = m
+		nodes = append(nodes, RingNode{Name: name, Driver: m})
+	}
+	r, err := NewRing(nodes)
+	if err != nil {
+		t.Fatalf("NewRing failed: %v", err)
+	}
+	return r, backends
+}
+
+func TestNewRing_Validation(t *testing.T) {
+	if _, err := NewRing(nil); err == nil {
+		t.Error("expected an error for an empty ring")
+	}
+	if _, err := NewRing([]RingNode{{Name: "a", Driver: NewMemory()}, {Name: "a", Driver: NewMemory()}}); err == nil {
+		t.Error("expected an error for duplicate names")
+	}
+	if _, err := NewRing([]RingNode{{Driver: NewMemory()}}); err == nil {
+		t.Error("expected an error for a nameless node")
+	}
+}
+
+func TestRing_Distribution(t *testing.T) {
+	r, backends := newTestRing(t, "a", "b", "c", "d")
+	ctx := context.Background()
+
+	for i := 0; i < 4000; i++ {
+		_ = r.Set(ctx, fmt.Sprintf("key:%d", i), []byte("v"), 0)
+	}
+	for name, m := range backends {
+		if n := len(m.data); n < 700 || n > 1300 {
+			t.Errorf("node %s holds %d of 4000 keys", name, n)
+		}
+	}
+}
+
+func TestRing_Weights(t *testing.T) {
+	heavy, light := NewMemory().(*Memory), NewMemory().(*Memory)
+	r, _ := NewRing([]RingNode{{Name: "heavy", Driver: heavy, Weight: 3}, {Name: "light", Driver: light}})
+	ctx := context.Background()
+
+	for i := 0; i < 4000; i++ {
+		_ = r.Set(ctx, fmt.Sprintf("key:%d", i), []byte("v"), 0)
+	}
+	if ratio := float64(len(heavy.data)) / float64(len(light.data)); ratio < 2 || ratio > 4 {
+		t.Errorf("heavy/light = %d/%d, want about 3", len(heavy.data), len(light.data))
+	}
+}
+
+func TestRing_MinimalMovement(t *testing.T) {
+	before, _ := newTestRing(t, "a", "b", "c")
+	after, _ := newTestRing(t, "a", "b", "c", "d")
+
+	moved := 0
+	for i := 0; i < 3000; i++ {
+		k := fmt.Sprintf("key:%d", i)
+		was, is := before.Locate(k), after.Locate(k)
+		if was != is {
+			moved++
+			if is != "d" {
+				t.Fatalf("%s moved from %s to %s, not to the new node", k, was, is)
+			}
+		}
+	}
+	if moved < 450 || moved > 1050 {
+		t.Errorf("%d of 3000 keys moved, want about a quarter", moved)
+	}
+}
+
+func TestRing_HashTags(t *testing.T) {
+	r, _ := newTestRing(t, "a", "b", "c", "d")
+
+	tests := []struct{ key, tag string }{
+		{"{user:1}:profile", "user:1"},
+		{"app:{user:1}:settings", "user:1"},
+		{"{}:x", "{}:x"},
+		{"no-tag", "no-tag"},
+		{"{unclosed", "{unclosed"},
+		{"a{b}c{d}", "b"},
+	}
+	for _, tt := range tests {
+		if got := hashTag(tt.key); got != tt.tag {
+			t.Errorf("hashTag(%q) = %q, want %q", tt.key, got, tt.tag)
+		}
+	}
+
+	for i := 0; i < 50; i++ {
+		tag := fmt.Sprintf("{user:%d}", i)
+		if r.Locate(tag+":profile") != r.Locate("app:"+tag+":settings") {
+			t.Errorf("keys tagged %s landed on different nodes", tag)
+		}
+	}
+}
+
+func TestRing_BatchAndNamespaceOperations(t *testing.T) {
+	r, backends := newTestRing(t, "a", "b", "c")
+	ctx := context.Background()
+
+	pairs := make(map[string][]byte)
+	keys := make([]string, 0, 60)
+	for i := 0; i < 60; i++ {
+		k := fmt.Sprintf("app:users:%d", i)
+		pairs[k] = []byte(k)
+		keys = append(keys, k)
+	}
+	if err := r.MSet(ctx, pairs, 0); err != nil {
+		t.Fatalf("MSet failed: %v", err)
+	}
+	for name, m := range backends {
+		if len(m.data) == 0 {
+			t.Errorf("node %s received no keys", name)
+		}
+	}
+
+	got, err := r.MGet(ctx, append(keys, "app:users:missing"))
+	if err != nil || len(got) != 60 || string(got["app:users:7"]) != "app:users:7" {
+		t.Fatalf("MGet returned %d keys, %v", len(got), err)
+	}
+
+	_ = r.Set(ctx, "app:other:1", []byte("v"), 0)
+	all, _ := r.Keys(ctx, "app:users", "*")
+	if len(all) != 60 {
+		t.Errorf("Keys returned %d keys, want 60", len(all))
+	}
+
+	var scanned []string
+	var cursor uint64
+	for {
+		page, next, err := r.Scan(ctx, "app:users", "*", cursor, 7)
+		if err != nil {
+			t.Fatalf("Scan failed: %v", err)
+		}
+		scanned = append(scanned, page...)
+		if cursor = next; cursor == 0 {
+			break
+		}
+	}
+	sort.Strings(scanned)
+	sort.Strings(all)
+	if fmt.Sprint(scanned) != fmt.Sprint(all) {
+		t.Errorf("Scan returned %d keys, Keys %d", len(scanned), len(all))
+	}
+	if _, _, err := r.Scan(ctx, "app:users", "*", 9<<ringScanShift, 10); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("expected ErrInvalidCursor, got %v", err)
+	}
+
+	_ = r.MDel(ctx, keys[:30])
+	if got, _ = r.MGet(ctx, keys); len(got) != 30 {
+		t.Errorf("MGet after MDel returned %d keys, want 30", len(got))
+	}
+
+	_ = r.Clear(ctx, "app:users")
+	if all, _ = r.Keys(ctx, "app:users", "*"); len(all) != 0 {
+		t.Errorf("Keys after Clear = %v", all)
+	}
+	if exists, _ := r.Exists(ctx, "app:other:1"); !exists {
+		t.Error("Clear removed a key from another namespace")
+	}
+}
+
+func TestRing_PartialFailure(t *testing.T) {
+	good := NewMemory()
+	r, _ := NewRing([]RingNode{{Name: "good", Driver: good}, {Name: "bad", Driver: &errorDriver{}}})
+	ctx := context.Background()
+
+	pairs := make(map[string][]byte)
+	for i := 0; i < 20; i++ {
+		pairs[fmt.Sprintf("k%d", i)] = []byte("v")
+	}
+	if err := r.MSet(ctx, pairs, 0); !errors.Is(err, errMockMSet) {
+		t.Errorf("expected the failing node's error, got %v", err)
+	}
+}
+
+func TestRing_WithClient(t *testing.T) {
+	r, _ := newTestRing(t, "a", "b")
+	c := New[string]("app", "users", WithDriver[string](r))
+	ctx := context.Background()
+
+	_, _ = c.Incr(ctx, "n", 2)
+	if n, _ := c.Decr(ctx, "n", 1); n != 1 {
+		t.Errorf("counter = %d, want 1", n)
+	}
+	if ok, _ := c.CompareAndSwap(ctx, "missing", nil, []byte("x"), 0); ok {
+		t.Error("CompareAndSwap on a missing key should fail")
+	}
+}
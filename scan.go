@@ -0,0 +1,204 @@
+package namestore
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultScanCount is the page size used when Scan is given a
+	// non-positive count.
+	DefaultScanCount = 100
+
+	// scanMaxOpen caps the number of unfinished Memory scans; starting another
+	// one abandons the least recently used.
+	scanMaxOpen = 1024
+	// scanIdleTimeout abandons Memory scans that have not been resumed.
+	scanIdleTimeout = 5 * time.Minute
+	// scanWorkFactor bounds the entries a page may visit to count times this
+	// factor, so selective patterns cannot hold the lock for long.
+	scanWorkFactor = 10
+)
+
+// memoryScans tracks the open cursors of a Memory or FileDriver.
+type memoryScans struct {
+	mu   sync.Mutex
+	seq  uint64
+	open map[uint64]*memoryScan
+}
+
+// memoryScan is a paused walk over the map. reflect.MapIter follows range
+// semantics, so keys deleted before they are reached are skipped, keys added
+// mid-scan may or may not be returned, and no key is returned twice.
+type memoryScan struct {
+	iter     *reflect.MapIter
+	after    string // FileDriver: the last key visited
+	prefix   string
+	pattern  string
+	lastUsed time.Time
+}
+
+// take removes and returns the scan for cursor, or a new scan without an
+// iterator for cursor 0. Removing it while in use keeps concurrent callers
+// from advancing the same iterator.
+func (s *memoryScans) take(prefix, pattern string, cursor uint64, now time.Time) (*memoryScan, error) {
+	if cursor == 0 {
+		return &memoryScan{prefix: prefix, pattern: pattern}, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	scan, ok := s.open[cursor]
+	if !ok || scan.prefix != prefix || scan.pattern != pattern || now.Sub(scan.lastUsed) > scanIdleTimeout {
+		return nil, ErrInvalidCursor
+	}
+	delete(s.open, cursor)
+	return scan, nil
+}
+
+// park stores an unfinished scan and returns the cursor that resumes it.
+func (s *memoryScans) park(scan *memoryScan, cursor uint64, now time.Time) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.open == nil {
+		s.open = make(map[uint64]*memoryScan)
+	}
+	if cursor == 0 {
+		s.seq++
+		cursor = s.seq
+	}
+	scan.lastUsed = now
+
+	for id, open := range s.open {
+		if now.Sub(open.lastUsed) > scanIdleTimeout {
+			delete(s.open, id)
+		}
+	}
+	if len(s.open) >= scanMaxOpen {
+		var oldest uint64
+		for id, open := range s.open {
+			if oldest == 0 || open.lastUsed.Before(s.open[oldest].lastUsed) {
+				oldest = id
+			}
+		}
+		delete(s.open, oldest)
+	}
+
+	s.open[cursor] = scan
+	return cursor
+}
+
+// Scan returns a page of up to count keys matching the prefix and pattern.
+// Each call holds the read lock only while visiting at most count*10 entries,
+// so large namespaces can be walked without blocking writers for the whole
+// scan. Cursors are kept by the driver: they stay valid for five minutes
+// between calls, must be resumed with the same prefix and pattern, and yield
+// ErrInvalidCursor otherwise.
+func (m *Memory) Scan(ctx context.Context, prefix, pattern string, cursor uint64, count int) ([]string, uint64, error) {
+	if pattern != "" && pattern != "*" {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, 0, ErrInvalidPattern
+		}
+	}
+	if count <= 0 {
+		count = DefaultScanCount
+	}
+
+	now := m.cfg.now()
+	scan, err := m.scans.take(prefix, pattern, cursor, now)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	m.mu.RLock()
+	if scan.iter == nil {
+		scan.iter = reflect.ValueOf(m.data).MapRange()
+	}
+	keys, done := scan.next(now, count)
+	m.mu.RUnlock()
+
+	if done {
+		return keys, 0, nil
+	}
+	return keys, m.scans.park(scan, cursor, now), nil
+}
+
+// next advances the walk; the caller must hold the Memory lock.
+func (s *memoryScan) next(now time.Time, count int) (keys []string, done bool) {
+	for visited := 0; len(keys) < count && visited < count*scanWorkFactor; visited++ {
+		if !s.iter.Next() {
+			return keys, true
+		}
+
+		key := s.iter.Key().String()
+		if !strings.HasPrefix(key, s.prefix+":") {
+			continue
+		}
+		if s.pattern != "" && s.pattern != "*" {
+			if matched, _ := filepath.Match(s.pattern, key[len(s.prefix)+1:]); !matched {
+				continue
+			}
+		}
+		if e := s.iter.Value().Interface().(entry); e.expiredAt(now) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys, false
+}
+
+// KeyIterator walks a namespace page by page with Client.Scan:
+//
+//	it := namestore.NewKeyIterator(client, "user:*", 100)
+//	for it.Next(ctx) {
+//	    fmt.Println(it.Key())
+//	}
+//	if err := it.Err(); err != nil {
+//	    // handle error
+//	}
+type KeyIterator[TKey ~string] struct {
+	client  Client[TKey]
+	pattern string
+	count   int
+
+	cursor  uint64
+	started bool
+	page    []TKey
+	key     TKey
+	err     error
+}
+
+// NewKeyIterator returns an iterator over the keys of c matching pattern,
+// fetching count keys per page.
+func NewKeyIterator[TKey ~string](c Client[TKey], pattern string, count int) *KeyIterator[TKey] {
+	return &KeyIterator[TKey]{client: c, pattern: pattern, count: count}
+}
+
+// Next advances to the next key, fetching pages as needed. It returns false
+// when the scan is complete or an error occurred.
+func (it *KeyIterator[TKey]) Next(ctx context.Context) bool {
+	for len(it.page) == 0 {
+		if it.err != nil || (it.started && it.cursor == 0) {
+			return false
+		}
+		it.started = true
+		it.page, it.cursor, it.err = it.client.Scan(ctx, it.pattern, it.cursor, it.count)
+	}
+	it.key, it.page = it.page[0], it.page[1:]
+	return true
+}
+
+// Key returns the current key.
+func (it *KeyIterator[TKey]) Key() TKey {
+	return it.key
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (it *KeyIterator[TKey]) Err() error {
+	return it.err
+}
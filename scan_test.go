@@ -0,0 +1,238 @@
+package namestore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// scanAll drains a scan, failing the test on errors or duplicate keys.
+func scanAll(t *testing.T, d Driver, prefix, pattern string, count int) []string {
+	t.Helper()
+	seen := make(map[string]bool)
+	var cursor uint64
+	for {
+		keys, next, err := d.Scan(context.Background(), prefix, pattern, cursor, count)
+		if err != nil {
+			t.Fatalf("Scan failed: %v", err)
+		}
+		if len(keys) > count {
+			t.Fatalf("page has %d keys, count is %d", len(keys), count)
+		}
+		for _, k := range keys {
+			if seen[k] {
+				t.Fatalf("key %s returned twice", k)
+			}
+			seen[k] = true
+		}
+		if cursor = next; cursor == 0 {
+			break
+		}
+	}
+
+	result := make([]string, 0, len(seen))
+	for k := range seen {
+		result = append(result, k)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func TestMemory_Scan(t *testing.T) {
+	m := NewMemory()
+	ctx := context.Background()
+
+	for i := 0; i < 250; i++ {
+		_ = m.Set(ctx, fmt.Sprintf("app:users:%03d", i), []byte("v"), 0)
+		_ = m.Set(ctx, fmt.Sprintf("app:other:%03d", i), []byte("v"), 0)
+	}
+	_ = m.Set(ctx, "app:users:expired", []byte("v"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	if keys := scanAll(t, m, "app:users", "*", 20); len(keys) != 250 {
+		t.Errorf("Scan returned %d keys, want 250", len(keys))
+	}
+	if keys := scanAll(t, m, "app:users", "1??", 7); len(keys) != 100 || keys[0] != "app:users:100" {
+		t.Errorf("Scan with pattern returned %d keys", len(keys))
+	}
+}
+
+func TestMemory_ScanWithConcurrentDeletes(t *testing.T) {
+	m := NewMemory()
+	ctx := context.Background()
+
+	for i := 0; i < 100; i++ {
+		_ = m.Set(ctx, fmt.Sprintf("p:%d", i), []byte("v"), 0)
+	}
+
+	// Keys that exist for the whole scan are returned exactly once, even
+	// when other keys are deleted between pages.
+	seen := make(map[string]bool)
+	var cursor uint64
+	for {
+		keys, next, err := m.Scan(ctx, "p", "*", cursor, 10)
+		if err != nil {
+			t.Fatalf("Scan failed: %v", err)
+		}
+		for _, k := range keys {
+			seen[k] = true
+		}
+		for i := 50; i < 100; i++ {
+			_ = m.Delete(ctx, fmt.Sprintf("p:%d", i))
+		}
+		if cursor = next; cursor == 0 {
+			break
+		}
+	}
+	for i := 0; i < 50; i++ {
+		if !seen[fmt.Sprintf("p:%d", i)] {
+			t.Errorf("key p:%d was not returned", i)
+		}
+	}
+}
+
+func TestMemory_ScanCursors(t *testing.T) {
+	m := NewMemory().(*Memory)
+	ctx := context.Background()
+
+	for i := 0; i < 50; i++ {
+		_ = m.Set(ctx, fmt.Sprintf("p:%d", i), []byte("v"), 0)
+	}
+
+	if _, _, err := m.Scan(ctx, "p", "[invalid", 0, 10); !errors.Is(err, ErrInvalidPattern) {
+		t.Errorf("expected ErrInvalidPattern, got %v", err)
+	}
+	if _, _, err := m.Scan(ctx, "p", "*", 12345, 10); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("unknown cursor: expected ErrInvalidCursor, got %v", err)
+	}
+
+	_, cursor, err := m.Scan(ctx, "p", "*", 0, 10)
+	if err != nil || cursor == 0 {
+		t.Fatalf("Scan = %d, %v", cursor, err)
+	}
+	if _, _, err := m.Scan(ctx, "other", "*", cursor, 10); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("mismatched prefix: expected ErrInvalidCursor, got %v", err)
+	}
+
+	m.scans.open[cursor].lastUsed = time.Now().Add(-2 * scanIdleTimeout)
+	if _, _, err := m.Scan(ctx, "p", "*", cursor, 10); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("idle cursor: expected ErrInvalidCursor, got %v", err)
+	}
+
+	for i := 0; i < scanMaxOpen+10; i++ {
+		_, _, _ = m.Scan(ctx, "p", "*", 0, 1)
+	}
+	if n := len(m.scans.open); n > scanMaxOpen {
+		t.Errorf("%d open scans, want at most %d", n, scanMaxOpen)
+	}
+}
+
+func TestMemory_ScanConcurrentWrites(t *testing.T) {
+	m := NewMemory()
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+				_ = m.Set(ctx, fmt.Sprintf("p:%d", i%500), []byte("v"), 0)
+				_ = m.Delete(ctx, fmt.Sprintf("p:%d", (i+250)%500))
+			}
+		}
+	}()
+
+	for i := 0; i < 20; i++ {
+		scanAll(t, m, "p", "*", 16)
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestShardedMemory_Scan(t *testing.T) {
+	s := NewShardedMemory(8)
+	ctx := context.Background()
+
+	for i := 0; i < 300; i++ {
+		_ = s.Set(ctx, fmt.Sprintf("app:users:%d", i), []byte("v"), 0)
+	}
+	_ = s.Set(ctx, "app:other:1", []byte("v"), 0)
+
+	if keys := scanAll(t, s, "app:users", "*", 25); len(keys) != 300 {
+		t.Errorf("Scan returned %d keys, want 300", len(keys))
+	}
+	if keys := scanAll(t, s, "app:users", "*", 1000); len(keys) != 300 {
+		t.Errorf("single-page Scan returned %d keys, want 300", len(keys))
+	}
+	if _, _, err := s.Scan(ctx, "app:users", "*", 99<<shardedScanShift, 10); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("expected ErrInvalidCursor, got %v", err)
+	}
+}
+
+func TestClient_Scan(t *testing.T) {
+	c := New[string]("app", "users")
+	ctx := context.Background()
+
+	for i := 0; i < 30; i++ {
+		_ = c.Set(ctx, fmt.Sprintf("user:%d", i), []byte("v"), 0)
+	}
+
+	keys, cursor, err := c.Scan(ctx, "user:*", 0, 10)
+	if err != nil || len(keys) != 10 || cursor == 0 {
+		t.Fatalf("Scan = %v, %d, %v", keys, cursor, err)
+	}
+	if keys[0][:5] != "user:" {
+		t.Errorf("Scan should return business keys, got %q", keys[0])
+	}
+
+	_, _, err = c.Scan(ctx, "[invalid", 0, 10)
+	if !errors.Is(err, ErrInvalidPattern) {
+		t.Errorf("expected ErrInvalidPattern, got %v", err)
+	}
+}
+
+func TestKeyIterator(t *testing.T) {
+	c := New[string]("app", "users")
+	ctx := context.Background()
+
+	for i := 0; i < 55; i++ {
+		_ = c.Set(ctx, fmt.Sprintf("user:%d", i), []byte("v"), 0)
+	}
+
+	it := NewKeyIterator(c, "user:*", 10)
+	n := 0
+	for it.Next(ctx) {
+		if it.Key() == "" {
+			t.Fatal("empty key")
+		}
+		n++
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("Err = %v", err)
+	}
+	if n != 55 {
+		t.Errorf("iterated %d keys, want 55", n)
+	}
+	if it.Next(ctx) {
+		t.Error("Next after completion should return false")
+	}
+
+	it = NewKeyIterator(c, "[invalid", 10)
+	if it.Next(ctx) || !errors.Is(it.Err(), ErrInvalidPattern) {
+		t.Errorf("iterator error = %v, want ErrInvalidPattern", it.Err())
+	}
+
+	it = NewKeyIterator(New[string]("app", "empty"), "*", 10)
+	if it.Next(ctx) || it.Err() != nil {
+		t.Error("empty namespace should yield no keys")
+	}
+}
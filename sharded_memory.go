@@ -0,0 +1,292 @@
+package namestore
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"time"
+)
+
+// DefaultShardCount is the number of shards used by NewShardedMemory when
+// given a non-positive count.
+const DefaultShardCount = 32
+
+// ShardedMemory implements Driver with in-memory storage split across
+// independently locked Memory shards, reducing lock contention for
+// write-heavy workloads. Keys are assigned to shards by FNV-1a hash.
+//
+// Semantics match Memory: multi-key operations lock every shard they touch
+// (in shard order, to avoid deadlock) so MGet, MSet and MDel stay atomic, and
+// Clear locks all shards so it removes the namespace in one step.
+type ShardedMemory struct {
+	shards []*Memory
+	mask   uint32
+
+	janitor *periodic
+	hub     *watchHub
+}
+
+// NewShardedMemory creates a sharded in-memory Driver. The shard count is
+// rounded up to a power of two; non-positive values use DefaultShardCount.
+// Options apply to every shard; WithJanitor starts a single janitor that
+// sweeps the shards in turn, and WithMaxEntries and WithMaxBytes limits are
+// divided evenly between shards, each evicting independently. Persistence is
+// not supported: WithSnapshotFile and WithAppendLog are ignored.
+func NewShardedMemory(shards int, opts ...MemoryOption) Driver {
+	if shards <= 0 {
+		shards = DefaultShardCount
+	}
+	n := 1
+	for n < shards {
+		n <<= 1
+	}
+
+	cfg := newMemoryConfig(opts)
+	shardCfg := cfg
+	shardCfg.janitorInterval = 0
+	shardCfg.snapshotPath = ""
+	shardCfg.logPath = ""
+	shardCfg.maxEntries = (cfg.maxEntries + n - 1) / n
+	shardCfg.maxBytes = (cfg.maxBytes + int64(n) - 1) / int64(n)
+
+	s := &ShardedMemory{shards: make([]*Memory, n), mask: uint32(n - 1), hub: newWatchHub(cfg.watchBuffer)}
+	for i := range s.shards {
+		s.shards[i] = newMemory(shardCfg)
+		s.shards[i].hub = s.hub
+	}
+	if cfg.janitorInterval > 0 {
+		s.janitor = startJanitor(cfg.janitorInterval, s.sweep)
+	}
+	return s
+}
+
+// Close stops the janitor, if any, and closes Watch channels. It is safe to
+// call more than once.
+func (s *ShardedMemory) Close() error {
+	s.janitor.stop()
+	for _, shard := range s.shards {
+		_ = shard.Close()
+	}
+	return nil
+}
+
+// sweep splits the budget evenly across shards.
+func (s *ShardedMemory) sweep(budget time.Duration) int {
+	deleted := 0
+	for _, shard := range s.shards {
+		deleted += shard.sweep(budget / time.Duration(len(s.shards)))
+	}
+	return deleted
+}
+
+// shardIndex returns the shard for key using 32-bit FNV-1a.
+func (s *ShardedMemory) shardIndex(key string) int {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return int(h & s.mask)
+}
+
+func (s *ShardedMemory) shard(key string) *Memory {
+	return s.shards[s.shardIndex(key)]
+}
+
+// now reads the clock shared by all shards.
+func (s *ShardedMemory) now() time.Time {
+	return s.shards[0].cfg.now()
+}
+
+// lockKeys write-locks the shards owning keys in ascending index order, so
+// concurrent multi-key operations cannot deadlock, and returns an unlock func.
+func (s *ShardedMemory) lockKeys(keys []string) func() {
+	idxs := make([]int, len(keys))
+	for i, k := range keys {
+		idxs[i] = s.shardIndex(k)
+	}
+	sort.Ints(idxs)
+	idxs = slices.Compact(idxs)
+
+	for _, i := range idxs {
+		s.shards[i].mu.Lock()
+	}
+	return func() {
+		for _, i := range idxs {
+			s.shards[i].mu.Unlock()
+		}
+	}
+}
+
+func (s *ShardedMemory) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return s.shard(key).Set(ctx, key, value, ttl)
+}
+
+func (s *ShardedMemory) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	return s.shard(key).SetNX(ctx, key, value, ttl)
+}
+
+func (s *ShardedMemory) Get(ctx context.Context, key string) ([]byte, error) {
+	return s.shard(key).Get(ctx, key)
+}
+
+func (s *ShardedMemory) Delete(ctx context.Context, key string) error {
+	return s.shard(key).Delete(ctx, key)
+}
+
+func (s *ShardedMemory) Exists(ctx context.Context, key string) (bool, error) {
+	return s.shard(key).Exists(ctx, key)
+}
+
+// MGet retrieves multiple keys atomically across shards.
+func (s *ShardedMemory) MGet(ctx context.Context, keys []string) (map[string][]byte, error) {
+	unlock := s.lockKeys(keys)
+	defer unlock()
+
+	now := s.now()
+	result := make(map[string][]byte, len(keys))
+	for _, key := range keys {
+		s.shard(key).mgetLocked(now, key, result)
+	}
+	return result, nil
+}
+
+// MSet sets multiple key-value pairs atomically across shards.
+func (s *ShardedMemory) MSet(ctx context.Context, pairs map[string][]byte, ttl time.Duration) error {
+	keys := make([]string, 0, len(pairs))
+	for k := range pairs {
+		keys = append(keys, k)
+	}
+	unlock := s.lockKeys(keys)
+	defer unlock()
+
+	if s.shards[0].cfg.limited() {
+		grow := make([]growth, len(s.shards))
+		for key, value := range pairs {
+			i := s.shardIndex(key)
+			grow[i].add(s.shards[i], key, value)
+		}
+		protected := func(key string) bool { _, ok := pairs[key]; return ok }
+		victims := make([][]string, len(s.shards))
+		for i, g := range grow {
+			if g.written == 0 {
+				continue
+			}
+			v, err := s.shards[i].planEvictionsLocked(g, protected)
+			if err != nil {
+				return err
+			}
+			victims[i] = v
+		}
+		for i, v := range victims {
+			s.shards[i].evictLocked(v)
+		}
+	}
+
+	exp := expiry(s.now(), ttl)
+	for key, value := range pairs {
+		s.shard(key).msetLocked(key, value, exp)
+	}
+	return nil
+}
+
+// MDel deletes multiple keys atomically across shards.
+func (s *ShardedMemory) MDel(ctx context.Context, keys []string) error {
+	unlock := s.lockKeys(keys)
+	defer unlock()
+
+	for _, key := range keys {
+		s.shard(key).mdelLocked(key)
+	}
+	return nil
+}
+
+func (s *ShardedMemory) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return s.shard(key).TTL(ctx, key)
+}
+
+func (s *ShardedMemory) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	return s.shard(key).Expire(ctx, key, ttl)
+}
+
+func (s *ShardedMemory) Persist(ctx context.Context, key string) error {
+	return s.shard(key).Persist(ctx, key)
+}
+
+// Keys returns all keys matching the prefix and pattern across every shard.
+func (s *ShardedMemory) Keys(ctx context.Context, prefix, pattern string) ([]string, error) {
+	var result []string
+	for _, shard := range s.shards {
+		keys, err := shard.Keys(ctx, prefix, pattern)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, keys...)
+	}
+	return result, nil
+}
+
+// shardedScanShift places the shard index in the high bits of a cursor, above
+// the shard's own cursor.
+const shardedScanShift = 48
+
+// Scan walks the shards in order, filling each page with up to count keys.
+func (s *ShardedMemory) Scan(ctx context.Context, prefix, pattern string, cursor uint64, count int) ([]string, uint64, error) {
+	if count <= 0 {
+		count = DefaultScanCount
+	}
+	i, inner := int(cursor>>shardedScanShift), cursor&(1<<shardedScanShift-1)
+	if i >= len(s.shards) {
+		return nil, 0, ErrInvalidCursor
+	}
+
+	var result []string
+	for i < len(s.shards) {
+		keys, next, err := s.shards[i].Scan(ctx, prefix, pattern, inner, count-len(result))
+		if err != nil {
+			return nil, 0, err
+		}
+		result = append(result, keys...)
+		if next != 0 {
+			return result, uint64(i)<<shardedScanShift | next, nil
+		}
+		if i, inner = i+1, 0; len(result) >= count && i < len(s.shards) {
+			return result, uint64(i) << shardedScanShift, nil
+		}
+	}
+	return result, 0, nil
+}
+
+// Clear removes all keys with the given prefix from every shard atomically.
+func (s *ShardedMemory) Clear(ctx context.Context, prefix string) error {
+	for _, shard := range s.shards {
+		shard.mu.Lock()
+	}
+	defer func() {
+		for _, shard := range s.shards {
+			shard.mu.Unlock()
+		}
+	}()
+
+	for _, shard := range s.shards {
+		shard.clearLocked(prefix)
+	}
+	s.hub.publish(EventClear, prefix)
+	return nil
+}
+
+func (s *ShardedMemory) Incr(ctx context.Context, key string, delta int64) (int64, error) {
+	return s.shard(key).Incr(ctx, key, delta)
+}
+
+func (s *ShardedMemory) Decr(ctx context.Context, key string, delta int64) (int64, error) {
+	return s.shard(key).Decr(ctx, key, delta)
+}
+
+func (s *ShardedMemory) GetSet(ctx context.Context, key string, value []byte) ([]byte, error) {
+	return s.shard(key).GetSet(ctx, key, value)
+}
+
+func (s *ShardedMemory) CompareAndSwap(ctx context.Context, key string, oldValue, newValue []byte, ttl time.Duration) (bool, error) {
+	return s.shard(key).CompareAndSwap(ctx, key, oldValue, newValue, ttl)
+}
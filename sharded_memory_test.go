@@ -0,0 +1,226 @@
+package namestore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewShardedMemory_ShardCount(t *testing.T) {
+	tests := []struct {
+		shards int
+		want   int
+	}{
+		{shards: 0, want: DefaultShardCount},
+		{shards: -1, want: DefaultShardCount},
+		{shards: 1, want: 1},
+		{shards: 5, want: 8},
+		{shards: 16, want: 16},
+	}
+	for _, tt := range tests {
+		s := NewShardedMemory(tt.shards).(*ShardedMemory)
+		if len(s.shards) != tt.want {
+			t.Errorf("NewShardedMemory(%d) has %d shards, want %d", tt.shards, len(s.shards), tt.want)
+		}
+	}
+}
+
+func TestNewShardedMemory_IgnoresPersistence(t *testing.T) {
+	dir := t.TempDir()
+	d := NewShardedMemory(2,
+		WithSnapshotFile(filepath.Join(dir, "cache.snap"), time.Millisecond),
+		WithAppendLog(filepath.Join(dir, "cache.aof"), FsyncAlways))
+	ctx := context.Background()
+
+	if err := d.Set(ctx, "k", []byte("v"), 0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := d.(*ShardedMemory).Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("NewShardedMemory wrote %d files, want none", len(entries))
+	}
+}
+
+func TestShardedMemory_Distribution(t *testing.T) {
+	s := NewShardedMemory(8).(*ShardedMemory)
+	ctx := context.Background()
+
+	for i := 0; i < 1000; i++ {
+		_ = s.Set(ctx, fmt.Sprintf("key:%d", i), []byte("v"), 0)
+	}
+
+	for i, shard := range s.shards {
+		if n := len(shard.data); n < 50 {
+			t.Errorf("shard %d holds %d keys, distribution too skewed", i, n)
+		}
+	}
+}
+
+func TestShardedMemory_SingleKeyOperations(t *testing.T) {
+	s := NewShardedMemory(4)
+	ctx := context.Background()
+
+	_ = s.Set(ctx, "k", []byte("v"), 0)
+	got, err := s.Get(ctx, "k")
+	if err != nil || string(got) != "v" {
+		t.Fatalf("Get = %q, %v", got, err)
+	}
+
+	ok, _ := s.SetNX(ctx, "k", []byte("x"), 0)
+	if ok {
+		t.Error("SetNX should fail for existing key")
+	}
+
+	if err := s.Expire(ctx, "k", time.Minute); err != nil {
+		t.Fatalf("Expire failed: %v", err)
+	}
+	if ttl, _ := s.TTL(ctx, "k"); ttl <= 0 {
+		t.Errorf("TTL = %v, want > 0", ttl)
+	}
+	_ = s.Persist(ctx, "k")
+	if ttl, _ := s.TTL(ctx, "k"); ttl != -1 {
+		t.Errorf("TTL after Persist = %v, want -1", ttl)
+	}
+
+	n, _ := s.Incr(ctx, "counter", 5)
+	n2, _ := s.Decr(ctx, "counter", 2)
+	if n != 5 || n2 != 3 {
+		t.Errorf("Incr/Decr = %d/%d, want 5/3", n, n2)
+	}
+
+	old, _ := s.GetSet(ctx, "k", []byte("v2"))
+	if string(old) != "v" {
+		t.Errorf("GetSet old = %q", old)
+	}
+	swapped, _ := s.CompareAndSwap(ctx, "k", []byte("v2"), []byte("v3"), 0)
+	if !swapped {
+		t.Error("CompareAndSwap should succeed")
+	}
+
+	_ = s.Delete(ctx, "k")
+	if exists, _ := s.Exists(ctx, "k"); exists {
+		t.Error("key should be deleted")
+	}
+}
+
+func TestShardedMemory_BatchAcrossShards(t *testing.T) {
+	s := NewShardedMemory(8)
+	ctx := context.Background()
+
+	pairs := make(map[string][]byte)
+	keys := make([]string, 0, 64)
+	for i := 0; i < 64; i++ {
+		k := fmt.Sprintf("key:%d", i)
+		pairs[k] = []byte(k)
+		keys = append(keys, k)
+	}
+	if err := s.MSet(ctx, pairs, time.Minute); err != nil {
+		t.Fatalf("MSet failed: %v", err)
+	}
+
+	got, _ := s.MGet(ctx, append([]string{"missing"}, keys...))
+	if len(got) != 64 || string(got["key:7"]) != "key:7" {
+		t.Errorf("MGet returned %d keys", len(got))
+	}
+
+	_ = s.MDel(ctx, keys[:32])
+	got, _ = s.MGet(ctx, keys)
+	if len(got) != 32 {
+		t.Errorf("MGet after MDel returned %d keys, want 32", len(got))
+	}
+}
+
+func TestShardedMemory_KeysAndClear(t *testing.T) {
+	s := NewShardedMemory(8)
+	ctx := context.Background()
+
+	for i := 0; i < 20; i++ {
+		_ = s.Set(ctx, fmt.Sprintf("app:users:%d", i), []byte("v"), 0)
+		_ = s.Set(ctx, fmt.Sprintf("app:other:%d", i), []byte("v"), 0)
+	}
+	_ = s.Set(ctx, "app:users:expired", []byte("v"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	keys, err := s.Keys(ctx, "app:users", "*")
+	if err != nil {
+		t.Fatalf("Keys failed: %v", err)
+	}
+	if len(keys) != 20 {
+		t.Errorf("Keys returned %d keys, want 20", len(keys))
+	}
+
+	keys, _ = s.Keys(ctx, "app:users", "1?")
+	sort.Strings(keys)
+	if len(keys) != 10 || keys[0] != "app:users:10" {
+		t.Errorf("Keys with pattern = %v", keys)
+	}
+
+	if _, err := s.Keys(ctx, "app:users", "[invalid"); !errors.Is(err, ErrInvalidPattern) {
+		t.Errorf("expected ErrInvalidPattern, got %v", err)
+	}
+
+	_ = s.Clear(ctx, "app:users")
+	keys, _ = s.Keys(ctx, "app:users", "*")
+	if len(keys) != 0 {
+		t.Errorf("Keys after Clear = %v", keys)
+	}
+	keys, _ = s.Keys(ctx, "app:other", "*")
+	if len(keys) != 20 {
+		t.Errorf("Clear removed keys from another namespace: %d left", len(keys))
+	}
+}
+
+func TestShardedMemory_ConcurrentAccess(t *testing.T) {
+	s := NewShardedMemory(4)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				_, _ = s.Incr(ctx, fmt.Sprintf("counter:%d", i%8), 1)
+				_ = s.MSet(ctx, map[string][]byte{
+					fmt.Sprintf("a:%d", g): []byte("x"),
+					fmt.Sprintf("b:%d", i): []byte("y"),
+				}, 0)
+				_, _ = s.MGet(ctx, []string{fmt.Sprintf("a:%d", i%16), fmt.Sprintf("b:%d", i)})
+				_ = s.MDel(ctx, []string{fmt.Sprintf("b:%d", i), fmt.Sprintf("a:%d", (g+1)%16)})
+				if i%50 == 0 {
+					_ = s.Clear(ctx, "b")
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	var total int64
+	for i := 0; i < 8; i++ {
+		n, _ := s.Incr(ctx, fmt.Sprintf("counter:%d", i), 0)
+		total += n
+	}
+	if total != 16*200 {
+		t.Errorf("counters sum to %d, want %d", total, 16*200)
+	}
+}
+
+func TestShardedMemory_WithClient(t *testing.T) {
+	c := New[string]("app", "users", WithDriver[string](NewShardedMemory(0)))
+	ctx := context.Background()
+
+	_ = c.MSet(ctx, map[string][]byte{"1": []byte("a"), "2": []byte("b")}, 0)
+	keys, _ := c.Keys(ctx, "*")
+	sort.Strings(keys)
+	if len(keys) != 2 || keys[0] != "1" {
+		t.Errorf("Keys = %v", keys)
+	}
+}
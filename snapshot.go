@@ -0,0 +1,305 @@
+package namestore
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Snapshot format, version 1. All integers are big-endian unless noted.
+//
+//	magic    "NSSNAP"
+//	version  uint16
+//	count    uvarint
+//	count × entry:
+//	    keyLen   uvarint, key
+//	    valueLen uvarint, value
+//	    expire   int64, Unix nanoseconds, 0 for no expiry
+//	checksum uint32, CRC-32C of everything above
+const (
+	snapshotMagic   = "NSSNAP"
+	snapshotVersion = 1
+
+	// snapshotMaxLen rejects absurd lengths in corrupt input before
+	// allocating for them.
+	snapshotMaxLen = 1 << 30
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// WithSnapshotFile persists the store to path. OpenMemory restores the file
+// if it exists, rewrites it every interval (if positive) and Close writes a
+// final snapshot. Files are replaced atomically via rename, so a crash while
+// writing never corrupts the previous snapshot. Only OpenMemory honors this
+// option, since loading the file can fail: NewMemory and NewShardedMemory
+// ignore it.
+func WithSnapshotFile(path string, interval time.Duration) MemoryOption {
+	return func(c *memoryConfig) {
+		c.snapshotPath = path
+		c.snapshotInterval = interval
+	}
+}
+
+// OpenMemory creates a Memory driver like NewMemory and additionally sets up
+// the persistence configured by WithSnapshotFile and WithAppendLog, restoring
+// previously saved data. Call Close to stop background work and flush it.
+func OpenMemory(opts ...MemoryOption) (*Memory, error) {
+	cfg := newMemoryConfig(opts)
+	m := newMemory(cfg)
+
+	if cfg.snapshotPath != "" {
+		if err := m.LoadSnapshot(cfg.snapshotPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			m.janitor.stop()
+			return nil, err
+		}
+		m.snapshotPath = cfg.snapshotPath
+		if cfg.snapshotInterval > 0 {
+			// Failures are retried on the next tick; Close reports the final save.
+			m.snapshotter = startPeriodic(cfg.snapshotInterval, func() { _ = m.SaveSnapshot(m.snapshotPath) })
+		}
+	}
+	if cfg.logPath != "" {
+		if err := m.openLog(cfg); err != nil {
+			m.janitor.stop()
+			m.snapshotter.stop()
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Snapshot writes all live entries to w. Expiry times are stored as absolute
+// times. The lock is only held while collecting entries, not while writing.
+func (m *Memory) Snapshot(w io.Writer) error {
+	m.mu.RLock()
+	keys, entries := m.liveEntriesLocked()
+	m.mu.RUnlock()
+
+	crc := crc32.New(castagnoli)
+	bw := bufio.NewWriter(io.MultiWriter(w, crc))
+
+	var buf [binary.MaxVarintLen64]byte
+	bw.WriteString(snapshotMagic)
+	bw.Write(binary.BigEndian.AppendUint16(buf[:0], snapshotVersion))
+	bw.Write(binary.AppendUvarint(buf[:0], uint64(len(keys))))
+	for i, k := range keys {
+		e := entries[i]
+		var expire int64
+		if !e.expire.IsZero() {
+			expire = e.expire.UnixNano()
+		}
+		bw.Write(binary.AppendUvarint(buf[:0], uint64(len(k))))
+		bw.WriteString(k)
+		bw.Write(binary.AppendUvarint(buf[:0], uint64(len(e.value))))
+		bw.Write(e.value)
+		bw.Write(binary.BigEndian.AppendUint64(buf[:0], uint64(expire)))
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	_, err := w.Write(binary.BigEndian.AppendUint32(buf[:0], crc.Sum32()))
+	return err
+}
+
+// liveEntriesLocked returns the unexpired entries. Stored values are never
+// mutated in place, so callers may use them after releasing the lock.
+func (m *Memory) liveEntriesLocked() ([]string, []entry) {
+	now := m.cfg.now()
+	keys := make([]string, 0, len(m.data))
+	entries := make([]entry, 0, len(m.data))
+	for k, e := range m.data {
+		if !e.expiredAt(now) {
+			keys = append(keys, k)
+			entries = append(entries, e)
+		}
+	}
+	return keys, entries
+}
+
+// Restore replaces the contents of m with a snapshot read from r, dropping
+// entries that have expired since. The snapshot is fully read and verified
+// before anything is replaced; a damaged snapshot yields ErrCorrupt and
+// leaves m unchanged. Restore does not publish Watch events or evict. With
+// an append log, the restored contents are logged as a single frame.
+func (m *Memory) Restore(r io.Reader) error {
+	hr := &hashReader{r: bufio.NewReader(r), h: crc32.New(castagnoli)}
+
+	header := make([]byte, len(snapshotMagic)+2)
+	if _, err := io.ReadFull(hr, header); err != nil {
+		return snapshotReadError(err)
+	}
+	if string(header[:len(snapshotMagic)]) != snapshotMagic {
+		return fmt.Errorf("%w: not a snapshot", ErrCorrupt)
+	}
+	if v := binary.BigEndian.Uint16(header[len(snapshotMagic):]); v != snapshotVersion {
+		return fmt.Errorf("%w: unsupported snapshot version %d", ErrCorrupt, v)
+	}
+
+	count, err := binary.ReadUvarint(hr)
+	if err != nil {
+		return snapshotReadError(err)
+	}
+
+	now := m.cfg.now()
+	data := make(map[string]entry, min(count, 1<<20))
+	for i := uint64(0); i < count; i++ {
+		key, err := readSnapshotBytes(hr)
+		if err != nil {
+			return err
+		}
+		value, err := readSnapshotBytes(hr)
+		if err != nil {
+			return err
+		}
+		var raw [8]byte
+		if _, err := io.ReadFull(hr, raw[:]); err != nil {
+			return snapshotReadError(err)
+		}
+
+		e := entry{value: value}
+		if ns := int64(binary.BigEndian.Uint64(raw[:])); ns != 0 {
+			e.expire = time.Unix(0, ns)
+		}
+		if !e.expiredAt(now) {
+			data[string(key)] = e
+		}
+	}
+
+	sum := hr.h.Sum32()
+	var trailer [4]byte
+	if _, err := io.ReadFull(hr.r, trailer[:]); err != nil {
+		return snapshotReadError(err)
+	}
+	if binary.BigEndian.Uint32(trailer[:]) != sum {
+		return fmt.Errorf("%w: snapshot checksum mismatch", ErrCorrupt)
+	}
+
+	m.mu.Lock()
+	defer m.unlock()
+	m.data = make(map[string]entry, len(data))
+	m.used = 0
+	m.logResetLocked()
+	for k, e := range data {
+		m.loadLocked(k, e)
+		m.logPutLocked(k, e)
+	}
+	return nil
+}
+
+// loadLocked inserts a restored entry, with accounting but without events.
+func (m *Memory) loadLocked(key string, e entry) {
+	m.deleteLocked(key)
+	if m.cfg.limited() {
+		e.stats = newEntryStats(m.cfg.now())
+	}
+	m.seq++
+	e.version = m.seq
+	m.used += entrySize(key, e.value)
+	m.data[key] = e
+}
+
+// SaveSnapshot writes a snapshot to path atomically: it is written to a
+// temporary file in the same directory, synced, and renamed over path.
+func (m *Memory) SaveSnapshot(path string) error {
+	return writeFileAtomic(path, m.Snapshot)
+}
+
+// LoadSnapshot restores a snapshot previously written by SaveSnapshot.
+func (m *Memory) LoadSnapshot(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return m.Restore(f)
+}
+
+// writeFileAtomic writes path via a synced temporary file and a rename.
+func writeFileAtomic(path string, write func(w io.Writer) error) error {
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := os.CreateTemp(dir, "."+base+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op after a successful rename
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	syncDir(dir)
+	return nil
+}
+
+// syncDir makes a rename in dir durable. Best effort: not every platform
+// supports syncing directories.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		d.Close()
+	}
+}
+
+// hashReader hashes everything read through it.
+type hashReader struct {
+	r *bufio.Reader
+	h hash.Hash32
+}
+
+func (hr *hashReader) Read(p []byte) (int, error) {
+	n, err := hr.r.Read(p)
+	hr.h.Write(p[:n])
+	return n, err
+}
+
+func (hr *hashReader) ReadByte() (byte, error) {
+	b, err := hr.r.ReadByte()
+	if err == nil {
+		hr.h.Write([]byte{b})
+	}
+	return b, err
+}
+
+func readSnapshotBytes(hr *hashReader) ([]byte, error) {
+	n, err := binary.ReadUvarint(hr)
+	if err != nil {
+		return nil, snapshotReadError(err)
+	}
+	if n > snapshotMaxLen {
+		return nil, fmt.Errorf("%w: length %d out of range", ErrCorrupt, n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(hr, b); err != nil {
+		return nil, snapshotReadError(err)
+	}
+	return b, nil
+}
+
+// snapshotReadError reports a truncated snapshot as ErrCorrupt.
+func snapshotReadError(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("%w: snapshot truncated", ErrCorrupt)
+	}
+	return err
+}
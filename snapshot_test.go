@@ -0,0 +1,207 @@
+package namestore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMemory_SnapshotRestore(t *testing.T) {
+	src := NewMemory().(*Memory)
+	ctx := context.Background()
+
+	_ = src.Set(ctx, "app:a", []byte("alpha"), 0)
+	_ = src.Set(ctx, "app:empty", nil, 0)
+	_ = src.Set(ctx, "app:ttl", []byte("beta"), time.Hour)
+	_ = src.Set(ctx, "app:short", []byte("gamma"), 20*time.Millisecond)
+	_, _ = src.Incr(ctx, "app:n", 42)
+
+	var buf bytes.Buffer
+	if err := src.Snapshot(&buf); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+
+	dst := NewMemory().(*Memory)
+	_ = dst.Set(ctx, "stale", []byte("replaced"), 0)
+	if err := dst.Restore(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+
+	if got, _ := dst.Get(ctx, "app:a"); string(got) != "alpha" {
+		t.Errorf("app:a = %q", got)
+	}
+	if exists, _ := dst.Exists(ctx, "app:empty"); !exists {
+		t.Error("empty value should be restored")
+	}
+	if n, _ := dst.Incr(ctx, "app:n", 0); n != 42 {
+		t.Errorf("counter = %d, want 42", n)
+	}
+	if exists, _ := dst.Exists(ctx, "stale"); exists {
+		t.Error("Restore should replace existing contents")
+	}
+
+	// Expiry times are absolute: the TTL keeps counting down.
+	srcTTL := src.data["app:ttl"].expire
+	if got := dst.data["app:ttl"].expire; !got.Equal(srcTTL) {
+		t.Errorf("expire = %v, want %v", got, srcTTL)
+	}
+
+	var want int64
+	for k, e := range dst.data {
+		want += int64(len(k) + len(e.value))
+	}
+	if dst.used != want {
+		t.Errorf("used = %d, want %d", dst.used, want)
+	}
+
+	// Entries that expired since the snapshot are dropped on load.
+	time.Sleep(30 * time.Millisecond)
+	late := NewMemory().(*Memory)
+	_ = late.Restore(bytes.NewReader(buf.Bytes()))
+	if _, ok := late.data["app:short"]; ok {
+		t.Error("expired entry should be dropped on load")
+	}
+	if len(late.data) != 4 {
+		t.Errorf("restored %d entries, want 4", len(late.data))
+	}
+}
+
+func TestMemory_RestoreCorrupt(t *testing.T) {
+	src := NewMemory().(*Memory)
+	ctx := context.Background()
+	for i := 0; i < 10; i++ {
+		_ = src.Set(ctx, fmt.Sprintf("k%d", i), []byte("value"), 0)
+	}
+	var buf bytes.Buffer
+	_ = src.Snapshot(&buf)
+	good := buf.Bytes()
+
+	flipped := bytes.Clone(good)
+	flipped[len(flipped)/2] ^= 0xff
+
+	badVersion := bytes.Clone(good)
+	badVersion[len(snapshotMagic)+1] = 99
+
+	cases := map[string][]byte{
+		"flipped byte":  flipped,
+		"truncated":     good[:len(good)-3],
+		"empty":         nil,
+		"bad magic":     append([]byte("NOTSNP"), good[6:]...),
+		"wrong version": badVersion,
+	}
+	for name, data := range cases {
+		dst := NewMemory().(*Memory)
+		_ = dst.Set(ctx, "keep", []byte("v"), 0)
+
+		err := dst.Restore(bytes.NewReader(data))
+		if !errors.Is(err, ErrCorrupt) {
+			t.Errorf("%s: Restore = %v, want ErrCorrupt", name, err)
+		}
+		if exists, _ := dst.Exists(ctx, "keep"); !exists {
+			t.Errorf("%s: failed Restore modified the store", name)
+		}
+	}
+}
+
+func TestOpenMemory_SnapshotFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.snap")
+	ctx := context.Background()
+
+	m, err := OpenMemory(WithSnapshotFile(path, 0))
+	if err != nil {
+		t.Fatalf("OpenMemory without existing file failed: %v", err)
+	}
+	_ = m.Set(ctx, "k", []byte("v"), 0)
+	if err := m.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	m, err = OpenMemory(WithSnapshotFile(path, 0))
+	if err != nil {
+		t.Fatalf("OpenMemory failed: %v", err)
+	}
+	defer m.Close()
+	if got, _ := m.Get(ctx, "k"); string(got) != "v" {
+		t.Errorf("Get after reopen = %q", got)
+	}
+
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("directory holds %d files, want only the snapshot", len(entries))
+	}
+}
+
+func TestOpenMemory_PeriodicSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.snap")
+	ctx := context.Background()
+
+	m, err := OpenMemory(WithSnapshotFile(path, 5*time.Millisecond))
+	if err != nil {
+		t.Fatalf("OpenMemory failed: %v", err)
+	}
+	defer m.Close()
+	_ = m.Set(ctx, "k", []byte("v"), 0)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		other := NewMemory().(*Memory)
+		if err := other.LoadSnapshot(path); err == nil {
+			if exists, _ := other.Exists(ctx, "k"); exists {
+				break
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("periodic snapshot was not written")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestOpenMemory_CorruptSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.snap")
+	if err := os.WriteFile(path, []byte("garbage"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := OpenMemory(WithSnapshotFile(path, time.Millisecond)); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("OpenMemory = %v, want ErrCorrupt", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if data, _ := os.ReadFile(path); string(data) != "garbage" {
+		t.Error("failed OpenMemory must not overwrite the existing file")
+	}
+}
+
+func TestMemory_SaveSnapshotKeepsPreviousOnFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.snap")
+	m := NewMemory().(*Memory)
+	_ = m.Set(context.Background(), "k", []byte("v"), 0)
+	if err := m.SaveSnapshot(path); err != nil {
+		t.Fatalf("SaveSnapshot failed: %v", err)
+	}
+	before, _ := os.ReadFile(path)
+
+	errWrite := errors.New("disk full")
+	err := writeFileAtomic(path, func(w io.Writer) error {
+		_, _ = w.Write([]byte("partial"))
+		return errWrite
+	})
+	if !errors.Is(err, errWrite) {
+		t.Fatalf("writeFileAtomic = %v", err)
+	}
+
+	after, _ := os.ReadFile(path)
+	if !bytes.Equal(before, after) {
+		t.Error("failed write replaced the previous snapshot")
+	}
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("temporary file left behind: %d files", len(entries))
+	}
+}
@@ -11,6 +11,15 @@ var (
 	ErrNotFound       = errors.New("namestore: not found")
 	ErrTypeMismatch   = errors.New("namestore: type mismatch")
 	ErrInvalidPattern = errors.New("namestore: invalid pattern")
+	ErrUnsupported    = errors.New("namestore: operation not supported by driver")
+	ErrOutOfMemory    = errors.New("namestore: out of memory")
+	ErrDecode         = errors.New("namestore: decode failed")
+	ErrInvalidCursor  = errors.New("namestore: invalid cursor")
+	ErrTxConflict     = errors.New("namestore: transaction conflict")
+	ErrCorrupt        = errors.New("namestore: corrupt data")
+	ErrLocked         = errors.New("namestore: store locked by another process")
+	ErrClosed         = errors.New("namestore: driver closed")
+	ErrCircuitOpen    = errors.New("namestore: circuit breaker open")
 )
 
 // Driver describes comprehensive KV storage operations.
@@ -34,6 +43,11 @@ type Driver interface {
 
 	// Namespace operations
 	Keys(ctx context.Context, prefix, pattern string) ([]string, error)
+	// Scan returns a page of keys matching the prefix and pattern, starting at
+	// cursor (0 to begin) and examining roughly count keys. The returned cursor
+	// resumes the scan; 0 means the scan is complete. Pages may be empty before
+	// the scan ends.
+	Scan(ctx context.Context, prefix, pattern string, cursor uint64, count int) ([]string, uint64, error)
 	Clear(ctx context.Context, prefix string) error
 
 	// Atomic operations
@@ -95,13 +109,22 @@ type Client[TKey ~string] interface {
 
 	// Namespace operations
 	Keys(ctx context.Context, pattern string) ([]TKey, error)
+	Scan(ctx context.Context, pattern string, cursor uint64, count int) ([]TKey, uint64, error)
 	Clear(ctx context.Context) error
+	Watch(ctx context.Context, pattern string) (<-chan Event[TKey], error)
+
+	// Transactions
+	Tx(ctx context.Context, fn func(tx Tx[TKey]) error, watch ...TKey) error
 
 	// Atomic operations
 	Incr(ctx context.Context, key TKey, delta int64) (int64, error)
 	Decr(ctx context.Context, key TKey, delta int64) (int64, error)
 	GetSet(ctx context.Context, key TKey, newValue []byte) ([]byte, error)
 	CompareAndSwap(ctx context.Context, key TKey, oldValue, newValue []byte, ttl time.Duration) (bool, error)
+
+	// Cache-aside
+	GetOrLoad(ctx context.Context, key TKey, ttl time.Duration, load Loader[TKey]) ([]byte, error)
+	MGetOrLoad(ctx context.Context, keys []TKey, ttl time.Duration, load BatchLoader[TKey]) (map[TKey][]byte, error)
 }
 
 type client[TKey ~string] struct {
@@ -110,6 +133,12 @@ type client[TKey ~string] struct {
 	driver          Driver
 	logger          Logger
 	logTag          string
+	metrics         Metrics
+	tracer          Tracer
+	middleware      []Middleware
+	negative        *Memory
+	negativeTTL     time.Duration
+	store           any // identifies the driver for shared loads, see storeID
 }
 
 // New creates a namespace-scoped Client.
@@ -127,6 +156,8 @@ func New[TKey ~string](rootNS, domain string, opts ...Option[TKey]) Client[TKey]
 	for _, opt := range opts {
 		opt(c)
 	}
+	c.store = storeID(c.driver, c)
+	c.driver = Chain(c.driver, c.middleware...)
 	return c
 }
 
@@ -158,42 +189,56 @@ func (c *client[TKey]) logf(level string, ctx context.Context, format string, ar
 }
 
 func (c *client[TKey]) Set(ctx context.Context, key TKey, value []byte, ttl time.Duration) error {
+	ctx, call := c.begin(ctx, "Set")
 	err := c.driver.Set(ctx, c.key(key), value, ttl)
 	if err != nil {
 		c.logf("error", ctx, "Set %s failed: %v", key, err)
 	}
+	c.end(ctx, call, Observation{}, err)
 	return err
 }
 
 func (c *client[TKey]) SetNX(ctx context.Context, key TKey, value []byte, ttl time.Duration) (bool, error) {
+	ctx, call := c.begin(ctx, "SetNX")
 	ok, err := c.driver.SetNX(ctx, c.key(key), value, ttl)
 	if err != nil {
 		c.logf("error", ctx, "SetNX %s failed: %v", key, err)
 	}
+	c.end(ctx, call, Observation{}, err)
 	return ok, err
 }
 
 func (c *client[TKey]) Get(ctx context.Context, key TKey) ([]byte, error) {
+	ctx, call := c.begin(ctx, "Get")
 	data, err := c.driver.Get(ctx, c.key(key))
 	if err != nil && !errors.Is(err, ErrNotFound) {
 		c.logf("error", ctx, "Get %s failed: %v", key, err)
 	}
+	c.end(ctx, call, Observation{Hits: hit(err == nil)}, err)
 	return data, err
 }
 
 func (c *client[TKey]) Delete(ctx context.Context, key TKey) error {
+	ctx, call := c.begin(ctx, "Delete")
 	err := c.driver.Delete(ctx, c.key(key))
 	if err != nil {
 		c.logf("error", ctx, "Delete %s failed: %v", key, err)
 	}
+	c.end(ctx, call, Observation{}, err)
 	return err
 }
 
 func (c *client[TKey]) Exists(ctx context.Context, key TKey) (bool, error) {
+	ctx, call := c.begin(ctx, "Exists")
 	exists, err := c.driver.Exists(ctx, c.key(key))
 	if err != nil {
 		c.logf("error", ctx, "Exists %s failed: %v", key, err)
 	}
+	var o Observation
+	if err == nil {
+		o.Hits, o.Misses = hit(exists), hit(!exists)
+	}
+	c.end(ctx, call, o, err)
 	return exists, err
 }
 
@@ -203,6 +248,7 @@ func (c *client[TKey]) MGet(ctx context.Context, keys ...TKey) (map[TKey][]byte,
 		return make(map[TKey][]byte), nil
 	}
 
+	ctx, call := c.begin(ctx, "MGet")
 	fullKeys := make([]string, len(keys))
 	for i, k := range keys {
 		fullKeys[i] = c.key(k)
@@ -211,6 +257,7 @@ func (c *client[TKey]) MGet(ctx context.Context, keys ...TKey) (map[TKey][]byte,
 	result, err := c.driver.MGet(ctx, fullKeys)
 	if err != nil {
 		c.logf("error", ctx, "MGet failed: %v", err)
+		c.end(ctx, call, Observation{BatchSize: len(keys)}, err)
 		return nil, err
 	}
 
@@ -222,6 +269,11 @@ func (c *client[TKey]) MGet(ctx context.Context, keys ...TKey) (map[TKey][]byte,
 		}
 	}
 
+	c.end(ctx, call, Observation{
+		Hits:      len(businessResult),
+		Misses:    len(keys) - len(businessResult),
+		BatchSize: len(keys),
+	}, nil)
 	return businessResult, nil
 }
 
@@ -231,6 +283,7 @@ func (c *client[TKey]) MSet(ctx context.Context, pairs map[TKey][]byte, ttl time
 		return nil
 	}
 
+	ctx, call := c.begin(ctx, "MSet")
 	fullPairs := make(map[string][]byte, len(pairs))
 	for k, v := range pairs {
 		fullPairs[c.key(k)] = v
@@ -240,6 +293,7 @@ func (c *client[TKey]) MSet(ctx context.Context, pairs map[TKey][]byte, ttl time
 	if err != nil {
 		c.logf("error", ctx, "MSet failed: %v", err)
 	}
+	c.end(ctx, call, Observation{BatchSize: len(pairs)}, err)
 	return err
 }
 
@@ -249,6 +303,7 @@ func (c *client[TKey]) MDel(ctx context.Context, keys ...TKey) error {
 		return nil
 	}
 
+	ctx, call := c.begin(ctx, "MDel")
 	fullKeys := make([]string, len(keys))
 	for i, k := range keys {
 		fullKeys[i] = c.key(k)
@@ -258,45 +313,71 @@ func (c *client[TKey]) MDel(ctx context.Context, keys ...TKey) error {
 	if err != nil {
 		c.logf("error", ctx, "MDel failed: %v", err)
 	}
+	c.end(ctx, call, Observation{BatchSize: len(keys)}, err)
 	return err
 }
 
 // TTL returns the remaining time-to-live for a key. Returns -1 if key has no expiration.
 func (c *client[TKey]) TTL(ctx context.Context, key TKey) (time.Duration, error) {
+	ctx, call := c.begin(ctx, "TTL")
 	ttl, err := c.driver.TTL(ctx, c.key(key))
 	if err != nil && !errors.Is(err, ErrNotFound) {
 		c.logf("error", ctx, "TTL %s failed: %v", key, err)
 	}
+	c.end(ctx, call, Observation{Hits: hit(err == nil)}, err)
 	return ttl, err
 }
 
 // Expire sets or updates the TTL for an existing key.
 func (c *client[TKey]) Expire(ctx context.Context, key TKey, ttl time.Duration) error {
+	ctx, call := c.begin(ctx, "Expire")
 	err := c.driver.Expire(ctx, c.key(key), ttl)
 	if err != nil && !errors.Is(err, ErrNotFound) {
 		c.logf("error", ctx, "Expire %s failed: %v", key, err)
 	}
+	c.end(ctx, call, Observation{}, err)
 	return err
 }
 
 // Persist removes the expiration from a key.
 func (c *client[TKey]) Persist(ctx context.Context, key TKey) error {
+	ctx, call := c.begin(ctx, "Persist")
 	err := c.driver.Persist(ctx, c.key(key))
 	if err != nil && !errors.Is(err, ErrNotFound) {
 		c.logf("error", ctx, "Persist %s failed: %v", key, err)
 	}
+	c.end(ctx, call, Observation{}, err)
 	return err
 }
 
 // Keys returns all business keys matching the pattern within this namespace.
 func (c *client[TKey]) Keys(ctx context.Context, pattern string) ([]TKey, error) {
+	ctx, call := c.begin(ctx, "Keys")
 	fullKeys, err := c.driver.Keys(ctx, c.prefix, pattern)
+	c.end(ctx, call, Observation{}, err)
 	if err != nil {
 		c.logf("error", ctx, "Keys pattern=%s failed: %v", pattern, err)
 		return nil, err
 	}
 
-	// Strip prefix to get business keys
+	return c.businessKeys(fullKeys), nil
+}
+
+// Scan returns one page of business keys matching the pattern, resuming from
+// cursor. Start with cursor 0 and continue until the returned cursor is 0.
+func (c *client[TKey]) Scan(ctx context.Context, pattern string, cursor uint64, count int) ([]TKey, uint64, error) {
+	ctx, call := c.begin(ctx, "Scan")
+	fullKeys, next, err := c.driver.Scan(ctx, c.prefix, pattern, cursor, count)
+	c.end(ctx, call, Observation{}, err)
+	if err != nil {
+		c.logf("error", ctx, "Scan pattern=%s cursor=%d failed: %v", pattern, cursor, err)
+		return nil, 0, err
+	}
+	return c.businessKeys(fullKeys), next, nil
+}
+
+// businessKeys strips the namespace prefix from full keys.
+func (c *client[TKey]) businessKeys(fullKeys []string) []TKey {
 	prefixLen := len(c.prefix) + 1 // +1 for the colon
 	businessKeys := make([]TKey, 0, len(fullKeys))
 	for _, fullKey := range fullKeys {
@@ -304,51 +385,98 @@ func (c *client[TKey]) Keys(ctx context.Context, pattern string) ([]TKey, error)
 			businessKeys = append(businessKeys, TKey(fullKey[prefixLen:]))
 		}
 	}
-
-	return businessKeys, nil
+	return businessKeys
 }
 
 // Clear removes all keys in this namespace.
 func (c *client[TKey]) Clear(ctx context.Context) error {
+	ctx, call := c.begin(ctx, "Clear")
 	err := c.driver.Clear(ctx, c.prefix)
 	if err != nil {
 		c.logf("error", ctx, "Clear failed: %v", err)
 	}
+	c.end(ctx, call, Observation{}, err)
 	return err
 }
 
+// Watch streams changes to keys in this namespace matching pattern until ctx
+// is done. EventClear events have an empty key. The driver, and any
+// middleware around it (see WithMiddleware), must implement Watcher;
+// otherwise Watch returns ErrUnsupported. The channel is also closed if the consumer falls too far
+// behind the driver's buffer, in which case events were missed and cached
+// state should be rebuilt.
+func (c *client[TKey]) Watch(ctx context.Context, pattern string) (<-chan Event[TKey], error) {
+	w, ok := c.driver.(Watcher)
+	if !ok {
+		return nil, fmt.Errorf("%w: driver does not support Watch", ErrUnsupported)
+	}
+
+	ctx, call := c.begin(ctx, "Watch")
+	src, err := w.Watch(ctx, c.prefix, pattern)
+	c.end(ctx, call, Observation{}, err)
+	if err != nil {
+		c.logf("error", ctx, "Watch pattern=%s failed: %v", pattern, err)
+		return nil, err
+	}
+
+	out := make(chan Event[TKey])
+	go func() {
+		defer close(out)
+		for ev := range src {
+			e := Event[TKey]{Type: ev.Type}
+			if ev.Type != EventClear {
+				e.Key = TKey(ev.Key[len(c.prefix)+1:])
+			}
+			select {
+			case out <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
 // Incr atomically increments the integer value of a key by delta.
 func (c *client[TKey]) Incr(ctx context.Context, key TKey, delta int64) (int64, error) {
+	ctx, call := c.begin(ctx, "Incr")
 	val, err := c.driver.Incr(ctx, c.key(key), delta)
 	if err != nil {
 		c.logf("error", ctx, "Incr %s failed: %v", key, err)
 	}
+	c.end(ctx, call, Observation{}, err)
 	return val, err
 }
 
 // Decr atomically decrements the integer value of a key by delta.
 func (c *client[TKey]) Decr(ctx context.Context, key TKey, delta int64) (int64, error) {
+	ctx, call := c.begin(ctx, "Decr")
 	val, err := c.driver.Decr(ctx, c.key(key), delta)
 	if err != nil {
 		c.logf("error", ctx, "Decr %s failed: %v", key, err)
 	}
+	c.end(ctx, call, Observation{}, err)
 	return val, err
 }
 
 // GetSet atomically sets a key to a new value and returns the old value.
 func (c *client[TKey]) GetSet(ctx context.Context, key TKey, newValue []byte) ([]byte, error) {
+	ctx, call := c.begin(ctx, "GetSet")
 	oldVal, err := c.driver.GetSet(ctx, c.key(key), newValue)
 	if err != nil && !errors.Is(err, ErrNotFound) {
 		c.logf("error", ctx, "GetSet %s failed: %v", key, err)
 	}
+	c.end(ctx, call, Observation{Hits: hit(err == nil)}, err)
 	return oldVal, err
 }
 
 // CompareAndSwap atomically compares and swaps the value if it matches oldValue.
 func (c *client[TKey]) CompareAndSwap(ctx context.Context, key TKey, oldValue, newValue []byte, ttl time.Duration) (bool, error) {
+	ctx, call := c.begin(ctx, "CompareAndSwap")
 	ok, err := c.driver.CompareAndSwap(ctx, c.key(key), oldValue, newValue, ttl)
 	if err != nil {
 		c.logf("error", ctx, "CompareAndSwap %s failed: %v", key, err)
 	}
+	c.end(ctx, call, Observation{}, err)
 	return ok, err
 }
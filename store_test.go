@@ -87,6 +87,14 @@ func (m *mockDriver) Keys(ctx context.Context, prefix, pattern string) ([]string
 	return nil, nil
 }
 
+func (m *mockDriver) Scan(ctx context.Context, prefix, pattern string, cursor uint64, count int) ([]string, uint64, error) {
+	if m.keysFunc != nil {
+		keys, err := m.keysFunc(ctx, prefix, pattern)
+		return keys, 0, err
+	}
+	return nil, 0, nil
+}
+
 func (m *mockDriver) Clear(ctx context.Context, prefix string) error {
 	return nil
 }
@@ -0,0 +1,113 @@
+package namestore
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Span attribute keys set by Client.
+const (
+	AttrNamespace = "namestore.namespace" // string, "rootNS:domain"
+	AttrOp        = "namestore.op"        // string, the Client method
+	AttrKeys      = "namestore.keys"      // int, keys of a batch call or writes of Tx
+	AttrHit       = "namestore.hit"       // bool, whether a single-key read found its key
+	AttrHits      = "namestore.hits"      // int, keys found by MGet
+	AttrMisses    = "namestore.misses"    // int, keys not found by MGet
+	AttrError     = "error"               // bool, set when the call failed
+)
+
+// Attribute is a key-value pair attached to a Span.
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+// Span is one traced Client call, in the style of an OpenTelemetry span.
+type Span interface {
+	SetAttributes(attrs ...Attribute)
+	// RecordError records a failure of the call.
+	RecordError(err error)
+	End()
+}
+
+// Tracer starts a Span for every Client call. The context it returns is
+// passed down to the Driver, so driver spans nest under the client's. An
+// adapter for OpenTelemetry wraps trace.Tracer.Start, converting Attribute
+// values to attribute.KeyValue.
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// WithTracer wraps every Client call in a span named "namestore.<Op>", such
+// as "namestore.Get", carrying the Attr* attributes. ErrNotFound is recorded
+// as a miss, not as an error.
+func WithTracer[TKey ~string](t Tracer) Option[TKey] {
+	return func(c *client[TKey]) {
+		c.tracer = t
+	}
+}
+
+// clientCall is a Client call in progress.
+type clientCall struct {
+	op    string
+	start time.Time
+	span  Span
+}
+
+// begin starts a call of op, returning the context to pass to the driver.
+// Without metrics and tracing it leaves ctx alone and skips the clock.
+func (c *client[TKey]) begin(ctx context.Context, op string) (context.Context, clientCall) {
+	call := clientCall{op: op}
+	if c.metrics == nil && c.tracer == nil {
+		return ctx, call
+	}
+	call.start = time.Now()
+	if c.tracer != nil {
+		ctx, call.span = c.tracer.Start(ctx, "namestore."+op)
+		call.span.SetAttributes(Attribute{AttrNamespace, c.prefix}, Attribute{AttrOp, op})
+	}
+	return ctx, call
+}
+
+// end finishes a call that returned err. o carries any hits and batch size;
+// end fills in the rest and reports it to the metrics and the span.
+func (c *client[TKey]) end(ctx context.Context, call clientCall, o Observation, err error) {
+	if c.metrics == nil && c.tracer == nil {
+		return
+	}
+	o.Namespace = c.prefix
+	o.Op = call.op
+	o.Duration = time.Since(call.start)
+	o.Err = err
+	if errors.Is(err, ErrNotFound) {
+		o.Misses++
+	}
+	if c.metrics != nil {
+		c.metrics.Observe(ctx, o)
+	}
+	if call.span != nil {
+		endSpan(call.span, o)
+	}
+}
+
+func endSpan(span Span, o Observation) {
+	var attrs []Attribute
+	if o.BatchSize > 0 {
+		attrs = append(attrs, Attribute{AttrKeys, o.BatchSize})
+	}
+	switch {
+	case (o.Op == "MGet" || o.Op == "MGetOrLoad") && o.Err == nil:
+		attrs = append(attrs, Attribute{AttrHits, o.Hits}, Attribute{AttrMisses, o.Misses})
+	case o.Hits+o.Misses == 1:
+		attrs = append(attrs, Attribute{AttrHit, o.Hits == 1})
+	}
+	if o.Failed() {
+		attrs = append(attrs, Attribute{AttrError, true})
+		span.SetAttributes(attrs...)
+		span.RecordError(o.Err)
+	} else if len(attrs) > 0 {
+		span.SetAttributes(attrs...)
+	}
+	span.End()
+}
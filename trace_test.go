@@ -0,0 +1,160 @@
+package namestore_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"code.byted.org/khicago/namestore"
+	"code.byted.org/khicago/namestore/namestoretest"
+)
+
+// spanDriver records the span ID of the context of every call.
+type spanDriver struct {
+	namestore.Driver
+	ids []int
+}
+
+func (d *spanDriver) Get(ctx context.Context, key string) ([]byte, error) {
+	d.ids = append(d.ids, namestoretest.SpanID(ctx))
+	return d.Driver.Get(ctx, key)
+}
+
+func (d *spanDriver) MGet(ctx context.Context, keys []string) (map[string][]byte, error) {
+	d.ids = append(d.ids, namestoretest.SpanID(ctx))
+	return d.Driver.MGet(ctx, keys)
+}
+
+func (d *spanDriver) Keys(ctx context.Context, prefix, pattern string) ([]string, error) {
+	d.ids = append(d.ids, namestoretest.SpanID(ctx))
+	return d.Driver.Keys(ctx, prefix, pattern)
+}
+
+func (d *spanDriver) CompareAndSwap(ctx context.Context, key string, oldValue, newValue []byte, ttl time.Duration) (bool, error) {
+	d.ids = append(d.ids, namestoretest.SpanID(ctx))
+	return d.Driver.CompareAndSwap(ctx, key, oldValue, newValue, ttl)
+}
+
+func TestWithTracer_Spans(t *testing.T) {
+	tracer := namestoretest.NewRecordingTracer()
+	d := &spanDriver{Driver: namestore.NewMemory()}
+	c := namestore.New[string]("app", "users", namestore.WithDriver[string](d), namestore.WithTracer[string](tracer))
+	ctx := context.Background()
+
+	_ = c.Set(ctx, "1", []byte("alice"), 0)
+	_, _ = c.Get(ctx, "1")
+	_, _ = c.Get(ctx, "missing")
+	_, _ = c.MGet(ctx, "1", "2", "3")
+	_, _ = c.Keys(ctx, "*")
+	_, _ = c.CompareAndSwap(ctx, "1", []byte("alice"), []byte("bob"), 0)
+	_, _ = c.Incr(ctx, "1", 1)
+
+	spans := tracer.Spans()
+	if len(spans) != 7 {
+		t.Fatalf("got %d spans, want 7", len(spans))
+	}
+	for _, s := range spans {
+		if !s.Ended || s.ParentID != 0 || s.Attributes[namestore.AttrNamespace] != "app:users" ||
+			s.Name != "namestore."+s.Attributes[namestore.AttrOp].(string) {
+			t.Errorf("span %+v", s)
+		}
+	}
+
+	want := []struct {
+		name  string
+		attrs map[string]any
+	}{
+		{"namestore.Set", nil},
+		{"namestore.Get", map[string]any{namestore.AttrHit: true}},
+		{"namestore.Get", map[string]any{namestore.AttrHit: false}},
+		{"namestore.MGet", map[string]any{namestore.AttrKeys: 3, namestore.AttrHits: 1, namestore.AttrMisses: 2}},
+		{"namestore.Keys", nil},
+		{"namestore.CompareAndSwap", nil},
+		{"namestore.Incr", map[string]any{namestore.AttrError: true}},
+	}
+	for i, w := range want {
+		s := spans[i]
+		if s.Name != w.name || len(s.Attributes) != 2+len(w.attrs) {
+			t.Errorf("span %d = %s %v, want %s %v", i, s.Name, s.Attributes, w.name, w.attrs)
+			continue
+		}
+		for k, v := range w.attrs {
+			if s.Attributes[k] != v {
+				t.Errorf("span %d %s = %v, want %v", i, k, s.Attributes[k], v)
+			}
+		}
+	}
+	if errs := spans[6].Errors; len(errs) != 1 || !errors.Is(errs[0], namestore.ErrTypeMismatch) {
+		t.Errorf("Incr span errors = %v", errs)
+	}
+	if len(spans[2].Errors) != 0 {
+		t.Errorf("ErrNotFound recorded as an error: %v", spans[2].Errors)
+	}
+
+	// The driver ran inside each span: Get, Get, MGet, Keys, CompareAndSwap.
+	wantIDs := []int{spans[1].ID, spans[2].ID, spans[3].ID, spans[4].ID, spans[5].ID}
+	if len(d.ids) != len(wantIDs) {
+		t.Fatalf("driver saw span IDs %v, want %v", d.ids, wantIDs)
+	}
+	for i := range wantIDs {
+		if d.ids[i] != wantIDs[i] {
+			t.Errorf("driver saw span IDs %v, want %v", d.ids, wantIDs)
+			break
+		}
+	}
+}
+
+func TestWithTracer_ParentSpan(t *testing.T) {
+	tracer := namestoretest.NewRecordingTracer()
+	c := namestore.New[string]("app", "users", namestore.WithTracer[string](tracer))
+
+	ctx, parent := tracer.Start(context.Background(), "handler")
+	_ = c.Set(ctx, "1", []byte("v"), 0)
+	err := c.Tx(ctx, func(tx namestore.Tx[string]) error {
+		tx.Set("2", []byte("v"), 0)
+		tx.Delete("3")
+		return nil
+	})
+	parent.End()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spans := tracer.Spans()
+	if len(spans) != 3 {
+		t.Fatalf("got %d spans, want 3", len(spans))
+	}
+	for _, s := range spans[1:] {
+		if s.ParentID != spans[0].ID {
+			t.Errorf("%s has parent %d, want %d", s.Name, s.ParentID, spans[0].ID)
+		}
+	}
+	if tx := spans[2]; tx.Name != "namestore.Tx" || tx.Attributes[namestore.AttrKeys] != 2 {
+		t.Errorf("Tx span = %+v", tx)
+	}
+}
+
+func TestWithTracer_AndMetrics(t *testing.T) {
+	tracer := namestoretest.NewRecordingTracer()
+	metrics := &countingMetrics{}
+	c := namestore.New[string]("app", "users", namestore.WithTracer[string](tracer), namestore.WithMetrics[string](metrics))
+
+	_, _ = c.Get(context.Background(), "missing")
+	if spans := tracer.Spans(); len(spans) != 1 || spans[0].Attributes[namestore.AttrHit] != false {
+		t.Errorf("spans = %+v", spans)
+	}
+	if metrics.n != 1 || metrics.last.Misses != 1 || metrics.last.Op != "Get" {
+		t.Errorf("metrics saw %d observations, last %+v", metrics.n, metrics.last)
+	}
+}
+
+type countingMetrics struct {
+	n    int
+	last namestore.Observation
+}
+
+func (m *countingMetrics) Observe(ctx context.Context, o namestore.Observation) {
+	m.n++
+	m.last = o
+}
@@ -0,0 +1,347 @@
+package namestore
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// TxOpKind identifies a write queued in a transaction.
+type TxOpKind int
+
+const (
+	TxSet TxOpKind = iota
+	TxDelete
+	TxIncr
+	TxExpire
+)
+
+// TxOp is a write queued in a transaction, addressed by full key.
+type TxOp struct {
+	Kind  TxOpKind
+	Key   string
+	Value []byte        // TxSet
+	TTL   time.Duration // TxSet and TxExpire
+	Delta int64         // TxIncr
+}
+
+// TxDriver is implemented by drivers that support optimistic multi-key
+// transactions. It is an optional extension of Driver used by Client.Tx.
+type TxDriver interface {
+	// Versions returns the current version of each key. Versions change on
+	// every write; missing and expired keys have version 0.
+	Versions(ctx context.Context, keys []string) (map[string]uint64, error)
+	// Commit applies ops atomically, in order, provided every watched key
+	// still has the given version. Otherwise nothing is applied and
+	// ErrTxConflict is returned. If an op cannot be applied (for example
+	// TxIncr on a non-integer value), nothing is applied either.
+	Commit(ctx context.Context, watched map[string]uint64, ops []TxOp) error
+}
+
+// Tx reads values and queues writes inside Client.Tx. Writes are buffered
+// until the transaction commits, so reads do not observe them.
+type Tx[TKey ~string] interface {
+	// Get reads the committed value of key and watches it: the transaction
+	// fails with ErrTxConflict if key changes before commit.
+	Get(ctx context.Context, key TKey) ([]byte, error)
+
+	Set(key TKey, value []byte, ttl time.Duration)
+	Delete(key TKey)
+	Incr(key TKey, delta int64)
+	// Expire sets the TTL of key at commit time; ttl <= 0 removes it. Keys
+	// that do not exist at commit are left alone.
+	Expire(key TKey, ttl time.Duration)
+}
+
+type clientTx[TKey ~string] struct {
+	c       *client[TKey]
+	driver  TxDriver
+	watched map[string]uint64
+	ops     []TxOp
+}
+
+func (t *clientTx[TKey]) Get(ctx context.Context, key TKey) ([]byte, error) {
+	full := t.c.key(key)
+	if _, ok := t.watched[full]; !ok {
+		// Read the version before the value: a write in between then shows up
+		// as a conflict at commit instead of going unnoticed.
+		versions, err := t.driver.Versions(ctx, []string{full})
+		if err != nil {
+			return nil, err
+		}
+		t.watched[full] = versions[full]
+	}
+	return t.c.driver.Get(ctx, full)
+}
+
+func (t *clientTx[TKey]) Set(key TKey, value []byte, ttl time.Duration) {
+	t.ops = append(t.ops, TxOp{Kind: TxSet, Key: t.c.key(key), Value: clone(value), TTL: ttl})
+}
+
+func (t *clientTx[TKey]) Delete(key TKey) {
+	t.ops = append(t.ops, TxOp{Kind: TxDelete, Key: t.c.key(key)})
+}
+
+func (t *clientTx[TKey]) Incr(key TKey, delta int64) {
+	t.ops = append(t.ops, TxOp{Kind: TxIncr, Key: t.c.key(key), Delta: delta})
+}
+
+func (t *clientTx[TKey]) Expire(key TKey, ttl time.Duration) {
+	t.ops = append(t.ops, TxOp{Kind: TxExpire, Key: t.c.key(key), TTL: ttl})
+}
+
+// Tx runs fn and commits the writes it queues atomically. The keys in watch,
+// and every key read through tx.Get, are checked at commit: if any changed
+// since it was first observed, nothing is written and Tx returns
+// ErrTxConflict, after which the caller may retry. If fn returns an error,
+// nothing is written and that error is returned. The driver, and any
+// middleware around it (see WithMiddleware), must implement TxDriver;
+// otherwise Tx returns ErrUnsupported.
+func (c *client[TKey]) Tx(ctx context.Context, fn func(tx Tx[TKey]) error, watch ...TKey) error {
+	d, ok := c.driver.(TxDriver)
+	if !ok {
+		return fmt.Errorf("%w: driver does not support transactions", ErrUnsupported)
+	}
+
+	ctx, call := c.begin(ctx, "Tx")
+	tx := &clientTx[TKey]{c: c, driver: d, watched: make(map[string]uint64, len(watch))}
+	err := c.runTx(ctx, tx, fn, watch)
+	c.end(ctx, call, Observation{BatchSize: len(tx.ops)}, err)
+	return err
+}
+
+// runTx watches the keys, runs fn and commits tx.
+func (c *client[TKey]) runTx(ctx context.Context, tx *clientTx[TKey], fn func(tx Tx[TKey]) error, watch []TKey) error {
+	d := tx.driver
+	if len(watch) > 0 {
+		keys := make([]string, len(watch))
+		for i, k := range watch {
+			keys[i] = c.key(k)
+		}
+		versions, err := d.Versions(ctx, keys)
+		if err != nil {
+			c.logf("error", ctx, "Tx watch failed: %v", err)
+			return err
+		}
+		for _, k := range keys {
+			tx.watched[k] = versions[k]
+		}
+	}
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if len(tx.ops) == 0 && len(tx.watched) == 0 {
+		return nil
+	}
+
+	err := d.Commit(ctx, tx.watched, tx.ops)
+	if err != nil && !errors.Is(err, ErrTxConflict) {
+		c.logf("error", ctx, "Tx commit failed: %v", err)
+	}
+	return err
+}
+
+// versionLocked returns the version of a live key, or 0.
+func (m *Memory) versionLocked(key string, now time.Time) uint64 {
+	if e, ok := m.data[key]; ok && !e.expiredAt(now) {
+		return e.version
+	}
+	return 0
+}
+
+// Versions implements TxDriver.
+func (m *Memory) Versions(ctx context.Context, keys []string) (map[string]uint64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := m.cfg.now()
+	versions := make(map[string]uint64, len(keys))
+	for _, k := range keys {
+		versions[k] = m.versionLocked(k, now)
+	}
+	return versions, nil
+}
+
+// Commit implements TxDriver.
+func (m *Memory) Commit(ctx context.Context, watched map[string]uint64, ops []TxOp) error {
+	m.mu.Lock()
+	defer m.unlock()
+
+	now := m.cfg.now()
+	for k, v := range watched {
+		if m.versionLocked(k, now) != v {
+			return ErrTxConflict
+		}
+	}
+
+	stage, err := m.stageLocked(now, ops)
+	if err != nil {
+		return err
+	}
+	victims, err := m.planStageLocked(stage)
+	if err != nil {
+		return err
+	}
+	m.evictLocked(victims)
+	m.applyLocked(stage)
+	return nil
+}
+
+// txStage holds the final state of every key written by a transaction.
+type txStage struct {
+	keys  []string // in order of first write
+	final map[string]txFinal
+	grow  growth
+}
+
+type txFinal struct {
+	e      entry
+	exists bool
+	typ    EventType // event to publish; empty if the key is unchanged
+}
+
+// stageLocked computes the outcome of ops, and the space it needs, without
+// modifying data.
+func (m *Memory) stageLocked(now time.Time, ops []TxOp) (*txStage, error) {
+	st := &txStage{final: make(map[string]txFinal, len(ops))}
+	for _, op := range ops {
+		f, ok := st.final[op.Key]
+		if !ok {
+			e, exists := m.data[op.Key]
+			if exists && e.expiredAt(now) {
+				e, exists = entry{}, false
+			}
+			f = txFinal{e: e, exists: exists}
+			st.keys = append(st.keys, op.Key)
+		}
+
+		switch op.Kind {
+		case TxSet:
+			f.e = entry{value: clone(op.Value), expire: expiry(now, op.TTL), stats: f.e.stats}
+			f.exists, f.typ = true, EventSet
+		case TxDelete:
+			f.e, f.exists, f.typ = entry{}, false, EventDel
+		case TxIncr:
+			var current int64
+			if f.exists {
+				if len(f.e.value) != 8 {
+					return nil, fmt.Errorf("%w: key %q", ErrTypeMismatch, op.Key)
+				}
+				current = int64(binary.LittleEndian.Uint64(f.e.value))
+			}
+			buf := make([]byte, 8)
+			binary.LittleEndian.PutUint64(buf, uint64(current+op.Delta))
+			f.e.value = buf
+			f.exists, f.typ = true, EventIncr
+		case TxExpire:
+			if !f.exists {
+				break
+			}
+			f.e.expire = expiry(now, op.TTL)
+			f.typ = EventExpire
+			if f.e.expire.IsZero() {
+				f.typ = EventPersist
+			}
+		default:
+			return nil, fmt.Errorf("namestore: unknown transaction op %d", op.Kind)
+		}
+		st.final[op.Key] = f
+	}
+
+	if m.cfg.limited() {
+		for _, key := range st.keys {
+			if f := st.final[key]; f.exists {
+				st.grow.add(m, key, f.e.value)
+			} else if old, ok := m.data[key]; ok {
+				st.grow.entries--
+				st.grow.bytes -= entrySize(key, old.value)
+			}
+		}
+	}
+	return st, nil
+}
+
+// planStageLocked returns the keys to evict so that st fits, or
+// ErrOutOfMemory.
+func (m *Memory) planStageLocked(st *txStage) ([]string, error) {
+	if !m.cfg.limited() {
+		return nil, nil
+	}
+	protected := func(key string) bool { _, ok := st.final[key]; return ok }
+	return m.planEvictionsLocked(st.grow, protected)
+}
+
+func (m *Memory) applyLocked(st *txStage) {
+	for _, key := range st.keys {
+		f := st.final[key]
+		switch {
+		case f.exists && f.typ != "":
+			m.storeLocked(key, f.e, f.typ)
+		case !f.exists && f.typ != "":
+			m.removeLocked(key, f.typ)
+		}
+	}
+}
+
+// Versions implements TxDriver.
+func (s *ShardedMemory) Versions(ctx context.Context, keys []string) (map[string]uint64, error) {
+	unlock := s.lockKeys(keys)
+	defer unlock()
+
+	now := s.now()
+	versions := make(map[string]uint64, len(keys))
+	for _, k := range keys {
+		versions[k] = s.shard(k).versionLocked(k, now)
+	}
+	return versions, nil
+}
+
+// Commit implements TxDriver, locking every shard the transaction touches.
+func (s *ShardedMemory) Commit(ctx context.Context, watched map[string]uint64, ops []TxOp) error {
+	keys := make([]string, 0, len(watched)+len(ops))
+	for k := range watched {
+		keys = append(keys, k)
+	}
+	byShard := make(map[int][]TxOp)
+	for _, op := range ops {
+		keys = append(keys, op.Key)
+		i := s.shardIndex(op.Key)
+		byShard[i] = append(byShard[i], op)
+	}
+	unlock := s.lockKeys(keys)
+	defer unlock()
+
+	now := s.now()
+	for k, v := range watched {
+		if s.shard(k).versionLocked(k, now) != v {
+			return ErrTxConflict
+		}
+	}
+
+	// Stage and plan evictions on every shard before changing any of them,
+	// so a failure leaves the store untouched.
+	stages := make(map[int]*txStage, len(byShard))
+	victims := make(map[int][]string, len(byShard))
+	for i, shardOps := range byShard {
+		stage, err := s.shards[i].stageLocked(now, shardOps)
+		if err != nil {
+			return err
+		}
+		stages[i] = stage
+	}
+	for i, stage := range stages {
+		v, err := s.shards[i].planStageLocked(stage)
+		if err != nil {
+			return err
+		}
+		victims[i] = v
+	}
+	for i, stage := range stages {
+		s.shards[i].evictLocked(victims[i])
+		s.shards[i].applyLocked(stage)
+	}
+	return nil
+}
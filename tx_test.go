@@ -0,0 +1,260 @@
+package namestore
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClient_Tx(t *testing.T) {
+	c := New[string]("app", "bank")
+	ctx := context.Background()
+
+	_, _ = c.Incr(ctx, "alice", 100)
+	_ = c.Set(ctx, "note", []byte("old"), 0)
+
+	err := c.Tx(ctx, func(tx Tx[string]) error {
+		tx.Incr("alice", -30)
+		tx.Incr("bob", 30)
+		tx.Set("note", []byte("transfer"), time.Hour)
+		tx.Set("tmp", []byte("x"), 0)
+		tx.Delete("tmp")
+		tx.Expire("bob", time.Hour)
+		tx.Expire("missing", time.Hour)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Tx failed: %v", err)
+	}
+
+	alice, _ := c.Incr(ctx, "alice", 0)
+	bob, _ := c.Incr(ctx, "bob", 0)
+	if alice != 70 || bob != 30 {
+		t.Errorf("balances = %d/%d, want 70/30", alice, bob)
+	}
+	if note, _ := c.Get(ctx, "note"); string(note) != "transfer" {
+		t.Errorf("note = %q", note)
+	}
+	if ttl, _ := c.TTL(ctx, "bob"); ttl <= 0 {
+		t.Errorf("bob TTL = %v, want > 0", ttl)
+	}
+	if exists, _ := c.Exists(ctx, "tmp"); exists {
+		t.Error("tmp should be deleted")
+	}
+	if exists, _ := c.Exists(ctx, "missing"); exists {
+		t.Error("Expire in a transaction should not create keys")
+	}
+}
+
+func TestClient_TxConflict(t *testing.T) {
+	c := New[string]("app", "tx")
+	ctx := context.Background()
+	_ = c.Set(ctx, "k", []byte("v1"), 0)
+
+	err := c.Tx(ctx, func(tx Tx[string]) error {
+		_ = c.Set(ctx, "k", []byte("v2"), 0) // concurrent writer
+		tx.Set("other", []byte("x"), 0)
+		return nil
+	}, "k")
+	if !errors.Is(err, ErrTxConflict) {
+		t.Fatalf("Tx = %v, want ErrTxConflict", err)
+	}
+	if exists, _ := c.Exists(ctx, "other"); exists {
+		t.Error("conflicting transaction should not write")
+	}
+
+	// Keys read through tx.Get are watched too, including missing ones.
+	err = c.Tx(ctx, func(tx Tx[string]) error {
+		if _, err := tx.Get(ctx, "new"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get = %v, want ErrNotFound", err)
+		}
+		_ = c.Set(ctx, "new", []byte("created"), 0)
+		tx.Set("new", []byte("mine"), 0)
+		return nil
+	})
+	if !errors.Is(err, ErrTxConflict) {
+		t.Errorf("Tx = %v, want ErrTxConflict", err)
+	}
+
+	// A watched key that expires counts as changed.
+	_ = c.Set(ctx, "session", []byte("v"), 5*time.Millisecond)
+	err = c.Tx(ctx, func(tx Tx[string]) error {
+		time.Sleep(10 * time.Millisecond)
+		tx.Set("x", []byte("y"), 0)
+		return nil
+	}, "session")
+	if !errors.Is(err, ErrTxConflict) {
+		t.Errorf("Tx = %v, want ErrTxConflict after expiry", err)
+	}
+}
+
+func TestClient_TxAbort(t *testing.T) {
+	c := New[string]("app", "tx")
+	ctx := context.Background()
+	_ = c.Set(ctx, "text", []byte("not a number"), 0)
+
+	errAbort := errors.New("abort")
+	err := c.Tx(ctx, func(tx Tx[string]) error {
+		tx.Set("a", []byte("x"), 0)
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Errorf("Tx = %v, want closure error", err)
+	}
+
+	// A failing op aborts the whole transaction.
+	err = c.Tx(ctx, func(tx Tx[string]) error {
+		tx.Set("a", []byte("x"), 0)
+		tx.Incr("text", 1)
+		return nil
+	})
+	if !errors.Is(err, ErrTypeMismatch) {
+		t.Errorf("Tx = %v, want ErrTypeMismatch", err)
+	}
+	if exists, _ := c.Exists(ctx, "a"); exists {
+		t.Error("aborted transactions should not write")
+	}
+}
+
+func TestClient_TxOutOfMemory(t *testing.T) {
+	c := New[string]("app", "tx", WithDriver[string](NewMemory(WithMaxEntries(2))))
+	ctx := context.Background()
+	_ = c.Set(ctx, "a", []byte("v"), 0)
+	_ = c.Set(ctx, "b", []byte("v"), 0)
+
+	err := c.Tx(ctx, func(tx Tx[string]) error {
+		tx.Set("c", []byte("v"), 0)
+		return nil
+	})
+	if !errors.Is(err, ErrOutOfMemory) {
+		t.Errorf("Tx = %v, want ErrOutOfMemory", err)
+	}
+
+	// Deleting in the same transaction frees the room.
+	err = c.Tx(ctx, func(tx Tx[string]) error {
+		tx.Delete("a")
+		tx.Set("c", []byte("v"), 0)
+		return nil
+	})
+	if err != nil {
+		t.Errorf("Tx with delete = %v", err)
+	}
+}
+
+func TestShardedMemory_CommitOutOfMemoryEvictsNothing(t *testing.T) {
+	// Two shards of one entry each; only keys with a TTL can be evicted.
+	s := NewShardedMemory(2, WithMaxEntries(2), WithEvictionPolicy(VolatileLRU)).(*ShardedMemory)
+	ctx := context.Background()
+
+	// keyOn returns a key with the given prefix on shard i.
+	keyOn := func(i int, prefix string) string {
+		for n := 0; ; n++ {
+			if k := fmt.Sprintf("%s%d", prefix, n); s.shardIndex(k) == i {
+				return k
+			}
+		}
+	}
+	volatile, pinned := keyOn(0, "volatile"), keyOn(1, "pinned")
+	_ = s.Set(ctx, volatile, []byte("v"), time.Hour)
+	_ = s.Set(ctx, pinned, []byte("v"), 0)
+
+	// Shard 0 could make room by evicting volatile, but shard 1 cannot.
+	ops := []TxOp{
+		{Kind: TxSet, Key: keyOn(0, "new")},
+		{Kind: TxSet, Key: keyOn(1, "new")},
+	}
+	if err := s.Commit(ctx, nil, ops); !errors.Is(err, ErrOutOfMemory) {
+		t.Fatalf("Commit = %v, want ErrOutOfMemory", err)
+	}
+	if ok, _ := s.Exists(ctx, volatile); !ok {
+		t.Error("failed Commit evicted a key")
+	}
+
+	if err := s.MSet(ctx, map[string][]byte{keyOn(0, "m"): nil, keyOn(1, "m"): nil}, 0); !errors.Is(err, ErrOutOfMemory) {
+		t.Fatalf("MSet = %v, want ErrOutOfMemory", err)
+	}
+	if ok, _ := s.Exists(ctx, volatile); !ok {
+		t.Error("failed MSet evicted a key")
+	}
+}
+
+func TestClient_TxConcurrentIncrements(t *testing.T) {
+	for name, d := range map[string]Driver{"memory": NewMemory(), "sharded": NewShardedMemory(4)} {
+		t.Run(name, func(t *testing.T) {
+			c := New[string]("app", "tx", WithDriver[string](d))
+			ctx := context.Background()
+
+			var wg sync.WaitGroup
+			for g := 0; g < 8; g++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := 0; i < 50; i++ {
+						for {
+							err := c.Tx(ctx, func(tx Tx[string]) error {
+								data, err := tx.Get(ctx, "a")
+								var n uint64
+								if err == nil {
+									n = binary.LittleEndian.Uint64(data)
+								}
+								buf := binary.LittleEndian.AppendUint64(nil, n+1)
+								tx.Set("a", buf, 0)
+								tx.Incr(fmt.Sprintf("b%d", i%3), 1)
+								return nil
+							})
+							if err == nil {
+								break
+							}
+							if !errors.Is(err, ErrTxConflict) {
+								t.Errorf("Tx failed: %v", err)
+								return
+							}
+						}
+					}
+				}()
+			}
+			wg.Wait()
+
+			a, _ := c.Incr(ctx, "a", 0)
+			var b int64
+			for i := 0; i < 3; i++ {
+				n, _ := c.Incr(ctx, fmt.Sprintf("b%d", i), 0)
+				b += n
+			}
+			if a != 400 || b != 400 {
+				t.Errorf("a = %d, sum(b) = %d, want 400/400", a, b)
+			}
+		})
+	}
+}
+
+func TestClient_TxEvents(t *testing.T) {
+	c := New[string]("app", "tx")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch, _ := c.Watch(ctx, "*")
+	_ = c.Tx(ctx, func(tx Tx[string]) error {
+		tx.Set("a", []byte("1"), 0)
+		tx.Incr("n", 1)
+		return nil
+	})
+	if ev := nextEvent(t, ch); ev != (Event[string]{EventSet, "a"}) {
+		t.Errorf("event = %+v", ev)
+	}
+	if ev := nextEvent(t, ch); ev != (Event[string]{EventIncr, "n"}) {
+		t.Errorf("event = %+v", ev)
+	}
+}
+
+func TestClient_TxUnsupported(t *testing.T) {
+	c := New[string]("app", "tx", WithDriver[string](&mockDriver{}))
+	err := c.Tx(context.Background(), func(tx Tx[string]) error { return nil })
+	if !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Tx = %v, want ErrUnsupported", err)
+	}
+}
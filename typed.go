@@ -0,0 +1,138 @@
+package namestore
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// TypedClient wraps a Client and converts values with a Codec, so callers
+// work with TValue instead of []byte. Values that fail to decode are reported
+// as ErrDecode, wrapping the key name and the codec error.
+type TypedClient[TKey ~string, TValue any] interface {
+	Set(ctx context.Context, key TKey, value TValue, ttl time.Duration) error
+	Get(ctx context.Context, key TKey) (TValue, error)
+
+	// Batch operations
+	MGet(ctx context.Context, keys ...TKey) (map[TKey]TValue, error)
+	MSet(ctx context.Context, pairs map[TKey]TValue, ttl time.Duration) error
+
+	// Atomic operations
+	GetSet(ctx context.Context, key TKey, newValue TValue) (TValue, error)
+	CompareAndSwap(ctx context.Context, key TKey, oldValue, newValue TValue, ttl time.Duration) (bool, error)
+
+	// Client returns the underlying Client for operations that do not
+	// involve values, such as Delete, TTL or Keys.
+	Client() Client[TKey]
+}
+
+type typedClient[TKey ~string, TValue any] struct {
+	client Client[TKey]
+	codec  Codec[TValue]
+}
+
+// NewTyped creates a TypedClient that stores values in c using codec.
+func NewTyped[TKey ~string, TValue any](c Client[TKey], codec Codec[TValue]) TypedClient[TKey, TValue] {
+	return &typedClient[TKey, TValue]{client: c, codec: codec}
+}
+
+func (t *typedClient[TKey, TValue]) Client() Client[TKey] {
+	return t.client
+}
+
+func (t *typedClient[TKey, TValue]) encode(key TKey, value TValue) ([]byte, error) {
+	data, err := t.codec.Encode(value)
+	if err != nil {
+		return nil, fmt.Errorf("namestore: encode key %q: %w", key, err)
+	}
+	return data, nil
+}
+
+func (t *typedClient[TKey, TValue]) decode(key TKey, data []byte) (TValue, error) {
+	value, err := t.codec.Decode(data)
+	if err != nil {
+		var zero TValue
+		return zero, fmt.Errorf("%w: key %q: %w", ErrDecode, key, err)
+	}
+	return value, nil
+}
+
+func (t *typedClient[TKey, TValue]) Set(ctx context.Context, key TKey, value TValue, ttl time.Duration) error {
+	data, err := t.encode(key, value)
+	if err != nil {
+		return err
+	}
+	return t.client.Set(ctx, key, data, ttl)
+}
+
+func (t *typedClient[TKey, TValue]) Get(ctx context.Context, key TKey) (TValue, error) {
+	data, err := t.client.Get(ctx, key)
+	if err != nil {
+		var zero TValue
+		return zero, err
+	}
+	return t.decode(key, data)
+}
+
+// MGet retrieves multiple keys; missing keys are omitted from the result.
+// It fails with ErrDecode if any present value cannot be decoded.
+func (t *typedClient[TKey, TValue]) MGet(ctx context.Context, keys ...TKey) (map[TKey]TValue, error) {
+	raw, err := t.client.MGet(ctx, keys...)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[TKey]TValue, len(raw))
+	for k, data := range raw {
+		value, err := t.decode(k, data)
+		if err != nil {
+			return nil, err
+		}
+		result[k] = value
+	}
+	return result, nil
+}
+
+// MSet encodes all values before writing, so an encoding error leaves the
+// store untouched.
+func (t *typedClient[TKey, TValue]) MSet(ctx context.Context, pairs map[TKey]TValue, ttl time.Duration) error {
+	raw := make(map[TKey][]byte, len(pairs))
+	for k, v := range pairs {
+		data, err := t.encode(k, v)
+		if err != nil {
+			return err
+		}
+		raw[k] = data
+	}
+	return t.client.MSet(ctx, raw, ttl)
+}
+
+// GetSet stores newValue and returns the previous value. As with
+// Client.GetSet, a missing key is written and reported as ErrNotFound.
+func (t *typedClient[TKey, TValue]) GetSet(ctx context.Context, key TKey, newValue TValue) (TValue, error) {
+	var zero TValue
+	data, err := t.encode(key, newValue)
+	if err != nil {
+		return zero, err
+	}
+
+	old, err := t.client.GetSet(ctx, key, data)
+	if err != nil {
+		return zero, err
+	}
+	return t.decode(key, old)
+}
+
+// CompareAndSwap replaces the value if its encoded form equals the encoding
+// of oldValue.
+func (t *typedClient[TKey, TValue]) CompareAndSwap(ctx context.Context, key TKey, oldValue, newValue TValue, ttl time.Duration) (bool, error) {
+	oldData, err := t.encode(key, oldValue)
+	if err != nil {
+		return false, err
+	}
+	newData, err := t.encode(key, newValue)
+	if err != nil {
+		return false, err
+	}
+	return t.client.CompareAndSwap(ctx, key, oldData, newData, ttl)
+}
@@ -0,0 +1,150 @@
+package namestore
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+type typedUser struct {
+	Name string
+	Age  int
+}
+
+func TestCodecs_RoundTrip(t *testing.T) {
+	user := typedUser{Name: "Alice", Age: 30}
+
+	for name, codec := range map[string]Codec[typedUser]{
+		"json": JSONCodec[typedUser](),
+		"gob":  GobCodec[typedUser](),
+	} {
+		data, err := codec.Encode(user)
+		if err != nil {
+			t.Fatalf("%s Encode failed: %v", name, err)
+		}
+		got, err := codec.Decode(data)
+		if err != nil || got != user {
+			t.Errorf("%s round trip = %+v, %v", name, got, err)
+		}
+		if _, err := codec.Decode([]byte("garbage")); err == nil {
+			t.Errorf("%s Decode should reject garbage", name)
+		}
+	}
+
+	type token string
+	data, _ := StringCodec[token]().Encode("abc")
+	if string(data) != "abc" {
+		t.Errorf("StringCodec Encode = %q", data)
+	}
+	if got, _ := StringCodec[token]().Decode([]byte("xyz")); got != "xyz" {
+		t.Errorf("StringCodec Decode = %q", got)
+	}
+}
+
+func TestTypedClient_SetGet(t *testing.T) {
+	users := NewTyped(New[string]("app", "users"), JSONCodec[typedUser]())
+	ctx := context.Background()
+
+	if err := users.Set(ctx, "1", typedUser{Name: "Alice", Age: 30}, time.Minute); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	got, err := users.Get(ctx, "1")
+	if err != nil || got.Name != "Alice" || got.Age != 30 {
+		t.Errorf("Get = %+v, %v", got, err)
+	}
+
+	if _, err := users.Get(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get missing = %v, want ErrNotFound", err)
+	}
+
+	raw, _ := users.Client().Get(ctx, "1")
+	if string(raw) != `{"Name":"Alice","Age":30}` {
+		t.Errorf("stored bytes = %s", raw)
+	}
+}
+
+func TestTypedClient_DecodeError(t *testing.T) {
+	c := New[string]("app", "users")
+	users := NewTyped(c, JSONCodec[typedUser]())
+	ctx := context.Background()
+
+	_ = c.Set(ctx, "bad", []byte("not json"), 0)
+	_ = users.Set(ctx, "good", typedUser{Name: "Bob"}, 0)
+
+	_, err := users.Get(ctx, "bad")
+	if !errors.Is(err, ErrDecode) {
+		t.Fatalf("Get = %v, want ErrDecode", err)
+	}
+	if !strings.Contains(err.Error(), `"bad"`) {
+		t.Errorf("error %q should name the key", err)
+	}
+
+	if _, err := users.MGet(ctx, "good", "bad"); !errors.Is(err, ErrDecode) {
+		t.Errorf("MGet = %v, want ErrDecode", err)
+	}
+	if _, err := users.GetSet(ctx, "bad", typedUser{Name: "Carol"}); !errors.Is(err, ErrDecode) {
+		t.Errorf("GetSet = %v, want ErrDecode", err)
+	}
+}
+
+func TestTypedClient_EncodeError(t *testing.T) {
+	c := New[string]("app", "funcs")
+	funcs := NewTyped(c, JSONCodec[func()]())
+	ctx := context.Background()
+
+	err := funcs.MSet(ctx, map[string]func(){"a": func() {}}, 0)
+	if err == nil || errors.Is(err, ErrDecode) {
+		t.Fatalf("MSet = %v, want encode error", err)
+	}
+	if exists, _ := c.Exists(ctx, "a"); exists {
+		t.Error("failed MSet should not write")
+	}
+}
+
+func TestTypedClient_Batch(t *testing.T) {
+	type Name string
+	names := NewTyped(New[string]("app", "names"), StringCodec[Name]())
+	ctx := context.Background()
+
+	err := names.MSet(ctx, map[string]Name{"1": "Alice", "2": "Bob"}, 0)
+	if err != nil {
+		t.Fatalf("MSet failed: %v", err)
+	}
+
+	got, err := names.MGet(ctx, "1", "2", "3")
+	if err != nil {
+		t.Fatalf("MGet failed: %v", err)
+	}
+	if len(got) != 2 || got["1"] != "Alice" || got["2"] != "Bob" {
+		t.Errorf("MGet = %v", got)
+	}
+}
+
+func TestTypedClient_Atomic(t *testing.T) {
+	users := NewTyped(New[string]("app", "users"), GobCodec[typedUser]())
+	ctx := context.Background()
+
+	if _, err := users.GetSet(ctx, "1", typedUser{Name: "Alice"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetSet on missing key = %v, want ErrNotFound", err)
+	}
+	old, err := users.GetSet(ctx, "1", typedUser{Name: "Bob"})
+	if err != nil || old.Name != "Alice" {
+		t.Errorf("GetSet = %+v, %v", old, err)
+	}
+
+	swapped, err := users.CompareAndSwap(ctx, "1", typedUser{Name: "Bob"}, typedUser{Name: "Carol"}, 0)
+	if err != nil || !swapped {
+		t.Errorf("CompareAndSwap = %v, %v, want true", swapped, err)
+	}
+	swapped, _ = users.CompareAndSwap(ctx, "1", typedUser{Name: "Bob"}, typedUser{Name: "Dave"}, 0)
+	if swapped {
+		t.Error("CompareAndSwap with stale value should fail")
+	}
+
+	got, _ := users.Get(ctx, "1")
+	if got.Name != "Carol" {
+		t.Errorf("Get = %+v, want Carol", got)
+	}
+}
@@ -0,0 +1,164 @@
+package namestore
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultWatchBuffer is the per-subscriber event buffer of the memory drivers.
+const DefaultWatchBuffer = 256
+
+// EventType identifies the kind of change reported by Watch.
+type EventType string
+
+const (
+	EventSet     EventType = "set"     // value written by Set, SetNX, MSet, GetSet or CompareAndSwap
+	EventDel     EventType = "del"     // key removed by Delete or MDel
+	EventExpired EventType = "expired" // key removed because its TTL passed
+	EventEvicted EventType = "evicted" // key removed to respect a capacity limit
+	EventIncr    EventType = "incr"    // counter changed by Incr or Decr
+	EventExpire  EventType = "expire"  // TTL set by Expire
+	EventPersist EventType = "persist" // TTL removed by Persist or Expire with ttl <= 0
+	EventClear   EventType = "clear"   // namespace cleared; Key is empty
+)
+
+// Event describes a change to a key.
+type Event[TKey ~string] struct {
+	Type EventType
+	Key  TKey
+}
+
+// Watcher is implemented by drivers that can report key changes. It is an
+// optional extension of Driver used by Client.Watch.
+type Watcher interface {
+	// Watch streams events for keys under prefix whose remainder matches
+	// pattern, with full keys, until ctx is done. EventClear events carry the
+	// prefix as key. The channel is closed when ctx is done or if the
+	// subscriber falls too far behind.
+	Watch(ctx context.Context, prefix, pattern string) (<-chan Event[string], error)
+}
+
+// WithWatchBuffer sets how many events a Watch subscriber may have pending
+// before it is considered too slow and dropped. Defaults to DefaultWatchBuffer.
+func WithWatchBuffer(n int) MemoryOption {
+	return func(c *memoryConfig) {
+		if n > 0 {
+			c.watchBuffer = n
+		}
+	}
+}
+
+// watchHub fans events out to subscribers. Publishing never blocks: a
+// subscriber whose buffer is full has its channel closed and is removed, so
+// a slow consumer cannot stall writers and learns that it missed events.
+type watchHub struct {
+	mu     sync.Mutex
+	subs   map[*watchSub]struct{}
+	active atomic.Int32
+	buffer int
+}
+
+type watchSub struct {
+	prefix  string
+	pattern string
+	ch      chan Event[string]
+}
+
+func newWatchHub(buffer int) *watchHub {
+	if buffer <= 0 {
+		buffer = DefaultWatchBuffer
+	}
+	return &watchHub{subs: make(map[*watchSub]struct{}), buffer: buffer}
+}
+
+func (h *watchHub) subscribe(ctx context.Context, prefix, pattern string) (<-chan Event[string], error) {
+	if pattern != "" && pattern != "*" {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, ErrInvalidPattern
+		}
+	}
+
+	sub := &watchSub{prefix: prefix, pattern: pattern, ch: make(chan Event[string], h.buffer)}
+	h.mu.Lock()
+	h.subs[sub] = struct{}{}
+	h.active.Add(1)
+	h.mu.Unlock()
+
+	context.AfterFunc(ctx, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.dropLocked(sub)
+	})
+	return sub.ch, nil
+}
+
+func (h *watchHub) dropLocked(sub *watchSub) {
+	if _, ok := h.subs[sub]; ok {
+		delete(h.subs, sub)
+		h.active.Add(-1)
+		close(sub.ch)
+	}
+}
+
+// publish delivers an event to matching subscribers. It is cheap when nobody
+// is watching, and safe on a nil hub.
+func (h *watchHub) publish(typ EventType, key string) {
+	if h == nil || h.active.Load() == 0 {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subs {
+		if !sub.matches(typ, key) {
+			continue
+		}
+		select {
+		case sub.ch <- Event[string]{Type: typ, Key: key}:
+		default:
+			h.dropLocked(sub)
+		}
+	}
+}
+
+// close ends every subscription. Safe on a nil hub.
+func (h *watchHub) close() {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subs {
+		h.dropLocked(sub)
+	}
+}
+
+func (s *watchSub) matches(typ EventType, key string) bool {
+	if typ == EventClear {
+		return key == s.prefix
+	}
+	if !strings.HasPrefix(key, s.prefix+":") {
+		return false
+	}
+	if s.pattern == "" || s.pattern == "*" {
+		return true
+	}
+	matched, _ := filepath.Match(s.pattern, key[len(s.prefix)+1:])
+	return matched
+}
+
+// Watch streams changes to keys under prefix matching pattern. Events are
+// published while the write lock is held, so they arrive in the order the
+// changes were applied. See WithWatchBuffer for the slow-consumer policy.
+func (m *Memory) Watch(ctx context.Context, prefix, pattern string) (<-chan Event[string], error) {
+	return m.hub.subscribe(ctx, prefix, pattern)
+}
+
+// Watch streams changes across all shards. Events for keys in the same shard
+// arrive in order; events from different shards may interleave.
+func (s *ShardedMemory) Watch(ctx context.Context, prefix, pattern string) (<-chan Event[string], error) {
+	return s.hub.subscribe(ctx, prefix, pattern)
+}
@@ -0,0 +1,200 @@
+package namestore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// nextEvent receives one event or fails the test after a timeout.
+func nextEvent[TKey ~string](t *testing.T, ch <-chan Event[TKey]) Event[TKey] {
+	t.Helper()
+	select {
+	case ev, ok := <-ch:
+		if !ok {
+			t.Fatal("watch channel closed")
+		}
+		return ev
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+	return Event[TKey]{}
+}
+
+func TestMemory_WatchEvents(t *testing.T) {
+	m := NewMemory(WithMaxEntries(10), WithEvictionPolicy(AllKeysLRU)).(*Memory)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch, err := m.Watch(ctx, "app", "*")
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+
+	_ = m.Set(ctx, "app:a", []byte("v"), 0)
+	_, _ = m.SetNX(ctx, "app:a", []byte("v"), 0) // no change, no event
+	_ = m.MSet(ctx, map[string][]byte{"app:b": []byte("v")}, 0)
+	_, _ = m.Incr(ctx, "app:n", 1)
+	_, _ = m.Decr(ctx, "app:n", 1)
+	_ = m.Expire(ctx, "app:a", time.Hour)
+	_ = m.Persist(ctx, "app:a")
+	_, _ = m.GetSet(ctx, "app:a", []byte("v2"))
+	_, _ = m.CompareAndSwap(ctx, "app:a", []byte("v2"), []byte("v3"), 0)
+	_ = m.Delete(ctx, "app:a")
+	_ = m.Delete(ctx, "app:missing") // no event
+	_ = m.MDel(ctx, []string{"app:b"})
+	_ = m.Set(ctx, "other:x", []byte("v"), 0) // outside the prefix
+	_ = m.Set(ctx, "app:ttl", []byte("v"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	_, _ = m.Get(ctx, "app:ttl")
+	_ = m.Clear(ctx, "app")
+
+	want := []Event[string]{
+		{EventSet, "app:a"},
+		{EventSet, "app:b"},
+		{EventIncr, "app:n"},
+		{EventIncr, "app:n"},
+		{EventExpire, "app:a"},
+		{EventPersist, "app:a"},
+		{EventSet, "app:a"},
+		{EventSet, "app:a"},
+		{EventDel, "app:a"},
+		{EventDel, "app:b"},
+		{EventSet, "app:ttl"},
+		{EventExpired, "app:ttl"},
+		{EventClear, "app"},
+	}
+	for i, w := range want {
+		if got := nextEvent(t, ch); got != w {
+			t.Fatalf("event %d = %+v, want %+v", i, got, w)
+		}
+	}
+
+	// Only app keys remain, so the eleventh key evicts exactly one of them.
+	_ = m.Delete(ctx, "other:x")
+	for i := 0; i < 11; i++ {
+		_ = m.Set(ctx, fmt.Sprintf("app:k%d", i), []byte("v"), 0)
+	}
+	evicted := 0
+	for i := 0; i < 12; i++ {
+		if nextEvent(t, ch).Type == EventEvicted {
+			evicted++
+		}
+	}
+	if evicted != 1 {
+		t.Errorf("got %d evicted events, want 1", evicted)
+	}
+
+	cancel()
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Error("expected channel to be closed after cancel")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("channel not closed after cancel")
+	}
+}
+
+func TestMemory_WatchPattern(t *testing.T) {
+	m := NewMemory()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if _, err := m.(Watcher).Watch(ctx, "app", "[invalid"); !errors.Is(err, ErrInvalidPattern) {
+		t.Errorf("expected ErrInvalidPattern, got %v", err)
+	}
+
+	ch, _ := m.(Watcher).Watch(ctx, "app", "user:*")
+	_ = m.Set(ctx, "app:session:1", []byte("v"), 0)
+	_ = m.Set(ctx, "app:user:1", []byte("v"), 0)
+
+	if got := nextEvent(t, ch); got.Key != "app:user:1" {
+		t.Errorf("event = %+v, want app:user:1", got)
+	}
+}
+
+func TestMemory_WatchSlowConsumer(t *testing.T) {
+	m := NewMemory(WithWatchBuffer(4)).(*Memory)
+	ctx := context.Background()
+
+	slow, _ := m.Watch(ctx, "app", "*")
+	for i := 0; i < 10; i++ {
+		_ = m.Set(ctx, "app:k", []byte("v"), 0)
+	}
+
+	n := 0
+	for range slow {
+		n++
+	}
+	if n != 4 {
+		t.Errorf("slow subscriber received %d events before being dropped, want 4", n)
+	}
+	if m.hub.active.Load() != 0 {
+		t.Error("dropped subscriber still registered")
+	}
+}
+
+func TestMemory_WatchClose(t *testing.T) {
+	m := NewMemory().(*Memory)
+	ch, _ := m.Watch(context.Background(), "app", "*")
+	_ = m.Close()
+
+	if _, ok := <-ch; ok {
+		t.Error("expected channel to be closed by Close")
+	}
+}
+
+func TestShardedMemory_Watch(t *testing.T) {
+	s := NewShardedMemory(4).(*ShardedMemory)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch, _ := s.Watch(ctx, "app", "*")
+	for i := 0; i < 8; i++ {
+		_ = s.Set(ctx, fmt.Sprintf("app:%d", i), []byte("v"), 0)
+	}
+	_ = s.Clear(ctx, "app")
+
+	for i := 0; i < 8; i++ {
+		if ev := nextEvent(t, ch); ev.Type != EventSet {
+			t.Fatalf("event %d = %+v, want set", i, ev)
+		}
+	}
+	if ev := nextEvent(t, ch); ev.Type != EventClear {
+		t.Errorf("event = %+v, want a single clear", ev)
+	}
+}
+
+func TestClient_Watch(t *testing.T) {
+	type UserID string
+	c := New[UserID]("app", "users")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch, err := c.Watch(ctx, "*")
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+
+	other := New[UserID]("app", "orders", WithDriver[UserID](c.(*client[UserID]).driver))
+	_ = other.Set(ctx, "1", []byte("v"), 0)
+	_ = c.Set(ctx, "1001", []byte("v"), 0)
+	_ = c.Clear(ctx)
+
+	if got := nextEvent(t, ch); got != (Event[UserID]{EventSet, "1001"}) {
+		t.Errorf("event = %+v, want set 1001", got)
+	}
+	if got := nextEvent(t, ch); got != (Event[UserID]{Type: EventClear}) {
+		t.Errorf("event = %+v, want clear", got)
+	}
+}
+
+func TestClient_WatchUnsupported(t *testing.T) {
+	c := New[string]("app", "users", WithDriver[string](&mockDriver{}))
+	if _, err := c.Watch(context.Background(), "*"); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("expected ErrUnsupported, got %v", err)
+	}
+}